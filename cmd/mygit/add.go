@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Usage: mygit add [-n|--dry-run] [-v|--verbose] [-f|--force] <pathspec>...
+func cmdAdd(args []string) {
+	dryRun, verbose, force := false, false, false
+	var pathspecs []string
+	for i, arg := range args {
+		if arg == "--" {
+			pathspecs = append(pathspecs, args[i+1:]...)
+			break
+		}
+		switch arg {
+		case "-n", "--dry-run":
+			dryRun = true
+		case "-v", "--verbose":
+			verbose = true
+		case "-f", "--force":
+			force = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				fmt.Fprintf(os.Stderr, "usage: mygit add [--dry-run] [--verbose] [--force] <pathspec>...\n")
+				os.Exit(1)
+			}
+			pathspecs = append(pathspecs, arg)
+		}
+	}
+	if len(pathspecs) == 0 {
+		fmt.Println("Nothing specified, nothing added.")
+		return
+	}
+
+	headSha, err := headCommit()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error resolving HEAD: %s\n", err)
+		os.Exit(1)
+	}
+	headTree, err := commitTree(headSha)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading HEAD commit: %s\n", err)
+		os.Exit(1)
+	}
+	headFiles, err := treeFiles(headTree)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading HEAD tree: %s\n", err)
+		os.Exit(1)
+	}
+	entries, err := loadIndex(headFiles)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading index: %s\n", err)
+		os.Exit(1)
+	}
+
+	s := &stager{
+		index:   indexByPath(entries),
+		touched: map[string]bool{},
+		ignore:  newIgnoreMatcher(),
+		dryRun:  dryRun,
+		verbose: verbose || dryRun,
+		force:   force,
+	}
+	var ignoredPaths []string
+	for _, spec := range pathspecs {
+		p := parsePathspec(spec)
+		if p.isPattern() {
+			if matched, err := s.addMatching(p); err != nil {
+				fmt.Fprintf(os.Stderr, "error: unable to add '%s': %s\n", spec, err)
+				os.Exit(1)
+			} else if !matched {
+				fmt.Fprintf(os.Stderr, "fatal: pathspec '%s' did not match any files\n", spec)
+				os.Exit(128)
+			}
+			continue
+		}
+		filePath := p.pattern
+		fi, err := os.Lstat(filePath)
+		if os.IsNotExist(err) {
+			if !s.removeMissing(filePath) {
+				fmt.Fprintf(os.Stderr, "fatal: pathspec '%s' did not match any files\n", spec)
+				os.Exit(128)
+			}
+			continue
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %s\n", spec, err)
+			os.Exit(1)
+		}
+
+		if fi.IsDir() {
+			err = s.addDir(filePath)
+		} else if _, tracked := s.index[filePath]; !tracked && !force && s.ignore.isIgnored(filePath, false) {
+			ignoredPaths = append(ignoredPaths, filePath)
+			if s.verbose {
+				fmt.Printf("ignored '%s'\n", filePath)
+			}
+		} else {
+			err = s.addFile(filePath, fi)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: unable to add '%s': %s\n", spec, err)
+			os.Exit(1)
+		}
+	}
+
+	if !dryRun {
+		var newEntries []indexEntry
+		for _, entry := range entries {
+			if !s.touched[entry.path] {
+				newEntries = append(newEntries, entry)
+			}
+		}
+		for filePath := range s.touched {
+			if entry, ok := s.index[filePath]; ok {
+				newEntries = append(newEntries, entry)
+			}
+		}
+		if err := writeIndex(newEntries); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing index: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if len(ignoredPaths) > 0 && !s.verbose {
+		fmt.Fprintf(os.Stderr, "The following paths are ignored by one of your .gitignore files:\n%s\n", strings.Join(ignoredPaths, "\n"))
+		fmt.Fprintf(os.Stderr, "hint: Use -f if you really want to add them.\n")
+	}
+	if len(ignoredPaths) > 0 {
+		os.Exit(1)
+	}
+}
+
+type stager struct {
+	index   map[string]indexEntry
+	touched map[string]bool //paths whose index entry was added, updated or removed
+	ignore  *ignoreMatcher
+	dryRun  bool
+	verbose bool
+	force   bool
+}
+
+func (s *stager) addFile(filePath string, fi os.FileInfo) error {
+	existing, tracked := s.index[filePath]
+	if tracked && existing.statMatches(fi) {
+		return nil
+	}
+	contents, fi, err := readWorktreeFile(filePath)
+	if err != nil {
+		return err
+	}
+	sha := hashObject("blob", contents)
+	mode := fileMode(fi)
+	unchanged := tracked && existing.sha == sha && existing.mode == mode
+
+	if !unchanged && s.verbose {
+		fmt.Printf("add '%s'\n", filePath)
+	}
+	if s.dryRun {
+		return nil
+	}
+	if !unchanged {
+		if _, err := writeObject("blob", contents); err != nil {
+			return err
+		}
+	}
+	// refresh the stat data even when the content is unchanged
+	entry, err := newIndexEntry(filePath, sha, mode)
+	if err != nil {
+		return err
+	}
+	s.index[filePath] = entry
+	s.touched[filePath] = true
+	return nil
+}
+
+func (s *stager) addDir(dir string) error {
+	seen := map[string]bool{}
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if file.Name() == ".git" {
+				continue
+			}
+			filePath := path.Join(dir, file.Name())
+			_, tracked := s.index[filePath]
+			if !tracked && !s.force && s.ignore.isIgnored(filePath, file.IsDir()) {
+				if s.verbose {
+					if file.IsDir() {
+						filePath += "/"
+					}
+					fmt.Printf("ignored '%s'\n", filePath)
+				}
+				continue
+			}
+			if file.IsDir() {
+				if err := walk(filePath); err != nil {
+					return err
+				}
+				continue
+			}
+			fi, err := os.Lstat(filePath)
+			if err != nil {
+				return err
+			}
+			seen[filePath] = true
+			if err := s.addFile(filePath, fi); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(dir); err != nil {
+		return err
+	}
+
+	// tracked files under the directory that are gone from disk are staged as removals
+	var gone []string
+	for filePath := range s.index {
+		if !seen[filePath] && (dir == "." || strings.HasPrefix(filePath, dir+"/")) {
+			if _, err := os.Lstat(filePath); os.IsNotExist(err) {
+				gone = append(gone, filePath)
+			}
+		}
+	}
+	sort.Strings(gone)
+	for _, filePath := range gone {
+		s.remove(filePath)
+	}
+	return nil
+}
+
+// addMatching stages the files a pattern matches: those in the working tree that aren't
+// ignored, and tracked ones, which are staged as removals if they are gone. It reports whether
+// the pattern matched anything.
+func (s *stager) addMatching(p pathspec) (bool, error) {
+	matched := false
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			filePath := path.Join(dir, file.Name())
+			_, tracked := s.index[filePath]
+			if file.Name() == ".git" || !tracked && !s.force && s.ignore.isIgnored(filePath, file.IsDir()) {
+				continue
+			}
+			if file.IsDir() {
+				if err := walk(filePath); err != nil {
+					return err
+				}
+				continue
+			}
+			if !p.matches(filePath) {
+				continue
+			}
+			fi, err := os.Lstat(filePath)
+			if err != nil {
+				return err
+			}
+			matched = true
+			if err := s.addFile(filePath, fi); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk("."); err != nil {
+		return false, err
+	}
+
+	var gone []string
+	for filePath := range s.index {
+		if p.matches(filePath) {
+			if _, err := os.Lstat(filePath); os.IsNotExist(err) {
+				gone = append(gone, filePath)
+			}
+		}
+	}
+	sort.Strings(gone)
+	for _, filePath := range gone {
+		matched = true
+		s.remove(filePath)
+	}
+	return matched, nil
+}
+
+// removeMissing stages the removal of tracked files at or under a path that no longer exists
+func (s *stager) removeMissing(missing string) bool {
+	var matched []string
+	for filePath := range s.index {
+		if filePath == missing || strings.HasPrefix(filePath, missing+"/") {
+			matched = append(matched, filePath)
+		}
+	}
+	sort.Strings(matched)
+	for _, filePath := range matched {
+		s.remove(filePath)
+	}
+	return len(matched) > 0
+}
+
+func (s *stager) remove(filePath string) {
+	if s.verbose {
+		fmt.Printf("remove '%s'\n", filePath)
+	}
+	if !s.dryRun {
+		delete(s.index, filePath)
+		s.touched[filePath] = true
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAddWritesIndex(t *testing.T) {
+	initTestRepo(t)
+	writeTestFile(t, "a", "a\n")
+	writeTestFile(t, "d/b", "b\n")
+	runTestCommand(t, "add", "a", "d/b")
+
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[:4]) != "DIRC" || binary.BigEndian.Uint32(data[4:8]) != 2 || binary.BigEndian.Uint32(data[8:12]) != 2 {
+		t.Fatalf("index header % x, want DIRC, version 2 and 2 entries", data[:12])
+	}
+	checkEntries := func(want map[string]string) {
+		t.Helper()
+		entries, err := readIndex()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != len(want) {
+			t.Fatalf("index has %d entries, want %d", len(entries), len(want))
+		}
+		for _, entry := range entries {
+			fi, err := os.Lstat(entry.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.sha != hashObject("blob", []byte(want[entry.path])) || entry.mode != 0o100644 {
+				t.Errorf("%s staged as %o %s, want the blob of %q", entry.path, entry.mode, entry.sha, want[entry.path])
+			}
+			if !entry.statMatches(fi) {
+				t.Errorf("%s staged with stat data that doesn't match the file", entry.path)
+			}
+		}
+	}
+	checkEntries(map[string]string{"a": "a\n", "d/b": "b\n"})
+
+	// staging a file again replaces its entry, and the index is replaced by renaming a new one
+	// over it, leaving nothing behind
+	before, err := os.Stat(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, "a", "changed\n")
+	runTestCommand(t, "add", "a")
+	checkEntries(map[string]string{"a": "changed\n", "d/b": "b\n"})
+	after, err := os.Stat(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(before, after) {
+		t.Error("the index was rewritten in place")
+	}
+	if leftover, _ := filepath.Glob(filepath.Join(".git", ".index*")); len(leftover) > 0 {
+		t.Errorf("left behind %s", strings.Join(leftover, " "))
+	}
+
+	// git reads the index too, and with the stat data right, finds nothing changed
+	if git, err := exec.LookPath("git"); err == nil {
+		if out, err := exec.Command(git, "diff-files", "--name-only").CombinedOutput(); err != nil || len(out) > 0 {
+			t.Errorf("git diff-files: %v\n%s", err, out)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+An archive holds the files of a tree as of one commit, like a checkout without the repository.
+Which files go in, and how, is up to the export-ignore and export-subst attributes. These are
+taken from the .gitattributes files of the tree being archived, not the working tree's, plus
+.git/info/attributes, so that an archive of a commit always comes out the same.
+*/
+
+// archiveFormats are the formats archive can write, by name
+var archiveFormats = []string{"tar", "tgz", "tar.gz", "zip"}
+
+// archiveEntry is one file, directory, symlink or submodule to write to an archive
+type archiveEntry struct {
+	path     string //with the prefix, and a trailing slash for directories
+	mode     uint32 //as in a tree
+	contents []byte
+}
+
+// archiveFormatFor guesses the format from an output file name, "" when it doesn't tell
+func archiveFormatFor(filename string) string {
+	for _, format := range archiveFormats {
+		if strings.HasSuffix(filename, "."+format) {
+			return format
+		}
+	}
+	return ""
+}
+
+// formatTokens matches what an export-subst file has expanded
+var formatTokens = regexp.MustCompile(`\$Format:([^$\n]*)\$`)
+
+// archiveTree collects the entries of a tree for an archive, leaving out what export-ignore
+// says to and, for a commit, expanding $Format:<format>$ in export-subst files. With
+// worktreeAttributes, the working tree's .gitattributes apply after those of the tree.
+type archiveTree struct {
+	commitSHA          string //"" when archiving a bare tree
+	commit             *Commit
+	prefix             string
+	pathspecs          []string
+	worktreeAttributes bool
+
+	dirRules map[string][]attributeRule //from the .gitattributes in each directory of the tree
+	entries  []archiveEntry
+}
+
+func (t *archiveTree) rules(dir string) []attributeRule {
+	rules := t.dirRules[dir]
+	if t.worktreeAttributes {
+		rules = append(append([]attributeRule{}, rules...), worktreeAttributeRules(dir)...)
+	}
+	return rules
+}
+
+func (t *archiveTree) attributes(filePath string) map[string]string {
+	return matchAttributes(filePath, attributeRulesFrom(filePath, t.rules))
+}
+
+// add collects the tree sha at dir, "" for the top, and what is inside it
+func (t *archiveTree) add(sha string, dir string) error {
+	entries, err := readTree(sha)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.name == ".gitattributes" && !entry.isTree() {
+			_, contents, err := parseObject(entry.sha)
+			if err != nil {
+				return err
+			}
+			t.dirRules[dir] = parseAttributesFile(contents, dir)
+		}
+	}
+
+	for _, entry := range entries {
+		entryPath := path.Join(dir, entry.name)
+		if t.attributes(entryPath)["export-ignore"] == attrSet {
+			continue
+		}
+		if entry.isTree() {
+			// a directory is only written when it or something in it was asked for
+			before := len(t.entries)
+			t.entries = append(t.entries, archiveEntry{path: t.prefix + entryPath + "/", mode: entry.mode})
+			if err := t.add(entry.sha, entryPath); err != nil {
+				return err
+			}
+			if len(t.entries) == before+1 && !matchesPathspec(entryPath, t.pathspecs) {
+				t.entries = t.entries[:before]
+			}
+			continue
+		}
+		if !matchesPathspec(entryPath, t.pathspecs) {
+			continue
+		}
+		if entry.mode == 0o160000 {
+			t.entries = append(t.entries, archiveEntry{path: t.prefix + entryPath + "/", mode: entry.mode})
+			continue //a submodule's files aren't in this repository
+		}
+		_, contents, err := parseObject(entry.sha)
+		if err != nil {
+			return err
+		}
+		if t.commit != nil && t.attributes(entryPath)["export-subst"] == attrSet {
+			contents = formatTokens.ReplaceAllFunc(contents, func(token []byte) []byte {
+				format := formatTokens.FindSubmatch(token)[1]
+				return []byte(formatCommit(string(format), t.commitSHA, t.commit))
+			})
+		}
+		t.entries = append(t.entries, archiveEntry{path: t.prefix + entryPath, mode: entry.mode, contents: contents})
+	}
+	return nil
+}
+
+// writeTarArchive writes entries as a tar file. Like git, the commit's SHA goes in a global
+// pax header, and permissions are given as git's default tar.umask of 002 leaves them.
+func writeTarArchive(w io.Writer, entries []archiveEntry, commitSHA string, modified time.Time) error {
+	tw := tar.NewWriter(w)
+	if commitSHA != "" {
+		header := &tar.Header{Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": commitSHA}}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+	}
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.path, Mode: 0o664, ModTime: modified, Uname: "root", Gname: "root", Format: tar.FormatPAX}
+		switch entry.mode {
+		case 0o040000, 0o160000:
+			header.Typeflag, header.Mode = tar.TypeDir, 0o775
+		case 0o120000:
+			header.Typeflag, header.Mode, header.Linkname = tar.TypeSymlink, 0o777, string(entry.contents)
+		case 0o100755:
+			header.Typeflag, header.Mode, header.Size = tar.TypeReg, 0o775, int64(len(entry.contents))
+		default:
+			header.Typeflag, header.Size = tar.TypeReg, int64(len(entry.contents))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := tw.Write(entry.contents); err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Close()
+}
+
+// writeZipArchive writes entries as a zip file, with the commit's SHA as its comment
+func writeZipArchive(w io.Writer, entries []archiveEntry, commitSHA string, modified time.Time) error {
+	zw := zip.NewWriter(w)
+	if commitSHA != "" {
+		if err := zw.SetComment(commitSHA); err != nil {
+			return err
+		}
+	}
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.path, Method: zip.Deflate, Modified: modified}
+		switch entry.mode {
+		case 0o040000, 0o160000:
+			header.Method = zip.Store
+			header.SetMode(os.ModeDir | 0o755)
+		case 0o120000:
+			header.SetMode(os.ModeSymlink | 0o777)
+		case 0o100755:
+			header.SetMode(0o755)
+		default:
+			header.SetMode(0o644)
+		}
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(entry.contents); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// Usage: mygit archive [--format=<fmt>] [--prefix=<prefix>/] [-o <file>] [--worktree-attributes] <tree-ish> [<path>...]
+//
+// Writes the files of a tree, or of the paths given in it, as a tar or zip archive, to stdout or
+// the file given with -o, whose name picks the format when --format doesn't. Every path in it
+// starts with the prefix. For a commit, its date is the files' modification time and its SHA is
+// recorded in the archive. -l lists the formats.
+//
+// Files with the export-ignore attribute are left out. In files with export-subst, each
+// $Format:<format>$ is replaced with the commit's details in the pretty format given, such as
+// "$Format:%H$" for its SHA. --worktree-attributes has the .gitattributes files of the working
+// tree apply as well as those of the tree.
+func cmdArchive(args []string) {
+	usage := "usage: mygit archive [--format=<fmt>] [--prefix=<prefix>/] [-o <file>] [--worktree-attributes] <tree-ish> [<path>...]\n" +
+		"   or: mygit archive --list\n"
+	format, output := "", ""
+	tree := &archiveTree{dirRules: map[string][]attributeRule{}}
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-l" || arg == "--list":
+			for _, format := range archiveFormats {
+				fmt.Println(format)
+			}
+			return
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.TrimPrefix(arg, "--format=")
+		case strings.HasPrefix(arg, "--prefix="):
+			tree.prefix = strings.TrimPrefix(arg, "--prefix=")
+		case (arg == "-o" || arg == "--output") && i+1 < len(args):
+			i++
+			output = args[i]
+		case strings.HasPrefix(arg, "--output="):
+			output = strings.TrimPrefix(arg, "--output=")
+		case arg == "--worktree-attributes":
+			tree.worktreeAttributes = true
+		case arg == "--":
+			rest = append(rest, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(129)
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if len(rest) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(129)
+	}
+	fatal := func(format string, a ...any) {
+		fmt.Fprintf(os.Stderr, "fatal: "+format+"\n", a...)
+		os.Exit(128)
+	}
+	if format == "" {
+		if format = archiveFormatFor(output); format == "" {
+			format = "tar"
+		}
+	}
+	known := false
+	for _, name := range archiveFormats {
+		known = known || name == format
+	}
+	if !known {
+		fatal("Unknown archive format '%s'", format)
+	}
+
+	sha, err := resolveRevision(rest[0])
+	if err != nil {
+		fatal("not a valid object name: %s", rest[0])
+	}
+	modified := time.Now()
+	if commitSHA, err := peelToCommit(sha); err == nil {
+		if tree.commit, err = readCommit(commitSHA); err != nil {
+			fatal("%s", err)
+		}
+		tree.commitSHA = commitSHA
+		modified = signatureTime(tree.commit.Committer)
+	}
+	treeSHA, err := peelToTree(sha)
+	if err != nil {
+		fatal("not a tree object: %s", sha)
+	}
+	tree.pathspecs = rest[1:]
+	if err := tree.add(treeSHA, ""); err != nil {
+		fatal("%s", err)
+	}
+	if len(tree.pathspecs) > 0 && len(tree.entries) == 0 {
+		fatal("pathspec '%s' did not match any files", tree.pathspecs[0])
+	}
+	if strings.HasSuffix(tree.prefix, "/") {
+		tree.entries = append([]archiveEntry{{path: tree.prefix, mode: 0o040000}}, tree.entries...)
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "zip":
+		err = writeZipArchive(&buf, tree.entries, tree.commitSHA, modified)
+	case "tar":
+		err = writeTarArchive(&buf, tree.entries, tree.commitSHA, modified)
+	default:
+		gz := gzip.NewWriter(&buf)
+		if err = writeTarArchive(gz, tree.entries, tree.commitSHA, modified); err == nil {
+			err = gz.Close()
+		}
+	}
+	if err != nil {
+		fatal("%s", err)
+	}
+	if output == "" {
+		_, err = os.Stdout.Write(buf.Bytes())
+	} else {
+		err = os.WriteFile(output, buf.Bytes(), 0644)
+	}
+	if err != nil {
+		fatal("%s", err)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+)
+
+/*
+Attributes come from .gitattributes files in the working tree and from .git/info/attributes.
+Each line is a pattern followed by attributes: "name" sets one, "-name" unsets it, "!name" makes
+it unspecified again and "name=value" gives it a value. Patterns match like gitignore patterns,
+minus negation. For a given path, rules in deeper directories win over shallower ones, within a
+file the last matching line wins, and .git/info/attributes overrides them all.
+*/
+
+const (
+	attrSet   = "set"
+	attrUnset = "unset"
+)
+
+type attributeRule struct {
+	base  string //directory the rule is relative to
+	regex *regexp.Regexp
+	attrs [][2]string //name and value; a "" value makes the attribute unspecified
+}
+
+// builtinMacros are the attribute macros git predefines
+var builtinMacros = map[string][]string{
+	"binary": {"-diff", "-merge", "-text"},
+}
+
+func readAttributesFile(filename string, base string) []attributeRule {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+	return parseAttributesFile(contents, base)
+}
+
+// parseAttributesFile reads the rules of a .gitattributes file in directory base
+func parseAttributesFile(contents []byte, base string) []attributeRule {
+	var rules []attributeRule
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "!") {
+			continue //negative patterns are not allowed in attributes files
+		}
+		rule := attributeRule{base: base, regex: compileIgnoreGlob(fields[0])}
+		for _, field := range fields[1:] {
+			rule.attrs = append(rule.attrs, parseAttribute(field))
+			for _, expanded := range builtinMacros[field] {
+				rule.attrs = append(rule.attrs, parseAttribute(expanded))
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func parseAttribute(field string) [2]string {
+	switch {
+	case strings.HasPrefix(field, "-"):
+		return [2]string{field[1:], attrUnset}
+	case strings.HasPrefix(field, "!"):
+		return [2]string{field[1:], ""}
+	}
+	if name, value, ok := strings.Cut(field, "="); ok {
+		return [2]string{name, value}
+	}
+	return [2]string{field, attrSet}
+}
+
+// attributeRules returns the rules that can apply to filePath, lowest precedence first
+func attributeRules(filePath string) []attributeRule {
+	return attributeRulesFrom(filePath, worktreeAttributeRules)
+}
+
+// worktreeAttributeRules reads the .gitattributes file of a working tree directory
+func worktreeAttributeRules(dir string) []attributeRule {
+	return readAttributesFile(path.Join(dir, ".gitattributes"), dir)
+}
+
+// attributeRulesFrom returns the rules that can apply to filePath, lowest precedence first,
+// with dirRules giving the rules of each directory's .gitattributes
+func attributeRulesFrom(filePath string, dirRules func(dir string) []attributeRule) []attributeRule {
+	rules := dirRules("")
+	parts := strings.Split(filePath, "/")
+	for i := 1; i < len(parts); i++ {
+		rules = append(rules, dirRules(strings.Join(parts[:i], "/"))...)
+	}
+	return append(rules, readAttributesFile(path.Join(".git", "info", "attributes"), "")...)
+}
+
+func (rule attributeRule) matches(filePath string) bool {
+	rel := filePath
+	if rule.base != "" {
+		rel = strings.TrimPrefix(filePath, rule.base+"/")
+	}
+	return rule.regex.MatchString(rel)
+}
+
+// pathAttributes returns the attributes specified for filePath, a path relative to the top of
+// the working tree. Attributes left unspecified are absent.
+func pathAttributes(filePath string) map[string]string {
+	return matchAttributes(filePath, attributeRules(filePath))
+}
+
+// matchAttributes applies the rules that match filePath in order
+func matchAttributes(filePath string, rules []attributeRule) map[string]string {
+	attrs := map[string]string{}
+	for _, rule := range rules {
+		if !rule.matches(filePath) {
+			continue
+		}
+		for _, attr := range rule.attrs {
+			if attr[1] == "" {
+				delete(attrs, attr[0])
+			} else {
+				attrs[attr[0]] = attr[1]
+			}
+		}
+	}
+	return attrs
+}
+
+// cleanFilter runs contents through the clean command of the filter driver filePath's
+// "filter" attribute names, turning it into what should be stored in the repository. Without a
+// driver, or with one that has no clean command, contents are stored as they are. A failing
+// filter is an error only when filter.<driver>.required is set; otherwise the unfiltered
+// contents are used.
+func cleanFilter(filePath string, contents []byte) ([]byte, error) {
+	driver := pathAttributes(filePath)["filter"]
+	if driver == "" || driver == attrSet || driver == attrUnset {
+		return contents, nil
+	}
+	command, ok := configGet("filter." + driver + ".clean")
+	required := configBool("filter."+driver+".required", false)
+	if !ok || command == "" {
+		if required {
+			return nil, fmt.Errorf("%s: clean filter '%s' failed", filePath, driver)
+		}
+		return contents, nil
+	}
+
+	// %f is the path being filtered, quoted for the shell
+	command = strings.ReplaceAll(command, "%f", "'"+strings.ReplaceAll(filePath, "'", `'\''`)+"'")
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(contents)
+	cmd.Stderr = os.Stderr
+	cleaned, err := cmd.Output()
+	if err != nil {
+		if required {
+			return nil, fmt.Errorf("%s: clean filter '%s' failed", filePath, driver)
+		}
+		fmt.Fprintf(os.Stderr, "error: external filter '%s' failed\n", command)
+		return contents, nil
+	}
+	return cleaned, nil
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+/*
+Bisect state lives in .git:
+- BISECT_START: the branch (or detached SHA) we started from, restored by reset
+- BISECT_LOG: every command issued so far, in a form `bisect replay` can run again
+- refs/bisect/bad and refs/bisect/good-<sha>: the marks
+*/
+
+const bisectStartPath = ".git/BISECT_START"
+const bisectLogPath = ".git/BISECT_LOG"
+
+// Usage:
+//
+//	mygit bisect start [<bad> [<good>...]]
+//	mygit bisect (good|bad) [<rev>...]
+//	mygit bisect (reset|log|visualize)
+//	mygit bisect replay <logfile>
+func cmdBisect(args []string) {
+	usage := "usage: mygit bisect (start|good|bad|reset|log|replay|visualize) [<args>...]\n"
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+
+	var err error
+	switch args[0] {
+	case "start":
+		err = bisectStart(args[1:])
+	case "good", "bad":
+		err = bisectMark(args[0], args[1:])
+	case "reset":
+		err = bisectReset()
+	case "log":
+		err = bisectShowLog()
+	case "replay":
+		if len(args) != 2 {
+			fmt.Fprintf(os.Stderr, "usage: mygit bisect replay <logfile>\n")
+			os.Exit(1)
+		}
+		err = bisectReplay(args[1])
+	case "visualize", "view":
+		err = bisectVisualize()
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func isBisecting() bool {
+	_, err := os.Stat(bisectStartPath)
+	return err == nil
+}
+
+func appendBisectLog(lines ...string) error {
+	f, err := os.OpenFile(bisectLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(f, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func bisectStart(revs []string) error {
+	if isBisecting() {
+		if err := bisectReset(); err != nil {
+			return err
+		}
+	}
+
+	// remember where we started so reset can go back there
+	start, err := currentBranch()
+	if err != nil {
+		return err
+	}
+	if start == "" {
+		if start, err = headCommit(); err != nil {
+			return err
+		}
+	}
+	if start == "" {
+		return fmt.Errorf("cannot bisect on an unborn branch")
+	}
+	if err := os.WriteFile(bisectStartPath, []byte(start+"\n"), 0644); err != nil {
+		return err
+	}
+	os.Remove(bisectLogPath)
+	if err := appendBisectLog("git bisect start"); err != nil {
+		return err
+	}
+
+	// mygit bisect start <bad> [<good>...]
+	for i, rev := range revs {
+		term := "good"
+		if i == 0 {
+			term = "bad"
+		}
+		if err := bisectMark(term, []string{rev}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func bisectMark(term string, revs []string) error {
+	if !isBisecting() {
+		return fmt.Errorf("you need to start by \"mygit bisect start\"")
+	}
+	if len(revs) == 0 {
+		revs = []string{"HEAD"}
+	}
+	if term == "bad" && len(revs) > 1 {
+		return fmt.Errorf("'bisect bad' can take only one argument")
+	}
+
+	for _, rev := range revs {
+		sha, err := resolveObjectArg(rev)
+		if err != nil {
+			return err
+		}
+		commit, err := readCommit(sha)
+		if err != nil {
+			return err
+		}
+		ref := path.Join("refs", "bisect", "bad")
+		if term == "good" {
+			ref = path.Join("refs", "bisect", "good-"+sha)
+		}
+		if err := updateRef(ref, sha, ""); err != nil {
+			return err
+		}
+		if err := appendBisectLog(
+			fmt.Sprintf("# %s: [%s] %s", term, sha, commit.Subject()),
+			fmt.Sprintf("git bisect %s %s", term, sha),
+		); err != nil {
+			return err
+		}
+	}
+	return bisectNext()
+}
+
+// bisectCandidates returns the commits that could still be the first bad one, newest first
+func bisectCandidates() ([]string, map[string]*Commit, error) {
+	bad, err := readRef(path.Join("refs", "bisect", "bad"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("no bad commit marked yet")
+	}
+	var goods []string
+	entries, _ := os.ReadDir(path.Join(".git", "refs", "bisect"))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "good-") {
+			goods = append(goods, strings.TrimPrefix(entry.Name(), "good-"))
+		}
+	}
+
+	suspects, err := ancestors([]string{bad})
+	if err != nil {
+		return nil, nil, err
+	}
+	cleared, err := ancestors(goods)
+	if err != nil {
+		return nil, nil, err
+	}
+	var candidates []string
+	for sha := range suspects {
+		if _, ok := cleared[sha]; !ok {
+			candidates = append(candidates, sha)
+		}
+	}
+	sortByDate(candidates, suspects)
+	return candidates, suspects, nil
+}
+
+// bisectNext checks out the commit that best halves the remaining candidates
+func bisectNext() error {
+	_, badErr := readRef(path.Join("refs", "bisect", "bad"))
+	marks, _ := os.ReadDir(path.Join(".git", "refs", "bisect"))
+	if badErr != nil || len(marks) < 2 {
+		fmt.Println("status: waiting for both good and bad commits")
+		return nil
+	}
+
+	candidates, commits, err := bisectCandidates()
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("some good revs are not ancestors of the bad rev")
+	}
+	if len(candidates) == 1 {
+		sha := candidates[0]
+		commit := commits[sha]
+		fmt.Printf("%s is the first bad commit\n", sha)
+		fmt.Printf("commit %s\nAuthor: %s\n\n", sha, signatureIdentity(commit.Author))
+		for _, line := range strings.Split(strings.TrimRight(commit.Message, "\n"), "\n") {
+			fmt.Printf("    %s\n", line)
+		}
+		return appendBisectLog(fmt.Sprintf("# first bad commit: [%s] %s", sha, commit.Subject()))
+	}
+
+	inRange := map[string]bool{}
+	for _, sha := range candidates {
+		inRange[sha] = true
+	}
+	best, bestScore := "", -1
+	for _, sha := range candidates {
+		reach, err := ancestors([]string{sha})
+		if err != nil {
+			return err
+		}
+		count := 0
+		for ancestor := range reach {
+			if inRange[ancestor] {
+				count++
+			}
+		}
+		score := count
+		if len(candidates)-count < score {
+			score = len(candidates) - count
+		}
+		if score > bestScore {
+			best, bestScore = sha, score
+		}
+	}
+
+	headSha, err := headCommit()
+	if err != nil {
+		return err
+	}
+	if err := checkoutCommit(headSha, best); err != nil {
+		return err
+	}
+	if err := detachHead(best, "checkout: moving from "+headDescription()+" to "+best); err != nil {
+		return err
+	}
+
+	left := len(candidates) / 2
+	steps := 0
+	for n := left; n > 0; n /= 2 {
+		steps++
+	}
+	fmt.Printf("Bisecting: %d revisions left to test after this (roughly %d steps)\n", left, steps)
+	fmt.Printf("[%s] %s\n", best, commits[best].Subject())
+	return nil
+}
+
+// checkoutCommit updates the working tree and index from one commit to another
+func checkoutCommit(fromSha string, toSha string) error {
+	fromTree, err := commitTree(fromSha)
+	if err != nil {
+		return err
+	}
+	toTree, err := commitTree(toSha)
+	if err != nil {
+		return err
+	}
+	return checkoutTree(fromTree, toTree, false)
+}
+
+func bisectReset() error {
+	contents, err := os.ReadFile(bisectStartPath)
+	if os.IsNotExist(err) {
+		fmt.Println("We are not bisecting.")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	start := strings.TrimSpace(string(contents))
+
+	headSha, err := headCommit()
+	if err != nil {
+		return err
+	}
+	startSha := start
+	if branchExists(start) {
+		if startSha, err = readRef(path.Join("refs", "heads", start)); err != nil {
+			return err
+		}
+	}
+	if err := checkoutCommit(headSha, startSha); err != nil {
+		return err
+	}
+	message := "checkout: moving from " + headDescription() + " to " + start
+	if startSha == start {
+		err = detachHead(start, message)
+	} else {
+		err = setHead(start, message)
+	}
+	if err != nil {
+		return err
+	}
+
+	os.Remove(bisectStartPath)
+	os.Remove(bisectLogPath)
+	return os.RemoveAll(path.Join(".git", "refs", "bisect"))
+}
+
+func bisectShowLog() error {
+	contents, err := os.ReadFile(bisectLogPath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("we are not bisecting")
+	} else if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(contents)
+	return err
+}
+
+// bisectReplay starts over and re-runs every "git bisect ..." line from a saved log
+func bisectReplay(logFile string) error {
+	f, err := os.Open(logFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if isBisecting() {
+		if err := bisectReset(); err != nil {
+			return err
+		}
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if (fields[0] != "git" && fields[0] != "mygit") || fields[1] != "bisect" {
+			continue
+		}
+		var revs []string
+		for _, rev := range fields[3:] {
+			revs = append(revs, strings.Trim(rev, "'"))
+		}
+		switch fields[2] {
+		case "start":
+			err = bisectStart(revs)
+		case "good", "bad":
+			err = bisectMark(fields[2], revs)
+		default:
+			err = fmt.Errorf("unsupported bisect command in log: %s", fields[2])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// bisectVisualize lists the commits still in the running, one per line
+func bisectVisualize() error {
+	if !isBisecting() {
+		return fmt.Errorf("we are not bisecting")
+	}
+	candidates, commits, err := bisectCandidates()
+	if err != nil {
+		return err
+	}
+	for _, sha := range candidates {
+		fmt.Printf("%s %s\n", sha[:7], commits[sha].Subject())
+	}
+	return nil
+}
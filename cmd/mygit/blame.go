@@ -0,0 +1,534 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+/*
+Blame starts with every line of the file suspected to come from the starting commit. Each commit
+taken off the queue (newest first) is compared with its parents: lines that are also in a
+parent's version of the file pass to that parent, and whatever no parent has is blamed on the
+commit. With -M, lines that moved within the file pass too; with -C, so do lines copied from
+other files the commit changed.
+*/
+
+type blameOptions struct {
+	ignoreWhitespace bool //-w
+	detectMoves      bool //-M
+	detectCopies     bool //-C
+}
+
+// blameLine is who a line of the final file is blamed on
+type blameLine struct {
+	sha      string //zeroSHA for changes not committed yet
+	path     string //the file's name in that commit
+	origLine int    //1-based line number in that commit's version
+}
+
+// blameSuspect is a line of the final file still being traced, at its index in one version
+type blameSuspect struct {
+	final int
+	line  int
+}
+
+type blamer struct {
+	options blameOptions
+	commits map[string]*Commit
+	files   map[string]map[string]treeEntry //commit -> its tree, flattened
+	pending map[string]map[string][]blameSuspect
+	queue   *commitQueue
+	result  []blameLine
+}
+
+// Usage: mygit blame [-w] [-M] [-C] [-L <start>,<end>] [<rev>] [--] <file>
+func cmdBlame(args []string) {
+	rev, filePath, ranges, options := parseBlameArgs("blame", args)
+	lines, result := runBlame(rev, filePath, ranges, options)
+	writeBlame(lines, result, ranges, filePath)
+}
+
+// Usage: mygit annotate [-w] [-M] [-C] [-L <start>,<end>] [<rev>] [--] <file>
+//
+// The same as blame, but one "<sha>\t(<author>\t<date>\t<line>)\t<content>" line per line, with
+// full SHAs, for scripts.
+func cmdAnnotate(args []string) {
+	rev, filePath, ranges, options := parseBlameArgs("annotate", args)
+	lines, result := runBlame(rev, filePath, ranges, options)
+	writeAnnotate(lines, result, ranges)
+}
+
+// lineRange is a 1-based, inclusive range of lines from -L
+type lineRange struct {
+	start, end int
+}
+
+func parseBlameArgs(command string, args []string) (string, string, []lineRange, blameOptions) {
+	usage := fmt.Sprintf("usage: mygit %s [-w] [-M] [-C] [-L <start>,<end>] [<rev>] [--] <file>\n", command)
+	var options blameOptions
+	var ranges []lineRange
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--":
+			positional = append(positional, args[i+1:]...)
+			i = len(args)
+		case arg == "-w":
+			options.ignoreWhitespace = true
+		case arg == "-M":
+			options.detectMoves = true
+		case arg == "-C":
+			options.detectCopies = true
+			options.detectMoves = true //as in git, looking across files implies looking within one
+		case arg == "-L" && i+1 < len(args), strings.HasPrefix(arg, "-L") && len(arg) > 2:
+			spec := strings.TrimPrefix(arg, "-L")
+			if spec == "" {
+				i++
+				spec = args[i]
+			}
+			r, ok := parseLineRange(spec)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "fatal: invalid -L range '%s'\n", spec)
+				os.Exit(128)
+			}
+			ranges = append(ranges, r)
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	switch len(positional) {
+	case 1:
+		return "", repoPath(positional[0]), ranges, options
+	case 2:
+		return positional[0], repoPath(positional[1]), ranges, options
+	}
+	fmt.Fprint(os.Stderr, usage)
+	os.Exit(1)
+	return "", "", nil, options
+}
+
+// parseLineRange parses "<start>,<end>", "<start>,+<count>", "<start>,-<count>" or "<start>"
+// (to the end of the file); end is 0 for the end of the file
+func parseLineRange(spec string) (lineRange, bool) {
+	from, to, hasTo := strings.Cut(spec, ",")
+	start, err := strconv.Atoi(from)
+	if err != nil || start < 1 {
+		return lineRange{}, false
+	}
+	if !hasTo || to == "" {
+		return lineRange{start, 0}, true
+	}
+	count, err := strconv.Atoi(strings.TrimLeft(to, "+-"))
+	if err != nil {
+		return lineRange{}, false
+	}
+	switch to[0] {
+	case '+':
+		return lineRange{start, start + count - 1}, count > 0
+	case '-':
+		if count <= 0 {
+			return lineRange{}, false
+		}
+		if count > start {
+			count = start
+		}
+		return lineRange{start - count + 1, start}, true
+	}
+	if count < start {
+		return lineRange{count, start}, true
+	}
+	return lineRange{start, count}, true
+}
+
+// runBlame blames every line of a file, as of rev or, when rev is "", as in the working tree
+func runBlame(rev string, filePath string, ranges []lineRange, options blameOptions) ([]string, []blameLine) {
+	b := &blamer{
+		options: options,
+		commits: map[string]*Commit{},
+		files:   map[string]map[string]treeEntry{},
+		pending: map[string]map[string][]blameSuspect{},
+	}
+	b.queue = &commitQueue{commits: b.commits}
+
+	start := rev
+	if start == "" {
+		start = "HEAD"
+	}
+	sha, err := resolveObjectArg(start)
+	if err == nil {
+		sha, err = peelToCommit(sha)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: bad revision '%s'\n", start)
+		os.Exit(128)
+	}
+	files, err := b.commitFiles(sha)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading tree: %s\n", err)
+		os.Exit(1)
+	}
+
+	var contents []byte
+	if rev == "" {
+		contents, _, err = readWorktreeFile(filePath)
+		if err != nil && files[filePath].sha == "" {
+			fmt.Fprintf(os.Stderr, "fatal: no such path '%s' in HEAD\n", filePath)
+			os.Exit(128)
+		}
+	} else if entry, ok := files[filePath]; ok {
+		_, contents, err = parseObject(entry.sha)
+	} else {
+		fmt.Fprintf(os.Stderr, "fatal: no such path %s in %s\n", filePath, rev)
+		os.Exit(128)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: cannot read %s: %s\n", filePath, err)
+		os.Exit(128)
+	}
+	lines := splitLines(contents)
+	for _, r := range ranges {
+		if r.start > len(lines) || r.end > len(lines) {
+			fmt.Fprintf(os.Stderr, "fatal: file %s has only %d lines\n", filePath, len(lines))
+			os.Exit(128)
+		}
+	}
+
+	b.result = make([]blameLine, len(lines))
+	suspects := make([]blameSuspect, len(lines))
+	for i := range lines {
+		suspects[i] = blameSuspect{final: i, line: i}
+	}
+	if rev == "" {
+		// lines the working tree changed are nobody's yet; the rest start with HEAD
+		remaining, err := b.passTo(sha, nil, filePath, suspects, lines)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %s\n", filePath, err)
+			os.Exit(1)
+		}
+		for _, s := range remaining {
+			b.result[s.final] = blameLine{sha: zeroSHA, path: filePath, origLine: s.line + 1}
+		}
+	} else {
+		b.suspect(sha, filePath, suspects)
+	}
+
+	for b.queue.Len() > 0 {
+		if err := b.blameCommit(heap.Pop(b.queue).(string)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error walking history: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	return lines, b.result
+}
+
+func (b *blamer) readCommit(sha string) (*Commit, error) {
+	if commit, ok := b.commits[sha]; ok {
+		return commit, nil
+	}
+	commit, err := readCommit(sha)
+	if err != nil {
+		return nil, err
+	}
+	b.commits[sha] = commit
+	return commit, nil
+}
+
+func (b *blamer) commitFiles(sha string) (map[string]treeEntry, error) {
+	if files, ok := b.files[sha]; ok {
+		return files, nil
+	}
+	commit, err := b.readCommit(sha)
+	if err != nil {
+		return nil, err
+	}
+	files, err := treeFiles(commit.Tree)
+	if err != nil {
+		return nil, err
+	}
+	b.files[sha] = files
+	return files, nil
+}
+
+// suspect queues lines to be traced further back from a commit's version of a file. The commit
+// must already be loaded, as the queue orders by its date.
+func (b *blamer) suspect(sha string, filePath string, suspects []blameSuspect) {
+	if len(suspects) == 0 {
+		return
+	}
+	if _, queued := b.pending[sha]; !queued {
+		b.pending[sha] = map[string][]blameSuspect{}
+		heap.Push(b.queue, sha)
+	}
+	b.pending[sha][filePath] = append(b.pending[sha][filePath], suspects...)
+}
+
+// blameCommit passes what it can of a commit's suspect lines to its parents, and takes the
+// blame for the rest
+func (b *blamer) blameCommit(sha string) error {
+	commit, err := b.readCommit(sha)
+	if err != nil {
+		return err
+	}
+	files, err := b.commitFiles(sha)
+	if err != nil {
+		return err
+	}
+	for filePath, suspects := range b.pending[sha] {
+		_, contents, err := parseObject(files[filePath].sha)
+		if err != nil {
+			return err
+		}
+		lines := splitLines(contents)
+		for _, parent := range commit.Parents {
+			if suspects, err = b.passTo(parent, files, filePath, suspects, lines); err != nil {
+				return err
+			}
+		}
+		for _, s := range suspects {
+			b.result[s.final] = blameLine{sha: sha, path: filePath, origLine: s.line + 1}
+		}
+	}
+	delete(b.pending, sha)
+	return nil
+}
+
+// passTo hands the suspect lines the parent also has over to it, returning those it doesn't.
+// files is the child's tree, or nil when the lines are from the working tree.
+func (b *blamer) passTo(parent string, files map[string]treeEntry, filePath string, suspects []blameSuspect, lines []string) ([]blameSuspect, error) {
+	parentFiles, err := b.commitFiles(parent)
+	if err != nil {
+		return nil, err
+	}
+	parentPath := filePath
+	entry, ok := parentFiles[filePath]
+	if !ok {
+		if parentPath, ok = b.findRename(parent, lines); !ok {
+			return suspects, nil
+		}
+		entry = parentFiles[parentPath]
+	}
+	_, contents, err := parseObject(entry.sha)
+	if err != nil {
+		return nil, err
+	}
+	parentLines := splitLines(contents)
+
+	// map each line of this version to the parent's copy of it
+	mapped := map[int]int{}
+	used := map[int]bool{}
+	for _, m := range matchLines(b.normalize(parentLines), b.normalize(lines)) {
+		mapped[m.b] = m.a
+		used[m.a] = true
+	}
+	if b.options.detectMoves {
+		b.matchMoved(lines, parentLines, mapped, used)
+	}
+	var passed, remaining []blameSuspect
+	for _, s := range suspects {
+		if line, ok := mapped[s.line]; ok {
+			passed = append(passed, blameSuspect{final: s.final, line: line})
+		} else {
+			remaining = append(remaining, s)
+		}
+	}
+	b.suspect(parent, parentPath, passed)
+
+	if b.options.detectCopies && len(remaining) > 0 {
+		return b.passCopies(parent, files, parentPath, remaining, lines)
+	}
+	return remaining, nil
+}
+
+// matchMoved maps lines the diff left unmatched to an unused identical line elsewhere in the
+// parent's version, so a moved block keeps its history
+func (b *blamer) matchMoved(lines []string, parentLines []string, mapped map[int]int, used map[int]bool) {
+	where := map[string][]int{}
+	normalized := b.normalize(parentLines)
+	for i, line := range normalized {
+		if !used[i] && strings.TrimSpace(line) != "" {
+			where[line] = append(where[line], i)
+		}
+	}
+	for i, line := range b.normalize(lines) {
+		if _, ok := mapped[i]; ok || len(where[line]) == 0 {
+			continue
+		}
+		mapped[i] = where[line][0]
+		where[line] = where[line][1:]
+	}
+}
+
+// passCopies looks for the remaining lines in the parent's versions of the other files the
+// child changed, and hands any found over to the parent under that file's name
+func (b *blamer) passCopies(parent string, files map[string]treeEntry, skip string, suspects []blameSuspect, lines []string) ([]blameSuspect, error) {
+	parentFiles, err := b.commitFiles(parent)
+	if err != nil {
+		return nil, err
+	}
+	normalized := b.normalize(lines)
+	for otherPath, other := range parentFiles {
+		if otherPath == skip || len(suspects) == 0 {
+			continue
+		}
+		if child, ok := files[otherPath]; ok && child.sha == other.sha {
+			continue //unchanged by the commit, so not where its new lines were copied from
+		}
+		_, contents, err := parseObject(other.sha)
+		if err != nil {
+			return nil, err
+		}
+		where := map[string][]int{}
+		for i, line := range b.normalize(splitLines(contents)) {
+			if strings.TrimSpace(line) != "" {
+				where[line] = append(where[line], i)
+			}
+		}
+		var passed, remaining []blameSuspect
+		for _, s := range suspects {
+			if found := where[normalized[s.line]]; len(found) > 0 {
+				passed = append(passed, blameSuspect{final: s.final, line: found[0]})
+				where[normalized[s.line]] = found[1:]
+			} else {
+				remaining = append(remaining, s)
+			}
+		}
+		b.suspect(parent, otherPath, passed)
+		suspects = remaining
+	}
+	return suspects, nil
+}
+
+// findRename looks in the parent for the file this one was renamed from: a file with exactly
+// the same contents, or failing that the one sharing the most lines (at least half)
+func (b *blamer) findRename(parent string, lines []string) (string, bool) {
+	parentFiles, err := b.commitFiles(parent)
+	if err != nil {
+		return "", false
+	}
+	sha := hashObject("blob", []byte(strings.Join(lines, "")))
+	best, bestCount := "", 0
+	for filePath, entry := range parentFiles {
+		if entry.sha == sha {
+			return filePath, true
+		}
+	}
+	for filePath, entry := range parentFiles {
+		_, contents, err := parseObject(entry.sha)
+		if err != nil || isBinary(contents) {
+			continue
+		}
+		if count := len(matchLines(splitLines(contents), lines)); count > bestCount {
+			best, bestCount = filePath, count
+		}
+	}
+	return best, bestCount > 0 && bestCount*2 >= len(lines)
+}
+
+// normalize prepares lines for comparison; with -w, whitespace doesn't count
+func (b *blamer) normalize(lines []string) []string {
+	if !b.options.ignoreWhitespace {
+		return lines
+	}
+	normalized := make([]string, len(lines))
+	for i, line := range lines {
+		normalized[i] = strings.Map(func(r rune) rune {
+			if unicode.IsSpace(r) {
+				return -1
+			}
+			return r
+		}, line)
+	}
+	return normalized
+}
+
+func inRanges(line int, ranges []lineRange) bool {
+	if len(ranges) == 0 {
+		return true
+	}
+	for _, r := range ranges {
+		if line >= r.start && (r.end == 0 || line <= r.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// blameAuthor returns the name and date shown for a blamed line
+func blameAuthor(line blameLine, commits map[string]*Commit, mm *mailmap) (string, string) {
+	const dateFormat = "2006-01-02 15:04:05 -0700"
+	if line.sha == zeroSHA {
+		return "Not Committed Yet", time.Now().Format(dateFormat)
+	}
+	author := mm.mapSignature(commits[line.sha].Author)
+	name := strings.TrimSpace(author[:strings.IndexByte(author, '<')])
+	return name, signatureTime(author).Format(dateFormat)
+}
+
+func blameCommits(result []blameLine) map[string]*Commit {
+	commits := map[string]*Commit{}
+	for _, line := range result {
+		if _, ok := commits[line.sha]; !ok && line.sha != zeroSHA {
+			commits[line.sha], _ = readCommit(line.sha)
+		}
+	}
+	return commits
+}
+
+// writeBlame prints git blame's default format. Root commits are marked with "^", and file names
+// are shown when some lines come from a file by another name.
+func writeBlame(lines []string, result []blameLine, ranges []lineRange, filePath string) {
+	commits := blameCommits(result)
+	mm := readMailmap()
+	nameWidth, pathWidth, numberWidth := 0, 0, 0
+	showPath := false
+	for i, line := range result {
+		if !inRanges(i+1, ranges) {
+			continue
+		}
+		name, _ := blameAuthor(line, commits, mm)
+		if n := len([]rune(name)); n > nameWidth {
+			nameWidth = n
+		}
+		if len(line.path) > pathWidth {
+			pathWidth = len(line.path)
+		}
+		showPath = showPath || line.path != filePath
+		numberWidth = len(strconv.Itoa(i + 1))
+	}
+
+	for i, line := range result {
+		if !inRanges(i+1, ranges) {
+			continue
+		}
+		sha := line.sha[:8]
+		if commit := commits[line.sha]; commit != nil && len(commit.Parents) == 0 {
+			sha = "^" + line.sha[:7]
+		}
+		if showPath {
+			sha += fmt.Sprintf(" %-*s", pathWidth, line.path)
+		}
+		name, date := blameAuthor(line, commits, mm)
+		fmt.Printf("%s (%-*s %s %*d) %s\n", sha, nameWidth, name, date, numberWidth, i+1, strings.TrimSuffix(lines[i], "\n"))
+	}
+}
+
+// writeAnnotate prints one tab-separated line per line of the file, with full SHAs
+func writeAnnotate(lines []string, result []blameLine, ranges []lineRange) {
+	commits := blameCommits(result)
+	mm := readMailmap()
+	for i, line := range result {
+		if !inRanges(i+1, ranges) {
+			continue
+		}
+		name, date := blameAuthor(line, commits, mm)
+		fmt.Printf("%s\t(%s\t%s\t%d)\t%s\n", line.sha, name, date, i+1, strings.TrimSuffix(lines[i], "\n"))
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// branchMerged reports whether a branch can be deleted without losing commits: whether it is
+// merged into its upstream, if it has one, or else into HEAD. Like git, it warns when the
+// upstream has the branch but HEAD doesn't.
+func branchMerged(branch string, sha string, head string) (bool, error) {
+	reference, referenceName := head, ""
+	if remote, mergeRef, ok := branchUpstream(branch); ok {
+		if tracking := trackingRef(remote, mergeRef); tracking != "" {
+			if upstream, err := readRef(tracking); err == nil {
+				reference, referenceName = upstream, tracking
+			}
+		}
+	}
+	if reference == "" {
+		return false, nil
+	}
+	merged, err := isAncestor(sha, reference)
+	if err != nil || !merged || referenceName == "" || head == "" {
+		return merged, err
+	}
+	if inHead, err := isAncestor(sha, head); err != nil {
+		return false, err
+	} else if !inHead {
+		fmt.Fprintf(os.Stderr, "warning: deleting branch '%s' that has been merged to\n         '%s', but not yet merged to HEAD.\n", branch, referenceName)
+	}
+	return true, nil
+}
+
+// deleteBranches deletes branches, along with their config, refusing the current branch and,
+// unless force is set, any branch with commits not merged anywhere. It reports whether every
+// branch was deleted.
+func deleteBranches(names []string, force bool) (bool, error) {
+	current, err := currentBranch()
+	if err != nil {
+		return false, err
+	}
+	head, err := headCommit()
+	if err != nil {
+		return false, err
+	}
+	ok := true
+	for _, name := range names {
+		ref := path.Join("refs", "heads", name)
+		if name == current {
+			top, err := filepath.Abs(".")
+			if err != nil {
+				return false, err
+			}
+			fmt.Fprintf(os.Stderr, "error: Cannot delete branch '%s' checked out at '%s'\n", name, top)
+			ok = false
+			continue
+		}
+		sha, err := readRef(ref)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: branch '%s' not found.\n", name)
+			ok = false
+			continue
+		}
+		if !force {
+			merged, err := branchMerged(name, sha, head)
+			if err != nil {
+				return false, err
+			}
+			if !merged {
+				fmt.Fprintf(os.Stderr, "error: The branch '%s' is not fully merged.\nIf you are sure you want to delete it, run 'mygit branch -D %s'.\n", name, name)
+				ok = false
+				continue
+			}
+		}
+		if err := deleteRef(ref); err != nil {
+			return false, err
+		}
+		if err := configRemoveSection("branch." + name); err != nil {
+			return false, err
+		}
+		fmt.Printf("Deleted branch %s (was %s).\n", name, sha[:7])
+	}
+	return ok, nil
+}
+
+// branchPatternMatch reports whether a branch's name matches one of the glob patterns, or
+// there are none
+func branchPatternMatch(branch string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, branch); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Usage:
+//
+//	mygit branch [--list] [--merged [<commit>]] [--no-merged [<commit>]] [--contains [<commit>]] [<pattern>...]
+//	mygit branch <branch> [<start-point>]
+//	mygit branch (-d | -D) <branch>...
+//
+// Lists the local branches, marking the current one, or a detached HEAD, with "*". Given a
+// name, creates a branch there pointing at <start-point>, HEAD by default, without switching
+// to it. -d deletes branches that are merged into their upstream, or into HEAD when they
+// have none; -D deletes them regardless.
+//
+// --merged lists only the branches reachable from the commit, HEAD by default, --no-merged
+// only those that aren't, and --contains only those that can reach it. With any of them, or
+// --list, names are glob patterns the branches listed must match.
+func cmdBranch(args []string) {
+	usage := "usage: mygit branch [--list] [--merged [<commit>]] [--no-merged [<commit>]] [--contains [<commit>]] [<pattern>...]\n" +
+		"   or: mygit branch <branch> [<start-point>]\n" +
+		"   or: mygit branch (-d | -D) <branch>...\n"
+	listing, deleting, force := false, false, false
+	var filter refFilter
+	var names []string
+	for i := 0; i < len(args); i++ {
+		if n := filter.parseOption(args, i); n > 0 {
+			listing = true
+			i += n - 1
+			continue
+		}
+		arg := args[i]
+		switch arg {
+		case "-l", "--list":
+			listing = true
+		case "-d", "--delete":
+			deleting = true
+		case "-D":
+			deleting, force = true, true
+		case "-f", "--force":
+			force = true
+		case "--":
+			names = append(names, args[i+1:]...)
+			i = len(args)
+		default:
+			if strings.HasPrefix(arg, "-") {
+				fmt.Fprint(os.Stderr, usage)
+				os.Exit(129)
+			}
+			names = append(names, arg)
+		}
+	}
+	fatal := func(err error) {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+
+	switch {
+	case listing && deleting:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(129)
+
+	case deleting:
+		if len(names) == 0 {
+			fatal(fmt.Errorf("branch name required"))
+		}
+		ok, err := deleteBranches(names, force)
+		if err != nil {
+			fatal(err)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+
+	case len(names) > 0 && !listing:
+		if len(names) > 2 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(129)
+		}
+		name, start := names[0], "HEAD"
+		if len(names) == 2 {
+			start = names[1]
+		}
+		if !validBranchName(name) {
+			fatal(fmt.Errorf("'%s' is not a valid branch name", name))
+		}
+		if branchExists(name) && !force {
+			fatal(fmt.Errorf("a branch named '%s' already exists", name))
+		}
+		if current, err := currentBranch(); err == nil && current == name && branchExists(name) {
+			top, err := filepath.Abs(".")
+			if err != nil {
+				fatal(err)
+			}
+			fatal(fmt.Errorf("cannot force update the branch '%s' checked out at '%s'", name, top))
+		}
+		sha, err := resolveObjectArg(start)
+		if err == nil {
+			sha, err = peelToCommit(sha)
+		}
+		if err != nil {
+			if current, _ := currentBranch(); start == "HEAD" && current != "" {
+				start = current //an unborn branch, named as git names it
+			}
+			fatal(fmt.Errorf("not a valid object name: '%s'", start))
+		}
+		message := "branch: Created from " + start
+		if branchExists(name) {
+			message = "branch: Reset to " + start
+		}
+		if err := updateRef(path.Join("refs", "heads", name), sha, message); err != nil {
+			fatal(err)
+		}
+
+	default:
+		if err := filter.prepare(); err != nil {
+			fatal(err)
+		}
+		current, err := currentBranch()
+		if err != nil {
+			fatal(err)
+		}
+		head, err := headCommit()
+		if err != nil {
+			fatal(err)
+		}
+		headShown := current == "" && head != "" && len(names) == 0
+		if headShown {
+			if headShown, err = filter.matches(head); err != nil {
+				fatal(err)
+			}
+		}
+		if headShown {
+			detached, err := detachedStatus(head)
+			if err != nil {
+				fatal(err)
+			}
+			if detached == "Not currently on any branch." {
+				detached = "no branch"
+			}
+			fmt.Printf("* (%s)\n", detached)
+		}
+		refs, err := listRefs("refs/heads")
+		if err != nil {
+			fatal(err)
+		}
+		branches := make([]string, 0, len(refs))
+		for ref, sha := range refs {
+			branch := strings.TrimPrefix(ref, "refs/heads/")
+			if !branchPatternMatch(branch, names) {
+				continue
+			}
+			if ok, err := filter.matches(sha); err != nil {
+				fatal(err)
+			} else if ok {
+				branches = append(branches, branch)
+			}
+		}
+		sort.Strings(branches)
+		for _, branch := range branches {
+			if branch == current {
+				fmt.Printf("* %s\n", branch)
+			} else {
+				fmt.Printf("  %s\n", branch)
+			}
+		}
+	}
+}
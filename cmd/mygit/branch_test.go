@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBranchFilters(t *testing.T) {
+	initTestRepo(t)
+	first := testCommit(t, 0, "first", nil)
+	second := testCommit(t, 100, "second", nil, first)
+	side := testCommit(t, 200, "side", nil, first)
+	for branch, sha := range map[string]string{"master": second, "f1": first, "f2": second, "topic": side} {
+		if err := updateRef("refs/heads/"+branch, sha, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--merged", "f1"}, "  f1\n"},
+		{[]string{"--merged"}, "  f1\n  f2\n* master\n"},
+		{[]string{"--no-merged"}, "  topic\n"},
+		{[]string{"--no-merged", "master"}, "  topic\n"},
+		{[]string{"--contains", "f1"}, "  f1\n  f2\n* master\n  topic\n"},
+		{[]string{"--contains", "topic"}, "  topic\n"},
+		{[]string{"--merged", "master", "f*"}, "  f1\n  f2\n"},
+		{[]string{"--list", "t*"}, "  topic\n"},
+	}
+	for _, test := range tests {
+		t.Run(strings.Join(test.args, " "), func(t *testing.T) {
+			if out := runTestCommand(t, append([]string{"branch"}, test.args...)...); out != test.want {
+				t.Errorf("branch %s:\n%s\nwant:\n%s", strings.Join(test.args, " "), out, test.want)
+			}
+		})
+	}
+	if _, stderr, code := runMygit(t, "branch", "--merged", "nowhere"); code != 128 || !strings.Contains(stderr, "malformed object name nowhere") {
+		t.Errorf("branch --merged nowhere: exit %d, %q; want 128 and a malformed object name", code, stderr)
+	}
+}
+
+func TestBranchListsAndCreates(t *testing.T) {
+	initTestRepo(t)
+	// HEAD is "ref: refs/heads/master" with no commit yet, so there is nothing to list or to
+	// start a branch at
+	if stdout, stderr, code := runMygit(t, "branch"); code != 0 || stdout != "" || stderr != "" {
+		t.Errorf("branch on an unborn branch: exit %d, %q, %q", code, stdout, stderr)
+	}
+	if _, stderr, code := runMygit(t, "branch", "topic"); code != 128 || stderr != "fatal: not a valid object name: 'master'\n" {
+		t.Errorf("branch topic on an unborn branch: exit %d, %q", code, stderr)
+	}
+
+	head := testCommit(t, 0, "first", nil)
+	if err := updateRef("refs/heads/master", head, ""); err != nil {
+		t.Fatal(err)
+	}
+	runTestCommand(t, "branch", "topic")
+	if contents, err := os.ReadFile(filepath.Join(".git", "refs", "heads", "topic")); err != nil || string(contents) != head+"\n" {
+		t.Errorf("refs/heads/topic holds %q (%v), want HEAD's commit", contents, err)
+	}
+	if stdout := runTestCommand(t, "branch"); stdout != "* master\n  topic\n" {
+		t.Errorf("branch listed:\n%s", stdout)
+	}
+	if _, stderr, code := runMygit(t, "branch", "topic"); code != 128 || stderr != "fatal: a branch named 'topic' already exists\n" {
+		t.Errorf("branch topic again: exit %d, %q", code, stderr)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const defaultBatchFormat = "%(objectname) %(objecttype) %(objectsize)"
+
+// Usage: mygit cat-file (--batch|--batch-check|--batch-command)[=<format>] [--buffer] [--follow-symlinks]
+//
+// Reads object names from stdin, one per line, and prints each object's header (formatted with
+// %(objectname), %(objecttype), %(objectsize) and %(rest)) followed, for --batch, by its contents.
+// Output is flushed after every object unless --buffer is given. With --follow-symlinks,
+// <rev>:<path> names follow symlinks inside the tree to the object they point at.
+//
+// With --batch-command each line is a command instead: "info <object>" prints the header,
+// "contents <object>" the header and contents, and "flush" writes out what --buffer has held
+// back so far.
+func cmdCatFileBatch(args []string) {
+	usage := "usage: mygit cat-file (--batch|--batch-check|--batch-command)[=<format>] [--buffer] [--follow-symlinks]\n"
+	contents, format, buffer, followSymlinks := false, "", false, false
+	batchGiven, commands := false, false
+	for _, arg := range args {
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch {
+		case (name == "--batch" || name == "--batch-check" || name == "--batch-command") && !batchGiven:
+			batchGiven = true
+			contents = name == "--batch"
+			commands = name == "--batch-command"
+			format = defaultBatchFormat
+			if hasValue {
+				format = value
+			}
+		case arg == "--buffer":
+			buffer = true
+		case arg == "--follow-symlinks":
+			followSymlinks = true
+		default:
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		}
+	}
+	if !batchGiven {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	splitRest := strings.Contains(format, "%(rest)")
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		if commands {
+			catFileBatchCommand(out, scanner.Text(), format, buffer, followSymlinks)
+			continue
+		}
+		name, rest := scanner.Text(), ""
+		if splitRest {
+			// the object name ends at the first whitespace; what follows is echoed back
+			if i := strings.IndexAny(name, " \t"); i >= 0 {
+				name, rest = name[:i], strings.TrimLeft(name[i:], " \t")
+			}
+		}
+		if err := catFileBatchObject(out, name, rest, format, contents, followSymlinks); err != nil {
+			out.Flush()
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		if !buffer {
+			out.Flush()
+		}
+	}
+}
+
+// catFileBatchCommand runs one line of --batch-command input
+func catFileBatchCommand(out *bufio.Writer, line string, format string, buffer, followSymlinks bool) {
+	fatal := func(format string, a ...any) {
+		out.Flush()
+		fmt.Fprintf(os.Stderr, "fatal: "+format+"\n", a...)
+		os.Exit(128)
+	}
+	command, name, hasArgs := strings.Cut(line, " ")
+	switch {
+	case line == "":
+		fatal("empty command in input")
+	case strings.TrimLeft(line, " \t") != line:
+		fatal("whitespace before command: '%s'", line)
+	case command == "flush":
+		if hasArgs {
+			fatal("flush takes no arguments")
+		}
+		if !buffer {
+			fatal("flush is only for --buffer mode")
+		}
+		out.Flush()
+		return
+	case command != "info" && command != "contents":
+		fatal("unknown command: '%s'", line)
+	case !hasArgs:
+		fatal("%s requires arguments", command)
+	}
+	// the whole argument is the object name; there is no %(rest) to split off
+	if err := catFileBatchObject(out, name, "", format, command == "contents", followSymlinks); err != nil {
+		fatal("%s", err)
+	}
+	if !buffer {
+		out.Flush()
+	}
+}
+
+// catFileBatchObject writes one object's batch output, or why it couldn't be found
+func catFileBatchObject(out *bufio.Writer, name, rest, format string, contents, followSymlinks bool) error {
+	var sha string
+	var err error
+	rev, entryPath, hasPath := strings.Cut(name, ":")
+	if followSymlinks && hasPath {
+		var outside string
+		if sha, outside, err = followSymlinkSpec(rev, entryPath); err == nil && outside != "" {
+			fmt.Fprintf(out, "symlink %d\n%s\n", len(outside), outside)
+			return nil
+		}
+		for _, symlinkErr := range []error{errSymlinkDangling, errSymlinkLoop, errNotDir} {
+			if err == symlinkErr {
+				fmt.Fprintf(out, "%s %d\n%s\n", err, len(name), name)
+				return nil
+			}
+		}
+	} else {
+		sha, err = resolveObjectArg(name)
+	}
+	if err != nil {
+		fmt.Fprintf(out, "%s missing\n", name)
+		return nil
+	}
+
+	objType, payload, err := parseObject(sha)
+	if err != nil {
+		return err
+	}
+	header, err := expandBatchFormat(format, sha, objType, len(payload), rest)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, header)
+	if contents {
+		out.Write(payload)
+		out.WriteByte('\n')
+	}
+	return nil
+}
+
+// followSymlinkSpec resolves <rev>:<path>, following symlinks within rev's tree
+func followSymlinkSpec(rev string, entryPath string) (string, string, error) {
+	sha, err := resolveRevision(rev)
+	if err != nil {
+		return "", "", err
+	}
+	treeSha, err := peelToTree(sha)
+	if err != nil {
+		return "", "", err
+	}
+	entry, outside, err := followTreePath(treeSha, entryPath)
+	return entry.sha, outside, err
+}
+
+func expandBatchFormat(format string, sha string, objType string, size int, rest string) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.Index(format, "%(")
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(format[start:], ')')
+		if end < 0 {
+			break
+		}
+		b.WriteString(format[:start])
+		switch atom := format[start+2 : start+end]; atom {
+		case "objectname":
+			b.WriteString(sha)
+		case "objecttype":
+			b.WriteString(objType)
+		case "objectsize":
+			fmt.Fprint(&b, size)
+		case "rest":
+			b.WriteString(rest)
+		default:
+			return "", errors.New("unknown format element: " + atom)
+		}
+		format = format[start+end+1:]
+	}
+	b.WriteString(format)
+	return b.String(), nil
+}
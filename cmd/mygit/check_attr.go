@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// allAttributes lists the attributes specified for filePath in the order check-attr -a shows
+// them: the builtin macros and what they expand to first, then as the rules mention them
+func allAttributes(filePath string) [][2]string {
+	var order []string
+	seen := map[string]bool{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			order = append(order, name)
+		}
+	}
+	macros := make([]string, 0, len(builtinMacros))
+	for macro := range builtinMacros {
+		macros = append(macros, macro)
+	}
+	sort.Strings(macros)
+	for _, macro := range macros {
+		add(macro)
+		for _, expanded := range builtinMacros[macro] {
+			add(parseAttribute(expanded)[0])
+		}
+	}
+	for _, rule := range attributeRules(filePath) {
+		for _, attr := range rule.attrs {
+			add(attr[0])
+		}
+	}
+
+	attrs := pathAttributes(filePath)
+	var list [][2]string
+	for _, name := range order {
+		if value, ok := attrs[name]; ok {
+			list = append(list, [2]string{name, value})
+		}
+	}
+	return list
+}
+
+// Usage:
+//
+//	mygit check-attr [-z] [-a | <attr>...] [--] <path>...
+//	mygit check-attr --stdin [-z] [-a | <attr>...]
+//
+// Shows the attributes of each path, as the .gitattributes files and .git/info/attributes
+// give them, a "<path>: <attr>: <value>" line per attribute. The value is "set", "unset",
+// "unspecified" or the attribute's value. -a shows every attribute the path has, except
+// unspecified ones. Without "--", the first argument is the attribute and the rest are paths.
+// --stdin reads the paths from stdin, one per line, and -z separates them and the output
+// fields with NULs instead.
+func cmdCheckAttr(args []string) {
+	usage := "usage: mygit check-attr [-z] [-a | <attr>...] [--] <path>...\n" +
+		"   or: mygit check-attr --stdin [-z] [-a | <attr>...]\n"
+	all, stdin, nulTerminated := false, false, false
+	var rest []string
+	dashdash := -1
+	for i, arg := range args {
+		if arg == "--" {
+			dashdash = len(rest)
+			rest = append(rest, args[i+1:]...)
+			break
+		}
+		switch arg {
+		case "-a", "--all":
+			all = true
+		case "--stdin":
+			stdin = true
+		case "-z":
+			nulTerminated = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				fmt.Fprint(os.Stderr, usage)
+				os.Exit(129)
+			}
+			rest = append(rest, arg)
+		}
+	}
+	failUsage := func(message string) {
+		fmt.Fprintf(os.Stderr, "error: %s\n%s", message, usage)
+		os.Exit(129)
+	}
+
+	var attrs, paths []string
+	switch {
+	case dashdash >= 0:
+		attrs, paths = rest[:dashdash], rest[dashdash:]
+	case all || stdin:
+		paths = rest
+		if stdin {
+			attrs, paths = rest, nil
+		}
+	case len(rest) > 0:
+		attrs, paths = rest[:1], rest[1:]
+	}
+	if all && len(attrs) > 0 {
+		failUsage("Attributes and --all both specified")
+	}
+	if !all && len(attrs) == 0 {
+		failUsage("No attribute specified")
+	}
+	if stdin && len(paths) > 0 {
+		failUsage("Can't specify files with --stdin")
+	}
+	if !stdin && len(paths) == 0 {
+		failUsage("No file specified")
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	show := func(filePath string) {
+		var values [][2]string
+		if all {
+			values = allAttributes(repoPath(filePath))
+		} else {
+			specified := pathAttributes(repoPath(filePath))
+			for _, name := range attrs {
+				value, ok := specified[name]
+				if !ok {
+					value = "unspecified"
+				}
+				values = append(values, [2]string{name, value})
+			}
+		}
+		for _, value := range values {
+			if nulTerminated {
+				fmt.Fprintf(out, "%s\x00%s\x00%s\x00", filePath, value[0], value[1])
+			} else {
+				fmt.Fprintf(out, "%s: %s: %s\n", filePath, value[0], value[1])
+			}
+		}
+	}
+	if !stdin {
+		for _, filePath := range paths {
+			show(filePath)
+		}
+		return
+	}
+
+	separator := byte('\n')
+	if nulTerminated {
+		separator = 0
+	}
+	r := bufio.NewReader(os.Stdin)
+	for {
+		line, err := r.ReadString(separator)
+		if line = strings.TrimSuffix(line, string(separator)); line != "" {
+			show(line)
+			out.Flush() //a caller feeding paths one at a time waits for each answer
+		}
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Usage:
+//
+//	mygit check-ignore [-q] [-v [-n]] [--no-index] <path>...
+//	mygit check-ignore [-q] [-v [-n]] [--no-index] --stdin [-z]
+//
+// Prints each path that is ignored, for debugging ignore rules. With -v it prints the rule
+// that decided, as "<source>:<line>:<pattern>\t<path>", including "!" rules that re-include a
+// path; with -n too, paths no rule matched are shown as "::\t<path>". Tracked files are never
+// ignored unless --no-index is given. --stdin reads the paths from stdin, one per line or,
+// with -z, separated by NULs, which -z also uses in the output. -q prints nothing.
+//
+// It exits with 0 when some path was ignored, or matched with -v, and 1 otherwise.
+func cmdCheckIgnore(args []string) {
+	usage := "usage: mygit check-ignore [-q] [-v [-n]] [--no-index] (--stdin [-z] | <path>...)\n"
+	quiet, verbose, nonMatching, stdin, nulTerminated, noIndex := false, false, false, false, false, false
+	var paths []string
+	for i, arg := range args {
+		if arg == "--" {
+			paths = append(paths, args[i+1:]...)
+			break
+		}
+		switch arg {
+		case "-q", "--quiet":
+			quiet = true
+		case "-v", "--verbose":
+			verbose = true
+		case "-n", "--non-matching":
+			nonMatching = true
+		case "--stdin":
+			stdin = true
+		case "-z":
+			nulTerminated = true
+		case "--no-index":
+			noIndex = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				fmt.Fprint(os.Stderr, usage)
+				os.Exit(129)
+			}
+			paths = append(paths, arg)
+		}
+	}
+	fatal := func(message string) {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", message)
+		os.Exit(128)
+	}
+	switch {
+	case stdin && len(paths) > 0:
+		fatal("cannot specify pathnames with --stdin")
+	case nulTerminated && !stdin:
+		fatal("-z only makes sense with --stdin")
+	case !stdin && len(paths) == 0:
+		fatal("no path specified")
+	case quiet && verbose:
+		fatal("cannot have both --quiet and --verbose")
+	case nonMatching && !verbose:
+		fatal("--non-matching is only valid with --verbose")
+	}
+
+	tracked := map[string]bool{}
+	if !noIndex {
+		entries, err := readIndex()
+		if err != nil && !os.IsNotExist(err) {
+			fatal(err.Error())
+		}
+		for _, entry := range entries {
+			tracked[entry.path] = true
+		}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	terminator := "\n"
+	if nulTerminated {
+		terminator = "\x00"
+	}
+	matcher := newIgnoreMatcher()
+	ignored := 0
+	check := func(filePath string) {
+		var p *ignorePattern
+		if topPath := repoPath(strings.TrimSuffix(filePath, "/")); !tracked[topPath] {
+			fi, err := os.Stat(topPath)
+			isDir := strings.HasSuffix(filePath, "/") || (err == nil && fi.IsDir())
+			p = matcher.decidingPattern(topPath, isDir)
+			if p != nil && p.negate && !verbose {
+				p = nil
+			}
+		}
+		if p != nil {
+			ignored++
+		}
+		switch {
+		case quiet, p == nil && !nonMatching:
+		case !verbose:
+			fmt.Fprint(out, filePath+terminator)
+		case p == nil && nulTerminated:
+			fmt.Fprintf(out, "\x00\x00\x00%s\x00", filePath)
+		case p == nil:
+			fmt.Fprintf(out, "::\t%s\n", filePath)
+		case nulTerminated:
+			fmt.Fprintf(out, "%s\x00%d\x00%s\x00%s\x00", p.source, p.line, p.pattern, filePath)
+		default:
+			fmt.Fprintf(out, "%s:%d:%s\t%s\n", p.source, p.line, p.pattern, filePath)
+		}
+	}
+
+	if !stdin {
+		for _, filePath := range paths {
+			check(filePath)
+		}
+	} else {
+		r := bufio.NewReader(os.Stdin)
+		for {
+			line, err := r.ReadString(terminator[0])
+			if line = strings.TrimSuffix(line, terminator); line != "" {
+				check(line)
+				out.Flush() //a caller feeding paths one at a time waits for each answer
+			}
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				fatal(err.Error())
+			}
+		}
+	}
+	if ignored == 0 {
+		out.Flush()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Usage:
+//
+//	mygit checkout [-f] <branch>
+//	mygit checkout [-f] [--detach] <commit>
+//	mygit checkout [-f] (-b | -B) <new-branch> [<start-point>]
+//	mygit checkout --orphan <new-branch>
+//	mygit checkout [-f] [-q] [<tree-ish>] [--] <pathspec>...
+//
+// Switching branches is the same as mygit switch, and -b and -B are switch's -c and -C; a
+// commit that isn't a branch, or any commit with --detach, detaches HEAD there. --orphan
+// starts a branch with no history. Given paths, it restores them from the index, discarding
+// unstaged changes, or from <tree-ish>, which stages them too, and leaves HEAD alone. Without
+// "--", the first argument is a branch or tree-ish when it names one, and a path otherwise.
+func cmdCheckout(args []string) {
+	usage := "usage: mygit checkout [-f] <branch>\n" +
+		"   or: mygit checkout [-f] [--detach] <commit>\n" +
+		"   or: mygit checkout [-f] (-b | -B) <new-branch> [<start-point>]\n" +
+		"   or: mygit checkout --orphan <new-branch>\n" +
+		"   or: mygit checkout [-f] [-q] [<tree-ish>] [--] <pathspec>...\n"
+	var names, pathspecs []string
+	orphan, creating, reset, detach, force, quiet, separated := false, false, false, false, false, false, false
+	for i := 0; i < len(args) && !separated; i++ {
+		switch arg := args[i]; arg {
+		case "-b":
+			creating = true
+		case "-B":
+			creating, reset = true, true
+		case "--orphan":
+			orphan = true
+		case "-d", "--detach":
+			detach = true
+		case "-f", "--force":
+			force = true
+		case "-q", "--quiet":
+			quiet = true
+		case "--":
+			pathspecs, separated = args[i+1:], true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				fmt.Fprint(os.Stderr, usage)
+				os.Exit(1)
+			}
+			names = append(names, arg)
+		}
+	}
+	if orphan && (creating || detach) || creating && detach || (orphan || creating || detach) && separated {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+
+	// sort out which names, if any, are paths
+	source := ""
+	switch {
+	case orphan || creating || detach:
+	case separated:
+		if len(names) > 1 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		}
+		if len(names) == 1 {
+			source = names[0]
+		}
+	case len(names) == 1 && branchExists(names[0]):
+	case len(names) > 0:
+		if sha, err := resolveObjectArg(names[0]); err == nil {
+			if _, err := peelToTree(sha); err == nil {
+				source, pathspecs = names[0], names[1:]
+				break
+			}
+		}
+		pathspecs = names
+	}
+	if len(pathspecs) > 0 {
+		tree := ""
+		if source != "" {
+			sha, err := resolveObjectArg(source)
+			if err == nil {
+				tree, err = peelToTree(sha)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: invalid reference: %s\n", source)
+				os.Exit(128)
+			}
+		}
+		updated, ok, err := checkoutPaths(tree, pathspecs, force)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		if !quiet {
+			from := "the index"
+			if tree != "" {
+				from = tree[:7]
+			}
+			noun := "paths"
+			if updated == 1 {
+				noun = "path"
+			}
+			fmt.Fprintf(os.Stderr, "Updated %d %s from %s\n", updated, noun, from)
+		}
+		return
+	}
+
+	switch {
+	case orphan:
+		if len(names) != 1 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		}
+		if err := checkoutOrphan(names[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		fmt.Fprintf(os.Stderr, "Switched to a new branch '%s'\n", names[0])
+
+	case creating:
+		// -b hands its name to switch -c, which checks it with validBranchName before the
+		// branch is created
+		if len(names) == 0 || len(names) > 2 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		}
+		switchArgs := []string{"-c"}
+		if reset {
+			switchArgs[0] = "-C"
+		}
+		if force {
+			switchArgs = append(switchArgs, "-f")
+		}
+		cmdSwitch(append(switchArgs, names...))
+
+	case detach || len(names) == 1 && !branchExists(names[0]):
+		if len(names) > 1 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		}
+		rev := "HEAD"
+		if len(names) == 1 {
+			rev = names[0]
+		}
+		if err := checkoutDetached(rev, detach, force); err != nil {
+			switchFailed(err)
+		}
+
+	default:
+		if len(names) != 1 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		}
+		switchArgs := names
+		if force {
+			switchArgs = append(switchArgs, "-f")
+		}
+		cmdSwitch(switchArgs)
+	}
+}
+
+// checkoutDetached detaches HEAD at the commit rev names, as switch --detach does. Leaving a
+// branch for a commit not asked for with --detach is explained first, unless
+// advice.detachedHead is false.
+func checkoutDetached(rev string, asked bool, force bool) error {
+	isBranch, _, err := ReadHEAD(".git")
+	if err != nil {
+		return err
+	}
+	headSha, err := headCommit()
+	if err != nil {
+		return err
+	}
+	sha, err := resolveObjectArg(rev)
+	if err == nil {
+		if _, err = peelToCommit(sha); err != nil {
+			return fmt.Errorf("Cannot switch branch to a non-commit '%s'", rev)
+		}
+	}
+	if isBranch && !asked && configBool("advice.detachedHead", true) {
+		fmt.Fprintf(os.Stderr, "Note: switching to '%s'.\n\n"+
+			"You are in 'detached HEAD' state. You can look around, make experimental\n"+
+			"changes and commit them, and you can discard any commits you make in this\n"+
+			"state without impacting any branches by switching back to a branch.\n\n"+
+			"If you want to create a new branch to retain commits you create, you may\n"+
+			"do so (now or later) by using -c with the switch command. Example:\n\n"+
+			"  mygit switch -c <new-branch-name>\n\n"+
+			"Or undo this operation with:\n\n"+
+			"  mygit switch -\n\n"+
+			"Turn off this advice by setting config variable advice.detachedHead to false\n\n", rev)
+	}
+	return switchDetached(rev, headSha, !isBranch, force)
+}
+
+// checkoutOrphan points HEAD at a branch that doesn't exist yet, so the next commit starts a
+// new history. The index and working tree are left alone: whatever is staged now, including
+// the files of the commit being left, goes into that first commit.
+func checkoutOrphan(branch string) error {
+	if !validBranchName(branch) {
+		return fmt.Errorf("'%s' is not a valid branch name", branch)
+	}
+	if branchExists(branch) {
+		return fmt.Errorf("a branch named '%s' already exists", branch)
+	}
+	// an index that was never written still stands for HEAD's tree; write it out before HEAD
+	// stops pointing at that tree
+	if _, err := os.Stat(indexPath); os.IsNotExist(err) {
+		head, err := headCommit()
+		if err != nil {
+			return err
+		}
+		headTree, err := commitTree(head)
+		if err != nil {
+			return err
+		}
+		headFiles, err := treeFiles(headTree)
+		if err != nil {
+			return err
+		}
+		entries, err := loadIndex(headFiles)
+		if err != nil {
+			return err
+		}
+		if err := writeIndex(entries); err != nil {
+			return err
+		}
+	}
+	return setHead(branch, "")
+}
+
+// checkoutPaths restores the paths matching pathspecs in the working tree from the index or,
+// when source names a tree, from that tree, which updates the index as well. Files the tree
+// doesn't have are left alone. Like deleteBranches, it reports what stops it, a pathspec that
+// matches nothing or an unmerged path, which can only be restored from a tree unless force skips
+// it, and returns ok false without touching anything. Otherwise it returns how many files it
+// wrote.
+func checkoutPaths(source string, specs []string, force bool) (updated int, ok bool, err error) {
+	head, err := headCommit()
+	if err != nil {
+		return 0, false, err
+	}
+	headTree, err := commitTree(head)
+	if err != nil {
+		return 0, false, err
+	}
+	headFiles, err := treeFiles(headTree)
+	if err != nil {
+		return 0, false, err
+	}
+	entries, err := loadIndex(headFiles)
+	if err != nil {
+		return 0, false, err
+	}
+	files := map[string]treeEntry{}
+	if source != "" {
+		if files, err = treeFiles(source); err != nil {
+			return 0, false, err
+		}
+	}
+
+	parsed := make([]pathspec, len(specs))
+	for i, spec := range specs {
+		parsed[i] = parsePathspec(spec)
+	}
+	matched := make([]bool, len(specs))
+	matching := func(filePath string) bool {
+		found := false
+		for i, p := range parsed {
+			if p.matches(filePath) {
+				matched[i], found = true, true
+			}
+		}
+		return found
+	}
+	for _, entry := range entries {
+		matching(entry.path)
+	}
+	for filePath := range files {
+		matching(filePath)
+	}
+	ok = true
+	for i, spec := range specs {
+		if !matched[i] {
+			fmt.Fprintf(os.Stderr, "error: pathspec '%s' did not match any file(s) known to git\n", spec)
+			ok = false
+		}
+	}
+	if !ok {
+		return 0, false, nil
+	}
+
+	// entries from the tree replace those in the index, conflicted or not
+	var kept []indexEntry
+	for _, entry := range entries {
+		if _, inTree := files[entry.path]; inTree && matching(entry.path) {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	for filePath, file := range files {
+		if matching(filePath) {
+			kept = append(kept, indexEntry{mode: file.mode, sha: file.sha, path: filePath})
+		}
+	}
+	entries = kept
+
+	unmerged := map[string]bool{}
+	for _, entry := range entries {
+		if entry.stage() != 0 && !unmerged[entry.path] && matching(entry.path) {
+			unmerged[entry.path] = true
+			if force {
+				fmt.Fprintf(os.Stderr, "warning: path '%s' is unmerged\n", entry.path)
+			} else {
+				fmt.Fprintf(os.Stderr, "error: path '%s' is unmerged\n", entry.path)
+				ok = false
+			}
+		}
+	}
+	if !ok {
+		return 0, false, nil
+	}
+
+	for i, entry := range entries {
+		if entry.stage() != 0 || !matching(entry.path) {
+			continue
+		}
+		if entry.mode == 0o160000 {
+			continue //submodules aren't checked out
+		}
+		dirty, err := worktreeChanged(entry)
+		if err != nil {
+			return 0, false, err
+		}
+		if dirty {
+			if err := writeWorktreeFile(entry.path, treeEntry{mode: entry.mode, sha: entry.sha}); err != nil {
+				return 0, false, err
+			}
+			updated++
+		} else if entry.mtimeSec != 0 || entry.size != 0 || entry.ino != 0 {
+			continue
+		}
+		if entries[i], err = newIndexEntry(entry.path, entry.sha, entry.mode); err != nil {
+			return 0, false, err
+		}
+	}
+	return updated, true, writeIndex(entries)
+}
+
+type checkoutConflictError struct {
+	paths     []string
+	operation string //"merge" when moving trees for a merge, otherwise a checkout
+}
+
+func (e *checkoutConflictError) Error() string {
+	operation, advice := "checkout", "switch branches"
+	if e.operation == "merge" {
+		operation, advice = "merge", "merge"
+	}
+	return fmt.Sprintf("Your local changes to the following files would be overwritten by %s:\n\t%s\nPlease commit your changes or stash them before you %s.",
+		operation, strings.Join(e.paths, "\n\t"), advice)
+}
+
+// treeFiles flattens a tree into path -> entry, treating "" as the empty tree
+func treeFiles(treeSha string) (map[string]treeEntry, error) {
+	files := map[string]treeEntry{}
+	if treeSha == "" {
+		return files, nil
+	}
+	return files, flattenTree(treeSha, "", files)
+}
+
+// commitTree returns the tree of a commit, or "" for an unborn branch
+func commitTree(commitSha string) (string, error) {
+	if commitSha == "" {
+		return "", nil
+	}
+	commit, err := readCommit(commitSha)
+	if err != nil {
+		return "", err
+	}
+	return commit.Tree, nil
+}
+
+// loadIndex reads the index, seeding it from the HEAD tree when none has been written yet
+// (repositories built with write-tree/commit-tree never get one)
+func loadIndex(headFiles map[string]treeEntry) ([]indexEntry, error) {
+	entries, err := readIndex()
+	if !os.IsNotExist(err) {
+		return entries, err
+	}
+	entries = nil
+	for filePath, file := range headFiles {
+		entries = append(entries, indexEntry{mode: file.mode, sha: file.sha, path: filePath})
+	}
+	return entries, nil
+}
+
+func sameEntry(a treeEntry, aok bool, b treeEntry, bok bool) bool {
+	if aok != bok {
+		return false
+	}
+	return !aok || (a.sha == b.sha && a.mode == b.mode)
+}
+
+// checkoutTree moves the working tree and index from one tree to another. Files that are the
+// same in both trees keep any local modifications; unless force is set, local modifications to
+// files that differ between the trees abort the checkout before anything is touched.
+func checkoutTree(fromTree string, toTree string, force bool) error {
+	from, err := treeFiles(fromTree)
+	if err != nil {
+		return err
+	}
+	to, err := treeFiles(toTree)
+	if err != nil {
+		return err
+	}
+	entries, err := loadIndex(from)
+	if err != nil {
+		return err
+	}
+	index := indexByPath(entries)
+
+	var paths []string
+	changed := map[string]bool{}
+	for _, files := range []map[string]treeEntry{from, to} {
+		for filePath := range files {
+			if _, seen := changed[filePath]; seen {
+				continue
+			}
+			base, inBase := from[filePath]
+			target, inTarget := to[filePath]
+			changed[filePath] = !sameEntry(base, inBase, target, inTarget)
+			paths = append(paths, filePath)
+		}
+	}
+	sort.Strings(paths)
+
+	if !force {
+		var conflicts []string
+		for _, filePath := range paths {
+			if !changed[filePath] {
+				continue
+			}
+			dirty, err := locallyModified(filePath, from, to, index)
+			if err != nil {
+				return err
+			}
+			if dirty {
+				conflicts = append(conflicts, filePath)
+			}
+		}
+		if len(conflicts) > 0 {
+			return &checkoutConflictError{paths: conflicts}
+		}
+	}
+
+	var newEntries []indexEntry
+	for _, filePath := range paths {
+		target, inTarget := to[filePath]
+		if !inTarget {
+			if err := removeWorktreeFile(filePath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		current, staged := index[filePath]
+		if !changed[filePath] && !staged && !force {
+			continue //keep the staged deletion
+		}
+		if !changed[filePath] && staged {
+			if !force {
+				newEntries = append(newEntries, current)
+				continue
+			}
+			dirty, err := worktreeChanged(current)
+			if err != nil {
+				return err
+			}
+			if !dirty && current.sha == target.sha && current.mode == target.mode {
+				newEntries = append(newEntries, current)
+				continue
+			}
+		}
+
+		if err := writeWorktreeFile(filePath, target); err != nil {
+			return err
+		}
+		entry, err := newIndexEntry(filePath, target.sha, target.mode)
+		if err != nil {
+			return err
+		}
+		newEntries = append(newEntries, entry)
+	}
+
+	// files staged but in neither tree are new additions; carry them over
+	if !force {
+		for filePath, entry := range index {
+			if _, tracked := changed[filePath]; !tracked {
+				newEntries = append(newEntries, entry)
+			}
+		}
+	}
+	return writeIndex(newEntries)
+}
+
+// locallyModified reports whether switching trees would clobber a change to filePath that
+// exists in the index or working tree but not in the tree being left
+func locallyModified(filePath string, from, to map[string]treeEntry, index map[string]indexEntry) (bool, error) {
+	base, inBase := from[filePath]
+	target, inTarget := to[filePath]
+	current, staged := index[filePath]
+
+	if !staged {
+		if inBase {
+			return inTarget, nil //deletion staged; only a problem if the target brings the file back
+		}
+		// untracked file in the way of one the target tree creates
+		contents, _, err := readWorktreeFile(filePath)
+		if os.IsNotExist(err) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		return hashObject("blob", contents) != target.sha, nil
+	}
+
+	if !inBase || current.sha != base.sha || current.mode != base.mode {
+		return true, nil
+	}
+	return worktreeChanged(current)
+}
+
+// uncommittedChanges lists tracked paths whose index entry or working tree file differs from
+// a tree, as "git status" would show them staged or modified
+func uncommittedChanges(treeSha string) ([]string, error) {
+	files, err := treeFiles(treeSha)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := loadIndex(files)
+	if err != nil {
+		return nil, err
+	}
+	changed := map[string]bool{}
+	for _, entry := range entries {
+		file, inTree := files[entry.path]
+		if entry.stage() != 0 || !inTree || file.sha != entry.sha || file.mode != entry.mode {
+			changed[entry.path] = true
+			continue
+		}
+		dirty, err := worktreeChanged(entry)
+		if err != nil {
+			return nil, err
+		}
+		if dirty {
+			changed[entry.path] = true
+		}
+	}
+	index := indexByPath(entries)
+	for filePath := range files {
+		if _, staged := index[filePath]; !staged {
+			changed[filePath] = true
+		}
+	}
+	var paths []string
+	for filePath := range changed {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// resetWorktree makes the index and working tree match a tree exactly, removing files that are
+// tracked in the index but not in the tree
+func resetWorktree(treeSha string) error {
+	files, err := treeFiles(treeSha)
+	if err != nil {
+		return err
+	}
+	entries, err := loadIndex(files)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, inTree := files[entry.path]; !inTree {
+			if err := removeWorktreeFile(entry.path); err != nil {
+				return err
+			}
+		}
+	}
+	return checkoutTree(treeSha, treeSha, true)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCheckoutRejectsInvalidBranchNames(t *testing.T) {
+	initTestRepo(t)
+	head := testCommit(t, 0, "initial", map[string]string{"a": "a\n"})
+	if err := updateRef("refs/heads/master", head, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		option string
+		name   string
+	}{
+		{"-b", "../x"},
+		{"-b", "a b"},
+		{"-b", "x.lock"},
+		{"--orphan", "../x"},
+		{"--orphan", "a b"},
+		{"--orphan", "a:b"},
+	}
+	for _, test := range tests {
+		t.Run(test.option+" "+test.name, func(t *testing.T) {
+			_, stderr, code := runMygit(t, "checkout", test.option, test.name)
+			if code != 128 || !strings.Contains(stderr, "is not a valid branch name") {
+				t.Errorf("exit %d, %q; want 128 and an invalid name error", code, stderr)
+			}
+			if branch, _ := currentBranch(); branch != "master" {
+				t.Errorf("HEAD moved to %q", branch)
+			}
+		})
+	}
+
+	runTestCommand(t, "checkout", "-b", "topic")
+	if branch, _ := currentBranch(); branch != "topic" {
+		t.Errorf("HEAD is on %q after checkout -b topic", branch)
+	}
+}
+
+func TestCheckoutStartPointsAndCommits(t *testing.T) {
+	initTestRepo(t)
+	first := testCommit(t, 0, "first", map[string]string{"a": "1\n"})
+	second := testCommit(t, 100, "second", map[string]string{"a": "2\n"}, first)
+	if err := updateRef("refs/heads/master", second, ""); err != nil {
+		t.Fatal(err)
+	}
+	runTestCommand(t, "checkout", "-f", "master")
+
+	runTestCommand(t, "checkout", "-b", "old", "HEAD~1")
+	if sha, _ := readRef("refs/heads/old"); sha != first {
+		t.Errorf("checkout -b old HEAD~1 made old at %s, want %s", sha, first)
+	}
+	if branch, _ := currentBranch(); branch != "old" {
+		t.Errorf("HEAD is on %q after checkout -b old HEAD~1", branch)
+	}
+	runTestCommand(t, "checkout", "master")
+
+	// a commit that isn't a branch detaches HEAD, explaining what that means
+	_, stderr, code := runMygit(t, "checkout", first)
+	if code != 0 || !strings.HasPrefix(stderr, "Note: switching to '"+first+"'.") || !strings.HasSuffix(stderr, "HEAD is now at "+first[:7]+" first\n") {
+		t.Errorf("checkout <commit>: exit %d, %q", code, stderr)
+	}
+	if isBranch, target, _ := ReadHEAD(".git"); isBranch || target != first {
+		t.Errorf("HEAD is %v %s after checkout <commit>, want %s", isBranch, target, first)
+	}
+	if contents, _ := os.ReadFile("a"); string(contents) != "1\n" {
+		t.Errorf("a is %q after checkout <commit>", contents)
+	}
+
+	// restoring paths says how many, "--" or not
+	writeTestFile(t, "a", "changed\n")
+	if _, stderr, _ := runMygit(t, "checkout", "--", "a"); stderr != "Updated 1 path from the index\n" {
+		t.Errorf("checkout -- a said %q", stderr)
+	}
+
+	for _, args := range [][]string{{"-x"}, {"-b"}, {"-b", "a", "b", "c"}, {"--detach", "HEAD", "--", "a"}} {
+		if _, stderr, code := runMygit(t, append([]string{"checkout"}, args...)...); code != 1 || !strings.HasPrefix(stderr, "usage: mygit checkout") {
+			t.Errorf("checkout %s: exit %d, %q; want checkout's usage", strings.Join(args, " "), code, stderr)
+		}
+	}
+}
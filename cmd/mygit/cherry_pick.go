@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// cherryPickHeadPath names the commit being cherry-picked while its conflicts are resolved;
+// its message waits in MERGE_MSG, like a merge's
+const cherryPickHeadPath = ".git/CHERRY_PICK_HEAD"
+
+// Usage:
+//
+//	mygit cherry-pick <commit>
+//	mygit cherry-pick (--continue|--abort)
+//
+// Applies the changes a commit made to its parent onto HEAD, as a three-way merge with the
+// parent as the base, and commits them with the commit's author and message. A "(cherry picked
+// from commit <sha>)" line is added to the message, as git does with -x. Conflicts are left in
+// the index and working tree with CHERRY_PICK_HEAD recorded, for --continue to commit once
+// they are resolved and staged, or --abort to throw away.
+func cmdCherryPick(args []string) {
+	usage := "usage: mygit cherry-pick <commit>\n" +
+		"   or: mygit cherry-pick (--continue|--abort)\n"
+	action := ""
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case arg == "--continue", arg == "--abort":
+			action = arg
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(129)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if (action == "") == (len(positional) == 0) || len(positional) > 1 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(129)
+	}
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "error: %s\nfatal: cherry-pick failed\n", err)
+		os.Exit(128)
+	}
+
+	_, statErr := os.Stat(cherryPickHeadPath)
+	inProgress := statErr == nil
+	switch {
+	case action != "" && !inProgress:
+		fmt.Fprintln(os.Stderr, "error: no cherry-pick in progress")
+		os.Exit(128)
+	case action == "--continue":
+		if err := cherryPickContinue(); err != nil {
+			fail(err)
+		}
+		return
+	case action == "--abort":
+		if err := cherryPickAbort(); err != nil {
+			fail(err)
+		}
+		return
+	case inProgress:
+		fmt.Fprintf(os.Stderr, "error: cherry-pick is already in progress\n"+
+			"hint: try \"mygit cherry-pick (--continue | --abort)\"\n"+
+			"fatal: cherry-pick failed\n")
+		os.Exit(128)
+	}
+
+	name := positional[0]
+	sha, err := resolveObjectArg(name)
+	if err == nil {
+		sha, err = peelToCommit(sha)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: bad revision '%s'\n", name)
+		os.Exit(128)
+	}
+	conflicted, err := cherryPick(sha)
+	if err != nil {
+		var overwritten *checkoutConflictError
+		if errors.As(err, &overwritten) {
+			overwritten.operation = "merge"
+			fmt.Fprintf(os.Stderr, "error: %s\nAborting\nfatal: cherry-pick failed\n", err)
+			os.Exit(128)
+		}
+		fail(err)
+	}
+	if conflicted {
+		os.Exit(1)
+	}
+}
+
+// cherryPick replays the commit sha onto HEAD and commits the result, reporting true when it
+// stopped with conflicts instead
+func cherryPick(sha string) (bool, error) {
+	head, err := headCommit()
+	if err != nil {
+		return false, err
+	}
+	if head == "" {
+		return false, errors.New("cannot cherry-pick onto an unborn branch")
+	}
+	original, err := readCommit(sha)
+	if err != nil {
+		return false, err
+	}
+	if len(original.Parents) > 1 {
+		return false, fmt.Errorf("commit %s is a merge but no -m option was given.", sha)
+	}
+	headTree, err := commitTree(head)
+	if err != nil {
+		return false, err
+	}
+	entries, err := readIndex()
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if indexTree, err := indexTree(entries); err == errUnmerged || err == nil && indexTree != headTree {
+		return false, errors.New("your local changes would be overwritten by cherry-pick.\n" +
+			"hint: commit your changes or stash them to proceed.")
+	} else if err != nil {
+		return false, err
+	}
+
+	_, result, err := replayCommit(sha, mergeOptions{})
+	if err != nil {
+		return false, err
+	}
+	message := cherryPickedFrom(original.Message, sha)
+	if len(result.conflicts) > 0 {
+		msg := message + "\n# Conflicts:\n"
+		for _, conflict := range result.conflicts {
+			msg += "#\t" + conflict.path + "\n"
+		}
+		if err := os.WriteFile(cherryPickHeadPath, []byte(sha+"\n"), 0644); err != nil {
+			return false, err
+		}
+		if err := os.WriteFile(mergeMsgPath, []byte(msg), 0644); err != nil {
+			return false, err
+		}
+		fmt.Fprintf(os.Stderr, "error: could not apply %s... %s\n"+
+			"hint: After resolving the conflicts, mark them with\n"+
+			"hint: \"mygit add <pathspec>\", then run\n"+
+			"hint: \"mygit cherry-pick --continue\".\n"+
+			"hint: To abort and get back to the state before \"mygit cherry-pick\",\n"+
+			"hint: run \"mygit cherry-pick --abort\".\n", sha[:7], original.Subject())
+		return true, nil
+	}
+	if result.tree == headTree {
+		return false, errors.New("The cherry-pick is empty: its changes are already in HEAD.")
+	}
+	return false, commitCherryPick(result.tree, original, message)
+}
+
+// cherryPickedFrom appends the line git's -x adds to a cherry-picked commit's message: to its
+// trailers when it ends with some, otherwise as a paragraph of its own
+func cherryPickedFrom(message string, sha string) string {
+	line := "(cherry picked from commit " + sha + ")"
+	body := strings.TrimRight(message, "\n")
+	paragraphs := strings.Split(body, "\n\n")
+	last := strings.Split(paragraphs[len(paragraphs)-1], "\n")
+	isTrailers := len(paragraphs) > 1
+	for _, l := range last {
+		isTrailers = isTrailers && (trailerLine.MatchString(l) || strings.HasPrefix(l, "(cherry picked from commit "))
+	}
+	if isTrailers {
+		return body + "\n" + line + "\n"
+	}
+	return body + "\n\n" + line + "\n"
+}
+
+// commitCherryPick commits tree on top of HEAD with the original commit's author and the given
+// message, and reports it like commit does
+func commitCherryPick(tree string, original *Commit, message string) error {
+	head, err := headCommit()
+	if err != nil {
+		return err
+	}
+	committer, err := signature("committer")
+	if err != nil {
+		return err
+	}
+	commit := &Commit{
+		Tree:      tree,
+		Parents:   []string{head},
+		Author:    original.Author,
+		Committer: committer,
+		Message:   message,
+	}
+	sha, err := writeCommit(commit)
+	if err != nil {
+		return err
+	}
+	if err := updateHead(sha, "cherry-pick: "+commit.Subject()); err != nil {
+		return err
+	}
+	branch, _ := currentBranch()
+	if branch == "" {
+		branch = "detached HEAD"
+	}
+	fmt.Printf("[%s %s] %s\n", branch, sha[:7], commit.Subject())
+	return nil
+}
+
+// cherryPickContinue commits a cherry-pick whose conflicts have been resolved and staged
+func cherryPickContinue() error {
+	picked, err := os.ReadFile(cherryPickHeadPath)
+	if err != nil {
+		return err
+	}
+	original, err := readCommit(strings.TrimSpace(string(picked)))
+	if err != nil {
+		return err
+	}
+	entries, err := readIndex()
+	if err != nil {
+		return err
+	}
+	tree, err := indexTree(entries)
+	if err == errUnmerged {
+		return errors.New("Committing is not possible because you have unmerged files.\n" +
+			"hint: Fix them up in the work tree, and then use 'mygit add <file>'\n" +
+			"hint: as appropriate to mark resolution and make a commit.")
+	} else if err != nil {
+		return err
+	}
+	if err := rerereRecordResolutions(); err != nil {
+		return err
+	}
+	message, _ := os.ReadFile(mergeMsgPath)
+	cleaned := stripMessageSpace(cleanupMessage(string(message)))
+	if cleaned == "" {
+		return errors.New("Aborting commit due to empty commit message.")
+	}
+	if err := commitCherryPick(tree, original, cleaned); err != nil {
+		return err
+	}
+	os.Remove(cherryPickHeadPath)
+	os.Remove(mergeMsgPath)
+	return nil
+}
+
+// cherryPickAbort puts the index and working tree back to HEAD and forgets the cherry-pick
+func cherryPickAbort() error {
+	head, err := headCommit()
+	if err != nil {
+		return err
+	}
+	headTree, err := commitTree(head)
+	if err != nil {
+		return err
+	}
+	if err := resetWorktree(headTree); err != nil {
+		return err
+	}
+	os.Remove(cherryPickHeadPath)
+	os.Remove(mergeMsgPath)
+	return rerereClear()
+}
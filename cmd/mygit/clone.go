@@ -0,0 +1,352 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Usage: mygit clone [--filter=<filter-spec>] [-u|--upload-pack <program>] [-l|--local|--no-local] <url> [<directory>]
+//
+// The repository is built in a temporary directory next to the target and only renamed into
+// place once everything has been fetched and checked out, so a failed or interrupted clone
+// leaves nothing behind.
+//
+// With --filter the clone is partial: the remote leaves the objects the filter matches out of
+// the pack, and origin is recorded as the promisor remote that supplies them when they are
+// needed. The blobs needed to check out the default branch are fetched straight away.
+//
+// --upload-pack runs program as the remote's upload-pack over ssh or locally, and is kept as
+// remote.origin.uploadpack for later fetches. A local repository's path is recorded as an
+// absolute one.
+//
+// Cloning a repository given by its path, rather than a URL, hard-links its objects instead of
+// fetching them, copying only those that can't be linked, as across filesystems. --no-local, or
+// a file:// URL, fetches them as for any other remote; --filter only applies then.
+//
+// An HTTP server without git behind it is cloned over the dumb protocol, see dumb_http.go, for
+// which --filter is ignored.
+func cmdClone(args []string) {
+	usage := "usage: mygit clone [--filter=<filter-spec>] [-u|--upload-pack <program>] [-l|--local|--no-local] <url> [<directory>]\n"
+	filter := ""
+	noLocal := false
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--filter" && i+1 < len(args):
+			i++
+			filter = args[i]
+		case strings.HasPrefix(arg, "--filter="):
+			filter = strings.TrimPrefix(arg, "--filter=")
+		case (arg == "-u" || arg == "--upload-pack") && i+1 < len(args):
+			i++
+			servicePrograms["git-upload-pack"] = args[i]
+		case strings.HasPrefix(arg, "--upload-pack="):
+			servicePrograms["git-upload-pack"] = strings.TrimPrefix(arg, "--upload-pack=")
+		case arg == "-l" || arg == "--local":
+			noLocal = false
+		case arg == "--no-local":
+			noLocal = true
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) < 1 || len(positional) > 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+	if filter != "" && !validFilterSpec(filter) {
+		fmt.Fprintf(os.Stderr, "fatal: invalid filter-spec '%s'\n", filter)
+		os.Exit(128)
+	}
+	url := strings.TrimRight(positional[0], "/")
+	dir := strings.TrimSuffix(path.Base(url), ".git")
+	local := false
+	if isLocalURL(url) && !strings.HasPrefix(url, "file://") {
+		// the clone is made from inside a temporary directory, so a relative path won't do
+		if abs, err := filepath.Abs(url); err == nil {
+			url = abs
+		}
+		local = !noLocal
+	}
+	if local && filter != "" {
+		fmt.Fprintf(os.Stderr, "warning: --filter is ignored for local clones; use file:// instead.\n")
+		filter = ""
+	}
+	if len(positional) == 2 {
+		dir = positional[1]
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		fmt.Fprintf(os.Stderr, "fatal: destination path '%s' already exists and is not an empty directory.\n", dir)
+		os.Exit(128)
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error resolving directory: %s\n", err)
+		os.Exit(1)
+	}
+	tmpDir, err := os.MkdirTemp(filepath.Dir(absDir), "."+filepath.Base(absDir)+".clone-*")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating temporary directory: %s\n", err)
+		os.Exit(1)
+	}
+	cleanup := func() { os.RemoveAll(tmpDir) }
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupted
+		cleanup()
+		os.Exit(130)
+	}()
+
+	fmt.Fprintf(os.Stderr, "Cloning into '%s'...\n", dir)
+	origDir, err := os.Getwd()
+	if err == nil {
+		err = os.Chdir(tmpDir)
+	}
+	if err == nil {
+		err = cloneInto(url, filter, local)
+	}
+	if err == nil {
+		err = os.Chdir(origDir)
+	}
+	if err == nil {
+		os.Remove(absDir) //an empty target directory is allowed
+		err = os.Rename(tmpDir, absDir)
+	}
+	if err != nil {
+		cleanup()
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+}
+
+// validFilterSpec reports whether spec is a filter the upload-pack protocol understands:
+// blob:none, blob:limit=<n>[kmg] or tree:<depth>
+func validFilterSpec(spec string) bool {
+	if spec == "blob:none" {
+		return true
+	}
+	if limit, ok := strings.CutPrefix(spec, "blob:limit="); ok {
+		limit = strings.TrimRight(strings.ToLower(limit), "kmg")
+		_, err := strconv.ParseUint(limit, 10, 64)
+		return err == nil && len(spec)-len("blob:limit=")-len(limit) <= 1
+	}
+	if depth, ok := strings.CutPrefix(spec, "tree:"); ok {
+		_, err := strconv.ParseUint(depth, 10, 64)
+		return err == nil
+	}
+	return false
+}
+
+// linkObjects fills the object store with the objects of the repository at repo, bare or not,
+// hard-linking each file where it can and copying it where it can't
+func linkObjects(repo string) error {
+	objects := filepath.Join(repo, ".git", "objects")
+	if _, err := os.Stat(objects); err != nil {
+		objects = filepath.Join(repo, "objects")
+	}
+	return filepath.WalkDir(objects, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(objects, filePath)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(".git", "objects", rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		return linkOrCopyFile(filePath, target)
+	})
+}
+
+// cloneInto fetches url into a fresh repository in the current directory and checks out its
+// default branch. A non-empty filter makes it a partial clone, and local links the objects of
+// the repository at the path url instead of fetching them.
+func cloneInto(url string, filter string, local bool) error {
+	for _, dir := range []string{".git/objects", ".git/refs/heads", ".git/refs/tags"} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	if local {
+		if err := linkObjects(url); err != nil {
+			return err
+		}
+	}
+
+	refs, caps, err := discoverRefs(url, "git-upload-pack")
+	dumb := errors.As(err, &dumbHTTPError{})
+	if dumb {
+		if filter != "" {
+			fmt.Fprintf(os.Stderr, "warning: filtering not recognized by server, ignoring\n")
+			filter = ""
+		}
+		refs, caps, err = discoverDumbRefs(url)
+	}
+	if err != nil {
+		return err
+	}
+
+	// the default branch is advertised as a symref, or failing that matched by SHA
+	headSha, defaultBranch := "", ""
+	for _, ref := range refs {
+		if ref.name == "HEAD" {
+			headSha = ref.sha
+		}
+	}
+	if target, ok := capabilityValue(caps, "symref"); ok && strings.HasPrefix(target, "HEAD:refs/heads/") {
+		defaultBranch = strings.TrimPrefix(target, "HEAD:refs/heads/")
+	}
+	var wants []string
+	wanted := map[string]bool{}
+	for _, ref := range refs {
+		if !strings.HasPrefix(ref.name, "refs/heads/") && !strings.HasPrefix(ref.name, "refs/tags/") {
+			continue
+		}
+		if strings.HasSuffix(ref.name, "^{}") {
+			continue
+		}
+		if defaultBranch == "" && ref.sha == headSha && strings.HasPrefix(ref.name, "refs/heads/") {
+			defaultBranch = strings.TrimPrefix(ref.name, "refs/heads/")
+		}
+		if !wanted[ref.sha] {
+			wanted[ref.sha] = true
+			wants = append(wants, ref.sha)
+		}
+	}
+
+	config := fmt.Sprintf("[core]\n\trepositoryformatversion = 0\n\tfilemode = true\n\tbare = false\n"+
+		"[remote \"origin\"]\n\turl = %s\n\tfetch = +refs/heads/*:refs/remotes/origin/*\n", url)
+	if program := servicePrograms["git-upload-pack"]; program != "" {
+		config += fmt.Sprintf("\tuploadpack = %s\n", quoteConfigValue(program))
+	}
+	if defaultBranch != "" {
+		config += fmt.Sprintf("[branch \"%s\"]\n\tremote = origin\n\tmerge = refs/heads/%s\n", defaultBranch, defaultBranch)
+	}
+	if err := os.WriteFile(".git/config", []byte(config), 0644); err != nil {
+		return err
+	}
+	if filter != "" {
+		if err := registerPromisor("origin", filter); err != nil {
+			return err
+		}
+	}
+
+	if len(wants) == 0 {
+		hangUpService(url, "git-upload-pack")
+		fmt.Fprintf(os.Stderr, "warning: You appear to have cloned an empty repository.\n")
+		return setHead(defaultBranchName(), "")
+	}
+
+	if local {
+		// only what was written to the source since its objects were linked is left to fetch
+		var missing []string
+		for _, sha := range wants {
+			if !objectExists(sha) {
+				missing = append(missing, sha)
+			}
+		}
+		wants = missing
+	}
+	if dumb {
+		if err := fetchDumbObjects(url, wants); err != nil {
+			return err
+		}
+	} else if len(wants) == 0 {
+		hangUpService(url, "git-upload-pack")
+	} else {
+		pack, err := fetchPack(url, caps, wants, nil, filter)
+		if err != nil {
+			return err
+		}
+		defer pack.Close()
+		if _, err := receivePack(pack); err != nil {
+			return err
+		}
+	}
+
+	for _, ref := range refs {
+		if strings.HasSuffix(ref.name, "^{}") {
+			continue
+		}
+		if branch, ok := strings.CutPrefix(ref.name, "refs/heads/"); ok {
+			err = updateRef(path.Join("refs", "remotes", "origin", branch), ref.sha, "")
+		} else if strings.HasPrefix(ref.name, "refs/tags/") {
+			err = updateRef(ref.name, ref.sha, "")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if defaultBranch == "" {
+		fmt.Fprintf(os.Stderr, "warning: remote HEAD refers to nonexistent ref, unable to checkout\n")
+		return setHead(defaultBranchName(), "")
+	}
+	if err := os.WriteFile(".git/refs/remotes/origin/HEAD", []byte("ref: refs/remotes/origin/"+defaultBranch+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := updateRef(path.Join("refs", "heads", defaultBranch), headSha, "clone: from "+url); err != nil {
+		return err
+	}
+	if err := setHead(defaultBranch, "clone: from "+url); err != nil {
+		return err
+	}
+	if filter != "" {
+		if err := fetchMissingBlobs(url, caps, headSha); err != nil {
+			return err
+		}
+		if err := recordPromisedObjects(); err != nil {
+			return err
+		}
+	}
+	return checkoutCommit("", headSha)
+}
+
+// fetchMissingBlobs fetches, in one request, the blobs of commit's tree that a filtered fetch
+// left out
+func fetchMissingBlobs(url string, caps []string, commit string) error {
+	tree, err := commitTree(commit)
+	if err != nil {
+		return err
+	}
+	files, err := treeFiles(tree)
+	if err != nil {
+		return err
+	}
+	var wants []string
+	wanted := map[string]bool{}
+	for _, entry := range files {
+		if entry.mode == 0o160000 || wanted[entry.sha] || objectExists(entry.sha) {
+			continue
+		}
+		wanted[entry.sha] = true
+		wants = append(wants, entry.sha)
+	}
+	if len(wants) == 0 {
+		return nil
+	}
+	sort.Strings(wants)
+	pack, err := fetchPack(url, caps, wants, nil, "")
+	if err != nil {
+		return err
+	}
+	defer pack.Close()
+	_, err = receivePack(pack)
+	return err
+}
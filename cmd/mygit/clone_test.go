@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCloneLocalHardLinksObjects(t *testing.T) {
+	source := initTestRepo(t)
+	first := testCommit(t, 0, "first", map[string]string{"a": "a\n", "d/f": "f\n"})
+	second := testCommit(t, 1, "second", map[string]string{"a": "b\n", "d/f": "f\n"}, first)
+	if err := updateRef("refs/heads/master", second, ""); err != nil {
+		t.Fatal(err)
+	}
+	sourceObjects := filepath.Join(source, ".git", "objects")
+	var objects []string
+	err := filepath.WalkDir(sourceObjects, func(filePath string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(sourceObjects, filePath)
+			objects = append(objects, rel)
+		}
+		return err
+	})
+	if err != nil || len(objects) == 0 {
+		t.Fatalf("no objects to clone (%v)", err)
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		shared bool
+	}{
+		{"local path", nil, true},
+		{"--local", []string{"--local"}, true},
+		{"--no-local", []string{"--no-local"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clone := filepath.Join(t.TempDir(), "clone")
+			args := append(append([]string{"clone"}, test.args...), source, clone)
+			runTestCommand(t, args...)
+			for _, object := range objects {
+				sourceInfo, err := os.Stat(filepath.Join(sourceObjects, object))
+				if err != nil {
+					t.Fatal(err)
+				}
+				cloneInfo, err := os.Stat(filepath.Join(clone, ".git", "objects", object))
+				if err != nil {
+					if !test.shared {
+						continue //fetched objects come in a pack
+					}
+					t.Fatal(err)
+				}
+				if os.SameFile(sourceInfo, cloneInfo) != test.shared {
+					t.Errorf("%s: source and clone share it: %v, want %v", object, !test.shared, test.shared)
+				}
+			}
+		})
+	}
+}
+
+func TestPartialCloneFetchesMissingBlobs(t *testing.T) {
+	git, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is needed to serve the clone and check it")
+	}
+	source := initTestRepo(t)
+	first := testCommit(t, 0, "first", map[string]string{"a": "old\n", "b": "b\n"})
+	second := testCommit(t, 1, "second", map[string]string{"a": "new\n", "b": "b\n"}, first)
+	if err := updateRef("refs/heads/master", second, ""); err != nil {
+		t.Fatal(err)
+	}
+	// upload-pack only filters, and only hands out a blob asked for by SHA, when allowed to
+	for _, key := range []string{"uploadpack.allowFilter", "uploadpack.allowAnySHA1InWant"} {
+		if err := configSet(key, "true"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clone := filepath.Join(t.TempDir(), "clone")
+	runTestCommand(t, "clone", "--filter=blob:none", "file://"+source, clone)
+	chdirTest(t, clone)
+	old := hashObject("blob", []byte("old\n"))
+	if !readPromisedObjects()[old] {
+		t.Fatalf("the clone doesn't list %s, left out by the filter, as promised", old)
+	}
+	promisors, _ := filepath.Glob(filepath.Join(".git", "objects", "pack", "pack-*.promisor"))
+	if len(promisors) == 0 {
+		t.Error("the clone kept no promisor pack")
+	}
+
+	// git itself finds nothing wrong, knowing that what the promisor packs leave out is owed
+	fsck := func(when string) {
+		t.Helper()
+		if out, err := exec.Command(git, "fsck", "--strict").CombinedOutput(); err != nil || strings.Contains(string(out), "missing") {
+			t.Errorf("git fsck %s: %v\n%s", when, err, out)
+		}
+	}
+	fsck("after the clone")
+
+	if contents := runTestCommand(t, "cat-file", "-p", "HEAD~1:a"); contents != "old\n" {
+		t.Errorf("HEAD~1:a is %q, want the blob fetched from the promisor", contents)
+	}
+	if readPromisedObjects()[old] {
+		t.Errorf("%s is still promised after it was fetched", old)
+	}
+	fsck("after fetching a missing blob")
+}
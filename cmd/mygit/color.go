@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+Color output follows git's settings. Whether to color at all comes from --color[=<when>] on the
+command line, then color.<command> (color.log, color.diff, ...), then color.ui, and defaults to
+"auto": color only when stdout is a terminal. What color each part gets comes from keys such as
+color.log.commit, written in git's color syntax: up to two colors (foreground, then background)
+and any attributes, e.g. "bold yellow", "red black ul" or "#ff8000".
+*/
+
+const colorReset = "\x1b[m"
+
+// useColor decides whether a command colors its output, given the --color value from the
+// command line ("" when there was none)
+func useColor(command string, flag string) bool {
+	when := flag
+	if when == "" {
+		var ok bool
+		if when, ok = configGet("color." + command); !ok {
+			if when, ok = configGet("color.ui"); !ok {
+				when = "auto"
+			}
+		}
+	}
+	switch strings.ToLower(when) {
+	case "always":
+		return true
+	case "never", "false", "no", "off", "0":
+		return false
+	}
+	//"auto", and "true" which git also takes to mean auto
+	return isTerminal(os.Stdout) && os.Getenv("TERM") != "dumb"
+}
+
+// validColorWhen reports whether a --color value is one git accepts
+func validColorWhen(when string) bool {
+	switch when {
+	case "always", "never", "auto":
+		return true
+	}
+	return false
+}
+
+// configColor returns the escape sequence configured for a slot such as color.log.commit, or
+// fallback (also an escape sequence) when it isn't set or can't be parsed
+func configColor(key string, fallback string) string {
+	value, ok := configGet(key)
+	if !ok {
+		return fallback
+	}
+	color, err := parseColor(value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: invalid color value '%s' for %s\n", value, key)
+		return fallback
+	}
+	return color
+}
+
+var colorNames = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+var colorAttributes = map[string]int{
+	"bold": 1, "dim": 2, "italic": 3, "ul": 4, "blink": 5, "reverse": 7, "strike": 9,
+}
+
+// parseColor turns git's color syntax into an ANSI escape sequence; "" and "normal" mean no
+// color at all
+func parseColor(value string) (string, error) {
+	var codes []string
+	colors := 0
+	for _, word := range strings.Fields(strings.ToLower(value)) {
+		if code, ok := colorAttributes[word]; ok {
+			codes = append(codes, strconv.Itoa(code))
+			continue
+		}
+		if attribute, ok := strings.CutPrefix(word, "no"); ok {
+			if code, ok := colorAttributes[strings.TrimPrefix(attribute, "-")]; ok {
+				if code == 1 {
+					code = 2 //bold and dim are both turned off by 22
+				}
+				codes = append(codes, strconv.Itoa(20+code))
+				continue
+			}
+		}
+		if colors == 2 {
+			return "", fmt.Errorf("too many colors in '%s'", value)
+		}
+		code, err := colorCode(word, colors == 1)
+		if err != nil {
+			return "", err
+		}
+		colors++
+		if code != "" {
+			codes = append(codes, code)
+		}
+	}
+	if len(codes) == 0 {
+		return "", nil
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m", nil
+}
+
+// colorCode is the SGR code for one color word, as a foreground or a background
+func colorCode(word string, background bool) (string, error) {
+	base := 30
+	if background {
+		base = 40
+	}
+	if word == "normal" {
+		return "", nil
+	}
+	if word == "default" {
+		return strconv.Itoa(base + 9), nil
+	}
+	name, bright := strings.CutPrefix(word, "bright")
+	for i, colorName := range colorNames {
+		if name == colorName {
+			if bright {
+				return strconv.Itoa(base + 60 + i), nil
+			}
+			return strconv.Itoa(base + i), nil
+		}
+	}
+	if n, err := strconv.Atoi(word); err == nil && n >= 0 && n <= 255 {
+		return fmt.Sprintf("%d;5;%d", base+8, n), nil
+	}
+	if hex, ok := strings.CutPrefix(word, "#"); ok && len(hex) == 6 {
+		if rgb, err := strconv.ParseUint(hex, 16, 32); err == nil {
+			return fmt.Sprintf("%d;2;%d;%d;%d", base+8, rgb>>16, rgb>>8&0xff, rgb&0xff), nil
+		}
+	}
+	return "", fmt.Errorf("invalid color '%s'", word)
+}
+
+// colorize wraps text in color, leaving it alone when color is ""
+func colorize(color string, text string) string {
+	if color == "" || text == "" {
+		return text
+	}
+	return color + text + colorReset
+}
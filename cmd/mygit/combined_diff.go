@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+/*
+A combined diff shows a merge against all of its parents at once. Each line has a column per
+parent: "+" in a parent's column means the line is in the result but not in that parent, "-"
+that the line is in that parent but not in the result. So with two parents, "+ x" came from the
+second parent, " +x" from the first, and "++x" is new in the merge itself.
+*/
+
+// combinedChange is a path whose entry in a merge differs from its entry in every parent
+type combinedChange struct {
+	path    string
+	parents []treeEntry
+	result  treeEntry
+}
+
+// combinedTreeChanges lists the paths where result differs from all of the parent trees
+func combinedTreeChanges(parentTrees []string, resultTree string) ([]combinedChange, error) {
+	result, err := treeFiles(resultTree)
+	if err != nil {
+		return nil, err
+	}
+	parents := make([]map[string]treeEntry, len(parentTrees))
+	paths := map[string]bool{}
+	for filePath := range result {
+		paths[filePath] = true
+	}
+	for i, tree := range parentTrees {
+		if parents[i], err = treeFiles(tree); err != nil {
+			return nil, err
+		}
+		for filePath := range parents[i] {
+			paths[filePath] = true
+		}
+	}
+
+	var changes []combinedChange
+	for filePath := range paths {
+		change := combinedChange{path: filePath, result: result[filePath]}
+		differsFromAll := true
+		for _, parent := range parents {
+			entry := parent[filePath]
+			if entry == change.result {
+				differsFromAll = false
+				break
+			}
+			change.parents = append(change.parents, entry)
+		}
+		if differsFromAll {
+			changes = append(changes, change)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].path < changes[j].path })
+	return changes, nil
+}
+
+// writeCombinedChange writes the raw "::" line, or the combined patch, for one path
+func writeCombinedChange(w io.Writer, change combinedChange, opts diffTreeOptions) error {
+	if !opts.patch {
+		var modes, shas []string
+		var statuses strings.Builder
+		for _, parent := range change.parents {
+			modes = append(modes, fmt.Sprintf("%06o", parent.mode))
+			shas = append(shas, entrySHA(parent))
+			statuses.WriteByte(changeStatus(parent, change.result))
+		}
+		fmt.Fprintf(w, "%s%s %06o %s %s %s\t%s\n", strings.Repeat(":", len(change.parents)),
+			strings.Join(modes, " "), change.result.mode, strings.Join(shas, " "), entrySHA(change.result),
+			statuses.String(), change.path)
+		return nil
+	}
+
+	result, err := entrySide(change.result)
+	if err != nil {
+		return err
+	}
+	var resultContents []byte
+	if result != nil {
+		resultContents = result.contents
+	}
+	parentLines := make([][]string, len(change.parents))
+	var shortSHAs []string
+	modesDiffer := false
+	for i, parent := range change.parents {
+		side, err := entrySide(parent)
+		if err != nil {
+			return err
+		}
+		if side != nil {
+			parentLines[i] = splitLines(side.contents)
+		}
+		shortSHAs = append(shortSHAs, entrySHA(parent)[:7])
+		modesDiffer = modesDiffer || parent.mode != change.result.mode
+	}
+	lines := combineLines(parentLines, splitLines(resultContents))
+	hunks := combinedHunks(lines, len(change.parents), opts.dense)
+	if opts.dense && len(hunks) == 0 {
+		return nil //every change matches one parent or another, so there's nothing to show
+	}
+
+	kind := "combined"
+	if opts.dense {
+		kind = "cc"
+	}
+	fmt.Fprintf(w, "diff --%s %s\n", kind, change.path)
+	fmt.Fprintf(w, "index %s..%s\n", strings.Join(shortSHAs, ","), entrySHA(change.result)[:7])
+	added := true
+	for _, parent := range change.parents {
+		added = added && parent.sha == ""
+	}
+	oldName, newName := "a/"+change.path, "b/"+change.path
+	switch {
+	case added:
+		fmt.Fprintf(w, "new file mode %06o\n", change.result.mode)
+		oldName = "/dev/null"
+	case change.result.sha == "":
+		fmt.Fprintf(w, "deleted file mode %06o\n", change.parents[0].mode)
+		newName = "/dev/null"
+	case modesDiffer:
+		var modes []string
+		for _, parent := range change.parents {
+			modes = append(modes, fmt.Sprintf("%06o", parent.mode))
+		}
+		fmt.Fprintf(w, "mode %s..%06o\n", strings.Join(modes, ","), change.result.mode)
+	}
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", oldName, newName)
+	for _, hunk := range hunks {
+		writeCombinedHunk(w, lines, hunk, len(change.parents))
+	}
+	return nil
+}
+
+// combinedLine is a line of the result, or a line lost from some of the parents
+type combinedLine struct {
+	text  string
+	lost  bool
+	flags []bool //per parent: for a result line, not in that parent; for a lost line, from it
+}
+
+// combineLines lines up the result with each parent, giving the result's lines in order, each
+// preceded by the lines the parents had there that the result doesn't. A line lost from
+// several parents appears once, flagged for each of them.
+func combineLines(parents [][]string, result []string) []combinedLine {
+	kept := make([]combinedLine, len(result))
+	for j, text := range result {
+		kept[j] = combinedLine{text: text, flags: make([]bool, len(parents))}
+	}
+	lost := make([][]combinedLine, len(result)+1)
+	for i, parent := range parents {
+		cursor := make([]int, len(result)+1) //where parent i's lost lines continue at each position
+		for _, line := range editScript(parent, result) {
+			switch line.kind {
+			case '+':
+				kept[line.b].flags[i] = true
+			case '-':
+				at := line.b
+				found := false
+				for k := cursor[at]; k < len(lost[at]); k++ {
+					if lost[at][k].text == line.text && !lost[at][k].flags[i] {
+						lost[at][k].flags[i] = true
+						cursor[at], found = k+1, true
+						break
+					}
+				}
+				if !found {
+					flags := make([]bool, len(parents))
+					flags[i] = true
+					lost[at] = append(lost[at], combinedLine{text: line.text, lost: true, flags: flags})
+					cursor[at] = len(lost[at])
+				}
+			}
+		}
+	}
+	var lines []combinedLine
+	for j := range result {
+		lines = append(lines, lost[j]...)
+		lines = append(lines, kept[j])
+	}
+	return append(lines, lost[len(result)]...)
+}
+
+func (l combinedLine) changed() bool {
+	if l.lost {
+		return true
+	}
+	for _, flag := range l.flags {
+		if flag {
+			return true
+		}
+	}
+	return false
+}
+
+// combinedHunks groups the changed lines into hunks with context, returned as [start, end)
+// ranges of lines. When dense, runs of changes less than the context apart are taken together,
+// and a run is left out when the result just takes one parent's side: every change in it is
+// against the same parents, and not all of them.
+func combinedHunks(lines []combinedLine, parents int, dense bool) [][2]int {
+	var changes []int
+	for i, line := range lines {
+		if line.changed() {
+			changes = append(changes, i)
+		}
+	}
+	if dense {
+		var kept []int
+		for start := 0; start < len(changes); {
+			end := start + 1
+			for end < len(changes) && changes[end]-changes[end-1]-1 < diffContext {
+				end++
+			}
+			if interestingChanges(lines, changes[start:end], parents) {
+				kept = append(kept, changes[start:end]...)
+			}
+			start = end
+		}
+		changes = kept
+	}
+
+	// changes close enough for their contexts to touch share a hunk
+	var hunks [][2]int
+	for start := 0; start < len(changes); {
+		end := start + 1
+		for end < len(changes) && changes[end] <= changes[end-1]+2*diffContext+1 {
+			end++
+		}
+		from := changes[start] - diffContext
+		if from < 0 {
+			from = 0
+		}
+		to := changes[end-1] + 1 + diffContext
+		if to > len(lines) {
+			to = len(lines)
+		}
+		hunks = append(hunks, [2]int{from, to})
+		start = end
+	}
+	return hunks
+}
+
+// interestingChanges reports whether a run of changes is worth showing in a dense combined
+// diff: it differs from the parents in more than one way, or from all of them
+func interestingChanges(lines []combinedLine, changes []int, parents int) bool {
+	var same []bool
+	for _, i := range changes {
+		if same == nil {
+			same = lines[i].flags
+			continue
+		}
+		for j, flag := range lines[i].flags {
+			if flag != same[j] {
+				return true
+			}
+		}
+	}
+	for _, flag := range same {
+		if !flag {
+			return false
+		}
+	}
+	return parents > 0
+}
+
+// inSide reports whether a line is in parent i, or in the result when i is -1
+func (l combinedLine) inSide(i int) bool {
+	if i < 0 {
+		return !l.lost
+	}
+	return l.lost == l.flags[i]
+}
+
+func writeCombinedHunk(w io.Writer, lines []combinedLine, hunk [2]int, parents int) {
+	// each side's range: the lines of it before the hunk, and in it
+	sideRange := func(i int) string {
+		start, count := 0, 0
+		for k, line := range lines[:hunk[1]] {
+			if !line.inSide(i) {
+				continue
+			}
+			if k < hunk[0] {
+				start++
+			} else {
+				count++
+			}
+		}
+		return fmt.Sprintf("%d,%d", start+1, count) //unlike a plain hunk, always with both
+	}
+	marker := strings.Repeat("@", parents+1)
+	fmt.Fprint(w, marker)
+	for i := 0; i < parents; i++ {
+		fmt.Fprintf(w, " -%s", sideRange(i))
+	}
+	fmt.Fprintf(w, " +%s %s\n", sideRange(-1), marker)
+	for _, line := range lines[hunk[0]:hunk[1]] {
+		var columns strings.Builder
+		for _, flag := range line.flags {
+			switch {
+			case !flag:
+				columns.WriteByte(' ')
+			case line.lost:
+				columns.WriteByte('-')
+			default:
+				columns.WriteByte('+')
+			}
+		}
+		text, newline := strings.CutSuffix(line.text, "\n")
+		fmt.Fprintf(w, "%s%s\n", columns.String(), text)
+		if !newline {
+			fmt.Fprint(w, "\\ No newline at end of file\n")
+		}
+	}
+}
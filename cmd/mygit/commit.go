@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Commit struct {
+	Tree      string
+	Parents   []string
+	Author    string
+	Committer string
+	GPGSig    string //armored signature of the rest of the commit, when it is signed
+	Message   string
+}
+
+func parseCommit(data []byte) (*Commit, error) {
+	commit := &Commit{}
+	headerEnd := bytes.Index(data, []byte("\n\n"))
+	header := data
+	if headerEnd >= 0 {
+		header = data[:headerEnd]
+		commit.Message = string(data[headerEnd+2:])
+	}
+
+	lastKey := ""
+	for _, line := range strings.Split(string(header), "\n") {
+		if continued, ok := strings.CutPrefix(line, " "); ok {
+			if lastKey == "gpgsig" {
+				commit.GPGSig += "\n" + continued
+			}
+			continue
+		}
+		key, value, _ := strings.Cut(line, " ")
+		lastKey = key
+		switch key {
+		case "tree":
+			commit.Tree = value
+		case "parent":
+			commit.Parents = append(commit.Parents, value)
+		case "author":
+			commit.Author = value
+		case "committer":
+			commit.Committer = value
+		case "gpgsig":
+			commit.GPGSig = value
+		}
+	}
+	if commit.Tree == "" {
+		return nil, fmt.Errorf("commit has no tree")
+	}
+	return commit, nil
+}
+
+func readCommit(sha string) (*Commit, error) {
+	objType, contents, err := parseObject(sha)
+	if err != nil {
+		return nil, err
+	}
+	if objType != "commit" {
+		return nil, fmt.Errorf("object %s is a %s, not a commit", sha, objType)
+	}
+	return parseCommit(contents)
+}
+
+// writeCommit stores a commit object; Message is written as is, so it should end in a newline
+func writeCommit(c *Commit) (string, error) {
+	sha, err := writeObject("commit", encodeCommit(c))
+	return fmt.Sprintf("%x", sha), err
+}
+
+// encodeCommit is a commit object's contents. A signature goes in a gpgsig header, each line
+// after the first indented by a space; without one, this is the payload that gets signed.
+func encodeCommit(c *Commit) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "tree %s\n", c.Tree)
+	for _, parent := range c.Parents {
+		fmt.Fprintf(&b, "parent %s\n", parent)
+	}
+	fmt.Fprintf(&b, "author %s\ncommitter %s\n", c.Author, c.Committer)
+	if c.GPGSig != "" {
+		fmt.Fprintf(&b, "gpgsig %s\n", strings.ReplaceAll(strings.TrimSuffix(c.GPGSig, "\n"), "\n", "\n "))
+	}
+	if c.Message != "" {
+		fmt.Fprintf(&b, "\n%s", c.Message)
+	}
+	return b.Bytes()
+}
+
+func (c *Commit) Subject() string {
+	subject, _, _ := strings.Cut(c.Message, "\n")
+	return subject
+}
+
+// signatureTime extracts the timestamp from an author/committer line like "Name <email> 1700000000 +0100"
+func signatureTime(signature string) time.Time {
+	fields := strings.Fields(signature[strings.LastIndexByte(signature, '>')+1:])
+	if len(fields) < 2 {
+		return time.Time{}
+	}
+	seconds, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	zone := time.UTC
+	if offset, err := time.Parse("-0700", fields[1]); err == nil {
+		_, secs := offset.Zone()
+		zone = time.FixedZone(fields[1], secs)
+	}
+	return time.Unix(seconds, 0).In(zone)
+}
+
+// signatureIdentity strips the timestamp from a signature, leaving "Name <email>"
+func signatureIdentity(signature string) string {
+	return signature[:strings.LastIndexByte(signature, '>')+1]
+}
+
+// tagTarget returns the SHA from the "object" line of an annotated tag
+func tagTarget(contents []byte) string {
+	for _, line := range strings.Split(string(contents), "\n") {
+		if sha, ok := strings.CutPrefix(line, "object "); ok {
+			return sha
+		}
+	}
+	return ""
+}
+
+// peelToCommit follows annotated tags until it reaches a commit
+func peelToCommit(sha string) (string, error) {
+	for {
+		objType, contents, err := parseObject(sha)
+		if err != nil {
+			return "", err
+		}
+		switch objType {
+		case "commit":
+			return sha, nil
+		case "tag":
+			sha = tagTarget(contents)
+		default:
+			return "", fmt.Errorf("object %s is a %s, not a commit", sha, objType)
+		}
+	}
+}
+
+// trailerLine matches a "Token: value" line of a trailer block
+var trailerLine = regexp.MustCompile(`^[A-Za-z0-9-]+:\s`)
+
+// appendSignoff adds a "Signed-off-by: <identity>" trailer to a message, which ends in a newline.
+// Like interpret-trailers, it joins the trailer block when the last paragraph is one and starts
+// one after a blank line otherwise. A block that ends in the same sign-off already is left alone.
+func appendSignoff(message string, identity string) string {
+	signoff := "Signed-off-by: " + identity
+	body := strings.TrimRight(message, "\n")
+	paragraphs := strings.Split(body, "\n\n")
+	last := strings.Split(paragraphs[len(paragraphs)-1], "\n")
+	isTrailers := len(paragraphs) > 1
+	for _, line := range last {
+		isTrailers = isTrailers && trailerLine.MatchString(line)
+	}
+	switch {
+	case isTrailers && last[len(last)-1] == signoff:
+		return body + "\n"
+	case isTrailers:
+		return body + "\n" + signoff + "\n"
+	case body == "":
+		return "\n" + signoff + "\n"
+	}
+	return body + "\n\n" + signoff + "\n"
+}
+
+// stripMessageSpace tidies a message given with -m or -F the way git does: trailing whitespace
+// goes, runs of blank lines become one, and there are none at the start or end
+func stripMessageSpace(message string) string {
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(message, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Usage: mygit commit [-m <msg>]... [-F <file>] [-s|--signoff] [-S[<keyid>]|--no-gpg-sign] [--allow-empty]
+//
+// Records the index as a new commit on the current branch, or on a detached HEAD, finishing a
+// merge or cherry-pick in progress. The message comes from -m, each one a paragraph, from -F, or else from the
+// editor. -s adds a Signed-off-by trailer for the committer, unless the message ends with one
+// already. -S signs the commit with gpg, as does commit.gpgSign when set; --no-gpg-sign
+// overrides that.
+func cmdCommit(args []string) {
+	usage := "usage: mygit commit [-m <msg>]... [-F <file>] [-s|--signoff] [-S[<keyid>]|--no-gpg-sign] [--allow-empty]\n"
+	var messages []string
+	messageFile := ""
+	signoff, allowEmpty := false, false
+	sign := configBool("commit.gpgSign", false)
+	keyID := ""
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case (arg == "-m" || arg == "--message") && i+1 < len(args):
+			i++
+			messages = append(messages, args[i])
+		case strings.HasPrefix(arg, "--message="):
+			messages = append(messages, strings.TrimPrefix(arg, "--message="))
+		case strings.HasPrefix(arg, "-m") && len(arg) > 2:
+			messages = append(messages, arg[2:])
+		case (arg == "-F" || arg == "--file") && i+1 < len(args):
+			i++
+			messageFile = args[i]
+		case strings.HasPrefix(arg, "--file="):
+			messageFile = strings.TrimPrefix(arg, "--file=")
+		case arg == "-s" || arg == "--signoff":
+			signoff = true
+		case arg == "--no-signoff":
+			signoff = false
+		case arg == "--allow-empty":
+			allowEmpty = true
+		case arg == "-S" || arg == "--gpg-sign":
+			sign = true
+		case strings.HasPrefix(arg, "-S"):
+			sign, keyID = true, arg[2:]
+		case strings.HasPrefix(arg, "--gpg-sign="):
+			sign, keyID = true, strings.TrimPrefix(arg, "--gpg-sign=")
+		case arg == "--no-gpg-sign":
+			sign = false
+		default:
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(129)
+		}
+	}
+	fatal := func(err error) {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	if len(messages) > 0 && messageFile != "" {
+		fatal(errors.New("Only one of -m or -F can be used."))
+	}
+
+	head, err := headCommit()
+	if err != nil {
+		fatal(err)
+	}
+	entries, err := readIndex()
+	if err != nil && !os.IsNotExist(err) {
+		fatal(err)
+	}
+	tree, err := indexTree(entries)
+	if err == errUnmerged {
+		fmt.Fprintln(os.Stderr, "error: Committing is not possible because you have unmerged files.")
+		os.Exit(128)
+	} else if err != nil {
+		fatal(err)
+	}
+	var parents []string
+	if head != "" {
+		parents = append(parents, head)
+	}
+	merging := false
+	if theirs, err := os.ReadFile(mergeHeadPath); err == nil {
+		merging = true
+		parents = append(parents, strings.TrimSpace(string(theirs)))
+	}
+	var picked *Commit //the commit being cherry-picked, whose author the commit keeps
+	if pickedSHA, err := os.ReadFile(cherryPickHeadPath); err == nil {
+		if picked, err = readCommit(strings.TrimSpace(string(pickedSHA))); err != nil {
+			fatal(err)
+		}
+	}
+	headTree, err := commitTree(head)
+	if err != nil {
+		fatal(err)
+	}
+	if !merging && !allowEmpty && (tree == headTree || head == "" && len(entries) == 0) {
+		branch, _ := currentBranch()
+		if branch != "" {
+			fmt.Printf("On branch %s\n", branch)
+		}
+		if changes, _ := uncommittedChanges(headTree); len(changes) > 0 {
+			fmt.Println("no changes added to commit (use \"mygit add\")")
+		} else {
+			fmt.Println("nothing to commit, working tree clean")
+		}
+		os.Exit(1)
+	}
+
+	var message string
+	switch {
+	case len(messages) > 0:
+		message = stripMessageSpace(strings.Join(messages, "\n\n"))
+	case messageFile != "":
+		contents, err := os.ReadFile(messageFile)
+		if err != nil {
+			fatal(fmt.Errorf("could not read log file '%s': %s", messageFile, err))
+		}
+		message = stripMessageSpace(string(contents))
+	default:
+		template := "\n# Please enter the commit message for your changes. Lines starting\n" +
+			"# with '#' will be ignored, and an empty message aborts the commit.\n"
+		if merging || picked != nil {
+			if merged, err := os.ReadFile(mergeMsgPath); err == nil {
+				template = string(merged) + template
+			}
+		}
+		editPath := path.Join(".git", "COMMIT_EDITMSG")
+		if err := os.WriteFile(editPath, []byte(template), 0644); err != nil {
+			fatal(err)
+		}
+		if err := launchEditor(editPath); err != nil {
+			fatal(err)
+		}
+		edited, err := os.ReadFile(editPath)
+		if err != nil {
+			fatal(err)
+		}
+		message = stripMessageSpace(cleanupMessage(string(edited)))
+	}
+	if message == "" {
+		fmt.Fprintln(os.Stderr, "Aborting commit due to empty commit message.")
+		os.Exit(1)
+	}
+	commit := &Commit{Tree: tree, Parents: parents, Message: message}
+	if commit.Author, commit.Committer, err = commitSignatures(); err != nil {
+		fatal(err)
+	}
+	if picked != nil {
+		commit.Author = picked.Author
+	}
+	if signoff {
+		commit.Message = appendSignoff(message, signatureIdentity(commit.Committer))
+	}
+	if sign {
+		if commit.GPGSig, err = signPayload(encodeCommit(commit), keyID); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\nfatal: failed to write commit object\n", err)
+			os.Exit(128)
+		}
+	}
+	if merging || picked != nil {
+		if err := rerereRecordResolutions(); err != nil {
+			fatal(err)
+		}
+	}
+	sha, err := writeCommit(commit)
+	if err != nil {
+		fatal(err)
+	}
+	action := "commit"
+	switch {
+	case head == "":
+		action = "commit (initial)"
+	case merging:
+		action = "commit (merge)"
+	}
+	if err := updateHead(sha, action+": "+commit.Subject()); err != nil {
+		fatal(err)
+	}
+	os.Remove(mergeHeadPath)
+	os.Remove(cherryPickHeadPath)
+	os.Remove(mergeMsgPath)
+
+	branch := "detached HEAD"
+	if isBranch, ref, _ := ReadHEAD(".git"); isBranch {
+		branch = strings.TrimPrefix(ref, "refs/heads/")
+	}
+	if head == "" {
+		branch += " (root-commit)"
+	}
+	fmt.Printf("[%s %s] %s\n", branch, sha[:7], commit.Subject())
+}
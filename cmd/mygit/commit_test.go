@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCommitAdvancesHeadsBranch(t *testing.T) {
+	initTestRepo(t)
+	branchFile := filepath.Join(".git", "refs", "heads", "master")
+	if _, err := os.Stat(branchFile); err == nil {
+		t.Fatal("master exists before the first commit")
+	}
+
+	// the first commit creates the branch HEAD names
+	writeTestFile(t, "a", "1\n")
+	runTestCommand(t, "add", "a")
+	runTestCommand(t, "commit", "-m", "first")
+	contents, err := os.ReadFile(branchFile)
+	if err != nil {
+		t.Fatalf("the first commit didn't create master: %v", err)
+	}
+	first := strings.TrimSpace(string(contents))
+	if commit, err := readCommit(first); err != nil || len(commit.Parents) != 0 {
+		t.Fatalf("master is at %s (%v), want a root commit", first, err)
+	}
+
+	// later ones move it on, with the commit before as parent
+	writeTestFile(t, "a", "2\n")
+	runTestCommand(t, "add", "a")
+	runTestCommand(t, "commit", "-m", "second")
+	second, _ := readRef("refs/heads/master")
+	if commit, err := readCommit(second); err != nil || strings.Join(commit.Parents, " ") != first {
+		t.Errorf("master is at %s (%v), want a child of %s", second, err, first)
+	}
+
+	// commit-tree, the plumbing commit is built on, still leaves refs alone
+	commit, err := readCommit(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	made := strings.TrimSpace(runTestCommand(t, "commit-tree", commit.Tree, "-p", second, "-m", "plumbing"))
+	if _, err := readCommit(made); err != nil {
+		t.Fatalf("commit-tree printed %q: %v", made, err)
+	}
+	if sha, _ := readRef("refs/heads/master"); sha != second {
+		t.Errorf("commit-tree moved master to %s", sha)
+	}
+
+	// on another branch, only that branch moves
+	runTestCommand(t, "switch", "-c", "topic")
+	writeTestFile(t, "a", "3\n")
+	runTestCommand(t, "add", "a")
+	runTestCommand(t, "commit", "-m", "third")
+	topic, _ := readRef("refs/heads/topic")
+	if commit, err := readCommit(topic); err != nil || strings.Join(commit.Parents, " ") != second {
+		t.Errorf("topic is at %s (%v), want a child of %s", topic, err, second)
+	}
+	if sha, _ := readRef("refs/heads/master"); sha != second {
+		t.Errorf("committing on topic moved master to %s", sha)
+	}
+}
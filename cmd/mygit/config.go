@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+/*
+Config files are INI-like:
+
+	[section]
+		key = value
+	[section "subsection"]
+		key = value
+
+Keys are looked up as "section.key" or "section.subsection.key". Section and key names are
+case-insensitive, subsections are not. Global config is read first, then .git/config, then any
+GIT_CONFIG_KEY_<n>/GIT_CONFIG_VALUE_<n> pairs in the environment (n from 0 to GIT_CONFIG_COUNT-1),
+and the last value for a key wins.
+*/
+
+type configEntry struct {
+	key   string
+	value string
+}
+
+func readConfigFile(filename string) []configEntry {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var entries []configEntry
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.LastIndexByte(line, ']')
+			if end < 0 {
+				continue
+			}
+			name, subsection, hasSub := strings.Cut(line[1:end], " ")
+			section = strings.ToLower(name)
+			if hasSub {
+				section += "." + strings.Trim(strings.TrimSpace(subsection), `"`)
+			}
+			continue
+		}
+		key, value, hasValue := strings.Cut(line, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if !hasValue {
+			value = "true" //a bare key is a boolean set to true
+		}
+		entries = append(entries, configEntry{key: section + "." + key, value: parseConfigValue(value)})
+	}
+	return entries
+}
+
+// parseConfigValue strips comments and surrounding quotes and handles escapes
+func parseConfigValue(raw string) string {
+	var value strings.Builder
+	inQuotes := false
+	raw = strings.TrimSpace(raw)
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == '\\' && i+1 < len(raw):
+			i++
+			switch raw[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			default:
+				value.WriteByte(raw[i])
+			}
+		case (c == '#' || c == ';') && !inQuotes:
+			return strings.TrimSpace(value.String())
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return value.String()
+}
+
+func configFiles() []string {
+	var files []string
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, path.Join(home, ".config", "git", "config"), path.Join(home, ".gitconfig"))
+	}
+	return append(files, path.Join(".git", "config"))
+}
+
+func normalizeConfigKey(key string) string {
+	first := strings.IndexByte(key, '.')
+	last := strings.LastIndexByte(key, '.')
+	if first < 0 {
+		return strings.ToLower(key)
+	}
+	return strings.ToLower(key[:first]) + key[first:last] + strings.ToLower(key[last:])
+}
+
+// configEnvEntries reads config passed through the environment, as git -c does for its children
+func configEnvEntries() []configEntry {
+	count, err := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+	if err != nil {
+		return nil
+	}
+	var entries []configEntry
+	for i := 0; i < count; i++ {
+		key, ok := os.LookupEnv(fmt.Sprintf("GIT_CONFIG_KEY_%d", i))
+		if !ok || key == "" {
+			continue
+		}
+		value := os.Getenv(fmt.Sprintf("GIT_CONFIG_VALUE_%d", i))
+		entries = append(entries, configEntry{key: normalizeConfigKey(key), value: value})
+	}
+	return entries
+}
+
+// configGetAll returns every value for a key, in the order they were read
+func configGetAll(key string) []string {
+	key = normalizeConfigKey(key)
+	var values []string
+	var entries []configEntry
+	for _, filename := range configFiles() {
+		entries = append(entries, readConfigFile(filename)...)
+	}
+	for _, entry := range append(entries, configEnvEntries()...) {
+		if entry.key == key {
+			values = append(values, entry.value)
+		}
+	}
+	return values
+}
+
+func configGet(key string) (string, bool) {
+	values := configGetAll(key)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+func configBool(key string, fallback bool) bool {
+	value, ok := configGet(key)
+	if !ok {
+		return fallback
+	}
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true
+	case "false", "no", "off", "0", "":
+		return false
+	}
+	return fallback
+}
+
+// defaultRemote is the remote the current branch tracks, or origin
+func defaultRemote() string {
+	if branch, err := currentBranch(); err == nil && branch != "" {
+		if remote, ok := configGet("branch." + branch + ".remote"); ok {
+			return remote
+		}
+	}
+	return "origin"
+}
+
+// remoteURL resolves a configured remote name to its URL; anything else is taken as a URL already
+func remoteURL(remote string) string {
+	if url, ok := configGet("remote." + remote + ".url"); ok {
+		return strings.TrimRight(url, "/")
+	}
+	return strings.TrimRight(remote, "/")
+}
+
+// branchUpstream returns the remote a branch pulls from and the ref it merges on that remote,
+// from branch.<name>.remote and branch.<name>.merge
+func branchUpstream(branch string) (string, string, bool) {
+	remote, hasRemote := configGet("branch." + branch + ".remote")
+	merge, hasMerge := configGet("branch." + branch + ".merge")
+	return remote, merge, hasRemote && hasMerge && branch != ""
+}
+
+// defaultBranchName is the branch a new repository starts on: init.defaultBranch, or master
+func defaultBranchName() string {
+	if name, ok := configGet("init.defaultBranch"); ok && name != "" {
+		return name
+	}
+	return "master"
+}
+
+// configSet sets a key in .git/config, replacing its last value or adding it to the end of its
+// section, which is created if need be
+func configSet(key string, value string) error {
+	key = normalizeConfigKey(key)
+	last := strings.LastIndexByte(key, '.')
+	if last < 0 {
+		return fmt.Errorf("key does not contain a section: %s", key)
+	}
+	section, name := key[:last], key[last+1:]
+	header := "[" + section + "]"
+	if first := strings.IndexByte(section, '.'); first >= 0 {
+		header = fmt.Sprintf("[%s \"%s\"]", section[:first], section[first+1:])
+	}
+	line := "\t" + name + " = " + quoteConfigValue(value)
+
+	filename := path.Join(".git", "config")
+	contents, err := os.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+	if len(contents) == 0 {
+		lines = nil
+	}
+	current, sectionEnd, keyLine := "", -1, -1
+	for i, l := range lines {
+		trimmed := strings.TrimSpace(l)
+		if strings.HasPrefix(trimmed, "[") {
+			if end := strings.LastIndexByte(trimmed, ']'); end >= 0 {
+				sectionName, subsection, hasSub := strings.Cut(trimmed[1:end], " ")
+				current = strings.ToLower(sectionName)
+				if hasSub {
+					current += "." + strings.Trim(strings.TrimSpace(subsection), `"`)
+				}
+			}
+			if current == section {
+				sectionEnd = i
+			}
+			continue
+		}
+		if current != section {
+			continue
+		}
+		sectionEnd = i
+		lineKey, _, _ := strings.Cut(trimmed, "=")
+		if strings.ToLower(strings.TrimSpace(lineKey)) == name {
+			keyLine = i
+		}
+	}
+	switch {
+	case keyLine >= 0:
+		lines[keyLine] = line
+	case sectionEnd >= 0:
+		lines = append(lines[:sectionEnd+1], append([]string{line}, lines[sectionEnd+1:]...)...)
+	default:
+		lines = append(lines, header, line)
+	}
+	return writeFileAtomic(filename, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// configRemoveSection drops a section, such as "branch.topic", and everything in it from
+// .git/config
+func configRemoveSection(section string) error {
+	if first := strings.IndexByte(section, '.'); first >= 0 {
+		section = strings.ToLower(section[:first]) + section[first:]
+	} else {
+		section = strings.ToLower(section)
+	}
+	filename := path.Join(".git", "config")
+	contents, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var kept []string
+	current := ""
+	for _, l := range strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n") {
+		trimmed := strings.TrimSpace(l)
+		if strings.HasPrefix(trimmed, "[") {
+			if end := strings.LastIndexByte(trimmed, ']'); end >= 0 {
+				sectionName, subsection, hasSub := strings.Cut(trimmed[1:end], " ")
+				current = strings.ToLower(sectionName)
+				if hasSub {
+					current += "." + strings.Trim(strings.TrimSpace(subsection), `"`)
+				}
+			}
+		}
+		if current != section {
+			kept = append(kept, l)
+		}
+	}
+	return writeFileAtomic(filename, []byte(strings.Join(kept, "\n")+"\n"), 0644)
+}
+
+// quoteConfigValue quotes a value that wouldn't read back the same bare
+func quoteConfigValue(value string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(value)
+	if escaped != value || strings.ContainsAny(value, "#;") || strings.TrimSpace(value) != value {
+		return `"` + escaped + `"`
+	}
+	return value
+}
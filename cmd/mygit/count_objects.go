@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Usage: mygit count-objects [-v] [-H]
+//
+// Counts the loose objects and the disk space they use; -v adds the packs and anything in the
+// object store that doesn't belong there, and -H prints sizes in KiB/MiB rather than kilobytes.
+func cmdCountObjects(args []string) {
+	verbose, human := false, false
+	for _, arg := range args {
+		switch {
+		case arg == "--verbose":
+			verbose = true
+		case arg == "--human-readable":
+			human = true
+		case len(arg) > 1 && strings.Trim(arg[1:], "vH") == "" && arg[0] == '-':
+			verbose = verbose || strings.Contains(arg, "v")
+			human = human || strings.Contains(arg, "H")
+		default:
+			fmt.Fprintf(os.Stderr, "usage: mygit count-objects [-v] [-H]\n")
+			os.Exit(1)
+		}
+	}
+	counts, err := countObjects()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+
+	size := func(n int64) string {
+		if human {
+			return humanBytes(n)
+		}
+		return fmt.Sprint(n / 1024)
+	}
+	if !verbose {
+		if human {
+			fmt.Printf("%d objects, %s\n", counts.loose, size(counts.looseSize))
+		} else {
+			fmt.Printf("%d objects, %s kilobytes\n", counts.loose, size(counts.looseSize))
+		}
+		return
+	}
+	for _, filePath := range counts.garbage {
+		fmt.Fprintf(os.Stderr, "warning: garbage found: %s\n", filePath)
+	}
+	fmt.Printf("count: %d\n", counts.loose)
+	fmt.Printf("size: %s\n", size(counts.looseSize))
+	fmt.Printf("in-pack: %d\n", counts.inPack)
+	fmt.Printf("packs: %d\n", counts.packs)
+	fmt.Printf("size-pack: %s\n", size(counts.packSize))
+	fmt.Printf("prune-packable: %d\n", counts.prunePackable)
+	fmt.Printf("garbage: %d\n", len(counts.garbage))
+	fmt.Printf("size-garbage: %s\n", size(counts.garbageSize))
+}
+
+type objectCounts struct {
+	loose, inPack, packs, prunePackable int
+	garbage                             []string
+	looseSize, packSize, garbageSize    int64 //loose objects by disk usage, the rest by length
+}
+
+// countObjects tallies the object store: loose objects, packs and the objects in them, loose
+// objects a pack already has, and files that are neither
+func countObjects() (*objectCounts, error) {
+	counts := &objectCounts{}
+	garbage := func(filePath string, fi os.FileInfo) {
+		counts.garbage = append(counts.garbage, filePath)
+		counts.garbageSize += fi.Size()
+	}
+
+	packDir := filepath.Join(".git", "objects", "pack")
+	indexFiles, err := packIndexFiles()
+	if err != nil {
+		return nil, err
+	}
+	var packs []*packIndex
+	for _, indexFile := range indexFiles {
+		packInfo, err := os.Stat(packFileFor(indexFile))
+		if err != nil {
+			continue //an index without its pack is reported as garbage below
+		}
+		indexInfo, err := os.Stat(indexFile)
+		if err != nil {
+			return nil, err
+		}
+		idx, err := readPackIndex(indexFile)
+		if err != nil {
+			return nil, err
+		}
+		packs = append(packs, idx)
+		counts.packs++
+		counts.inPack += len(idx.shas)
+		counts.packSize += packInfo.Size() + indexInfo.Size()
+	}
+	if files, err := os.ReadDir(packDir); err == nil {
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+			name := file.Name()
+			ext := filepath.Ext(name)
+			base := filepath.Join(packDir, strings.TrimSuffix(name, ext))
+			ok := strings.HasPrefix(name, "pack-")
+			switch ext {
+			case ".pack":
+				ok = ok && fileExists(base+".idx")
+			case ".idx", ".keep", ".bitmap", ".rev", ".promisor", ".mtimes":
+				ok = ok && fileExists(base+".pack")
+			default:
+				ok = false
+			}
+			if !ok {
+				if fi, err := file.Info(); err == nil {
+					garbage(filepath.Join(packDir, name), fi)
+				}
+			}
+		}
+	}
+
+	dirs, err := os.ReadDir(filepath.Join(".git", "objects"))
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 || !isHex(dir.Name()) {
+			continue
+		}
+		dirPath := filepath.Join(".git", "objects", dir.Name())
+		files, err := os.ReadDir(dirPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			fi, err := file.Info()
+			if err != nil {
+				return nil, err
+			}
+			if len(file.Name()) != 38 || !isHex(file.Name()) {
+				garbage(filepath.Join(dirPath, file.Name()), fi)
+				continue
+			}
+			counts.loose++
+			counts.looseSize += diskUsage(fi)
+			sha := dir.Name() + file.Name()
+			for _, idx := range packs {
+				if idx.contains(sha) {
+					counts.prunePackable++
+					break
+				}
+			}
+		}
+	}
+	return counts, nil
+}
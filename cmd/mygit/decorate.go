@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// refDecoration is a ref shown next to the commit it points at by log --decorate
+type refDecoration struct {
+	name string //as shown, e.g. "main", "tag: v1.0" or "origin/main"
+	slot string //its color.decorate.<slot>: HEAD, branch, remoteBranch, tag or stash
+}
+
+// decorationColors holds git's default colors for each color.decorate slot
+var decorationColors = map[string]string{
+	"HEAD":         "\x1b[1;36m",
+	"branch":       "\x1b[1;32m",
+	"remoteBranch": "\x1b[1;31m",
+	"tag":          "\x1b[1;33m",
+	"stash":        "\x1b[1;35m",
+}
+
+// loadDecorations maps each commit to the refs pointing at it, annotated tags included, in the
+// order git lists them: HEAD first (joined with the branch it is on, as "HEAD -> main"), then
+// the rest by name, last first. full keeps the "refs/..." prefixes.
+func loadDecorations(full bool) (map[string][]refDecoration, error) {
+	refs, err := listRefs("refs")
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(refs))
+	for ref := range refs {
+		names = append(names, ref)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	head, _ := headCommit()
+	isBranch, headRef, _ := ReadHEAD(".git")
+	branch := strings.TrimPrefix(headRef, "refs/heads/")
+	decorations := map[string][]refDecoration{}
+	switch {
+	case head == "":
+	case isBranch:
+		decorations[head] = []refDecoration{{"HEAD", "HEAD"}}
+	default:
+		decorations[head] = []refDecoration{{"HEAD detached at " + head[:7], "HEAD"}}
+		headRef = ""
+	}
+	for _, ref := range names {
+		sha := refs[ref]
+		if ref == headRef && sha == head {
+			continue //shown as part of "HEAD -> <branch>"
+		}
+		short, slot := ref, "branch"
+		switch {
+		case strings.HasPrefix(ref, "refs/heads/"):
+			short = strings.TrimPrefix(ref, "refs/heads/")
+		case strings.HasPrefix(ref, "refs/remotes/"):
+			short, slot = strings.TrimPrefix(ref, "refs/remotes/"), "remoteBranch"
+		case strings.HasPrefix(ref, "refs/tags/"):
+			short, slot = "tag: "+strings.TrimPrefix(ref, "refs/tags/"), "tag"
+			if full {
+				short = "tag: " + ref
+			}
+		case ref == "refs/stash":
+			short, slot = "stash", "stash"
+		}
+		if full && slot != "tag" {
+			short = ref
+		}
+		decoration := refDecoration{short, slot}
+		decorations[sha] = append(decorations[sha], decoration)
+		if slot == "tag" {
+			// an annotated tag also decorates the commit it points at
+			if commit, err := peelToCommit(sha); err == nil && commit != sha {
+				decorations[commit] = append(decorations[commit], decoration)
+			}
+		}
+	}
+	if head != "" && headRef != "" && refs[headRef] == head {
+		name := branch
+		if full {
+			name = headRef
+		}
+		decorations[head][0] = refDecoration{"HEAD -> " + name, "HEAD"}
+	}
+	return decorations, nil
+}
+
+// formatDecorations renders decorations as " (HEAD -> main, tag: v1.0)", with the parentheses
+// and commas in the commit color and each ref in its slot's color
+func formatDecorations(decorations []refDecoration, colors logColors) string {
+	if len(decorations) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(colorize(colors.commit, " ("))
+	for i, decoration := range decorations {
+		if i > 0 {
+			b.WriteString(colorize(colors.commit, ", "))
+		}
+		if head, branch, ok := strings.Cut(decoration.name, " -> "); ok {
+			b.WriteString(colorize(colors.decorate["HEAD"], head+" -> "))
+			b.WriteString(colorize(colors.decorate["branch"], branch))
+			continue
+		}
+		b.WriteString(colorize(colors.decorate[decoration.slot], decoration.name))
+	}
+	b.WriteString(colorize(colors.commit, ")"))
+	return b.String()
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Usage: mygit diff [--cached] [-U<n>] [--ignore-cr-at-eol] [--ignore-submodules[=<when>]] [--[no-]color[=<when>]] [--color-words[=<regex>]] [<tree-ish> [<tree-ish>]] [--] [<path>...]
+//
+// Shows the changes in the working tree that aren't staged, as a patch against the index, with
+// -U lines of context around each change, 3 by default. --cached (or --staged) shows the staged
+// changes instead, as a patch from HEAD's tree to the index. Either way nothing is printed when
+// there are no changes.
+// Given a tree-ish, the working tree, or with --cached the index, is compared with it instead
+// of the index or HEAD. Given two, they are compared with each other; two blobs, such as
+// HEAD~1:file and HEAD:file, give the patch between them, under the paths they were named by.
+// Leading arguments that name objects are taken as these, unless a file of that name exists;
+// the rest are paths.
+// --ignore-cr-at-eol treats a line ending in CRLF as the same as one ending in LF, so files
+// that differ only in line endings aren't shown. With color, trailing whitespace on added lines
+// is highlighted, a trailing CR included unless the file's whitespace rules have cr-at-eol.
+//
+// --color-words shows each hunk as a word diff in color: the new text, with the old words
+// removed from it in red and the new ones in green. The regex sets what a word is, by default
+// a run of non-whitespace.
+//
+// A submodule shows as the commit it has checked out, with "-dirty" after it when its working
+// tree has changes. --ignore-submodules, by default diff.ignoreSubmodules, leaves out its
+// untracked files ("untracked"), all changes to its working tree ("dirty") or the submodule
+// altogether ("all", which a bare --ignore-submodules means). Untracked files only count with
+// "none"; by default they are left out too.
+func cmdDiff(args []string) {
+	usage := "usage: mygit diff [--cached] [-U<n>] [--ignore-cr-at-eol] [--ignore-submodules[=none|untracked|dirty|all]] [--[no-]color[=<when>]] [--color-words[=<regex>]] [<tree-ish> [<tree-ish>]] [--] [<path>...]\n"
+	var opts diffOptions
+	opts.ignoreSubmodules, _ = configGet("diff.ignoreSubmodules")
+	colorWhen := ""
+	cached, separated := false, false
+	var names, pathspecs []string
+	for i, arg := range args {
+		if arg == "--" {
+			pathspecs, separated = args[i+1:], true
+			break
+		}
+		switch {
+		case arg == "--cached" || arg == "--staged":
+			cached = true
+		case arg == "-U" || arg == "--unified":
+			opts.setContext = false
+		case strings.HasPrefix(arg, "-U") || strings.HasPrefix(arg, "--unified="):
+			value := strings.TrimPrefix(strings.TrimPrefix(arg, "-U"), "--unified=")
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				fmt.Fprintf(os.Stderr, "error: --unified expects a numerical value\n")
+				os.Exit(129)
+			}
+			opts.context, opts.setContext = n, true
+		case arg == "--color-words" || strings.HasPrefix(arg, "--color-words="):
+			regex := defaultWordRegex
+			if value, ok := strings.CutPrefix(arg, "--color-words="); ok {
+				regex = value
+			}
+			re, err := regexp.Compile(regex)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: invalid regular expression: %s\n", regex)
+				os.Exit(128)
+			}
+			opts.wordRegex = re
+			colorWhen = "always"
+		case arg == "--ignore-cr-at-eol":
+			opts.ignoreCRAtEOL = true
+		case arg == "--ignore-submodules":
+			opts.ignoreSubmodules = "all"
+		case strings.HasPrefix(arg, "--ignore-submodules="):
+			opts.ignoreSubmodules = strings.TrimPrefix(arg, "--ignore-submodules=")
+			if !ignoreSubmodulesValue(opts.ignoreSubmodules) {
+				fmt.Fprintf(os.Stderr, "fatal: bad --ignore-submodules argument: %s\n", opts.ignoreSubmodules)
+				os.Exit(128)
+			}
+		case arg == "--color":
+			colorWhen = "always"
+		case strings.HasPrefix(arg, "--color="):
+			colorWhen = strings.TrimPrefix(arg, "--color=")
+			if !validColorWhen(colorWhen) {
+				fmt.Fprintf(os.Stderr, "error: option `color' expects \"always\", \"auto\", or \"never\"\n")
+				os.Exit(129)
+			}
+		case arg == "--no-color":
+			colorWhen = "never"
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		default:
+			names = append(names, arg)
+		}
+	}
+	opts.colors = newDiffColors(useColor("diff", colorWhen))
+
+	// sort out which names are objects to compare: all of them before a "--", otherwise those
+	// at the start that name one and no file
+	var revs []string
+	for len(names) > 0 && (separated || len(revs) < 2) {
+		if !separated {
+			if _, err := resolveObjectArg(names[0]); err != nil || fileExists(repoPath(names[0])) {
+				break
+			}
+		}
+		revs, names = append(revs, names[0]), names[1:]
+	}
+	pathspecs = append(names, pathspecs...)
+	if len(revs) > 2 || cached && len(revs) > 1 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+
+	if cached || len(revs) > 0 {
+		out := bufio.NewWriter(os.Stdout)
+		defer out.Flush()
+		var err error
+		switch {
+		case len(revs) == 2:
+			err = diffObjects(out, revs[0], revs[1], pathspecs, opts)
+		case cached && len(revs) == 0:
+			err = diffCached(out, "HEAD", pathspecs, opts)
+		case cached:
+			err = diffCached(out, revs[0], pathspecs, opts)
+		default:
+			err = diffTreeToWorktree(out, revs[0], pathspecs, opts)
+		}
+		if err != nil {
+			out.Flush()
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		return
+	}
+	entries, err := readIndex()
+	if os.IsNotExist(err) {
+		return //nothing staged, so nothing to compare against
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading index: %s\n", err)
+		os.Exit(1)
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	if err := diffIndexToWorktree(out, entries, pathspecs, opts); err != nil {
+		out.Flush()
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+}
+
+// diffIndexToWorktree writes a patch for every index entry whose working tree file differs.
+// Entries whose cached stat data still matches the file are taken as unchanged without reading
+// the file or the blob.
+func diffIndexToWorktree(out *bufio.Writer, entries []indexEntry, pathspecs []string, opts diffOptions) error {
+	lastUnmerged := ""
+	for _, entry := range entries {
+		if !matchesPathspec(entry.path, pathspecs) {
+			continue
+		}
+		if entry.stage() != 0 {
+			if entry.path != lastUnmerged {
+				fmt.Fprintf(out, "* Unmerged path %s\n", entry.path)
+				lastUnmerged = entry.path
+			}
+			continue
+		}
+		if entry.mode == 0o160000 {
+			worktree, err := submoduleSide(entry, opts.ignoreSubmodules)
+			if err != nil {
+				return err
+			}
+			if worktree != nil {
+				recorded := &diffSide{mode: entry.mode, sha: entry.sha, contents: []byte("Subproject commit " + entry.sha + "\n")}
+				writePatch(out, entry.path, recorded, worktree, opts)
+			}
+			continue
+		}
+		changed, err := worktreeChanged(entry)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			continue
+		}
+
+		var worktree *diffSide
+		contents, fi, err := readWorktreeFile(entry.path)
+		if err == nil && !fi.IsDir() {
+			worktree = &diffSide{mode: fileMode(fi), sha: hashObject("blob", contents), contents: contents}
+			if worktree.sha == entry.sha && worktree.mode == entry.mode {
+				continue //touched, but the contents are the same
+			}
+		} else if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		_, staged, err := parseObject(entry.sha)
+		if err != nil {
+			return err
+		}
+		writePatch(out, entry.path, &diffSide{mode: entry.mode, sha: entry.sha, contents: staged}, worktree, opts)
+	}
+	return nil
+}
+
+// diffCached writes a patch for every path whose staged entry differs from the tree of rev;
+// against HEAD, that is what committing would change
+func diffCached(out *bufio.Writer, rev string, pathspecs []string, opts diffOptions) error {
+	head, err := headCommit()
+	if err != nil {
+		return err
+	}
+	headTree, err := commitTree(head)
+	if err != nil {
+		return err
+	}
+	headFiles, err := treeFiles(headTree)
+	if err != nil {
+		return err
+	}
+	entries, err := loadIndex(headFiles)
+	if err != nil {
+		return err
+	}
+	files := headFiles
+	if rev != "HEAD" {
+		tree, err := revisionTree(rev)
+		if err != nil {
+			return err
+		}
+		if files, err = treeFiles(tree); err != nil {
+			return err
+		}
+	}
+	staged := map[string]treeEntry{}
+	unmerged := map[string]bool{}
+	for _, entry := range entries {
+		if entry.stage() != 0 {
+			unmerged[entry.path] = true
+		} else {
+			staged[entry.path] = treeEntry{mode: entry.mode, sha: entry.sha}
+		}
+	}
+	seen := map[string]bool{}
+	for filePath := range unmerged {
+		seen[filePath] = true
+	}
+	for _, m := range []map[string]treeEntry{files, staged} {
+		for filePath := range m {
+			seen[filePath] = true
+		}
+	}
+	paths := make([]string, 0, len(seen))
+	for filePath := range seen {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+
+	for _, filePath := range paths {
+		if !matchesPathspec(filePath, pathspecs) {
+			continue
+		}
+		if unmerged[filePath] {
+			fmt.Fprintf(out, "* Unmerged path %s\n", filePath)
+			continue
+		}
+		committed, entry := files[filePath], staged[filePath]
+		if committed.sha == entry.sha && committed.mode == entry.mode {
+			continue
+		}
+		before, err := entrySide(committed)
+		if err != nil {
+			return err
+		}
+		after, err := entrySide(entry)
+		if err != nil {
+			return err
+		}
+		writePatch(out, filePath, before, after, opts)
+	}
+	return nil
+}
+
+// diffTreeToWorktree writes a patch for every path where the working tree differs from the
+// tree of rev, among the paths that tree or the index has
+func diffTreeToWorktree(out *bufio.Writer, rev string, pathspecs []string, opts diffOptions) error {
+	tree, err := revisionTree(rev)
+	if err != nil {
+		return err
+	}
+	files, err := treeFiles(tree)
+	if err != nil {
+		return err
+	}
+	entries, err := readIndex()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	seen := map[string]bool{}
+	for filePath := range files {
+		seen[filePath] = true
+	}
+	for _, entry := range entries {
+		seen[entry.path] = true
+	}
+	paths := make([]string, 0, len(seen))
+	for filePath := range seen {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+
+	for _, filePath := range paths {
+		committed := files[filePath]
+		if !matchesPathspec(filePath, pathspecs) || committed.mode == 0o160000 {
+			continue
+		}
+		var worktree *diffSide
+		contents, fi, err := readWorktreeFile(filePath)
+		if err == nil && !fi.IsDir() {
+			worktree = &diffSide{mode: fileMode(fi), sha: hashObject("blob", contents), contents: contents}
+		} else if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if worktree != nil && worktree.sha == committed.sha && worktree.mode == committed.mode ||
+			worktree == nil && committed.sha == "" {
+			continue
+		}
+		before, err := entrySide(committed)
+		if err != nil {
+			return err
+		}
+		writePatch(out, filePath, before, worktree, opts)
+	}
+	return nil
+}
+
+// diffObjects compares two objects given by name: two blobs give one patch, under the paths
+// they were named by, and two tree-ishes a patch for each path that differs between them
+func diffObjects(out *bufio.Writer, a string, b string, pathspecs []string, opts diffOptions) error {
+	var shas, types [2]string
+	for i, name := range []string{a, b} {
+		sha, err := resolveObjectArg(name)
+		if err != nil {
+			return err
+		}
+		objType, _, err := readObjectHeader(sha)
+		if err != nil {
+			return err
+		}
+		shas[i], types[i] = sha, objType
+	}
+	if types[0] == "blob" || types[1] == "blob" {
+		if types[0] != types[1] {
+			return fmt.Errorf("cannot compare a blob with a tree-ish: %s and %s", a, b)
+		}
+		var sides [2]*diffSide
+		var paths [2]string
+		for i, name := range []string{a, b} {
+			_, contents, err := parseObject(shas[i])
+			if err != nil {
+				return err
+			}
+			sides[i] = &diffSide{mode: 0o100644, sha: shas[i], contents: contents}
+			paths[i] = name
+			if _, objectPath, ok := strings.Cut(name, ":"); ok {
+				paths[i] = objectPath
+			}
+		}
+		if shas[0] != shas[1] {
+			writePatchPaths(out, paths[0], paths[1], sides[0], sides[1], opts)
+		}
+		return nil
+	}
+
+	var trees [2]string
+	for i, sha := range shas {
+		tree, err := peelToTree(sha)
+		if err != nil {
+			return err
+		}
+		trees[i] = tree
+	}
+	changes, err := diffTrees(trees[0], trees[1], true)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if !matchesPathspec(change.path, pathspecs) {
+			continue
+		}
+		before, err := entrySide(change.a)
+		if err != nil {
+			return err
+		}
+		after, err := entrySide(change.b)
+		if err != nil {
+			return err
+		}
+		writePatch(out, change.path, before, after, opts)
+	}
+	return nil
+}
+
+// revisionTree is the tree a tree-ish names
+func revisionTree(rev string) (string, error) {
+	sha, err := resolveObjectArg(rev)
+	if err != nil {
+		return "", err
+	}
+	return peelToTree(sha)
+}
+
+// splitLines splits data after every newline; the last line may lack one
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n') + 1
+		if end == 0 {
+			end = len(data)
+		}
+		lines = append(lines, string(data[:end]))
+		data = data[end:]
+	}
+	return lines
+}
+
+// lineMatch pairs up a line of a with an identical line of b
+type lineMatch struct {
+	a, b int
+}
+
+// matchLines returns the lines a and b have in common, in order, using Myers' O(ND) diff
+func matchLines(a, b []string) []lineMatch {
+	// common prefix and suffix are matched without searching
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var matches []lineMatch
+	for i := 0; i < prefix; i++ {
+		matches = append(matches, lineMatch{i, i})
+	}
+	for _, m := range myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]) {
+		matches = append(matches, lineMatch{m.a + prefix, m.b + prefix})
+	}
+	for i := suffix; i > 0; i-- {
+		matches = append(matches, lineMatch{len(a) - i, len(b) - i})
+	}
+	return matches
+}
+
+func myers(a, b []string) []lineMatch {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return nil
+	}
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+2)
+	var trace [][]int
+
+search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] //step down: insertion from b
+			} else {
+				x = v[offset+k-1] + 1 //step right: deletion from a
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// walk the saved frontiers backwards, collecting the diagonal (matching) moves
+	var reversed []lineMatch
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY && x > 0 && y > 0 {
+			x, y = x-1, y-1
+			reversed = append(reversed, lineMatch{x, y})
+		}
+		x, y = prevX, prevY
+	}
+
+	matches := make([]lineMatch, len(reversed))
+	for i, m := range reversed {
+		matches[len(reversed)-1-i] = m
+	}
+	return matches
+}
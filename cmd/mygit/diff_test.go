@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDiffRevisions(t *testing.T) {
+	initTestRepo(t)
+	first := testCommit(t, 0, "first", map[string]string{"a": "one\n", "d/f": "x\n"})
+	second := testCommit(t, 1, "second", map[string]string{"a": "one\ntwo\n", "b": "b\n", "d/f": "x\n"}, first)
+	if err := updateRef("refs/heads/master", second, ""); err != nil {
+		t.Fatal(err)
+	}
+	runTestCommand(t, "checkout", "-f", "master")
+	writeTestFile(t, "a", "three\n")
+
+	addedB := "diff --git a/b b/b\nnew file mode 100644\nindex 0000000..6178079\n--- /dev/null\n+++ b/b\n@@ -0,0 +1 @@\n+b\n"
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{
+			[]string{"HEAD~1:a", "HEAD:a"},
+			"diff --git a/a b/a\nindex 5626abf..814f4a4 100644\n--- a/a\n+++ b/a\n@@ -1 +1,2 @@\n one\n+two\n",
+		},
+		{
+			[]string{"HEAD~1:a", "HEAD:b"},
+			"diff --git a/a b/b\nindex 5626abf..6178079 100644\n--- a/a\n+++ b/b\n@@ -1 +1 @@\n-one\n+b\n",
+		},
+		{
+			[]string{"HEAD~1", "HEAD"},
+			"diff --git a/a b/a\nindex 5626abf..814f4a4 100644\n--- a/a\n+++ b/a\n@@ -1 +1,2 @@\n one\n+two\n" + addedB,
+		},
+		{[]string{"HEAD~1:d", "HEAD:d"}, ""},
+		{[]string{"HEAD~1", "HEAD", "--", "b"}, addedB},
+		{
+			[]string{"HEAD~1", "a"},
+			"diff --git a/a b/a\nindex 5626abf..2bdf67a 100644\n--- a/a\n+++ b/a\n@@ -1 +1 @@\n-one\n+three\n",
+		},
+		{[]string{"--cached", "HEAD~1", "--", "b"}, addedB},
+		{[]string{"--cached"}, ""},
+	}
+	for _, test := range tests {
+		t.Run(strings.Join(test.args, " "), func(t *testing.T) {
+			if got := runTestCommand(t, append([]string{"diff"}, test.args...)...); got != test.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+// BenchmarkDiffIndexToWorktree diffs a working tree of 10,000 staged files, a hundred of them
+// changed since, against the index
+func BenchmarkDiffIndexToWorktree(b *testing.B) {
+	initTestRepo(b)
+	var entries []indexEntry
+	for i := 0; i < 10000; i++ {
+		name := fmt.Sprintf("dir%02d/file%04d.txt", i%100, i)
+		contents := fmt.Sprintf("file %d\nsecond line\nthird line\n", i)
+		writeTestFile(b, name, contents)
+		rawSha, err := writeObject("blob", []byte(contents))
+		if err != nil {
+			b.Fatal(err)
+		}
+		entry, err := newIndexEntry(name, fmt.Sprintf("%x", rawSha), 0o100644)
+		if err != nil {
+			b.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+	if err := writeIndex(entries); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 10000; i += 100 {
+		writeTestFile(b, entries[i].path, "changed\n")
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		entries, err := readIndex()
+		if err != nil {
+			b.Fatal(err)
+		}
+		out := bufio.NewWriter(io.Discard)
+		if err := diffIndexToWorktree(out, entries, nil, diffOptions{}); err != nil {
+			b.Fatal(err)
+		}
+		out.Flush()
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// diffTreeOptions are the diff-tree flags that shape what is shown for each commit
+type diffTreeOptions struct {
+	recursive  bool
+	patch      bool
+	merges     bool //-m: diff merges against their first parent
+	combined   bool //-c: a combined diff for merges
+	dense      bool //--cc: a combined diff leaving out hunks that match one of the parents
+	root       bool
+	noCommitID bool
+}
+
+// Usage: mygit diff-tree [-r] [-p] [-m] [-c | --cc] [--root] [--no-commit-id] (--stdin | <tree-ish> [<tree-ish>])
+//
+// Compares two trees, or a commit with its parent, printing a raw line per changed path:
+//
+//	:<old mode> <new mode> <old sha> <new sha> <status>	<path>
+//
+// or a patch with -p. Without -r only the top level is compared, so a change inside a
+// directory shows as a changed tree. A commit's SHA is printed before its diff. Root commits are
+// only shown with --root, and merges only with -m, which diffs against the first parent, or
+// with -c/--cc, which diff against all parents at once and show the paths that differ from
+// every one of them.
+//
+// With --stdin, commits are read from stdin one per line, optionally followed by the parents to
+// diff against, and shown one after another with a blank line between them.
+func cmdDiffTree(args []string) {
+	usage := "usage: mygit diff-tree [-r] [-p] [-m] [-c | --cc] [--root] [--no-commit-id] (--stdin | <tree-ish> [<tree-ish>])\n"
+	var opts diffTreeOptions
+	stdin := false
+	var treeishes []string
+	for _, arg := range args {
+		switch arg {
+		case "-r":
+			opts.recursive = true
+		case "-p", "-u", "--patch":
+			opts.patch = true
+		case "-m":
+			opts.merges = true
+		case "-c":
+			opts.combined = true
+		case "--cc":
+			opts.combined, opts.dense, opts.patch = true, true, true
+		case "--root":
+			opts.root = true
+		case "--no-commit-id":
+			opts.noCommitID = true
+		case "--stdin":
+			stdin = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				fmt.Fprint(os.Stderr, usage)
+				os.Exit(1)
+			}
+			treeishes = append(treeishes, arg)
+		}
+	}
+	if opts.patch {
+		opts.recursive = true
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	fatal := func(err error) {
+		out.Flush()
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	if !stdin {
+		var err error
+		switch len(treeishes) {
+		case 1:
+			err = diffTreeCommit(out, treeishes[0], nil, opts)
+		case 2:
+			err = diffTreePair(out, treeishes[0], treeishes[1], opts)
+		default:
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if len(treeishes) > 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	first := true
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var commitOut strings.Builder
+		if err := diffTreeCommit(&commitOut, fields[0], fields[1:], opts); err != nil {
+			fatal(err)
+		}
+		if commitOut.Len() == 0 {
+			continue //a skipped merge or root commit
+		}
+		if !first {
+			fmt.Fprintln(out)
+		}
+		first = false
+		out.WriteString(commitOut.String())
+		out.Flush() //a hook reading the output sees each commit as soon as it is done
+	}
+}
+
+// diffTreePair compares two tree-ishes
+func diffTreePair(w io.Writer, a, b string, opts diffTreeOptions) error {
+	var trees [2]string
+	for i, rev := range []string{a, b} {
+		sha, err := resolveRevision(rev)
+		if err != nil {
+			return err
+		}
+		if trees[i], err = peelToTree(sha); err != nil {
+			return err
+		}
+	}
+	return writeTreeDiff(w, trees[0], trees[1], opts)
+}
+
+// diffTreeCommit compares a commit with its parents, or with the ones given instead
+func diffTreeCommit(w io.Writer, rev string, parents []string, opts diffTreeOptions) error {
+	sha, err := resolveRevision(rev)
+	if err != nil {
+		return err
+	}
+	if sha, err = peelToCommit(sha); err != nil {
+		return err
+	}
+	commit, err := readCommit(sha)
+	if err != nil {
+		return err
+	}
+	if len(parents) == 0 {
+		parents = commit.Parents
+	}
+	parentTrees := make([]string, len(parents))
+	for i, parent := range parents {
+		if parentTrees[i], err = commitTree(parent); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case len(parents) == 0 && !opts.root:
+		return nil
+	case len(parents) > 1 && opts.combined:
+		changes, err := combinedTreeChanges(parentTrees, commit.Tree)
+		if err != nil {
+			return err
+		}
+		var diff strings.Builder
+		for _, change := range changes {
+			if err := writeCombinedChange(&diff, change, opts); err != nil {
+				return err
+			}
+		}
+		//unlike for a single parent, git names a merge even when no path differs from them all
+		writeCommitID(w, sha, opts)
+		io.WriteString(w, diff.String())
+		return nil
+	case len(parents) > 1 && !opts.merges:
+		return nil
+	}
+	parentTree := ""
+	if len(parentTrees) > 0 {
+		parentTree = parentTrees[0]
+	}
+	var diff strings.Builder
+	if err := writeTreeDiff(&diff, parentTree, commit.Tree, opts); err != nil {
+		return err
+	}
+	if diff.Len() > 0 {
+		writeCommitID(w, sha, opts)
+		io.WriteString(w, diff.String())
+	}
+	return nil
+}
+
+func writeCommitID(w io.Writer, sha string, opts diffTreeOptions) {
+	if !opts.noCommitID {
+		fmt.Fprintln(w, sha)
+	}
+}
+
+// treeChange is a path whose entry differs between two trees; a zero entry means it is absent
+type treeChange struct {
+	path string
+	a, b treeEntry
+}
+
+// diffTrees lists the paths that differ between two trees, by path. Unless recursive, only the
+// top level is compared, and directories are entries like any other.
+func diffTrees(a, b string, recursive bool) ([]treeChange, error) {
+	entries := func(tree string) (map[string]treeEntry, error) {
+		if recursive || tree == "" {
+			return treeFiles(tree)
+		}
+		list, err := readTree(tree)
+		if err != nil {
+			return nil, err
+		}
+		files := map[string]treeEntry{}
+		for _, entry := range list {
+			files[entry.name] = entry
+		}
+		return files, nil
+	}
+	before, err := entries(a)
+	if err != nil {
+		return nil, err
+	}
+	after, err := entries(b)
+	if err != nil {
+		return nil, err
+	}
+	var changes []treeChange
+	for name, entry := range before {
+		if other, ok := after[name]; !ok || other != entry {
+			changes = append(changes, treeChange{name, entry, other})
+		}
+	}
+	for name, entry := range after {
+		if _, ok := before[name]; !ok {
+			changes = append(changes, treeChange{path: name, b: entry})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].path < changes[j].path })
+	return changes, nil
+}
+
+// writeTreeDiff writes the raw lines, or the patch, for the changes from tree a to tree b
+func writeTreeDiff(w io.Writer, a, b string, opts diffTreeOptions) error {
+	changes, err := diffTrees(a, b, opts.recursive)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if !opts.patch {
+			fmt.Fprintf(w, ":%06o %06o %s %s %c\t%s\n", change.a.mode, change.b.mode,
+				entrySHA(change.a), entrySHA(change.b), changeStatus(change.a, change.b), change.path)
+			continue
+		}
+		before, err := entrySide(change.a)
+		if err != nil {
+			return err
+		}
+		after, err := entrySide(change.b)
+		if err != nil {
+			return err
+		}
+		writePatch(w, change.path, before, after, diffOptions{})
+	}
+	return nil
+}
+
+// entrySHA is an entry's SHA, or all zeros when it is absent
+func entrySHA(entry treeEntry) string {
+	if entry.sha == "" {
+		return zeroSHA
+	}
+	return entry.sha
+}
+
+// changeStatus is the status letter git uses for a path going from a to b
+func changeStatus(a, b treeEntry) byte {
+	switch {
+	case a.sha == "":
+		return 'A'
+	case b.sha == "":
+		return 'D'
+	case a.mode&0o170000 != b.mode&0o170000:
+		return 'T'
+	}
+	return 'M'
+}
+
+// entrySide loads a tree entry for diffing, or returns nil when it is absent
+func entrySide(entry treeEntry) (*diffSide, error) {
+	if entry.sha == "" {
+		return nil, nil
+	}
+	side := &diffSide{mode: entry.mode, sha: entry.sha}
+	if entry.mode == 0o160000 {
+		side.contents = []byte("Subproject commit " + entry.sha + "\n")
+		return side, nil
+	}
+	_, contents, err := parseObject(entry.sha)
+	if err != nil {
+		return nil, err
+	}
+	side.contents = contents
+	return side, nil
+}
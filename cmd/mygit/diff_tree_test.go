@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestDiffTreeCombinedNamesTheMerge(t *testing.T) {
+	initTestRepo(t)
+	base := testCommit(t, 0, "base", map[string]string{"a": "a\n", "b": "b\n"})
+	ours := testCommit(t, 1, "ours", map[string]string{"a": "ours\n", "b": "b\n"}, base)
+	theirs := testCommit(t, 2, "theirs", map[string]string{"a": "a\n", "b": "theirs\n"}, base)
+	clean := testCommit(t, 3, "clean merge", map[string]string{"a": "ours\n", "b": "theirs\n"}, ours, theirs)
+	resolved := testCommit(t, 4, "resolved merge", map[string]string{"a": "both\n", "b": "theirs\n"}, ours, theirs)
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"diff-tree", "-r", "-c", clean}, clean + "\n"},
+		{[]string{"diff-tree", "-r", "--cc", clean}, clean + "\n"},
+		{[]string{"diff-tree", "-r", "-c", resolved}, resolved + "\n" +
+			"::100644 100644 100644 b19a1e93bec1317dc6097229e12afaffbfa74dc2 78981922613b2afb6025042ff6bd878ac1994e85 " +
+			"49f33a8c6e8bb31f5d7c68f9c298cac55ec7cd85 MM\ta\n"},
+		{[]string{"diff-tree", "--no-commit-id", "-r", "-c", clean}, ""},
+	}
+	for _, test := range tests {
+		if got := runTestCommand(t, test.args...); got != test.want {
+			t.Errorf("mygit %q:\n%s\nwant:\n%s", test.args, got, test.want)
+		}
+	}
+}
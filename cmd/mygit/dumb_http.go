@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+/*
+A server that only serves files, with no git behind it, can still be cloned over the "dumb"
+HTTP protocol, as long as "git update-server-info" keeps info/refs and objects/info/packs up to
+date in the repository. info/refs lists the refs and HEAD names the default branch. The objects
+are then fetched one at a time, starting at the refs and following commits to their trees and
+parents: loose ones from objects/<xx>/<rest>, and the others by downloading, whole, the pack
+whose index lists them.
+*/
+
+// dumbHTTPError is what discoverRefs returns for a server that doesn't speak the smart protocol
+type dumbHTTPError struct {
+	url string
+}
+
+func (e dumbHTTPError) Error() string {
+	return fmt.Sprintf("%s does not speak the smart HTTP protocol", e.url)
+}
+
+// getDumbFile downloads a file from the repository at url, returning nil when it isn't there
+func getDumbFile(url string, name string) ([]byte, error) {
+	resp, err := http.Get(url + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to get %s/%s (HTTP %d)", url, name, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// discoverDumbRefs reads the refs from info/refs, and HEAD, which is returned as a symref
+// capability the way the smart protocol advertises it
+func discoverDumbRefs(url string) ([]remoteRef, []string, error) {
+	data, err := getDumbFile(url, "info/refs")
+	if err != nil {
+		return nil, nil, err
+	}
+	if data == nil {
+		return nil, nil, fmt.Errorf("repository '%s' not found", url)
+	}
+	var refs []remoteRef
+	shas := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		sha, name, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok || !isHexSHA(sha) {
+			return nil, nil, fmt.Errorf("invalid info/refs from %s", url)
+		}
+		refs = append(refs, remoteRef{sha: sha, name: name})
+		shas[name] = sha
+	}
+
+	var caps []string
+	head, err := getDumbFile(url, "HEAD")
+	if err != nil {
+		return nil, nil, err
+	}
+	target := strings.TrimSpace(string(head))
+	if branch, ok := strings.CutPrefix(target, "ref: "); ok {
+		caps = append(caps, "symref=HEAD:"+branch)
+		target = shas[branch]
+	}
+	if isHexSHA(target) {
+		refs = append([]remoteRef{{sha: target, name: "HEAD"}}, refs...)
+	}
+	return refs, caps, nil
+}
+
+// dumbWalker fetches objects from a repository over the dumb protocol
+type dumbWalker struct {
+	url     string
+	indexes map[string]*packIndex //by pack name, for the packs not downloaded yet; nil until listed
+}
+
+// fetchDumbObjects fetches the wanted objects and everything they reach that isn't here yet
+func fetchDumbObjects(url string, wants []string) error {
+	w := &dumbWalker{url: url}
+	queue := append([]string{}, wants...)
+	seen := map[string]bool{}
+	for len(queue) > 0 {
+		sha := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if seen[sha] {
+			continue
+		}
+		seen[sha] = true
+		if !objectExists(sha) {
+			if err := w.fetchObject(sha); err != nil {
+				return err
+			}
+		}
+		objType, contents, err := parseObject(sha)
+		if err != nil {
+			return err
+		}
+		switch objType {
+		case "commit":
+			commit, err := parseCommit(contents)
+			if err != nil {
+				return err
+			}
+			queue = append(append(queue, commit.Parents...), commit.Tree)
+		case "tree":
+			entries, err := readTree(sha)
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				if entry.mode != 0o160000 {
+					queue = append(queue, entry.sha)
+				}
+			}
+		case "tag":
+			queue = append(queue, tagTarget(contents))
+		}
+	}
+	return nil
+}
+
+// fetchObject fetches an object loose, or else the pack holding it
+func (w *dumbWalker) fetchObject(sha string) error {
+	compressed, err := getDumbFile(w.url, "objects/"+sha[:2]+"/"+sha[2:])
+	if err != nil {
+		return err
+	}
+	if compressed == nil {
+		return w.fetchPackFor(sha)
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return fmt.Errorf("object %s is corrupt: %s", sha, err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return fmt.Errorf("object %s is corrupt: %s", sha, err)
+	}
+	header, contents, ok := bytes.Cut(data, []byte{0})
+	objType, _, _ := strings.Cut(string(header), " ")
+	if !ok || hashObject(objType, contents) != sha {
+		return fmt.Errorf("object %s is corrupt", sha)
+	}
+	_, err = writeObject(objType, contents)
+	return err
+}
+
+// fetchPackFor downloads and unpacks the pack that holds an object, reading objects/info/packs
+// and the pack indexes the first time
+func (w *dumbWalker) fetchPackFor(sha string) error {
+	if w.indexes == nil {
+		w.indexes = map[string]*packIndex{}
+		list, err := getDumbFile(w.url, "objects/info/packs")
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(list), "\n") {
+			name, ok := strings.CutPrefix(line, "P ")
+			if !ok {
+				continue
+			}
+			indexName := strings.TrimSuffix(name, ".pack") + ".idx"
+			data, err := getDumbFile(w.url, "objects/pack/"+indexName)
+			if err != nil {
+				return err
+			}
+			if data == nil {
+				continue
+			}
+			if w.indexes[name], err = parsePackIndex(data, indexName); err != nil {
+				return err
+			}
+		}
+	}
+	for name, idx := range w.indexes {
+		if !idx.contains(sha) {
+			continue
+		}
+		delete(w.indexes, name)
+		resp, err := http.Get(w.url + "/objects/pack/" + name)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unable to get %s/objects/pack/%s (HTTP %d)", w.url, name, resp.StatusCode)
+		}
+		_, err = unpackObjects(resp.Body)
+		return err
+	}
+	return fmt.Errorf("Unable to find %s under %s", sha, w.url)
+}
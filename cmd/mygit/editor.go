@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// editorCommand is the editor to run, chosen like git: $GIT_EDITOR, core.editor, $VISUAL,
+// $EDITOR, then vi
+func editorCommand() string {
+	if editor := os.Getenv("GIT_EDITOR"); editor != "" {
+		return editor
+	}
+	if editor, ok := configGet("core.editor"); ok && editor != "" {
+		return editor
+	}
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if editor := os.Getenv(name); editor != "" {
+			return editor
+		}
+	}
+	return "vi"
+}
+
+// launchEditor opens filename in the user's editor and waits for it to exit. The editor is run
+// through the shell, so it may carry arguments of its own.
+func launchEditor(filename string) error {
+	editor := editorCommand()
+	if editor == ":" {
+		return nil //git's way of saying "don't edit"
+	}
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, filename)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("There was a problem with the editor '%s'.", editor)
+	}
+	return nil
+}
+
+// isTerminal reports whether f is connected to a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// cleanupMessage drops '#' comment lines, trailing whitespace and leading and trailing blank
+// lines from an edited message. An empty result means the user aborted.
+func cleanupMessage(message string) string {
+	var lines []string
+	for _, line := range strings.Split(message, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, strings.TrimRight(line, " \t\r"))
+		}
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+type fetchUpdate struct {
+	remote   string //remote ref
+	local    string //local ref, "" when only recorded in FETCH_HEAD
+	sha      string
+	force    bool
+	forMerge bool //listed in FETCH_HEAD without not-for-merge
+}
+
+// Usage: mygit fetch [-f|--force] [--tags] [--filter=<filter-spec>] [--upload-pack=<program>] [<remote> [<refspec>...]]
+//
+// Without refspecs on the command line, the remote's configured remote.<name>.fetch refspecs
+// decide which refs are fetched and where they are stored. --filter makes the remote the
+// promisor of a partial clone, as clone --filter does. --upload-pack runs program as the
+// remote's upload-pack over ssh or locally, in place of remote.<name>.uploadpack or
+// git-upload-pack.
+func cmdFetch(args []string) {
+	usage := "usage: mygit fetch [--force] [--tags] [--filter=<filter-spec>] [--upload-pack=<program>] [<remote> [<refspec>...]]\n"
+	force, tags := false, false
+	filter := ""
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-f" || arg == "--force":
+			force = true
+		case arg == "-t" || arg == "--tags":
+			tags = true
+		case arg == "--filter" && i+1 < len(args):
+			i++
+			filter = args[i]
+		case strings.HasPrefix(arg, "--filter="):
+			filter = strings.TrimPrefix(arg, "--filter=")
+		case arg == "--upload-pack" && i+1 < len(args):
+			i++
+			servicePrograms["git-upload-pack"] = args[i]
+		case strings.HasPrefix(arg, "--upload-pack="):
+			servicePrograms["git-upload-pack"] = strings.TrimPrefix(arg, "--upload-pack=")
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if filter != "" && !validFilterSpec(filter) {
+		fmt.Fprintf(os.Stderr, "fatal: invalid filter-spec '%s'\n", filter)
+		os.Exit(128)
+	}
+
+	remote := defaultRemote()
+	var mergeSpecs, otherSpecs []string
+	if len(positional) > 0 {
+		remote, mergeSpecs = positional[0], positional[1:]
+	}
+	if len(mergeSpecs) == 0 {
+		otherSpecs = configGetAll("remote." + remote + ".fetch")
+	}
+	if tags {
+		otherSpecs = append(otherSpecs, "refs/tags/*:refs/tags/*")
+	}
+	if filter != "" {
+		// the remote becomes a promisor, and later fetches from it filter the same way
+		if err := registerPromisor(remote, filter); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+	}
+
+	_, failed, err := fetchRemote(remote, mergeSpecs, otherSpecs, force)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// fetchRemote fetches the refs the refspecs select from a remote, stores them where the
+// refspecs say, and writes FETCH_HEAD. Refs matched by mergeSpecs, the refspecs given on the
+// command line, are marked for merging; when there are none, the current branch's
+// branch.<name>.merge ref is. It returns what was fetched and whether any local ref update was
+// rejected.
+func fetchRemote(remote string, mergeSpecs []string, otherSpecs []string, force bool) ([]fetchUpdate, bool, error) {
+	parse := func(specs []string) ([]refspec, error) {
+		var refspecs []refspec
+		for _, spec := range specs {
+			r, err := parseRefspec(spec)
+			if err != nil {
+				return nil, err
+			}
+			r.force = r.force || force
+			refspecs = append(refspecs, r)
+		}
+		return refspecs, nil
+	}
+	mergeRefspecs, err := parse(mergeSpecs)
+	if err != nil {
+		return nil, false, err
+	}
+	otherRefspecs, err := parse(otherSpecs)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(mergeRefspecs) == 0 && len(otherRefspecs) == 0 {
+		// like git, fetch the remote's HEAD into FETCH_HEAD only
+		mergeRefspecs = append(mergeRefspecs, refspec{src: "HEAD"})
+	}
+	url := remoteURL(remote)
+	remoteServicePrograms(remote)
+
+	refs, caps, err := discoverRefs(url, "git-upload-pack")
+	if err != nil {
+		return nil, false, err
+	}
+	updates, err := matchRefspecs(refs, mergeRefspecs)
+	if err != nil {
+		return nil, false, err
+	}
+	for i := range updates {
+		updates[i].forMerge = true
+	}
+	others, err := matchRefspecs(refs, otherRefspecs)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(mergeRefspecs) == 0 {
+		markBranchMerge(remote, others, otherRefspecs)
+	}
+	updates = append(updates, others...)
+
+	filter := ""
+	if promisor := promisorRemote(); promisor == remote {
+		filter, _ = configGet("remote." + remote + ".partialclonefilter")
+	}
+	if err := fetchObjects(url, caps, updates, filter); err != nil {
+		return nil, false, err
+	}
+	if err := writeFetchHead(url, updates); err != nil {
+		return nil, false, fmt.Errorf("writing FETCH_HEAD: %s", err)
+	}
+	failed := applyFetchUpdates(url, updates)
+	if filter != "" {
+		if err := recordPromisedObjects(); err != nil {
+			return nil, false, err
+		}
+	}
+	return updates, failed, nil
+}
+
+// matchRefspecs pairs each advertised ref with the local ref the first matching refspec maps
+// it to. A non-glob refspec that matches nothing is an error.
+func matchRefspecs(refs []remoteRef, refspecs []refspec) ([]fetchUpdate, error) {
+	var updates []fetchUpdate
+	seen := map[string]bool{}
+	for _, r := range refspecs {
+		matched := false
+		for _, ref := range refs {
+			if strings.HasSuffix(ref.name, "^{}") {
+				continue
+			}
+			local, ok := r.mapRef(ref.name)
+			if !ok {
+				continue
+			}
+			matched = true
+			if local != "" && !strings.HasPrefix(local, "refs/") {
+				if strings.HasPrefix(ref.name, "refs/tags/") {
+					local = "refs/tags/" + local
+				} else {
+					local = "refs/heads/" + local
+				}
+			}
+			if seen[ref.name+":"+local] {
+				continue
+			}
+			seen[ref.name+":"+local] = true
+			updates = append(updates, fetchUpdate{remote: ref.name, local: local, sha: ref.sha, force: r.force})
+			if !r.isGlob() {
+				break
+			}
+		}
+		if !matched && !r.isGlob() {
+			return nil, fmt.Errorf("couldn't find remote ref %s", r.src)
+		}
+	}
+	return updates, nil
+}
+
+// fetchObjects downloads whatever the updates point at that isn't already here, offering
+// every local ref as a starting point for the remote. A non-empty filter leaves out the objects
+// it matches.
+func fetchObjects(url string, caps []string, updates []fetchUpdate, filter string) error {
+	var wants []string
+	wanted := map[string]bool{}
+	for _, update := range updates {
+		if objectExists(update.sha) || wanted[update.sha] {
+			continue
+		}
+		wanted[update.sha] = true
+		wants = append(wants, update.sha)
+	}
+	if len(wants) == 0 {
+		hangUpService(url, "git-upload-pack")
+		return nil
+	}
+
+	localRefs, err := listRefs("refs")
+	if err != nil {
+		return err
+	}
+	var haves []string
+	offered := map[string]bool{}
+	for _, sha := range localRefs {
+		if !offered[sha] {
+			offered[sha] = true
+			haves = append(haves, sha)
+		}
+	}
+	sort.Strings(haves)
+
+	pack, err := fetchPack(url, caps, wants, haves, filter)
+	if err != nil {
+		return err
+	}
+	defer pack.Close()
+	_, err = receivePack(pack)
+	return err
+}
+
+// markBranchMerge picks what a fetch using the configured refspecs leaves for merging: the ref
+// the current branch merges from when it tracks this remote, otherwise whatever the first
+// refspec fetched if that refspec names a single ref
+func markBranchMerge(remote string, updates []fetchUpdate, refspecs []refspec) {
+	mergeRef := ""
+	if branch, err := currentBranch(); err == nil && branch != "" {
+		if configured, _ := configGet("branch." + branch + ".remote"); configured == remote {
+			mergeRef, _ = configGet("branch." + branch + ".merge")
+		}
+	}
+	for i := range updates {
+		if mergeRef != "" && updates[i].remote == mergeRef {
+			updates[i].forMerge = true
+		}
+	}
+	if mergeRef == "" && len(refspecs) > 0 && !refspecs[0].isGlob() && len(updates) > 0 {
+		updates[0].forMerge = true
+	}
+}
+
+// writeFetchHead records what was fetched, one "<sha>\t[not-for-merge]\t<description> of <url>"
+// line per ref. Refs marked for merging come first, so a merge of FETCH_HEAD picks them up. A
+// ref that a configured refspec also matched, to update its remote-tracking ref, is only
+// listed the once, for merging.
+func writeFetchHead(url string, updates []fetchUpdate) error {
+	url = strings.TrimSuffix(url, ".git") //as git shortens it for merge messages
+	merging := map[string]bool{}
+	for _, update := range updates {
+		if update.forMerge {
+			merging[update.remote] = true
+		}
+	}
+	var forMerge, notForMerge []string
+	for _, update := range updates {
+		if !update.forMerge && merging[update.remote] {
+			continue
+		}
+		description := "'" + update.remote + "'"
+		if branch, ok := strings.CutPrefix(update.remote, "refs/heads/"); ok {
+			description = "branch '" + branch + "'"
+		} else if tag, ok := strings.CutPrefix(update.remote, "refs/tags/"); ok {
+			description = "tag '" + tag + "'"
+		}
+		if update.remote == "HEAD" {
+			forMerge = append(forMerge, fmt.Sprintf("%s\t\t%s\n", update.sha, url))
+		} else if update.forMerge {
+			forMerge = append(forMerge, fmt.Sprintf("%s\t\t%s of %s\n", update.sha, description, url))
+		} else {
+			notForMerge = append(notForMerge, fmt.Sprintf("%s\tnot-for-merge\t%s of %s\n", update.sha, description, url))
+		}
+	}
+	return os.WriteFile(".git/FETCH_HEAD", []byte(strings.Join(append(forMerge, notForMerge...), "")), 0644)
+}
+
+// applyFetchUpdates moves the local refs and prints git's summary table, returning true if any
+// update was rejected
+func applyFetchUpdates(url string, updates []fetchUpdate) bool {
+	type line struct{ flag, summary, from, to, note string }
+	var lines []line
+	width := 0
+	failed := false
+	for _, update := range updates {
+		l := line{from: shortRefName(update.remote), to: shortRefName(update.local)}
+		if update.local == "" {
+			l.to = "FETCH_HEAD"
+		}
+		old, err := readRef(update.local)
+		isTag := strings.HasPrefix(update.local, "refs/tags/")
+		switch {
+		case update.local == "" && strings.HasPrefix(update.remote, "refs/tags/"):
+			l.flag, l.summary = "*", "tag"
+		case update.local == "":
+			l.flag, l.summary = "*", "branch"
+		case err == nil && old == update.sha:
+			continue
+		case err != nil && isTag:
+			l.flag, l.summary = "*", "[new tag]"
+		case err != nil && strings.HasPrefix(update.remote, "refs/heads/"):
+			l.flag, l.summary = "*", "[new branch]"
+		case err != nil:
+			l.flag, l.summary = "*", "[new ref]"
+		case isTag && !update.force:
+			l.flag, l.summary, l.note = "!", "[rejected]", "(would clobber existing tag)"
+		default:
+			fastForward, err := isAncestor(old, update.sha)
+			if err != nil {
+				fastForward = false
+			}
+			if fastForward {
+				l.flag, l.summary = " ", old[:7]+".."+update.sha[:7]
+			} else if update.force {
+				l.flag, l.summary, l.note = "+", old[:7]+"..."+update.sha[:7], "(forced update)"
+			} else {
+				l.flag, l.summary, l.note = "!", "[rejected]", "(non-fast-forward)"
+			}
+		}
+		if l.flag == "!" {
+			failed = true
+		} else if update.local != "" {
+			if err := updateRef(update.local, update.sha, ""); err != nil {
+				l.flag, l.summary, l.note = "!", "[error]", "("+err.Error()+")"
+				failed = true
+			}
+		}
+		lines = append(lines, l)
+		if len(l.from) > width {
+			width = len(l.from)
+		}
+	}
+
+	if len(lines) == 0 {
+		return failed
+	}
+	fmt.Fprintf(os.Stderr, "From %s\n", url)
+	for _, l := range lines {
+		out := fmt.Sprintf(" %s %-17s %-*s -> %s", l.flag, l.summary, width, l.from, l.to)
+		if l.note != "" {
+			out += " " + l.note
+		}
+		fmt.Fprintln(os.Stderr, out)
+	}
+	return failed
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFetchHeadListsEachRefOnce(t *testing.T) {
+	upstream := initTestRepo(t)
+	master := testCommit(t, 0, "main", map[string]string{"a": "a\n"})
+	topic := testCommit(t, 1, "topic", map[string]string{"a": "b\n"}, master)
+	for ref, sha := range map[string]string{"refs/heads/master": master, "refs/heads/topic": topic} {
+		if err := updateRef(ref, sha, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	local := t.TempDir()
+	chdirTest(t, local)
+	runTestCommand(t, "init")
+	config := "[remote \"origin\"]\n\turl = " + upstream + "\n\tfetch = +refs/heads/*:refs/remotes/origin/*\n"
+	f, err := os.OpenFile(filepath.Join(".git", "config"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(config)
+	f.Close()
+
+	// pull fetches the branch it merges along with the configured refspecs, which match it too
+	if _, _, err := fetchRemote("origin", []string{"refs/heads/topic"}, configGetAll("remote.origin.fetch"), false); err != nil {
+		t.Fatal(err)
+	}
+	fetchHead, err := os.ReadFile(filepath.Join(".git", "FETCH_HEAD"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := topic + "\t\tbranch 'topic' of " + upstream + "\n" +
+		master + "\tnot-for-merge\tbranch 'master' of " + upstream + "\n"
+	if string(fetchHead) != want {
+		t.Errorf("FETCH_HEAD:\n%s\nwant:\n%s", fetchHead, want)
+	}
+	for ref, sha := range map[string]string{"refs/remotes/origin/master": master, "refs/remotes/origin/topic": topic} {
+		if got, err := readRef(ref); err != nil || got != sha {
+			t.Errorf("%s is at %q (%v), want %s", ref, got, err, sha)
+		}
+	}
+}
+
+func TestFetchOverSmartHTTP(t *testing.T) {
+	git, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git http-backend is needed to serve the fetch")
+	}
+	upstream := initTestRepo(t)
+	first := testCommit(t, 0, "first", map[string]string{"a": "a\n"})
+	if err := updateRef("refs/heads/master", first, ""); err != nil {
+		t.Fatal(err)
+	}
+	backend := &cgi.Handler{
+		Path: git,
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + upstream, "GIT_HTTP_EXPORT_ALL=1", "GIT_CONFIG_NOSYSTEM=1"},
+	}
+	// only the smart protocol is served, so the fetch can't fall back to fetching files
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		smart := r.URL.Query().Get("service") == "git-upload-pack" || strings.HasSuffix(r.URL.Path, "/git-upload-pack")
+		if !smart {
+			http.NotFound(w, r)
+			return
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	local := t.TempDir()
+	chdirTest(t, local)
+	runTestCommand(t, "init")
+	config := "[remote \"origin\"]\n\turl = " + server.URL + "/.git\n\tfetch = +refs/heads/*:refs/remotes/origin/*\n"
+	f, err := os.OpenFile(filepath.Join(".git", "config"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(config)
+	f.Close()
+
+	// the first fetch gets everything, the next only what is new
+	runTestCommand(t, "fetch", "origin")
+	if sha, err := readRef("refs/remotes/origin/master"); err != nil || sha != first {
+		t.Errorf("origin/master is at %q (%v), want %s", sha, err, first)
+	}
+	chdirTest(t, upstream)
+	second := testCommit(t, 1, "second", map[string]string{"a": "b\n"}, first)
+	if err := updateRef("refs/heads/master", second, ""); err != nil {
+		t.Fatal(err)
+	}
+	chdirTest(t, local)
+	runTestCommand(t, "fetch", "origin")
+	if sha, err := readRef("refs/remotes/origin/master"); err != nil || sha != second {
+		t.Errorf("origin/master is at %q (%v) after the second fetch, want %s", sha, err, second)
+	}
+	for _, contents := range []string{"a\n", "b\n"} {
+		if objType, data, err := parseObject(hashObject("blob", []byte(contents))); err != nil || objType != "blob" || string(data) != contents {
+			t.Errorf("the blob of %q wasn't fetched: %v", contents, err)
+		}
+	}
+}
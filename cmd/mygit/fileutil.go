@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path"
+)
+
+// writeFileAtomic writes to a temporary file next to the target and renames it into place, so
+// readers (and crashes) only ever see the old contents or the complete new ones
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tmpFile, err := os.CreateTemp(path.Dir(filename), "."+path.Base(filename)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	if err := os.Chmod(tmpFile.Name(), perm); err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	return os.Rename(tmpFile.Name(), filename)
+}
+
+// linkOrCopyFile hard-links src to dst, falling back to a copy when they are on different
+// filesystems or links aren't allowed
+func linkOrCopyFile(src string, dst string) error {
+	if os.Link(src, dst) == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// refPatternMatch reports whether ref is matched by a for-each-ref pattern: the ref itself, a
+// leading part of it ending at a "/", or a glob
+func refPatternMatch(ref string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(pattern, "/")
+		if ref == pattern || strings.HasPrefix(ref, pattern+"/") {
+			return true
+		}
+		if matched, _ := path.Match(pattern, ref); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// formatRef expands the %(field) placeholders of a --format for one ref, and %% to %
+func formatRef(format string, ref string, sha string) (string, error) {
+	var b strings.Builder
+	for format != "" {
+		i := strings.IndexByte(format, '%')
+		if i < 0 {
+			b.WriteString(format)
+			break
+		}
+		b.WriteString(format[:i])
+		format = format[i:]
+		if strings.HasPrefix(format, "%%") {
+			b.WriteByte('%')
+			format = format[2:]
+			continue
+		}
+		end := strings.IndexByte(format, ')')
+		if !strings.HasPrefix(format, "%(") || end < 0 {
+			b.WriteByte('%')
+			format = format[1:]
+			continue
+		}
+		field := format[2:end]
+		format = format[end+1:]
+		switch field {
+		case "refname":
+			b.WriteString(ref)
+		case "refname:short":
+			b.WriteString(shortRefName(ref))
+		case "objectname":
+			b.WriteString(sha)
+		case "objectname:short":
+			b.WriteString(sha[:7])
+		case "objecttype":
+			objType, _, err := readObjectHeader(sha)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(objType)
+		default:
+			return "", fmt.Errorf("unknown field name: %s", field)
+		}
+	}
+	return b.String(), nil
+}
+
+// Usage: mygit for-each-ref [--format=<format>] [--count=<n>] [--merged[=<commit>]] [--no-merged[=<commit>]] [--contains[=<commit>]] [<pattern>...]
+//
+// Lists the refs matching the patterns, or all of them, sorted by name, as
+// "<objectname> <objecttype>\t<refname>" or in the --format given, which can use %(refname),
+// %(refname:short), %(objectname), %(objectname:short) and %(objecttype). A pattern matches a
+// ref by a leading part of its name, such as refs/heads, or as a glob.
+//
+// --merged keeps only the refs whose commits are reachable from the commit, HEAD by default,
+// --no-merged only those whose commits aren't, and --contains only those whose commits can
+// reach it.
+func cmdForEachRef(args []string) {
+	usage := "usage: mygit for-each-ref [--format=<format>] [--count=<n>] [--merged[=<commit>]] [--no-merged[=<commit>]] [--contains[=<commit>]] [<pattern>...]\n"
+	format := "%(objectname) %(objecttype)\t%(refname)"
+	count := -1
+	var filter refFilter
+	var patterns []string
+	for i := 0; i < len(args); i++ {
+		if n := filter.parseOption(args, i); n > 0 {
+			i += n - 1
+			continue
+		}
+		switch arg := args[i]; {
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.TrimPrefix(arg, "--format=")
+		case strings.HasPrefix(arg, "--count="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--count="))
+			if err != nil || n < 0 {
+				fmt.Fprintf(os.Stderr, "error: invalid --count argument: `%s'\n", strings.TrimPrefix(arg, "--count="))
+				os.Exit(129)
+			}
+			count = n
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(129)
+		default:
+			patterns = append(patterns, arg)
+		}
+	}
+	fatal := func(err error) {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	if err := filter.prepare(); err != nil {
+		fatal(err)
+	}
+
+	refs, err := listRefs("refs")
+	if err != nil {
+		fatal(err)
+	}
+	names := make([]string, 0, len(refs))
+	for ref := range refs {
+		names = append(names, ref)
+	}
+	sort.Strings(names)
+	for _, ref := range names {
+		if count == 0 {
+			break
+		}
+		if !refPatternMatch(ref, patterns) {
+			continue
+		}
+		if ok, err := filter.matches(refs[ref]); err != nil {
+			fatal(err)
+		} else if !ok {
+			continue
+		}
+		line, err := formatRef(format, ref, refs[ref])
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Println(line)
+		count--
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+/*
+fsck reads every loose object and checks that it hashes to its name and parses, then walks
+everything reachable from HEAD, the refs and the index and reports objects that are missing.
+Objects left over are unreachable; those that no other unreachable object points to either are
+dangling, the tips of whatever was lost.
+*/
+
+// Usage: mygit fsck [--unreachable] [--[no-]dangling] [--lost-found]
+//
+// With --lost-found, dangling commits are recorded in .git/lost-found/commit and other dangling
+// objects in .git/lost-found/other, each in a file named by its SHA. Blobs are written out with
+// their contents; everything else holds just its SHA.
+func cmdFsck(args []string) {
+	showUnreachable, showDangling, lostFound := false, true, false
+	for _, arg := range args {
+		switch arg {
+		case "--unreachable":
+			showUnreachable = true
+		case "--dangling":
+			showDangling = true
+		case "--no-dangling":
+			showDangling = false
+		case "--lost-found":
+			lostFound = true
+		default:
+			fmt.Fprintf(os.Stderr, "usage: mygit fsck [--unreachable] [--[no-]dangling] [--lost-found]\n")
+			os.Exit(1)
+		}
+	}
+
+	result, err := fsck()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	for _, sha := range result.unreachable {
+		objType := result.types[sha]
+		if showUnreachable {
+			fmt.Printf("unreachable %s %s\n", objType, sha)
+		} else if showDangling && result.dangling[sha] {
+			fmt.Printf("dangling %s %s\n", objType, sha)
+		}
+		if lostFound && result.dangling[sha] {
+			if err := writeLostFound(sha, objType); err != nil {
+				fmt.Fprintf(os.Stderr, "error: could not write lost-found for %s: %s\n", sha, err)
+				result.broken = true
+			}
+		}
+	}
+	if result.broken {
+		os.Exit(1)
+	}
+}
+
+type fsckResult struct {
+	types       map[string]string //type of every object that could be read
+	unreachable []string          //sorted
+	dangling    map[string]bool
+	broken      bool //a corrupt, missing or unparseable object was reported
+}
+
+func fsck() (*fsckResult, error) {
+	result := &fsckResult{types: map[string]string{}, dangling: map[string]bool{}}
+	errorf := func(format string, a ...any) {
+		fmt.Fprintf(os.Stderr, "error: "+format+"\n", a...)
+		result.broken = true
+	}
+
+	shas, err := looseObjects()
+	if err != nil {
+		return nil, err
+	}
+	links := map[string][]objectLink{} //what each object points to
+	for _, sha := range shas {
+		objType, contents, err := readVerifiedObject(sha)
+		if err != nil {
+			errorf("%s", err)
+			continue
+		}
+		result.types[sha] = objType
+		objLinks, err := objectLinks(objType, contents)
+		if err != nil {
+			errorf("object %s: %s", sha, err)
+			continue
+		}
+		links[sha] = objLinks
+	}
+
+	var roots []string
+	if head, err := readRef("HEAD"); err == nil {
+		roots = append(roots, head)
+	}
+	refs, err := listRefs("refs")
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := result.types[refs[name]]; !ok {
+			errorf("%s: invalid sha1 pointer %s", name, refs[name])
+			continue
+		}
+		roots = append(roots, refs[name])
+	}
+	if entries, err := readIndex(); err == nil {
+		for _, entry := range entries {
+			if entry.mode == 0o160000 {
+				continue
+			}
+			if _, ok := result.types[entry.sha]; !ok {
+				errorf("%s: invalid sha1 pointer in index", entry.sha)
+				continue
+			}
+			roots = append(roots, entry.sha)
+		}
+	}
+
+	// in a partial clone, what the promisor remote still owes isn't missing
+	promised := map[string]bool{}
+	if promisorRemote() != "" {
+		promised = readPromisedObjects()
+	}
+	reachable, missing := map[string]bool{}, map[string]bool{}
+	queue := roots
+	for len(queue) > 0 {
+		sha := queue[0]
+		queue = queue[1:]
+		if reachable[sha] {
+			continue
+		}
+		reachable[sha] = true
+		for _, link := range links[sha] {
+			if _, ok := result.types[link.sha]; !ok {
+				if !missing[link.sha] && !promised[link.sha] {
+					missing[link.sha] = true
+					fmt.Printf("missing %s %s\n", link.objType, link.sha)
+					result.broken = true
+				}
+				continue
+			}
+			queue = append(queue, link.sha)
+		}
+	}
+
+	referenced := map[string]bool{}
+	for _, sha := range shas {
+		if _, ok := result.types[sha]; ok && !reachable[sha] {
+			result.unreachable = append(result.unreachable, sha)
+			for _, link := range links[sha] {
+				referenced[link.sha] = true
+			}
+		}
+	}
+	for _, sha := range result.unreachable {
+		if !referenced[sha] {
+			result.dangling[sha] = true
+		}
+	}
+	return result, nil
+}
+
+// readVerifiedObject reads a loose object like parseObject, but also checks that it hashes to
+// the name it is stored under
+func readVerifiedObject(sha string) (string, []byte, error) {
+	objectFile := objectPath(sha)
+	compressed, err := os.ReadFile(objectFile)
+	if err != nil {
+		return "", nil, err
+	}
+	zlibreader, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", nil, fmt.Errorf("object file %s is corrupt", objectFile)
+	}
+	raw, err := io.ReadAll(zlibreader)
+	if err != nil {
+		return "", nil, fmt.Errorf("object file %s is corrupt", objectFile)
+	}
+	if actual := fmt.Sprintf("%x", sha1.Sum(raw)); actual != sha {
+		return "", nil, fmt.Errorf("hash mismatch for %s (expected %s)", objectFile, sha)
+	}
+	header, contents, ok := bytes.Cut(raw, []byte{0})
+	objType, _, hasSize := strings.Cut(string(header), " ")
+	if !ok || !hasSize {
+		return "", nil, fmt.Errorf("object %s: malformed header", sha)
+	}
+	return objType, contents, nil
+}
+
+// objectLink is a reference from one object to another, with the type it should have
+type objectLink struct {
+	objType string
+	sha     string
+}
+
+// objectLinks lists the objects an object points to
+func objectLinks(objType string, contents []byte) ([]objectLink, error) {
+	switch objType {
+	case "commit":
+		commit, err := parseCommit(contents)
+		if err != nil {
+			return nil, err
+		}
+		links := []objectLink{{"tree", commit.Tree}}
+		for _, parent := range commit.Parents {
+			links = append(links, objectLink{"commit", parent})
+		}
+		return links, nil
+	case "tree":
+		entries, err := parseTree(contents)
+		if err != nil {
+			return nil, err
+		}
+		var links []objectLink
+		for _, entry := range entries {
+			switch {
+			case entry.mode == 0o160000:
+				continue //submodule commit, lives in another repository
+			case entry.isTree():
+				links = append(links, objectLink{"tree", entry.sha})
+			default:
+				links = append(links, objectLink{"blob", entry.sha})
+			}
+		}
+		return links, nil
+	case "tag":
+		link := objectLink{sha: tagTarget(contents)}
+		for _, line := range strings.Split(string(contents), "\n") {
+			if targetType, ok := strings.CutPrefix(line, "type "); ok {
+				link.objType = targetType
+				break
+			}
+		}
+		return []objectLink{link}, nil
+	case "blob":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown object type %s", objType)
+}
+
+// writeLostFound records a dangling object under .git/lost-found
+func writeLostFound(sha string, objType string) error {
+	dir := path.Join(".git", "lost-found", "other")
+	if objType == "commit" {
+		dir = path.Join(".git", "lost-found", "commit")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	contents := []byte(sha + "\n")
+	if objType == "blob" {
+		var err error
+		if _, contents, err = parseObject(sha); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path.Join(dir, sha), contents, 0644)
+}
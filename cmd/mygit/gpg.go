@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+)
+
+// signPayload makes an armored detached signature of data with gpg.program, gpg by default.
+// The key is keyID when given, then user.signingKey, then the committer's identity, which gpg
+// looks up by the user ID.
+func signPayload(data []byte, keyID string) (string, error) {
+	if keyID == "" {
+		keyID, _ = configGet("user.signingKey")
+	}
+	if keyID == "" {
+		identity, err := userIdentity("committer")
+		if err != nil {
+			return "", err
+		}
+		keyID = identity
+	}
+	program, ok := configGet("gpg.program")
+	if !ok || program == "" {
+		program = "gpg"
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(program, "--status-fd=2", "-bsau", keyID)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(data), &stdout, &stderr
+	// gpg reports success on its status channel; the exit code alone isn't enough to go by
+	if err := cmd.Run(); err != nil || !bytes.Contains(append([]byte("\n"), stderr.Bytes()...), []byte("\n[GNUPG:] SIG_CREATED ")) {
+		return "", errors.New("gpg failed to sign the data")
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,532 @@
+package main
+
+import "strings"
+
+/*
+The graph is drawn the way git draws it, one column per line of history still being followed.
+Each commit is drawn in a few states, one row of output each: any pre-commit rows that open up
+room for an octopus merge, the commit row itself, the row where a merge's lines leave it, and
+collapsing rows, where lines that now lead to the same commit, or that were left out of place,
+move one step left per row until every line is back in its column. Then, until the next commit,
+rows just continue each line straight down.
+
+columns are the commits the lines coming into the current commit's row lead to, and newColumns
+those of the lines leaving it. mapping says, for each screen position of the row being drawn,
+which of newColumns the line there ends up in, or -1 for none, and is what the collapsing rows
+work through.
+*/
+
+type graphState int
+
+const (
+	graphPadding graphState = iota
+	graphSkip
+	graphPreCommit
+	graphCommit
+	graphPostMerge
+	graphCollapsing
+)
+
+type graph struct {
+	commit  string
+	parents []string //the ones the graph leads to: those shown, and with --boundary the boundary
+	node    byte
+
+	width        int //of the current row, so that every row of a commit is padded alike
+	expansionRow int
+
+	state, prevState             graphState
+	commitIndex, prevCommitIndex int
+	mergeLayout                  int //0 when a merge's first parent is to its right, 1 when not
+	edgesAdded, prevEdgesAdded   int
+
+	columns, newColumns []string
+	mapping, oldMapping []int
+	mappingSize         int
+}
+
+// update moves the graph on to the next commit, drawn with node as its mark; parents are the
+// parents the graph draws lines to
+func (g *graph) update(sha string, parents []string, node byte) {
+	g.commit, g.parents, g.node = sha, parents, node
+	g.prevCommitIndex = g.commitIndex
+	g.updateColumns()
+	g.expansionRow = 0
+
+	// a commit whose rows weren't all drawn leaves a gap, shown as "..."
+	switch {
+	case g.state != graphPadding:
+		g.state = graphSkip
+	case g.needsPreCommitLine():
+		g.state = graphPreCommit
+	default:
+		g.state = graphCommit
+	}
+}
+
+func (g *graph) findNewColumn(sha string) int {
+	for i, column := range g.newColumns {
+		if column == sha {
+			return i
+		}
+	}
+	return -1
+}
+
+// insertIntoNewColumns gives the line leading to sha a column in newColumns, reusing the one
+// already there, and records in mapping where the line at the next screen position goes. idx is
+// the current commit's column when sha is one of its parents, and -1 otherwise.
+func (g *graph) insertIntoNewColumns(sha string, idx int) {
+	i := g.findNewColumn(sha)
+	if i < 0 {
+		i = len(g.newColumns)
+		g.newColumns = append(g.newColumns, sha)
+	}
+
+	var mappingIdx int
+	switch {
+	case len(g.parents) > 1 && idx > -1 && g.mergeLayout == -1:
+		// the first parent of a merge: the merge's lines lean left or right depending on
+		// where that parent is
+		dist := idx - i
+		shift := 1
+		if dist > 1 {
+			shift = 2*dist - 3
+		}
+		g.mergeLayout = 0
+		if dist <= 0 {
+			g.mergeLayout = 1
+		}
+		g.edgesAdded = len(g.parents) + g.mergeLayout - 2
+		mappingIdx = g.width + (g.mergeLayout-1)*shift
+		g.width += 2 * g.mergeLayout
+	case g.edgesAdded > 0 && i == g.mapping[g.width-2]:
+		// the parent is already in the last column a merge added to; join it straight away
+		mappingIdx = g.width - 2
+		g.edgesAdded = -1
+	default:
+		mappingIdx = g.width
+		g.width += 2
+	}
+	g.mapping[mappingIdx] = i
+}
+
+func (g *graph) updateColumns() {
+	g.columns, g.newColumns = g.newColumns, g.columns[:0]
+	maxNewColumns := len(g.columns) + len(g.parents)
+	if 2*maxNewColumns > len(g.mapping) {
+		g.mapping = growMapping(g.mapping, 2*maxNewColumns)
+		g.oldMapping = growMapping(g.oldMapping, 2*maxNewColumns)
+	}
+	g.mappingSize = 2 * maxNewColumns
+	for i := 0; i < g.mappingSize; i++ {
+		g.mapping[i] = -1
+	}
+	g.width = 0
+	g.prevEdgesAdded, g.edgesAdded = g.edgesAdded, 0
+
+	// every line carries on into newColumns, the current commit's replaced by its parents, and
+	// lines leading to the same commit share a column
+	seenThis := false
+	for i := 0; i <= len(g.columns); i++ {
+		var sha string
+		if i == len(g.columns) {
+			if seenThis {
+				break
+			}
+			sha = g.commit
+		} else {
+			sha = g.columns[i]
+		}
+		if sha != g.commit {
+			g.insertIntoNewColumns(sha, -1)
+			continue
+		}
+		seenThis = true
+		g.commitIndex = i
+		g.mergeLayout = -1
+		for _, parent := range g.parents {
+			g.insertIntoNewColumns(parent, i)
+		}
+		if len(g.parents) == 0 { //the commit takes up its column all the same
+			g.width += 2
+		}
+	}
+
+	for g.mappingSize > 1 && g.mapping[g.mappingSize-1] < 0 {
+		g.mappingSize--
+	}
+}
+
+// growMapping returns mapping with room for at least size positions, keeping what it holds
+func growMapping(mapping []int, size int) []int {
+	grown := make([]int, size)
+	copy(grown, mapping)
+	for i := len(mapping); i < size; i++ {
+		grown[i] = -1
+	}
+	return grown
+}
+
+func (g *graph) numDashedParents() int {
+	return len(g.parents) + g.mergeLayout - 3
+}
+
+// numExpansionRows is how many rows an octopus merge needs to open up room for its lines: two
+// for each parent drawn with a dash
+func (g *graph) numExpansionRows() int {
+	return g.numDashedParents() * 2
+}
+
+func (g *graph) needsPreCommitLine() bool {
+	return len(g.parents) >= 3 && g.commitIndex < len(g.columns)-1 && g.expansionRow < g.numExpansionRows()
+}
+
+// isMappingCorrect reports whether every line is in its column, or one step right of it, where
+// the '/' drawn there takes it home
+func (g *graph) isMappingCorrect() bool {
+	for i := 0; i < g.mappingSize; i++ {
+		if target := g.mapping[i]; target >= 0 && target != i/2 {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *graph) setState(state graphState) {
+	g.prevState, g.state = g.state, state
+}
+
+// isCommitFinished reports whether every row particular to the current commit has been drawn
+func (g *graph) isCommitFinished() bool {
+	return g.state == graphPadding
+}
+
+// nextLine draws the next row of the graph, padded to the width of the commit's rows, and
+// reports whether it was the commit's own row
+func (g *graph) nextLine() (string, bool) {
+	var line strings.Builder
+	commitLine := false
+	switch g.state {
+	case graphPadding:
+		g.paddingRow(&line)
+	case graphSkip:
+		line.WriteString("...")
+		if g.needsPreCommitLine() {
+			g.setState(graphPreCommit)
+		} else {
+			g.setState(graphCommit)
+		}
+	case graphPreCommit:
+		g.preCommitRow(&line)
+	case graphCommit:
+		g.commitRow(&line)
+		commitLine = true
+	case graphPostMerge:
+		g.postMergeRow(&line)
+	case graphCollapsing:
+		g.collapsingRow(&line)
+	}
+	return g.pad(line.String()), commitLine
+}
+
+func (g *graph) pad(line string) string {
+	if len(line) < g.width {
+		line += strings.Repeat(" ", g.width-len(line))
+	}
+	return line
+}
+
+// paddingRow continues every line straight down
+func (g *graph) paddingRow(line *strings.Builder) {
+	for range g.newColumns {
+		line.WriteString("| ")
+	}
+}
+
+// padding is the row put between the lines of a commit's entry once its own rows are out,
+// and between one entry and the next
+func (g *graph) padding() string {
+	if g.state != graphCommit {
+		line, _ := g.nextLine()
+		return line
+	}
+	var line strings.Builder
+	for _, column := range g.columns {
+		line.WriteByte('|')
+		if column == g.commit && len(g.parents) > 2 {
+			line.WriteString(strings.Repeat(" ", (len(g.parents)-2)*2))
+		} else {
+			line.WriteByte(' ')
+		}
+	}
+	g.prevState = graphPadding
+	return g.pad(line.String())
+}
+
+// preCommitRow widens the gap to the right of an octopus merge by a column
+func (g *graph) preCommitRow(line *strings.Builder) {
+	seenThis := false
+	for i, column := range g.columns {
+		switch {
+		case column == g.commit:
+			seenThis = true
+			line.WriteByte('|')
+			line.WriteString(strings.Repeat(" ", g.expansionRow))
+		case seenThis && g.expansionRow == 0:
+			// lines that left the previous merge as '' carry on that way
+			if g.prevState == graphPostMerge && g.prevCommitIndex < i {
+				line.WriteByte('\\')
+			} else {
+				line.WriteByte('|')
+			}
+		case seenThis && g.expansionRow > 0:
+			line.WriteByte('\\')
+		default:
+			line.WriteByte('|')
+		}
+		line.WriteByte(' ')
+	}
+	g.expansionRow++
+	if !g.needsPreCommitLine() {
+		g.setState(graphCommit)
+	}
+}
+
+// commitRow draws the commit's node, with the dashes of an octopus merge after it
+func (g *graph) commitRow(line *strings.Builder) {
+	seenThis := false
+	for i := 0; i <= len(g.columns); i++ {
+		var sha string
+		if i == len(g.columns) {
+			if seenThis {
+				break
+			}
+			sha = g.commit
+		} else {
+			sha = g.columns[i]
+		}
+		switch {
+		case sha == g.commit:
+			seenThis = true
+			line.WriteByte(g.node)
+			if len(g.parents) > 2 {
+				dashed := g.numDashedParents()
+				for j := 0; j < dashed; j++ {
+					line.WriteByte('-')
+					if j == dashed-1 {
+						line.WriteByte('.')
+					} else {
+						line.WriteByte('-')
+					}
+				}
+			}
+		case seenThis && g.edgesAdded > 1:
+			line.WriteByte('\\')
+		case seenThis && g.edgesAdded == 1:
+			// the first row of a merge leaning right: a line that left the previous merge as
+			// '' carries on that way
+			if g.prevState == graphPostMerge && g.prevEdgesAdded > 0 && g.prevCommitIndex < i {
+				line.WriteByte('\\')
+			} else {
+				line.WriteByte('|')
+			}
+		case g.prevState == graphCollapsing && g.oldMapping[2*i+1] == i && g.mapping[2*i] < i:
+			line.WriteByte('/')
+		default:
+			line.WriteByte('|')
+		}
+		line.WriteByte(' ')
+	}
+
+	switch {
+	case len(g.parents) > 1:
+		g.setState(graphPostMerge)
+	case g.isMappingCorrect():
+		g.setState(graphPadding)
+	default:
+		g.setState(graphCollapsing)
+	}
+}
+
+// mergeChars are the ends of a merge's lines, leaning left, straight down and right
+var mergeChars = [3]byte{'/', '|', '\\'}
+
+// postMergeRow draws the lines leaving a merge for each of its parents
+func (g *graph) postMergeRow(line *strings.Builder) {
+	seenThis, passedFirstParent := false, false
+	for i := 0; i <= len(g.columns); i++ {
+		var sha string
+		if i == len(g.columns) {
+			if seenThis {
+				break
+			}
+			sha = g.commit
+		} else {
+			sha = g.columns[i]
+		}
+		switch {
+		case sha == g.commit:
+			seenThis = true
+			idx := g.mergeLayout
+			for j := range g.parents {
+				line.WriteByte(mergeChars[idx])
+				if idx == 2 {
+					if g.edgesAdded > 0 || j < len(g.parents)-1 {
+						line.WriteByte(' ')
+					}
+				} else {
+					idx++
+				}
+			}
+			if g.edgesAdded == 0 {
+				line.WriteByte(' ')
+			}
+		case seenThis:
+			if g.edgesAdded > 0 {
+				line.WriteByte('\\')
+			} else {
+				line.WriteByte('|')
+			}
+			line.WriteByte(' ')
+		default:
+			line.WriteByte('|')
+			if g.mergeLayout != 0 || i != g.commitIndex-1 {
+				// a first parent to the left is reached by an '_' running under the
+				// lines between
+				if passedFirstParent {
+					line.WriteByte('_')
+				} else {
+					line.WriteByte(' ')
+				}
+			}
+		}
+		if sha == g.parents[0] {
+			passedFirstParent = true
+		}
+	}
+
+	if g.isMappingCorrect() {
+		g.setState(graphPadding)
+	} else {
+		g.setState(graphCollapsing)
+	}
+}
+
+// collapsingRow moves each line that isn't in its column one step left, merging lines that
+// lead to the same commit. Only one line runs sideways on a row, along an '_' when it has
+// further to go, and lines only ever move left, so lines that cross never hide each other.
+func (g *graph) collapsingRow(line *strings.Builder) {
+	usedHorizontal := false
+	horizontalEdge, horizontalEdgeTarget := -1, -1
+
+	g.mapping, g.oldMapping = g.oldMapping, g.mapping
+	for i := 0; i < g.mappingSize; i++ {
+		g.mapping[i] = -1
+	}
+	for i := 0; i < g.mappingSize; i++ {
+		target := g.oldMapping[i]
+		switch {
+		case target < 0:
+		case target*2 == i:
+			g.mapping[i] = target
+		case g.mapping[i-1] < 0:
+			// nothing to the left: move one step left
+			g.mapping[i-1] = target
+			if horizontalEdge == -1 {
+				horizontalEdge, horizontalEdgeTarget = i, target
+				for j := target*2 + 3; j < i-2; j += 2 {
+					g.mapping[j] = target
+				}
+			}
+		case g.mapping[i-1] == target:
+			// the line to the left leads to the same commit; this one joins it
+		default:
+			// cross over the line to the left, which leads elsewhere
+			g.mapping[i-2] = target
+			if horizontalEdge == -1 {
+				horizontalEdge, horizontalEdgeTarget = i-1, target
+				for j := target*2 + 3; j < i-2; j += 2 {
+					g.mapping[j] = target
+				}
+			}
+		}
+	}
+
+	copy(g.oldMapping, g.mapping[:g.mappingSize])
+	if g.mapping[g.mappingSize-1] < 0 {
+		g.mappingSize--
+	}
+
+	for i := 0; i < g.mappingSize; i++ {
+		target := g.mapping[i]
+		switch {
+		case target < 0:
+			line.WriteByte(' ')
+		case target*2 == i:
+			line.WriteByte('|')
+		case target == horizontalEdgeTarget && i != horizontalEdge-1:
+			// only the first segment of the sideways run carries on to the next row
+			if i != target*2+3 {
+				g.mapping[i] = -1
+			}
+			usedHorizontal = true
+			line.WriteByte('_')
+		default:
+			if usedHorizontal && i < horizontalEdge {
+				g.mapping[i] = -1
+			}
+			line.WriteByte('/')
+		}
+	}
+
+	if g.isMappingCorrect() {
+		g.setState(graphPadding)
+	}
+}
+
+// commitLines draws the rows down to and including the commit's own, returning them with the
+// commit's last
+func (g *graph) commitLines() []string {
+	if g.isCommitFinished() {
+		return []string{g.padding()}
+	}
+	var lines []string
+	for {
+		line, commitLine := g.nextLine()
+		lines = append(lines, line)
+		if commitLine || g.isCommitFinished() {
+			return lines
+		}
+	}
+}
+
+// remainder draws the rows the current commit still needs once its entry is out
+func (g *graph) remainder() []string {
+	var lines []string
+	for !g.isCommitFinished() {
+		line, _ := g.nextLine()
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// mergeDepths gives how many merges deep each commit of order, which must be topologically
+// ordered, sits below the starting points: commits on their first-parent lines are at depth 0,
+// those only reached through a merge's other parents one deeper than the merge, and so on.
+// A commit reachable several ways takes the shallowest.
+func mergeDepths(order []string, commits map[string]*Commit) map[string]int {
+	depths := map[string]int{}
+	for _, sha := range order {
+		depth := depths[sha] //0 for the starting points, which no shown commit reaches
+		for i, parent := range commits[sha].Parents {
+			if i > 0 {
+				depth = depths[sha] + 1
+			}
+			if old, ok := depths[parent]; !ok || depth < old {
+				depths[parent] = depth
+			}
+		}
+	}
+	return depths
+}
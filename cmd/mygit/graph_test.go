@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// drawGraph lays out commits, each "<sha> <parent>...", in the order given, the way log
+// --graph --oneline does. It returns the rows, each commit's SHA after its node, and the most
+// lines the graph had open at once.
+func drawGraph(commits []string) ([]string, int) {
+	g := &graph{}
+	var rows []string
+	width := 0
+	for _, commit := range commits {
+		fields := strings.Fields(commit)
+		g.update(fields[0], fields[1:], '*')
+		if len(g.newColumns) > width {
+			width = len(g.newColumns)
+		}
+		lines := g.commitLines()
+		lines[len(lines)-1] += fields[0]
+		for _, line := range append(lines, g.remainder()...) {
+			rows = append(rows, strings.TrimRight(line, " "))
+		}
+	}
+	return rows, width
+}
+
+// The expected rows are what git log --graph draws for the same history.
+func TestGraph(t *testing.T) {
+	tests := []struct {
+		name     string
+		commits  []string
+		want     []string
+		maxWidth int
+	}{
+		{
+			name:    "topic branches merged one after another",
+			commits: []string{"m3 m2 t3", "t3 m2", "m2 m1 t2", "t2 m1", "m1 r t1", "t1 r", "r"},
+			want: []string{
+				"*   m3", "|\\", "| * t3", "|/",
+				"*   m2", "|\\", "| * t2", "|/",
+				"*   m1", "|\\", "| * t1", "|/",
+				"* r",
+			},
+			maxWidth: 2,
+		},
+		{
+			name:    "merge beside a line that ends on the same row",
+			commits: []string{"z1 q", "q", "b p1", "m p1 p2", "p2 r", "p1 r", "r"},
+			want: []string{
+				"* z1", "* q", "* b", "| * m", "|/|", "| * p2", "* | p1", "|/",
+				"* r",
+			},
+			maxWidth: 2,
+		},
+		{
+			name:    "a merge's second parent crossing the line of the first",
+			commits: []string{"m2 c f", "f e", "e b", "c m1", "m1 b g", "g b", "b a", "a"},
+			want: []string{
+				"*   m2", "|\\", "| * f", "| * e", "* | c",
+				"* |   m1", "|\\ \\", "| |/", "|/|", "| * g", "|/",
+				"* b", "* a",
+			},
+			maxWidth: 3,
+		},
+		{
+			name:    "two merges with their lines still open",
+			commits: []string{"x m1 f", "f e", "e b", "m1 c g", "g b", "c b", "b a", "a"},
+			want: []string{
+				"*   x", "|\\", "| * f", "| * e",
+				"* |   m1", "|\\ \\", "| * | g", "| |/", "* / c", "|/",
+				"* b", "* a",
+			},
+			maxWidth: 3,
+		},
+		{
+			name:    "octopus merge",
+			commits: []string{"o a b c d", "d r", "c r", "b r", "a r", "r"},
+			want: []string{
+				"*---.   o", "|\\ \\ \\",
+				"| | | * d", "| | * | c", "| | |/", "| * / b", "| |/", "* / a", "|/",
+				"* r",
+			},
+			maxWidth: 4,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rows, width := drawGraph(test.commits)
+			if got, want := strings.Join(rows, "\n"), strings.Join(test.want, "\n"); got != want {
+				t.Errorf("graph:\n%s\nwant:\n%s", got, want)
+			}
+			if width > test.maxWidth {
+				t.Errorf("graph is %d lines wide, want at most %d", width, test.maxWidth)
+			}
+		})
+	}
+}
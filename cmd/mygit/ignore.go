@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+/*
+Ignore rules come from the global excludes file (core.excludesFile, by default
+~/.config/git/ignore), .git/info/exclude and .gitignore files anywhere in the working tree, in
+increasing order of precedence. Rules in deeper directories win over shallower ones, and within
+a file the last matching rule wins, so a "!pattern" can re-include something an earlier rule
+ignored.
+*/
+
+type ignorePattern struct {
+	source  string //file the pattern came from, for check-ignore
+	line    int
+	pattern string //as written
+	base    string //directory the rule is relative to
+	negate  bool
+	dirOnly bool
+	regex   *regexp.Regexp
+}
+
+type ignoreMatcher struct {
+	exclude []ignorePattern
+	dirs    map[string][]ignorePattern //.gitignore rules per directory, loaded on first use
+}
+
+func newIgnoreMatcher() *ignoreMatcher {
+	m := &ignoreMatcher{dirs: map[string][]ignorePattern{}}
+	if global := globalExcludesFile(); global != "" {
+		m.exclude = readIgnoreFile(global, "")
+	}
+	m.exclude = append(m.exclude, readIgnoreFile(path.Join(".git", "info", "exclude"), "")...)
+	return m
+}
+
+// globalExcludesFile is core.excludesFile, or git's default of $XDG_CONFIG_HOME/git/ignore
+func globalExcludesFile() string {
+	if file, ok := configGet("core.excludesFile"); ok {
+		return expandHome(file)
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return path.Join(xdg, "git", "ignore")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return path.Join(home, ".config", "git", "ignore")
+	}
+	return ""
+}
+
+func readIgnoreFile(filename string, base string) []ignorePattern {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var patterns []ignorePattern
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := ignorePattern{source: filename, line: lineNo, pattern: line, base: base}
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			line = line[1:] //escaped leading ! or #
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		p.regex = compileIgnoreGlob(line)
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// compileIgnoreGlob turns a gitignore glob into a regexp over paths relative to the rule's
+// directory. Patterns without a slash match a name at any depth; others are anchored.
+func compileIgnoreGlob(glob string) *regexp.Regexp {
+	anchored := strings.Contains(glob, "/")
+	glob = strings.TrimPrefix(glob, "/")
+
+	var re strings.Builder
+	re.WriteString("^")
+	if !anchored {
+		re.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			re.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			re.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return regexp.MustCompile(`^` + regexp.QuoteMeta(glob) + `$`)
+	}
+	return compiled
+}
+
+func (m *ignoreMatcher) dirPatterns(dir string) []ignorePattern {
+	patterns, loaded := m.dirs[dir]
+	if !loaded {
+		base := dir
+		if base == "." {
+			base = ""
+		}
+		patterns = readIgnoreFile(path.Join(dir, ".gitignore"), base)
+		m.dirs[dir] = patterns
+	}
+	return patterns
+}
+
+// match returns the rule deciding whether filePath is ignored, or nil when no rule applies.
+// Only the path itself is considered; see isIgnored for the effect of ignored parents.
+func (m *ignoreMatcher) match(filePath string, isDir bool) *ignorePattern {
+	var dirs []string
+	for dir := path.Dir(filePath); ; dir = path.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+		if dir == "." {
+			break
+		}
+	}
+
+	var matched *ignorePattern
+	candidates := append([]ignorePattern{}, m.exclude...)
+	for _, dir := range dirs {
+		candidates = append(candidates, m.dirPatterns(dir)...)
+	}
+	for i := range candidates {
+		p := &candidates[i]
+		if p.dirOnly && !isDir {
+			continue
+		}
+		rel := filePath
+		if p.base != "" {
+			if !strings.HasPrefix(filePath, p.base+"/") {
+				continue
+			}
+			rel = strings.TrimPrefix(filePath, p.base+"/")
+		}
+		if p.regex.MatchString(rel) {
+			matched = p
+		}
+	}
+	return matched
+}
+
+// decidingPattern returns the rule that decides whether filePath is ignored: the one ignoring
+// a parent directory, since nothing inside an ignored directory can be re-included, or else
+// the one matching the path itself. It is nil when no rule applies.
+func (m *ignoreMatcher) decidingPattern(filePath string, isDir bool) *ignorePattern {
+	parts := strings.Split(filePath, "/")
+	for i := 1; i < len(parts); i++ {
+		if p := m.match(strings.Join(parts[:i], "/"), true); p != nil && !p.negate {
+			return p
+		}
+	}
+	return m.match(filePath, isDir)
+}
+
+// isIgnored reports whether filePath is ignored, either itself or because a parent directory is
+func (m *ignoreMatcher) isIgnored(filePath string, isDir bool) bool {
+	p := m.decidingPattern(filePath, isDir)
+	return p != nil && !p.negate
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// imapSettings are the imap.* config imap-send uses
+type imapSettings struct {
+	host      string
+	port      string
+	ssl       bool //imaps://, TLS from the start rather than STARTTLS
+	user      string
+	pass      string
+	folder    string
+	sslVerify bool
+}
+
+func readIMAPSettings() (imapSettings, error) {
+	settings := imapSettings{sslVerify: configBool("imap.sslverify", true)}
+	settings.folder, _ = configGet("imap.folder")
+	if settings.folder == "" {
+		return settings, errors.New("no IMAP folder specified, set imap.folder")
+	}
+	host, _ := configGet("imap.host")
+	switch {
+	case strings.HasPrefix(host, "imaps://"):
+		settings.host, settings.ssl = strings.TrimPrefix(host, "imaps://"), true
+	case strings.HasPrefix(host, "imap://"):
+		settings.host = strings.TrimPrefix(host, "imap://")
+	default:
+		settings.host = host
+	}
+	if settings.host == "" {
+		return settings, errors.New("no IMAP host specified, set imap.host")
+	}
+	settings.port = "143"
+	if settings.ssl {
+		settings.port = "993"
+	}
+	if port, ok := configGet("imap.port"); ok {
+		settings.port = port
+	}
+	settings.user, _ = configGet("imap.user")
+	settings.pass, _ = configGet("imap.pass")
+	if settings.user == "" || settings.pass == "" {
+		return settings, errors.New("imap.user and imap.pass must be set")
+	}
+	return settings, nil
+}
+
+// splitMbox splits an mbox into its messages, without their "From " separator lines, with
+// CRLF line endings as IMAP wants
+func splitMbox(data []byte) []string {
+	var messages []string
+	var current []string
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if strings.HasPrefix(line, "From ") {
+			if len(current) > 0 {
+				messages = append(messages, strings.Join(current, ""))
+			}
+			current = []string{}
+			continue
+		}
+		if current == nil || line == "" {
+			continue //text before the first message
+		}
+		current = append(current, strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")+"\r\n")
+	}
+	if len(current) > 0 {
+		messages = append(messages, strings.Join(current, ""))
+	}
+	return messages
+}
+
+// imapConn is a connection to an IMAP server, speaking just enough of the protocol to log in
+// and append messages
+type imapConn struct {
+	conn         net.Conn
+	r            *bufio.Reader
+	tag          int
+	capabilities map[string]bool
+}
+
+func dialIMAP(settings imapSettings) (*imapConn, error) {
+	address := net.JoinHostPort(settings.host, settings.port)
+	tlsConfig := &tls.Config{ServerName: settings.host, InsecureSkipVerify: !settings.sslVerify}
+	var conn net.Conn
+	var err error
+	if settings.ssl {
+		conn, err = tls.Dial("tcp", address, tlsConfig)
+	} else {
+		conn, err = net.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &imapConn{conn: conn, r: bufio.NewReader(conn)}
+	greeting, err := c.readLine()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting, "* OK") && !strings.HasPrefix(greeting, "* PREAUTH") {
+		conn.Close()
+		return nil, fmt.Errorf("IMAP server refused the connection: %s", greeting)
+	}
+
+	if _, err := c.command("CAPABILITY"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !settings.ssl && c.capabilities["STARTTLS"] {
+		if _, err := c.command("STARTTLS"); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		c.conn, c.r = tlsConn, bufio.NewReader(tlsConn)
+		if _, err := c.command("CAPABILITY"); err != nil { //they may change once encrypted
+			c.conn.Close()
+			return nil, err
+		}
+	} else if !settings.ssl {
+		fmt.Fprintln(os.Stderr, "warning: the IMAP server has no STARTTLS, the password is sent in the clear")
+	}
+	return c, nil
+}
+
+func (c *imapConn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// command sends a tagged command and waits for its completion, calling literal, when given, for
+// the data the server asks for with a "+" continuation
+func (c *imapConn) command(command string, literal ...string) (string, error) {
+	c.tag++
+	tag := fmt.Sprintf("a%d", c.tag)
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, command); err != nil {
+		return "", err
+	}
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case strings.HasPrefix(line, "+"):
+			if len(literal) == 0 {
+				return "", fmt.Errorf("unexpected IMAP continuation: %s", line)
+			}
+			if _, err := io.WriteString(c.conn, literal[0]+"\r\n"); err != nil {
+				return "", err
+			}
+			literal = literal[1:]
+		case strings.HasPrefix(line, "* CAPABILITY "):
+			c.capabilities = map[string]bool{}
+			for _, capability := range strings.Fields(line)[2:] {
+				c.capabilities[strings.ToUpper(capability)] = true
+			}
+		case strings.HasPrefix(line, tag+" "):
+			status := strings.TrimPrefix(line, tag+" ")
+			if !strings.HasPrefix(status, "OK") {
+				name, _, _ := strings.Cut(command, " ")
+				return "", fmt.Errorf("IMAP command '%s' failed: %s", name, status)
+			}
+			return status, nil
+		}
+	}
+}
+
+// imapQuote quotes s as an IMAP string
+func imapQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// Usage: mygit imap-send
+//
+// Reads patch mails in mbox format from stdin, such as format-patch --stdout writes, and
+// appends each to the IMAP folder imap.folder as a draft, to be sent from a mail client. The
+// server is imap.host, imaps://<host> for TLS from the start or imap://<host> to upgrade with
+// STARTTLS, with imap.port, imap.user and imap.pass; imap.sslverify=false skips checking the
+// server's certificate.
+func cmdIMAPSend(args []string) {
+	if len(args) > 0 {
+		fmt.Fprint(os.Stderr, "usage: mygit imap-send < <mbox>\n")
+		os.Exit(1)
+	}
+	fatal := func(err error) {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	settings, err := readIMAPSettings()
+	if err != nil {
+		fatal(err)
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fatal(err)
+	}
+	messages := splitMbox(data)
+	if len(messages) == 0 {
+		fatal(errors.New("nothing to send"))
+	}
+
+	c, err := dialIMAP(settings)
+	if err != nil {
+		fatal(err)
+	}
+	defer c.conn.Close()
+	if _, err := c.command("LOGIN " + imapQuote(settings.user) + " " + imapQuote(settings.pass)); err != nil {
+		fatal(err)
+	}
+	if _, err := c.command("SELECT " + imapQuote(settings.folder)); err != nil {
+		fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "sending %d message", len(messages))
+	if len(messages) > 1 {
+		fmt.Fprint(os.Stderr, "s")
+	}
+	fmt.Fprintln(os.Stderr)
+	progress := newProgress("sending", len(messages))
+	for i, message := range messages {
+		append := fmt.Sprintf("APPEND %s (\\Draft) {%d}", imapQuote(settings.folder), len(message))
+		if _, err := c.command(append, message); err != nil {
+			fatal(err)
+		}
+		progress.update(i+1, 0)
+	}
+	progress.done(len(messages), 0)
+	c.command("LOGOUT")
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+/*
+The index (.git/index) is a binary file:
+- 12 byte header: "DIRC", version, number of entries
+- entries sorted by path, each padded with NULs to a multiple of 8 bytes
+- optional extensions, which we skip
+- SHA-1 of everything above
+*/
+
+type indexEntry struct {
+	ctimeSec  uint32
+	ctimeNsec uint32
+	mtimeSec  uint32
+	mtimeNsec uint32
+	dev       uint32
+	ino       uint32
+	mode      uint32
+	uid       uint32
+	gid       uint32
+	size      uint32
+	sha       string
+	flags     uint16
+	path      string
+}
+
+const indexPath = ".git/index"
+
+func readIndex() ([]indexEntry, error) {
+	return readIndexFile(indexPath)
+}
+
+// readIndexFile reads an index other than the repository's own, such as a submodule's
+func readIndexFile(filename string) ([]indexEntry, error) {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(contents) < 32 || string(contents[:4]) != "DIRC" {
+		return nil, fmt.Errorf("index file corrupt")
+	}
+	checksum := sha1.Sum(contents[:len(contents)-20])
+	if !bytes.Equal(checksum[:], contents[len(contents)-20:]) {
+		return nil, fmt.Errorf("index file corrupt: bad checksum")
+	}
+	version := binary.BigEndian.Uint32(contents[4:8])
+	if version != 2 && version != 3 {
+		return nil, fmt.Errorf("index version %d not supported", version)
+	}
+	count := binary.BigEndian.Uint32(contents[8:12])
+
+	entries := make([]indexEntry, 0, count)
+	offset := 12
+	for i := uint32(0); i < count; i++ {
+		if offset+62 > len(contents)-20 {
+			return nil, fmt.Errorf("index file corrupt: truncated entry")
+		}
+		data := contents[offset:]
+		var fields [10]uint32
+		for j := range fields {
+			fields[j] = binary.BigEndian.Uint32(data[j*4:])
+		}
+		entry := indexEntry{
+			ctimeSec: fields[0], ctimeNsec: fields[1],
+			mtimeSec: fields[2], mtimeNsec: fields[3],
+			dev: fields[4], ino: fields[5], mode: fields[6],
+			uid: fields[7], gid: fields[8], size: fields[9],
+			sha:   hex.EncodeToString(data[40:60]),
+			flags: binary.BigEndian.Uint16(data[60:62]),
+		}
+		nameStart := 62
+		if version == 3 && entry.flags&0x4000 != 0 {
+			nameStart += 2 //extended flags
+		}
+		nameEnd := bytes.IndexByte(data[nameStart:], 0)
+		if nameEnd < 0 {
+			return nil, fmt.Errorf("index file corrupt: unterminated path")
+		}
+		entry.path = string(data[nameStart : nameStart+nameEnd])
+		entries = append(entries, entry)
+
+		// entries are NUL padded to a multiple of 8 bytes
+		entryLen := (nameStart + nameEnd + 8) &^ 7
+		offset += entryLen
+	}
+	return entries, nil
+}
+
+func writeIndex(entries []indexEntry) error {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].path != entries[j].path {
+			return entries[i].path < entries[j].path
+		}
+		return entries[i].stage() < entries[j].stage()
+	})
+
+	var b bytes.Buffer
+	b.WriteString("DIRC")
+	binary.Write(&b, binary.BigEndian, uint32(2))
+	binary.Write(&b, binary.BigEndian, uint32(len(entries)))
+	for _, entry := range entries {
+		sha, err := hex.DecodeString(entry.sha)
+		if err != nil || len(sha) != 20 {
+			return fmt.Errorf("invalid SHA %q for %s", entry.sha, entry.path)
+		}
+		start := b.Len()
+		for _, field := range []uint32{
+			entry.ctimeSec, entry.ctimeNsec, entry.mtimeSec, entry.mtimeNsec,
+			entry.dev, entry.ino, entry.mode, entry.uid, entry.gid, entry.size,
+		} {
+			binary.Write(&b, binary.BigEndian, field)
+		}
+		b.Write(sha)
+		nameLen := len(entry.path)
+		if nameLen > 0xFFF {
+			nameLen = 0xFFF
+		}
+		binary.Write(&b, binary.BigEndian, entry.flags&0x3000|uint16(nameLen))
+		b.WriteString(entry.path)
+		padding := 8 - (b.Len()-start)%8
+		b.Write(make([]byte, padding))
+	}
+	checksum := sha1.Sum(b.Bytes())
+	b.Write(checksum[:])
+	return writeFileAtomic(indexPath, b.Bytes(), 0644)
+}
+
+func (e indexEntry) stage() uint16 {
+	return (e.flags >> 12) & 0x3
+}
+
+// newIndexEntry builds an index entry for a file in the working tree, filling in its stat data
+func newIndexEntry(filePath string, sha string, mode uint32) (indexEntry, error) {
+	fi, err := os.Lstat(filePath)
+	if err != nil {
+		return indexEntry{}, err
+	}
+	entry := indexEntry{
+		mode: mode,
+		size: uint32(fi.Size()),
+		sha:  sha,
+		path: filePath,
+	}
+	entry.mtimeSec = uint32(fi.ModTime().Unix())
+	entry.mtimeNsec = uint32(fi.ModTime().Nanosecond())
+	fillStatData(&entry, fi)
+	return entry, nil
+}
+
+// statMatches reports whether the cached stat data says the file is unchanged since it was staged
+func (e indexEntry) statMatches(fi os.FileInfo) bool {
+	if e.mtimeSec == 0 && e.size == 0 && e.ino == 0 {
+		return false //entry without stat data, e.g. seeded from a tree
+	}
+	if fileMode(fi) != e.mode || uint32(fi.Size()) != e.size {
+		return false
+	}
+	if uint32(fi.ModTime().Unix()) != e.mtimeSec || uint32(fi.ModTime().Nanosecond()) != e.mtimeNsec {
+		return false
+	}
+	other := indexEntry{}
+	fillStatData(&other, fi)
+	return other.ino == e.ino && other.ctimeSec == e.ctimeSec && other.ctimeNsec == e.ctimeNsec
+}
+
+func indexByPath(entries []indexEntry) map[string]indexEntry {
+	byPath := make(map[string]indexEntry, len(entries))
+	for _, entry := range entries {
+		if entry.stage() == 0 {
+			byPath[entry.path] = entry
+		}
+	}
+	return byPath
+}
+
+var errUnmerged = errors.New("you have unmerged files")
+
+// indexTree writes the tree for the staged (stage 0) entries, failing while conflicts remain
+func indexTree(entries []indexEntry) (string, error) {
+	files := map[string]treeEntry{}
+	for _, entry := range entries {
+		if entry.stage() != 0 {
+			return "", errUnmerged
+		}
+		files[entry.path] = treeEntry{mode: entry.mode, name: entry.path, sha: entry.sha}
+	}
+	return writeTreeFiles(files)
+}
+
+// indexTreeProblems lists, in git write-tree's words, what stops the index being written as a
+// tree: unmerged entries, and entries whose objects aren't in the object store unless missingOK
+func indexTreeProblems(entries []indexEntry, missingOK bool) []string {
+	var problems []string
+	for _, entry := range entries {
+		switch {
+		case entry.stage() != 0:
+			problems = append(problems, fmt.Sprintf("%s: unmerged (%s)", entry.path, entry.sha))
+		case !missingOK && entry.mode != 0o160000 && !objectExists(entry.sha):
+			//submodule commits live in another repository, so are never checked
+			problems = append(problems, fmt.Sprintf("error: invalid object %o %s for '%s'", entry.mode, entry.sha, entry.path))
+		}
+	}
+	return problems
+}
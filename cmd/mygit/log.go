@@ -0,0 +1,418 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const logDateFormat = "Mon Jan 2 15:04:05 2006 -0700"
+
+// Usage: mygit log [--oneline] [--graph [--indent-by-merges]] [--color[=<when>]] [--decorate[=<format>]] [--source] [--boundary] [-n <count>] [--all] [<rev>...] [-- <path>...]
+//
+// --all starts from every ref and HEAD, and --source shows which of the starting points each
+// commit was reached from. --boundary also shows, last and marked with "-", the commits just
+// outside the range that commits in it have as parents. --indent-by-merges indents each
+// commit's message by two spaces for every merge it is reached through, setting the mainline
+// apart from the branches merged in. Paths after "--" limit the log to the commits that change
+// what they match. As in git, -n's count can be attached, -n<count>, or given as -<count> or
+// --max-count=<count>.
+func cmdLog(args []string) {
+	usage := "usage: mygit log [--oneline] [--graph [--indent-by-merges]] [--[no-]mailmap] [--left-right] [--cherry-pick] [--[no-]color[=<when>]] [--[no-]decorate[=short|full|auto|no]] [--source] [--boundary] [-n <count>] [--all] [<revision-range>...] [-- <path>...]\n"
+	oneline, all, showSource, boundary := false, false, false, false
+	colorWhen := ""
+	decorate, _ := configGet("log.decorate")
+	showGraph, indentByMerges := false, false
+	leftRight, cherryPick := false, false
+	useMailmap := configBool("log.mailmap", true)
+	maxCount := -1
+	var revs, pathspecs []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			pathspecs = args[i+1:]
+			i = len(args)
+		case arg == "--oneline":
+			oneline = true
+		case arg == "--graph":
+			showGraph = true
+		case arg == "--indent-by-merges":
+			indentByMerges = true
+		case arg == "--all":
+			all = true
+		case arg == "--source":
+			showSource = true
+		case arg == "--boundary":
+			boundary = true
+		case arg == "--left-right":
+			leftRight = true
+		case arg == "--cherry-pick":
+			cherryPick = true
+		case arg == "--color":
+			colorWhen = "always"
+		case strings.HasPrefix(arg, "--color="):
+			colorWhen = strings.TrimPrefix(arg, "--color=")
+			if !validColorWhen(colorWhen) {
+				fmt.Fprintf(os.Stderr, "error: option `color' expects \"always\", \"auto\", or \"never\"\n")
+				os.Exit(129)
+			}
+		case arg == "--no-color":
+			colorWhen = "never"
+		case arg == "--decorate":
+			decorate = "short"
+		case strings.HasPrefix(arg, "--decorate="):
+			decorate = strings.TrimPrefix(arg, "--decorate=")
+			if _, ok := decorateFormat(decorate); !ok {
+				fmt.Fprintf(os.Stderr, "fatal: invalid --decorate option: %s\n", decorate)
+				os.Exit(128)
+			}
+		case arg == "--no-decorate":
+			decorate = "no"
+		case arg == "--mailmap" || arg == "--use-mailmap":
+			useMailmap = true
+		case arg == "--no-mailmap" || arg == "--no-use-mailmap":
+			useMailmap = false
+		case arg == "-n" && i+1 < len(args):
+			i++
+			count, err := strconv.Atoi(args[i])
+			if err != nil {
+				fmt.Fprint(os.Stderr, usage)
+				os.Exit(1)
+			}
+			maxCount = count
+		case strings.HasPrefix(arg, "-"):
+			count, ok := maxCountArg(arg)
+			if !ok {
+				fmt.Fprint(os.Stderr, usage)
+				os.Exit(1)
+			}
+			maxCount = count
+		default:
+			revs = append(revs, arg)
+		}
+	}
+	if len(revs) == 0 && !all {
+		if head, err := headCommit(); err == nil && head == "" {
+			return //an unborn branch has no history to show
+		}
+		revs = []string{"HEAD"}
+	}
+
+	var marks map[string]byte //the side of a symmetric range each commit is on, with --left-right
+	revRange, err := parseRevisionRange(revs)
+	if err == nil && all {
+		err = revRange.includeAllRefs()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	order, commits, err := revRange.walk()
+	if err == nil && (leftRight || cherryPick) {
+		var leftSide map[string]*Commit
+		if leftSide, err = ancestors(revRange.left); err == nil {
+			side := func(sha string) byte {
+				if _, ok := leftSide[sha]; ok {
+					return '<'
+				}
+				return '>'
+			}
+			if cherryPick {
+				order, err = dropCherryPicks(order, commits, side)
+			}
+			if leftRight {
+				marks = map[string]byte{}
+				for _, sha := range order {
+					marks[sha] = side(sha)
+				}
+			}
+		}
+	}
+	if err == nil && len(pathspecs) > 0 {
+		order, err = commitsTouching(order, commits, pathspecs)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error walking history: %s\n", err)
+		os.Exit(1)
+	}
+	if showGraph {
+		order = graphOrder(order, commits)
+	}
+	// the graph leads to parents the walk would show, even past -n's count
+	interesting := map[string]bool{}
+	for _, sha := range order {
+		interesting[sha] = true
+	}
+	if maxCount >= 0 && len(order) > maxCount {
+		order = order[:maxCount]
+	}
+	boundaries := map[string]bool{}
+	if boundary {
+		edge := boundaryCommits(order, commits)
+		for _, sha := range edge {
+			boundaries[sha] = true
+		}
+		// with --boundary, the graph also leads to the parents of everything shown
+		for _, sha := range order {
+			for _, parent := range commits[sha].Parents {
+				interesting[parent] = true
+			}
+		}
+		order = append(order, edge...)
+	}
+	var sources map[string]string
+	if showSource {
+		sources = revRange.sources(commits)
+	}
+	var depths map[string]int
+	if indentByMerges {
+		depths = mergeDepths(order, commits)
+	}
+
+	var mm *mailmap
+	if useMailmap {
+		mm = readMailmap()
+	}
+	colors := newLogColors(useColor("log", colorWhen))
+	var decorations map[string][]refDecoration
+	if format, _ := decorateFormat(decorate); format != "no" {
+		if decorations, err = loadDecorations(format == "full"); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+	}
+	var g *graph
+	if showGraph {
+		g = &graph{}
+	}
+	for n, sha := range order {
+		commit := commits[sha]
+		if mm != nil {
+			mapped := *commit
+			mapped.Author = mm.mapSignature(commit.Author)
+			mapped.Committer = mm.mapSignature(commit.Committer)
+			commit = &mapped
+		}
+		if depths[sha] > 0 {
+			indented := *commit
+			indent := strings.Repeat("  ", depths[sha])
+			message := strings.TrimRight(commit.Message, "\n")
+			indented.Message = indent + strings.ReplaceAll(message, "\n", "\n"+indent) + "\n"
+			commit = &indented
+		}
+		side := marks[sha]
+		if boundaries[sha] {
+			side = '-'
+		}
+		if g != nil {
+			side = 0 //the graph node shows it instead
+		}
+		lines := formatLogEntry(sha, commit, oneline, side, sources[sha], decorations[sha], colors)
+		if g == nil {
+			if !oneline && n > 0 {
+				fmt.Println()
+			}
+			for _, line := range lines {
+				fmt.Println(line)
+			}
+			continue
+		}
+
+		node := byte('*')
+		if boundaries[sha] {
+			node = 'o'
+		} else if marks != nil {
+			node = marks[sha]
+		}
+		var parents []string
+		for _, parent := range commit.Parents {
+			if interesting[parent] {
+				parents = append(parents, parent)
+			}
+		}
+		g.update(sha, parents, node)
+		if !oneline && n > 0 {
+			fmt.Println(g.padding())
+		}
+		// as in git, the rows particular to the commit run down the side of its entry, and any
+		// still to draw once it is out follow it
+		rows := g.commitLines()
+		for _, row := range rows[:len(rows)-1] {
+			fmt.Println(row)
+		}
+		fmt.Println(rows[len(rows)-1] + lines[0])
+		for _, line := range lines[1:] {
+			row, _ := g.nextLine()
+			fmt.Println(row + line)
+		}
+		for _, row := range g.remainder() {
+			fmt.Println(row)
+		}
+	}
+}
+
+// maxCountArg reads the forms of -n that carry their count: -n<count>, -<count> and
+// --max-count=<count>. ok is false for any other option.
+func maxCountArg(arg string) (count int, ok bool) {
+	var value string
+	switch {
+	case strings.HasPrefix(arg, "--max-count="):
+		value = strings.TrimPrefix(arg, "--max-count=")
+	case strings.HasPrefix(arg, "-n") && len(arg) > 2:
+		value = arg[2:]
+	case len(arg) > 1 && arg[1] >= '0' && arg[1] <= '9':
+		value = arg[1:]
+	default:
+		return 0, false
+	}
+	count, err := strconv.Atoi(value)
+	return count, err == nil
+}
+
+// logColors holds the escape sequences log colors each part of an entry with, all "" when
+// color is off
+type logColors struct {
+	commit, author, date string
+	decorate             map[string]string //by color.decorate slot
+}
+
+// newLogColors reads the log color scheme from color.log.<slot> and color.decorate.<slot>
+func newLogColors(enabled bool) logColors {
+	if !enabled {
+		return logColors{}
+	}
+	colors := logColors{
+		commit:   configColor("color.log.commit", "\x1b[33m"),
+		author:   configColor("color.log.author", "\x1b[32m"),
+		date:     configColor("color.log.date", "\x1b[36m"),
+		decorate: map[string]string{},
+	}
+	for slot, fallback := range decorationColors {
+		colors.decorate[slot] = configColor("color.decorate."+slot, fallback)
+	}
+	return colors
+}
+
+// decorateFormat interprets a --decorate or log.decorate value as "short", "full" or "no";
+// "auto", the default, decorates only when writing to a terminal
+func decorateFormat(value string) (string, bool) {
+	switch strings.ToLower(value) {
+	case "short", "full", "no":
+		return strings.ToLower(value), true
+	case "true", "yes", "on", "1":
+		return "short", true
+	case "false", "off", "0":
+		return "no", true
+	case "", "auto":
+		if isTerminal(os.Stdout) {
+			return "short", true
+		}
+		return "no", true
+	}
+	return "", false
+}
+
+// formatLogEntry formats one commit; a non-zero side ('<' or '>') is shown before its SHA, and
+// the source it was reached from, when given, and the refs pointing at it after
+func formatLogEntry(sha string, commit *Commit, oneline bool, side byte, source string, decorations []refDecoration, colors logColors) []string {
+	marker := ""
+	if side != 0 {
+		marker = string(side) + " "
+	}
+	decorated := formatDecorations(decorations, colors)
+	if source != "" {
+		decorated = "\t" + source + decorated
+	}
+	if oneline {
+		return []string{colorize(colors.commit, marker+sha[:7]) + decorated + " " + commit.Subject()}
+	}
+	lines := []string{colorize(colors.commit, "commit "+marker+sha) + decorated}
+	if len(commit.Parents) > 1 {
+		var short []string
+		for _, parent := range commit.Parents {
+			short = append(short, parent[:7])
+		}
+		lines = append(lines, "Merge: "+strings.Join(short, " "))
+	}
+	author := signatureIdentity(commit.Author)
+	if name, email, ok := strings.Cut(author, " <"); ok {
+		author = colorize(colors.author, name) + " <" + email
+	}
+	lines = append(lines,
+		"Author: "+author,
+		"Date:   "+colorize(colors.date, signatureTime(commit.Author).Format(logDateFormat)),
+		"",
+	)
+	for _, line := range strings.Split(strings.TrimRight(commit.Message, "\n"), "\n") {
+		lines = append(lines, strings.TrimRight("    "+line, " "))
+	}
+	return lines
+}
+
+// commitsTouching keeps the commits that change a path the pathspecs match. A merge is kept
+// only when it differs from every parent there, as git does, since otherwise it just brings in
+// a change that was made on one side.
+func commitsTouching(order []string, commits map[string]*Commit, pathspecs []string) ([]string, error) {
+	var kept []string
+	for _, sha := range order {
+		commit := commits[sha]
+		parents := commit.Parents
+		if len(parents) == 0 {
+			parents = []string{""} //a root commit changes everything it has
+		}
+		touches := true
+		for _, parent := range parents {
+			parentTree := ""
+			if parent != "" {
+				tree, err := commitTree(parent)
+				if err != nil {
+					return nil, err
+				}
+				parentTree = tree
+			}
+			changes, err := diffTrees(parentTree, commit.Tree, true)
+			if err != nil {
+				return nil, err
+			}
+			changed := false
+			for _, change := range changes {
+				changed = changed || matchesPathspec(change.path, pathspecs)
+			}
+			touches = touches && changed
+		}
+		if touches {
+			kept = append(kept, sha)
+		}
+	}
+	return kept, nil
+}
+
+// dropCherryPicks removes the commits on one side of a symmetric range that make the same
+// change as a commit on the other side, going by patch ID
+func dropCherryPicks(order []string, commits map[string]*Commit, side func(string) byte) ([]string, error) {
+	ids := map[string]string{}
+	sides := map[string]map[byte]bool{}
+	for _, sha := range order {
+		id, err := patchID(commits[sha])
+		if err != nil {
+			return nil, err
+		}
+		if id == "" {
+			continue
+		}
+		ids[sha] = id
+		if sides[id] == nil {
+			sides[id] = map[byte]bool{}
+		}
+		sides[id][side(sha)] = true
+	}
+	var kept []string
+	for _, sha := range order {
+		if id, ok := ids[sha]; !ok || len(sides[id]) < 2 {
+			kept = append(kept, sha)
+		}
+	}
+	return kept, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLogMaxCount(t *testing.T) {
+	initTestRepo(t)
+	first := testCommit(t, 0, "first", map[string]string{"a": "1\n"})
+	second := testCommit(t, 1, "second", map[string]string{"a": "2\n"}, first)
+	third := testCommit(t, 2, "third", map[string]string{"a": "3\n"}, second)
+	if err := updateRef("refs/heads/master", third, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{[]string{"-n", "2"}, 2},
+		{[]string{"-n2"}, 2},
+		{[]string{"-1"}, 1},
+		{[]string{"-2"}, 2},
+		{[]string{"--max-count=1"}, 1},
+		{[]string{"-n", "-1"}, 3},
+		{[]string{"-n0"}, 0},
+		{nil, 3},
+	}
+	for _, test := range tests {
+		t.Run(strings.Join(test.args, " "), func(t *testing.T) {
+			out := runTestCommand(t, append([]string{"log", "--oneline"}, test.args...)...)
+			if got := strings.Count(out, "\n"); got != test.want {
+				t.Errorf("%d commits shown, want %d:\n%s", got, test.want, out)
+			}
+		})
+	}
+	if _, _, code := runMygit(t, "log", "-nx"); code == 0 {
+		t.Error("log -nx succeeded")
+	}
+}
+
+func TestHistoryOfUnbornBranch(t *testing.T) {
+	tests := [][]string{
+		{"log"},
+		{"log", "--oneline"},
+		{"log", "--graph"},
+		{"log", "-n", "1"},
+		{"shortlog"},
+		{"shortlog", "-s"},
+		{"shortlog", "-n", "-s"},
+	}
+	initTestRepo(t)
+	for _, args := range tests {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			stdout, stderr, code := runMygit(t, args...)
+			if code != 0 || stdout != "" || stderr != "" {
+				t.Errorf("exit %d, stdout %q, stderr %q; want nothing shown", code, stdout, stderr)
+			}
+		})
+	}
+
+	// once the branch has a commit, it is shown
+	head := testCommit(t, 0, "first", map[string]string{"a": "a\n"})
+	if err := updateRef("refs/heads/master", head, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range tests {
+		t.Run(strings.Join(args, " ")+" after a commit", func(t *testing.T) {
+			if stdout := runTestCommand(t, args...); !strings.Contains(stdout, "first") && !strings.Contains(stdout, "A U Thor") {
+				t.Errorf("the commit isn't shown: %q", stdout)
+			}
+		})
+	}
+}
+
+func TestLogBoundaryOrder(t *testing.T) {
+	initTestRepo(t)
+	root := testCommit(t, 0, "root", nil)
+	x1 := testCommit(t, 100, "x1", nil, root)
+	x2 := testCommit(t, 200, "x2", nil, root)
+	x3 := testCommit(t, 150, "x3", nil, root)
+	merge := testCommit(t, 300, "merge", nil, x1, x2, x3)
+
+	// git lists the boundary last found first, not by date
+	out := runTestCommand(t, "log", "--oneline", "--boundary", merge, "^"+x1, "^"+x2, "^"+x3)
+	want := fmt.Sprintf("%.7s merge\n- %.7s x3\n- %.7s x2\n- %.7s x1\n", merge, x3, x2, x1)
+	if out != want {
+		t.Errorf("log --boundary:\n%s\nwant:\n%s", out, want)
+	}
+
+	// past two merges, the boundary comes out in the order git's walk reaches it, and with
+	// --graph the lines still open run down beside it
+	initTestRepo(t)
+	names := twoMergeHistory(t)
+	out = names.Replace(runTestCommand(t, "log", "--oneline", "--boundary", "HEAD~2..HEAD"))
+	if want := "h\nm2\ng\nd\n- f\n- m1\n- c\n"; out != want {
+		t.Errorf("log --boundary past two merges:\n%s\nwant:\n%s", out, want)
+	}
+	out = names.Replace(runTestCommand(t, "log", "--graph", "--oneline", "--boundary", "HEAD~2..HEAD"))
+	want = strings.Join([]string{
+		"*   h", "|\\  ", "| * g", "* |   m2", "|\\ \\  ", "| * | d",
+		"o | | m1", "|/ /  ", "o / c", " /  ", "o f",
+	}, "\n") + "\n"
+	if out != want {
+		t.Errorf("log --graph --boundary past two merges:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+// twoMergeHistory commits a history with two merges whose sides cross, each commit named by
+// its message, and points master at its tip. It returns a replacer that turns the commits'
+// short SHAs in log --oneline back into their names.
+func twoMergeHistory(t *testing.T) *strings.Replacer {
+	shas := map[string]string{}
+	for i, commit := range []string{"a", "b a", "c b", "f b", "e f", "m1 c e", "d c", "g f", "m2 m1 d", "h m2 g"} {
+		fields := strings.Fields(commit)
+		var parents []string
+		for _, parent := range fields[1:] {
+			parents = append(parents, shas[parent])
+		}
+		shas[fields[0]] = testCommit(t, i*100, fields[0], nil, parents...)
+	}
+	if err := updateRef("refs/heads/master", shas["h"], ""); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name, sha := range shas {
+		names = append(names, sha[:7]+" "+name, name)
+	}
+	return strings.NewReplacer(names...)
+}
+
+func TestLogGraph(t *testing.T) {
+	initTestRepo(t)
+	names := twoMergeHistory(t)
+
+	// as drawn by git log --graph, trailing spaces and all
+	want := strings.Join([]string{
+		"*   h", "|\\  ", "| * g", "* |   m2", "|\\ \\  ", "| * | d",
+		"* | |   m1", "|\\ \\ \\  ", "| |/ /  ", "|/| |   ", "| * | e", "| |/  ", "| * f",
+		"* | c", "|/  ", "* b", "* a",
+	}, "\n") + "\n"
+	if out := names.Replace(runTestCommand(t, "log", "--graph", "--oneline")); out != want {
+		t.Errorf("log --graph:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestLogDetachedHead(t *testing.T) {
+	initTestRepo(t)
+	first := testCommit(t, 0, "first", nil)
+	second := testCommit(t, 100, "second", nil, first)
+	if err := updateRef("refs/heads/master", second, ""); err != nil {
+		t.Fatal(err)
+	}
+	if isBranch, target, err := ReadHEAD(".git"); err != nil || !isBranch || target != "refs/heads/master" {
+		t.Errorf("ReadHEAD on master = %v, %q, %v", isBranch, target, err)
+	}
+	if err := detachHead(first, ""); err != nil {
+		t.Fatal(err)
+	}
+	if isBranch, target, err := ReadHEAD(".git"); err != nil || isBranch || target != first {
+		t.Errorf("ReadHEAD detached = %v, %q, %v; want false, %s", isBranch, target, err, first)
+	}
+
+	// the walk starts at the detached commit, and the decoration says where HEAD is
+	out := runTestCommand(t, "log", "--oneline", "--decorate")
+	if want := fmt.Sprintf("%.7s (HEAD detached at %.7s) first\n", first, first); out != want {
+		t.Errorf("log on a detached HEAD:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestLogFollowsParents(t *testing.T) {
+	initTestRepo(t)
+	first := testCommit(t, 0, "first", map[string]string{"a": "a\n"})
+	side := testCommit(t, 100, "side", map[string]string{"a": "a\n", "b": "b\n"}, first)
+	mainline := testCommit(t, 200, "main", map[string]string{"a": "a\n", "c": "c\n"}, first)
+	merge := testCommit(t, 300, "merge side", map[string]string{"a": "a\n", "b": "b\n", "c": "c\n"}, mainline, side)
+	for ref, sha := range map[string]string{"refs/heads/master": merge, "refs/heads/side": side} {
+		if err := updateRef(ref, sha, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// parseCommit keeps every parent of a merge, in order
+	_, data, err := parseObject(merge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := parseCommit(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(commit.Parents, " ") != mainline+" "+side || commit.Message != "merge side\n" {
+		t.Errorf("parseCommit gave parents %v and message %q", commit.Parents, commit.Message)
+	}
+
+	entry := func(sha string, merge string, date string, message string) string {
+		return "commit " + sha + "\n" + merge +
+			"Author: A U Thor <author@example.com>\nDate:   Tue Nov 14 " + date + " 2023 +0000\n\n    " + message + "\n"
+	}
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, entry(merge, "Merge: "+mainline[:7]+" "+side[:7]+"\n", "22:18:20", "merge side") + "\n" +
+			entry(mainline, "", "22:16:40", "main") + "\n" +
+			entry(side, "", "22:15:00", "side") + "\n" +
+			entry(first, "", "22:13:20", "first")},
+		{[]string{"side"}, entry(side, "", "22:15:00", "side") + "\n" + entry(first, "", "22:13:20", "first")},
+	}
+	for _, test := range tests {
+		if got := runTestCommand(t, append([]string{"log"}, test.args...)...); got != test.want {
+			t.Errorf("log %s:\n%s\nwant:\n%s", strings.Join(test.args, " "), got, test.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Usage: mygit ls-remote [--heads] [--tags] [--symref] [--exit-code] [--get-url] [--upload-pack=<program>] [<repository> [<pattern>...]]
+//
+// <repository> is a URL or a configured remote, by default the one the current branch tracks.
+// --symref also shows what symbolic refs such as HEAD point at, --exit-code exits with 2 when
+// no refs match, and --get-url prints the repository's URL without contacting it.
+// --upload-pack runs program as the remote's upload-pack over ssh or locally.
+func cmdLsRemote(args []string) {
+	usage := "usage: mygit ls-remote [--heads] [--tags] [--symref] [--exit-code] [--get-url] [--upload-pack=<program>] [<repository> [<pattern>...]]\n"
+	heads, tags, symref, exitCode, getURL := false, false, false, false, false
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--heads" || arg == "-h":
+			heads = true
+		case arg == "--tags" || arg == "-t":
+			tags = true
+		case arg == "--symref":
+			symref = true
+		case arg == "--exit-code":
+			exitCode = true
+		case arg == "--get-url":
+			getURL = true
+		case arg == "--upload-pack" && i+1 < len(args):
+			i++
+			servicePrograms["git-upload-pack"] = args[i]
+		case strings.HasPrefix(arg, "--upload-pack="):
+			servicePrograms["git-upload-pack"] = strings.TrimPrefix(arg, "--upload-pack=")
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	remote := defaultRemote()
+	var patterns []string
+	if len(positional) > 0 {
+		remote, patterns = positional[0], positional[1:]
+	}
+	url := remoteURL(remote)
+	if getURL {
+		fmt.Println(url)
+		return
+	}
+
+	remoteServicePrograms(remote)
+	refs, caps, err := discoverRefs(url, "git-upload-pack")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	hangUpService(url, "git-upload-pack")
+	// symbolic refs are advertised as capabilities, symref=<ref>:<target>
+	symrefs := map[string]string{}
+	for _, c := range caps {
+		if value, ok := strings.CutPrefix(c, "symref="); ok {
+			if ref, target, ok := strings.Cut(value, ":"); ok {
+				symrefs[ref] = target
+			}
+		}
+	}
+	matched := false
+	for _, ref := range refs {
+		if (heads || tags) &&
+			!(heads && strings.HasPrefix(ref.name, "refs/heads/")) &&
+			!(tags && strings.HasPrefix(ref.name, "refs/tags/")) {
+			continue
+		}
+		if len(patterns) > 0 && !refMatchesPattern(ref.name, patterns) {
+			continue
+		}
+		matched = true
+		if target, ok := symrefs[ref.name]; ok && symref {
+			fmt.Printf("ref: %s\t%s\n", target, ref.name)
+		}
+		fmt.Printf("%s\t%s\n", ref.sha, ref.name)
+	}
+	if exitCode && !matched {
+		os.Exit(2)
+	}
+}
+
+// refMatchesPattern matches patterns against the tail of a ref, the way ls-remote does:
+// "main" matches refs/heads/main but not refs/heads/domain
+func refMatchesPattern(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if name == pattern || strings.HasSuffix(name, "/"+pattern) {
+			return true
+		}
+	}
+	return false
+}
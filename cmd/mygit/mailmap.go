@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+A mailmap maps the names and emails recorded in commits to canonical ones. Each line is one of:
+
+	Proper Name <commit@email>
+	<proper@email> <commit@email>
+	Proper Name <proper@email> <commit@email>
+	Proper Name <proper@email> Commit Name <commit@email>
+
+The first three apply to every commit with that email; the last only when the name matches too.
+Emails and names are matched case-insensitively, and "#" starts a comment.
+*/
+
+type mailmapIdentity struct {
+	name  string //"" keeps the commit's name
+	email string //"" keeps the commit's email
+}
+
+type mailmap struct {
+	byEmail        map[string]mailmapIdentity
+	byNameAndEmail map[string]mailmapIdentity //key is lowercased "name\x00email"
+}
+
+// readMailmap loads ~/.mailmap, then .mailmap in the working tree, then mailmap.file; later
+// entries override earlier ones
+func readMailmap() *mailmap {
+	m := &mailmap{byEmail: map[string]mailmapIdentity{}, byNameAndEmail: map[string]mailmapIdentity{}}
+	var files []string
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".mailmap"))
+	}
+	files = append(files, ".mailmap")
+	if file, ok := configGet("mailmap.file"); ok {
+		files = append(files, expandHome(file))
+	}
+	for _, file := range files {
+		m.readFile(file)
+	}
+	return m
+}
+
+func (m *mailmap) readFile(filename string) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m.parseLine(scanner.Text())
+	}
+}
+
+func (m *mailmap) parseLine(line string) {
+	if hash := strings.IndexByte(line, '#'); hash >= 0 {
+		line = line[:hash]
+	}
+	// split into "name <email>" pairs; either name may be empty
+	type pair struct{ name, email string }
+	var pairs []pair
+	for len(pairs) < 2 {
+		open := strings.IndexByte(line, '<')
+		end := strings.IndexByte(line, '>')
+		if open < 0 || end < open {
+			break
+		}
+		pairs = append(pairs, pair{strings.TrimSpace(line[:open]), strings.TrimSpace(line[open+1 : end])})
+		line = line[end+1:]
+	}
+
+	switch len(pairs) {
+	case 1:
+		// Proper Name <commit@email>
+		if pairs[0].name != "" {
+			m.add("", pairs[0].email, mailmapIdentity{name: pairs[0].name})
+		}
+	case 2:
+		// the second pair is what the commit says; the first what to show instead
+		m.add(pairs[1].name, pairs[1].email, mailmapIdentity{name: pairs[0].name, email: pairs[0].email})
+	}
+}
+
+func (m *mailmap) add(commitName string, commitEmail string, proper mailmapIdentity) {
+	email := strings.ToLower(commitEmail)
+	if commitName == "" {
+		// entries for the same email combine, so a name line and an email line can both apply
+		existing := m.byEmail[email]
+		if proper.name != "" {
+			existing.name = proper.name
+		}
+		if proper.email != "" {
+			existing.email = proper.email
+		}
+		m.byEmail[email] = existing
+		return
+	}
+	m.byNameAndEmail[strings.ToLower(commitName)+"\x00"+email] = proper
+}
+
+// lookup returns the canonical name and email for a commit's name and email
+func (m *mailmap) lookup(name string, email string) (string, string) {
+	proper, ok := m.byNameAndEmail[strings.ToLower(name)+"\x00"+strings.ToLower(email)]
+	if !ok {
+		proper, ok = m.byEmail[strings.ToLower(email)]
+	}
+	if !ok {
+		return name, email
+	}
+	if proper.name != "" {
+		name = proper.name
+	}
+	if proper.email != "" {
+		email = proper.email
+	}
+	return name, email
+}
+
+// mapSignature rewrites the identity in an author/committer line, keeping its timestamp
+func (m *mailmap) mapSignature(signature string) string {
+	open := strings.IndexByte(signature, '<')
+	end := strings.LastIndexByte(signature, '>')
+	if open < 0 || end < open {
+		return signature
+	}
+	name, email := m.lookup(strings.TrimSpace(signature[:open]), signature[open+1:end])
+	return name + " <" + email + ">" + signature[end+1:]
+}
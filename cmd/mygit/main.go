@@ -4,8 +4,9 @@ import (
 	"bytes"
 	"compress/zlib"
 	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"path"
 	"sort"
@@ -30,56 +31,80 @@ os.WriteFile : Writes to the file
 - HEAD: The current ref that you’re looking at. In most cases it’s probably refs/heads/master
 */
 
+// hash_file writes a file as a blob. The file is streamed through the hash and zlib together
+// into a temporary file, renamed into place once its SHA is known, so memory use doesn't grow
+// with the file.
 func hash_file(filePath string) ([20]byte, error) {
-	fileContents, err := os.ReadFile(filePath)
+	file, err := os.Open(filePath)
+	if err != nil {
+		return [20]byte{}, err
+	}
+	defer file.Close()
+	fi, err := file.Stat()
 	if err != nil {
 		return [20]byte{}, err
 	}
 
-	//header
-	header := fmt.Sprintf("blob %d\x00", len(fileContents))
-	storeContents := append([]byte(header), fileContents...)
+	tmpFile, err := os.CreateTemp(path.Join(".git", "objects"), "tmp_obj_")
+	if err != nil {
+		return [20]byte{}, err
+	}
+	defer os.Remove(tmpFile.Name()) //only left to remove when something failed
+	hash := sha1.New()
+	zw, _ := zlib.NewWriterLevel(tmpFile, looseCompressionLevel())
+	w := io.MultiWriter(hash, zw)
+
+	//header, with the size from stat since the contents haven't been read yet
+	fmt.Fprintf(w, "blob %d\x00", fi.Size())
+	n, err := io.Copy(w, file)
+	if err == nil && n != fi.Size() {
+		err = fmt.Errorf("%s changed size while it was being hashed", filePath)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return [20]byte{}, err
+	}
 
-	//Get the SHA-1
-	rawSha := sha1.Sum(storeContents)
+	var rawSha [20]byte
+	copy(rawSha[:], hash.Sum(nil))
 	blobSha := fmt.Sprintf("%x", rawSha)
-	blobPath := path.Join(".git", "objects", blobSha[:2], blobSha[2:])
-
-	//zlib
-	var b bytes.Buffer
-	w := zlib.NewWriter(&b)
-	w.Write(storeContents)
-	w.Close()
-
-	// if file does not exist then create it, otherwise replace it
-	if _, err := os.Stat(blobPath); os.IsNotExist(err) {
-		if err := os.MkdirAll(path.Join(".git", "objects", blobSha[:2]), 0755); err != nil {
-			return [20]byte{}, err
-		}
+	if err := os.MkdirAll(path.Join(".git", "objects", blobSha[:2]), 0755); err != nil {
+		return [20]byte{}, err
 	}
-	if err := os.WriteFile(blobPath, b.Bytes(), 0644); err != nil {
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		return [20]byte{}, err
+	}
+	// replaces an existing copy of the object, which has the same contents
+	if err := os.Rename(tmpFile.Name(), objectPath(blobSha)); err != nil {
 		return [20]byte{}, err
 	}
-
 	return rawSha, nil
 }
 
-func hash_dir(rootPath string) ([20]byte, error) {
+// hash_dir writes a directory of the working tree as a tree, leaving out .git and whatever
+// ignore says is ignored. Paths are relative to the top of the working tree, which
+// rootPath is one of.
+func hash_dir(rootPath string, ignore *ignoreMatcher) ([20]byte, error) {
 	files, err := os.ReadDir(rootPath)
 	if err != nil {
 		return [20]byte{}, err
 	}
 	var entries []string
 	for _, file := range files {
-		// skip .git directory
-		if file.Name() == ".git" {
+		fullFilePath := path.Join(rootPath, file.Name())
+		// skip .git directory and ignored files, along with everything under ignored directories
+		if file.Name() == ".git" || ignore.isIgnored(fullFilePath, file.IsDir()) {
 			continue
 		}
 		var sha [20]byte
 		mode := 0o100644
-		fullFilePath := path.Join(rootPath, file.Name())
 		if file.IsDir() {
-			treeSha, err := hash_dir(fullFilePath)
+			treeSha, err := hash_dir(fullFilePath, ignore)
 			if err != nil {
 				return [20]byte{}, err
 			}
@@ -117,68 +142,93 @@ func hash_dir(rootPath string) ([20]byte, error) {
 
 	// create zlib writer
 	var compressed bytes.Buffer
-	w := zlib.NewWriter(&compressed)
+	w, _ := zlib.NewWriterLevel(&compressed, looseCompressionLevel())
 	w.Write(b.Bytes())
 	w.Close()
 
-	// if file does not exist then create it, otherwise replace it
+	// if file does not exist then create it, otherwise replace it, never leaving half of it behind
 	if _, err := os.Stat(treePath); os.IsNotExist(err) {
 		if err := os.MkdirAll(path.Join(".git", "objects", treeSha[:2]), 0755); err != nil {
 			return [20]byte{}, err
 		}
 	}
-	if err := os.WriteFile(treePath, compressed.Bytes(), 0644); err != nil {
+	if err := writeFileAtomic(treePath, compressed.Bytes(), 0644); err != nil {
 		return [20]byte{}, err
 	}
 	return rawSha, nil
 }
 
-func commit_tree(sha_tree string, sha_parent string, message string) ([20]byte, error) {
-	var commit bytes.Buffer
-	commit.WriteString(fmt.Sprintf("tree %s\n", sha_tree)) //Add tree SHA
-
-	if sha_parent != "" {
-		commit.WriteString(fmt.Sprintf("parent %s\n", sha_parent)) //Add parent SHA
+// userIdentity is "Name <email>" for a commit's author or committer, role being "author" or
+// "committer". Like git, GIT_AUTHOR_NAME and GIT_AUTHOR_EMAIL, or the committer's, win over
+// user.name and user.email, and $EMAIL is the last resort for the address.
+func userIdentity(role string) (string, error) {
+	variable := "GIT_" + strings.ToUpper(role)
+	name := os.Getenv(variable + "_NAME")
+	if name == "" {
+		name, _ = configGet("user.name")
 	}
-
-	timestamp := time.Now().Unix()
-	timezone_offset := time.Now().Format("-0700")
-	author := fmt.Sprintf("author Bocchi! The Rock <bocchi@therock.com> %d %s", timestamp, timezone_offset)
-	committer := fmt.Sprintf("committer Bocchi! The Rock <bocchi@therock.com> %d %s", timestamp, timezone_offset)
-	commit.WriteString(fmt.Sprintf("author %s\n", author))       //Add author
-	commit.WriteString(fmt.Sprintf("committer %s\n", committer)) //Add committer
-
-	if message != "" {
-		commit.WriteString(fmt.Sprintf("\n%s\n", message))
+	email := os.Getenv(variable + "_EMAIL")
+	if email == "" {
+		email, _ = configGet("user.email")
 	}
+	if email == "" {
+		email = os.Getenv("EMAIL")
+	}
+	if name == "" || email == "" {
+		return "", fmt.Errorf("%s%s identity unknown: set user.name and user.email in the config, or %s_NAME and %s_EMAIL",
+			strings.ToUpper(role[:1]), role[1:], variable, variable)
+	}
+	return fmt.Sprintf("%s <%s>", name, email), nil
+}
 
-	var raw_sha = sha1.Sum(commit.Bytes())
-	commit_sha := fmt.Sprintf("%x", raw_sha)
-	commitPath := path.Join(".git", "objects", commit_sha[:2], commit_sha[2:])
-
-	//header
-	header := fmt.Sprintf("commit %d\x00", commit.Len())
-	var b bytes.Buffer
-	b.WriteString(header)
-	b.Write(commit.Bytes())
+// signature is the identity and time recorded as a commit's author or committer
+func signature(role string) (string, error) {
+	identity, err := userIdentity(role)
+	if err != nil {
+		return "", err
+	}
+	// the seconds and the offset come from the same instant, so they can't straddle a change
+	// of offset; time.Local follows $TZ, or else /etc/localtime
+	now := time.Now().Local()
+	return fmt.Sprintf("%s %d %s", identity, now.Unix(), now.Format("-0700")), nil
+}
 
-	//zlib
-	var compressed bytes.Buffer
-	w := zlib.NewWriter(&compressed)
-	w.Write(b.Bytes())
-	w.Close()
+// commitSignatures are the author and committer of a new commit
+func commitSignatures() (author string, committer string, err error) {
+	if author, err = signature("author"); err != nil {
+		return "", "", err
+	}
+	committer, err = signature("committer")
+	return author, committer, err
+}
 
-	// if file does not exist then create it, otherwise replace it
-	if _, err := os.Stat(commitPath); os.IsNotExist(err) {
-		if err := os.MkdirAll(path.Join(".git", "objects", commit_sha[:2]), 0755); err != nil {
+func commit_tree(sha_tree string, parents []string, message string) ([20]byte, error) {
+	//a commit pointing at a missing or mistyped object would leave the repository corrupt
+	if err := validateObject(sha_tree, "tree"); err != nil {
+		return [20]byte{}, err
+	}
+	for _, parent := range parents {
+		if err := validateObject(parent, "commit"); err != nil {
 			return [20]byte{}, err
 		}
 	}
-	if err := os.WriteFile(commitPath, compressed.Bytes(), 0644); err != nil {
+
+	commit := &Commit{Tree: sha_tree, Parents: parents} //Add tree and parent SHAs
+	var err error
+	if commit.Author, commit.Committer, err = commitSignatures(); err != nil { //Add author and committer
 		return [20]byte{}, err
 	}
 
-	return raw_sha, nil
+	if message != "" {
+		commit.Message = message + "\n"
+	}
+
+	var raw_sha [20]byte
+	commit_sha, err := writeCommit(commit)
+	if err == nil {
+		_, err = hex.Decode(raw_sha[:], []byte(commit_sha))
+	}
+	return raw_sha, err
 }
 
 func main() {
@@ -187,9 +237,48 @@ func main() {
 		os.Exit(1)
 	}
 
+	//Commands run from the top of the working tree, wherever in it they were started
+	switch os.Args[1] {
+	case "init", "clone", "merge-file", "show-index", "verify-pack", "rev-parse":
+		//need no repository, or find their own
+	case "hash-object", "ls-remote", "send-email", "imap-send":
+		setupRepository(true)
+	default:
+		setupRepository(false)
+	}
+
 	//Switch case statement
 	switch command := os.Args[1]; command { //On the first argument passed
 	case "init": //If init
+		//Initial branch from -b/--initial-branch, else init.defaultBranch, else master
+		initialBranch := ""
+		template, templateGiven := "", false
+		for i := 2; i < len(os.Args); i++ {
+			switch arg := os.Args[i]; {
+			case (arg == "-b" || arg == "--initial-branch") && i+1 < len(os.Args):
+				i++
+				initialBranch = os.Args[i]
+			case strings.HasPrefix(arg, "--initial-branch="):
+				initialBranch = strings.TrimPrefix(arg, "--initial-branch=")
+			case arg == "--template" && i+1 < len(os.Args):
+				i++
+				template, templateGiven = os.Args[i], true
+			case strings.HasPrefix(arg, "--template="):
+				template, templateGiven = strings.TrimPrefix(arg, "--template="), true
+			default:
+				fmt.Fprintf(os.Stderr, "usage: mygit init [-b <branch-name> | --initial-branch=<branch-name>] [--template=<template-directory>]\n")
+				os.Exit(1)
+			}
+		}
+		branch := initialBranch
+		if branch == "" {
+			branch = defaultBranchName()
+		}
+		if !validBranchName(branch) {
+			fmt.Fprintf(os.Stderr, "fatal: invalid initial branch name: '%s'\n", branch)
+			os.Exit(128)
+		}
+
 		//Make directory structure
 		for _, dir := range []string{".git", ".git/objects", ".git/refs"} {
 			if err := os.MkdirAll(dir, 0755); err != nil {
@@ -197,149 +286,235 @@ func main() {
 			}
 		}
 
-		headFileContents := []byte("ref: refs/heads/master\n") //Contents of file
-		if err := os.WriteFile(".git/HEAD", headFileContents, 0644); err != nil {
+		//Copy the template directory in; an empty --template= means none
+		if dir, explicit := templateDir(template, templateGiven); dir != "" {
+			if !fileExists(dir) {
+				if explicit {
+					fmt.Fprintf(os.Stderr, "warning: templates not found in %s\n", dir)
+				}
+			} else if err := copyTemplate(dir, ".git"); err != nil {
+				fmt.Fprintf(os.Stderr, "Error copying templates: %s\n", err)
+			}
+		}
+
+		if _, err := os.Stat(".git/HEAD"); err == nil { //Reinitializing keeps the current HEAD
+			if initialBranch != "" {
+				fmt.Fprintf(os.Stderr, "warning: re-init: ignored --initial-branch=%s\n", initialBranch)
+			}
+		} else if err := setHead(branch, ""); err != nil {
 			fmt.Fprintf(os.Stderr, "Error writing file: %s\n", err)
 		}
 
 		fmt.Println("Initialized git directory") //Send response
 
 	case "cat-file":
+		for _, arg := range os.Args[2:] { //The batch modes take names from stdin
+			if strings.HasPrefix(arg, "--batch") {
+				cmdCatFileBatch(os.Args[2:])
+				return
+			}
+		}
 		if len(os.Args) < 4 {
-			fmt.Fprintf(os.Stderr, "usage: mygit cat-file -p <blob_hash>\n")
+			fmt.Fprintf(os.Stderr, "usage: mygit cat-file (-p|-t|-s) <object>\n")
 			os.Exit(1)
 		}
 
-		blob_sha := os.Args[3]                                               //Get the SHA
-		blobPath := path.Join(".git", "objects", blob_sha[:2], blob_sha[2:]) //Get the path
-
-		reader, err := os.Open(blobPath)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "File not found: %s\n", err)
+		if os.Args[2] != "-p" && os.Args[2] != "-t" && os.Args[2] != "-s" {
+			fmt.Fprintf(os.Stderr, "usage: mygit cat-file (-p|-t|-s) <object>\n")
 			os.Exit(1)
 		}
 
-		zlibreader, err := zlib.NewReader(reader)
+		blob_sha, err := resolveObjectArg(os.Args[3]) //Get the SHA, from a SHA, rev or <rev>:<path>
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error parsing file: %s\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
 		}
 
-		contents, err := ioutil.ReadAll(zlibreader)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading file: %s\n", err)
+		objType, payload, err := parseObject(blob_sha)
+		if err != nil { //there, but unreadable: corrupt, or a truncated pack
+			fmt.Fprintf(os.Stderr, "error: %s\nfatal: git cat-file: could not get object info\n", err)
+			os.Exit(128)
+		}
+		switch os.Args[2] {
+		case "-t":
+			fmt.Println(objType)
+		case "-s":
+			fmt.Println(len(payload))
+		default:
+			os.Stdout.Write(payload) //as stored, without adding a newline
 		}
-
-		//Remove the "blob size\0"
-		index := bytes.IndexByte(contents, 0) + 1
-		data := string(contents[index:])
-		fmt.Printf("%s\n", data)
 
 	case "hash-object":
-		if len(os.Args) < 4 {
-			fmt.Fprintf(os.Stderr, "usage: mygit hash-object -w <file>\n")
+		// Usage: mygit hash-object [-t <type>] [-w] [--literally] [--path=<path>] (--stdin | <file>)
+		//
+		// --stdin hashes what is read from standard input instead of a file. -w writes the
+		// object to the object store; without it the SHA is only printed. -t hashes the contents
+		// as a tree, commit or tag already in that format, rather than a blob; like git, it
+		// refuses one that isn't well formed, unless --literally is given, which also takes
+		// any type name. --path hashes the
+		// file as if it lived at <path>, running it through the clean filter that path's
+		// attributes name, so the SHA matches the blob a filtered add would store. Without it
+		// the contents are hashed exactly as they are.
+		usage := "usage: mygit hash-object [-t <type>] [-w] [--literally] [--path=<path>] (--stdin | <file>)\n"
+		write, stdin, literally, filterPath := false, false, false, ""
+		objType := "blob"
+		var files []string
+		args := os.Args[2:]
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; {
+			case arg == "-w":
+				write = true
+			case arg == "-t" && i+1 < len(args):
+				i++
+				objType = args[i]
+			case arg == "--stdin":
+				stdin = true
+			case arg == "--literally":
+				literally = true
+			case strings.HasPrefix(arg, "--path="):
+				filterPath = strings.TrimPrefix(arg, "--path=")
+			case strings.HasPrefix(arg, "-"):
+				fmt.Fprint(os.Stderr, usage)
+				os.Exit(1)
+			default:
+				files = append(files, arg)
+			}
+		}
+		if stdin && len(files) != 0 || !stdin && len(files) != 1 {
+			fmt.Fprint(os.Stderr, usage)
 			os.Exit(1)
 		}
+		switch objType {
+		case "blob", "tree", "commit", "tag":
+		default:
+			if !literally {
+				fmt.Fprintf(os.Stderr, "fatal: invalid object type \"%s\"\n", objType)
+				os.Exit(128)
+			}
+		}
 
-		filename := os.Args[3]
-		dat, err := os.ReadFile(filename)
+		var dat []byte
+		var err error
+		if stdin {
+			dat, err = io.ReadAll(os.Stdin)
+		} else {
+			dat, err = os.ReadFile(repoPath(files[0]))
+		}
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error unable to read file: %s\n", err)
+			os.Exit(1)
 		}
-		data := string(dat)                             //contents
-		header := fmt.Sprintf("blob %d\x00", len(data)) //Header
-		content := append([]byte(header), data...)      //Added header to content
-
-		sha_data := fmt.Sprintf("%x", sha1.Sum(content)) //sha1
-
-		var compresed_data bytes.Buffer
-		w := zlib.NewWriter(&compresed_data)
-		w.Write([]byte(content))
-		w.Close()
-
-		filepath := path.Join(".git", "objects", sha_data[:2], sha_data[2:])
-
-		if _, err := os.Stat(filepath); os.IsNotExist(err) {
-			if err := os.MkdirAll(path.Join(".git", "objects", sha_data[:2]), 0755); err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating folder: %s\n", err)
-				os.Exit(1)
+		if filterPath != "" && objType == "blob" {
+			if dat, err = cleanFilter(path.Clean(repoPath(filterPath)), dat); err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+				os.Exit(128)
+			}
+		}
+		if !literally {
+			if err := checkObjectFormat(objType, dat); err != nil {
+				if detail := err.(*objectFormatError).detail; detail != "" {
+					fmt.Fprintf(os.Stderr, "error: %s\n", detail)
+				}
+				fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+				os.Exit(128)
 			}
 		}
-		if err := os.WriteFile(filepath, compresed_data.Bytes(), 0644); err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating file: %s\n", err)
+		if !write {
+			fmt.Println(hashObject(objType, dat))
+			break
+		}
+		rawSha, err := writeObject(objType, dat)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing object: %s\n", err)
 			os.Exit(1)
 		}
-		fmt.Printf("%s\n", sha_data)
+		fmt.Printf("%x\n", rawSha)
 
 	case "ls-tree":
-		if len(os.Args) < 4 {
-			fmt.Fprintf(os.Stderr, "usage: mygit ls-tree --name-only <tree-SHA>\n")
-			os.Exit(1)
+		// Usage: mygit ls-tree [--name-only] <tree-ish>
+		//
+		// Lists a tree's entries as "<mode> <type> <sha>\t<name>", like git, or just the names
+		// with --name-only.
+		usage := "usage: mygit ls-tree [--name-only] <tree-ish>\n"
+		nameOnly := false
+		var treeish []string
+		for _, arg := range os.Args[2:] {
+			switch {
+			case arg == "--name-only":
+				nameOnly = true
+			case strings.HasPrefix(arg, "-"):
+				fmt.Fprint(os.Stderr, usage)
+				os.Exit(1)
+			default:
+				treeish = append(treeish, arg)
+			}
 		}
-
-		tree_sha := os.Args[3]
-		treePath := path.Join(".git", "objects", tree_sha[:2], tree_sha[2:])
-
-		reader, err := os.Open(treePath)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error finding tree : %s\n", err)
+		if len(treeish) != 1 {
+			fmt.Fprint(os.Stderr, usage)
 			os.Exit(1)
 		}
 
-		zlibreader, err := zlib.NewReader(reader)
+		tree_sha, err := resolveObjectArg(treeish[0])
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error parsing tree : %s\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
 		}
-
-		contents, err := ioutil.ReadAll(zlibreader)
+		if tree_sha, err = peelToTree(tree_sha); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: not a tree object\n")
+			os.Exit(128)
+		}
+		entries, err := readTree(tree_sha) //the empty tree needn't be stored to be listed
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading file: %s\n", err)
+			fmt.Fprintf(os.Stderr, "Error reading tree: %s\n", err)
 			os.Exit(1)
 		}
 
-		header_index := bytes.IndexByte(contents, 0)
-		contents = contents[header_index+1:]
-
-		var paths []string
-
-		for len(contents) > 0 {
-			space_index := strings.IndexByte(string(contents), ' ')
-			mode := contents[:space_index]
-			contents = contents[len(mode)+1:]
-
-			null_index := strings.IndexByte(string(contents), 0)
-			path := contents[:null_index]
-			contents = contents[len(path)+1:]
-
-			path_sha := contents[:20]
-			contents = contents[len(path_sha):]
-
-			paths = append(paths, string(path))
-		}
-
-		for _, name := range paths {
-			fmt.Println(name)
+		for _, entry := range entries {
+			if nameOnly {
+				fmt.Println(entry.name)
+				continue
+			}
+			objType := "blob"
+			switch entry.mode {
+			case 0o040000:
+				objType = "tree"
+			case 0o160000:
+				objType = "commit"
+			}
+			fmt.Printf("%06o %s %s\t%s\n", entry.mode, objType, entry.sha, entry.name)
 		}
 
 	case "write-tree":
-		if len(os.Args) < 2 {
-			fmt.Fprintf(os.Stderr, "usage: mygit write-tree\n")
-			os.Exit(1)
-		}
-		// find directory where .git is located
-		gitDir, err := os.Getwd() //Returns path to current directory
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error getting current directory: %s\n", err)
-			os.Exit(1)
+		missingOK := false
+		for _, arg := range os.Args[2:] {
+			if arg != "--missing-ok" {
+				fmt.Fprintf(os.Stderr, "usage: mygit write-tree [--missing-ok]\n")
+				os.Exit(1)
+			}
+			missingOK = true
 		}
-		for {
-			if _, err := os.Stat(path.Join(gitDir, ".git")); err == nil { //Id this dir has .git
-				break
+		//With an index, the tree is what is staged, like git; otherwise the working directory
+		if entries, err := readIndex(); err == nil {
+			problems := indexTreeProblems(entries, missingOK)
+			for _, problem := range problems {
+				fmt.Fprintln(os.Stderr, problem)
+			}
+			if len(problems) > 0 {
+				fmt.Fprintf(os.Stderr, "fatal: git-write-tree: error building trees\n")
+				os.Exit(128)
 			}
-			gitDir = path.Dir(gitDir) //Goes one dir up
+			treeSha, err := indexTree(entries)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing tree: %s\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(treeSha)
+			break
+		} else if !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Error reading index: %s\n", err)
+			os.Exit(1)
 		}
-		treeSha, err := hash_dir(gitDir)
+		treeSha, err := hash_dir(".", newIgnoreMatcher())
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error hashing tree: %s\n", err)
 			os.Exit(1)
@@ -375,7 +550,21 @@ func main() {
 				os.Exit(1)
 			}
 		}
-		commit_sha, err := commit_tree(tree_sha, parent_sha, message)
+		//both SHAs end up in the commit, so they must be real objects
+		tree_sha, err := resolveObjectArg(tree_sha)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		var parents []string
+		if parent_sha != "" {
+			if parent_sha, err = resolveObjectArg(parent_sha); err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+				os.Exit(128)
+			}
+			parents = append(parents, parent_sha)
+		}
+		commit_sha, err := commit_tree(tree_sha, parents, message)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error committing tree: %s\n", err)
 			os.Exit(1)
@@ -383,6 +572,111 @@ func main() {
 		// print sha
 		fmt.Printf("%x\n", commit_sha)
 
+	case "commit":
+		cmdCommit(os.Args[2:])
+
+	case "checkout":
+		cmdCheckout(os.Args[2:])
+
+	case "switch":
+		cmdSwitch(os.Args[2:])
+
+	case "bisect":
+		cmdBisect(os.Args[2:])
+
+	case "shortlog":
+		cmdShortlog(os.Args[2:])
+
+	case "log":
+		cmdLog(os.Args[2:])
+
+	case "show":
+		cmdShow(os.Args[2:])
+
+	case "clone":
+		cmdClone(os.Args[2:])
+
+	case "add":
+		cmdAdd(os.Args[2:])
+
+	case "ls-remote":
+		cmdLsRemote(os.Args[2:])
+
+	case "merge":
+		cmdMerge(os.Args[2:])
+
+	case "pull":
+		cmdPull(os.Args[2:])
+
+	case "rebase":
+		cmdRebase(os.Args[2:])
+
+	case "cherry-pick":
+		cmdCherryPick(os.Args[2:])
+
+	case "reflog":
+		cmdReflog(os.Args[2:])
+
+	case "fetch":
+		cmdFetch(os.Args[2:])
+
+	case "push":
+		cmdPush(os.Args[2:])
+
+	case "blame":
+		cmdBlame(os.Args[2:])
+
+	case "annotate":
+		cmdAnnotate(os.Args[2:])
+
+	case "diff":
+		cmdDiff(os.Args[2:])
+	case "diff-tree":
+		cmdDiffTree(os.Args[2:])
+
+	case "fsck":
+		cmdFsck(os.Args[2:])
+	case "count-objects":
+		cmdCountObjects(os.Args[2:])
+	case "tag":
+		cmdTag(os.Args[2:])
+	case "rev-parse":
+		cmdRevParse(os.Args[2:])
+	case "send-email":
+		cmdSendEmail(os.Args[2:])
+	case "imap-send":
+		cmdIMAPSend(os.Args[2:])
+	case "rerere":
+		cmdRerere(os.Args[2:])
+	case "merge-file":
+		cmdMergeFile(os.Args[2:])
+	case "for-each-ref":
+		cmdForEachRef(os.Args[2:])
+	case "check-attr":
+		cmdCheckAttr(os.Args[2:])
+	case "check-ignore":
+		cmdCheckIgnore(os.Args[2:])
+	case "show-index":
+		cmdShowIndex(os.Args[2:])
+	case "verify-pack":
+		cmdVerifyPack(os.Args[2:])
+	case "pack-redundant":
+		cmdPackRedundant(os.Args[2:])
+	case "archive":
+		cmdArchive(os.Args[2:])
+	case "status":
+		cmdStatus(os.Args[2:])
+	case "stash":
+		cmdStash(os.Args[2:])
+	case "notes":
+		cmdNotes(os.Args[2:])
+	case "merge-base":
+		cmdMergeBase(os.Args[2:])
+	case "rev-list":
+		cmdRevList(os.Args[2:])
+	case "branch":
+		cmdBranch(os.Args[2:])
+
 	default: //If anything else
 		fmt.Fprintf(os.Stderr, "Unknown command %s\n", command)
 		os.Exit(1)
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain lets a test run mygit as a command: the test binary runs itself with
+// MYGIT_TEST_MAIN set, and then behaves as mygit does, exit code and all
+func TestMain(m *testing.M) {
+	if os.Getenv("MYGIT_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// initTestRepo makes a new repository with mygit init in a temporary directory, and changes
+// into it until the test ends. The environment gives commits a fixed identity and keeps the
+// user's own config out of it.
+func initTestRepo(t testing.TB) string {
+	t.Helper()
+	home := t.TempDir()
+	for _, env := range [][2]string{
+		{"HOME", home},
+		{"XDG_CONFIG_HOME", filepath.Join(home, ".config")},
+		{"GIT_CONFIG_NOSYSTEM", "1"},
+		{"GIT_AUTHOR_NAME", "A U Thor"},
+		{"GIT_AUTHOR_EMAIL", "author@example.com"},
+		{"GIT_COMMITTER_NAME", "C O Mitter"},
+		{"GIT_COMMITTER_EMAIL", "committer@example.com"},
+		{"GIT_EDITOR", "true"},
+	} {
+		t.Setenv(env[0], env[1])
+	}
+	dir := t.TempDir()
+	chdirTest(t, dir)
+	runTestCommand(t, "init")
+	return dir
+}
+
+// chdirTest changes into dir until the test ends
+func chdirTest(t testing.TB, dir string) {
+	t.Helper()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+}
+
+// runMygit runs mygit with args in the current directory, returning what it wrote and its
+// exit code
+func runMygit(t testing.TB, args ...string) (stdout string, stderr string, code int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "MYGIT_TEST_MAIN=1")
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return out.String(), errOut.String(), code
+}
+
+// runTestCommand runs mygit with args and fails the test unless it succeeds, returning its
+// standard output
+func runTestCommand(t testing.TB, args ...string) string {
+	t.Helper()
+	stdout, stderr, code := runMygit(t, args...)
+	if code != 0 {
+		t.Fatalf("mygit %q exited with %d: %s", args, code, stderr)
+	}
+	return stdout
+}
+
+// writeTestFile writes a file in the working tree, making its directory first
+func writeTestFile(t testing.TB, name string, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testCommit writes a commit of files, path -> contents, with the given parents, dated
+// seconds after the epoch so that tests decide the order commits sort in. Nothing is checked
+// out and no ref moves.
+func testCommit(t testing.TB, seconds int, message string, files map[string]string, parents ...string) string {
+	t.Helper()
+	entries := map[string]treeEntry{}
+	for name, contents := range files {
+		rawSha, err := writeObject("blob", []byte(contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[name] = treeEntry{mode: 0o100644, sha: fmt.Sprintf("%x", rawSha)}
+	}
+	tree, err := writeTreeFiles(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stamp := fmt.Sprintf("%d +0000", 1700000000+seconds)
+	sha, err := writeCommit(&Commit{
+		Tree:      tree,
+		Parents:   parents,
+		Author:    "A U Thor <author@example.com> " + stamp,
+		Committer: "C O Mitter <committer@example.com> " + stamp,
+		Message:   message + "\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha
+}
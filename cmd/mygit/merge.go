@@ -0,0 +1,636 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	mergeHeadPath = ".git/MERGE_HEAD"
+	mergeMsgPath  = ".git/MERGE_MSG"
+)
+
+// Usage:
+//
+//	mygit merge [-m <message>] [--[no-]edit] [--allow-unrelated-histories] [<commit>]
+//	mygit merge (--continue|--abort)
+//
+// Without a commit, the current branch's upstream is merged, unless merge.defaultToUpstream is
+// false. The message of a merge commit is opened in the editor first when it wasn't given with
+// -m and the merge runs in a terminal, or always with --edit; --no-edit takes it as it is.
+func cmdMerge(args []string) {
+	usage := "usage: mygit merge [-m <message>] [--[no-]edit] [--allow-unrelated-histories] [<commit>]\n" +
+		"   or: mygit merge (--continue|--abort)\n"
+	message, allowUnrelated := "", false
+	edit, editGiven := false, false
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-m" && i+1 < len(args):
+			i++
+			message = args[i]
+		case strings.HasPrefix(arg, "-m") && len(arg) > 2:
+			message = arg[2:]
+		case arg == "--allow-unrelated-histories":
+			allowUnrelated = true
+		case arg == "-e" || arg == "--edit":
+			edit, editGiven = true, true
+		case arg == "--no-edit":
+			edit, editGiven = false, true
+		case arg == "--continue":
+			if err := mergeContinue(); err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+				os.Exit(128)
+			}
+			return
+		case arg == "--abort":
+			if err := mergeAbort(); err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+				os.Exit(128)
+			}
+			return
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) == 0 && configBool("merge.defaultToUpstream", true) {
+		upstream, err := defaultMergeUpstream()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+		positional = append(positional, upstream)
+	}
+	if len(positional) != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+	if _, err := os.Stat(mergeHeadPath); err == nil {
+		fmt.Fprintf(os.Stderr, "fatal: You have not concluded your merge (MERGE_HEAD exists).\n")
+		os.Exit(128)
+	}
+	if !editGiven {
+		edit = message == "" && isTerminal(os.Stdin) && isTerminal(os.Stdout) && os.Getenv("GIT_MERGE_AUTOEDIT") != "no"
+	}
+
+	name := positional[0]
+	sha, err := resolveObjectArg(name)
+	if err == nil {
+		sha, err = peelToCommit(sha)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "merge: %s - not something we can merge\n", name)
+		os.Exit(1)
+	}
+	if message == "" {
+		message = mergeMessage(name, sha)
+	}
+
+	conflicted, err := mergeCommit(name, sha, message, allowUnrelated, edit)
+	exitOnMergeFailure(conflicted, err)
+}
+
+// defaultMergeUpstream names the remote-tracking branch the current branch's upstream is
+// fetched into, such as "origin/main", for a merge given no commit
+func defaultMergeUpstream() (string, error) {
+	branch, err := currentBranch()
+	if err != nil {
+		return "", err
+	}
+	if branch == "" {
+		return "", errors.New("No current branch.")
+	}
+	remote, mergeRef, ok := branchUpstream(branch)
+	if !ok {
+		return "", errors.New("No remote for the current branch.")
+	}
+	tracking := trackingRef(remote, mergeRef)
+	if tracking == "" {
+		return "", errors.New("No remote-tracking branch for " + mergeRef + " from " + remote)
+	}
+	return strings.TrimPrefix(tracking, "refs/remotes/"), nil
+}
+
+// errMergeNotCommitted means the merge was left for "merge --continue" because the message
+// couldn't be edited or was left empty
+var errMergeNotCommitted = errors.New("Not committing merge; use 'mygit merge --continue' to complete the merge.")
+
+// exitOnMergeFailure reports a merge that stopped, either with conflicts or before starting
+// because of local changes, and exits
+func exitOnMergeFailure(conflicted bool, err error) {
+	if err == errMergeNotCommitted {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	if err != nil {
+		var overwritten *checkoutConflictError
+		if errors.As(err, &overwritten) {
+			overwritten.operation = "merge"
+			fmt.Fprintf(os.Stderr, "error: %s\nAborting\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	if conflicted {
+		fmt.Println("Automatic merge failed; fix conflicts and then commit the result.")
+		os.Exit(1)
+	}
+}
+
+// mergeMessage is the default message for merging name, worded like git's
+func mergeMessage(name string, sha string) string {
+	var message string
+	switch {
+	case name == "FETCH_HEAD":
+		message = "Merge " + fetchHeadDescription()
+	case branchExists(name):
+		message = fmt.Sprintf("Merge branch '%s'", name)
+	case strings.HasPrefix(name, "refs/tags/") || refExists(path.Join("refs", "tags", name)):
+		message = fmt.Sprintf("Merge tag '%s'", strings.TrimPrefix(name, "refs/tags/"))
+	case refExists(path.Join("refs", "remotes", name)):
+		message = fmt.Sprintf("Merge remote-tracking branch '%s'", name)
+	default:
+		message = fmt.Sprintf("Merge commit '%s'", sha)
+	}
+	if branch, _ := currentBranch(); branch != "" && branch != "main" && branch != "master" {
+		message += " into " + branch
+	}
+	return message
+}
+
+// fetchHeadDescription describes the ref FETCH_HEAD's first line records, such as
+// "branch 'main' of <url>"
+func fetchHeadDescription() string {
+	contents, _ := os.ReadFile(".git/FETCH_HEAD")
+	line, _, _ := strings.Cut(string(contents), "\n")
+	fields := strings.SplitN(line, "\t", 3)
+	if len(fields) < 3 {
+		return "commit '" + fields[0] + "'"
+	}
+	return fields[2]
+}
+
+func fileExists(filePath string) bool {
+	_, err := os.Stat(filePath)
+	return err == nil
+}
+
+// mergeCommit merges sha into HEAD: a fast-forward when HEAD is behind, otherwise a three-way
+// merge against the merge base that is committed straight away if it is clean, after editing
+// the message when edit is set. Conflicts are left in the index and working tree with
+// MERGE_HEAD recorded, and reported by returning true.
+func mergeCommit(name string, sha string, message string, allowUnrelated bool, edit bool) (bool, error) {
+	head, err := headCommit()
+	if err != nil {
+		return false, err
+	}
+	if head == "" {
+		// nothing to merge into; the branch simply starts at sha
+		if err := checkoutCommit("", sha); err != nil {
+			return false, err
+		}
+		return false, updateHead(sha, "initial pull")
+	}
+
+	if upToDate, err := isAncestor(sha, head); err != nil {
+		return false, err
+	} else if upToDate {
+		fmt.Println("Already up to date.")
+		return false, nil
+	}
+	if fastForward, err := isAncestor(head, sha); err != nil {
+		return false, err
+	} else if fastForward {
+		fmt.Printf("Updating %s..%s\nFast-forward\n", head[:7], sha[:7])
+		if err := checkoutCommit(head, sha); err != nil {
+			return false, err
+		}
+		return false, updateHead(sha, "merge "+name+": Fast-forward")
+	}
+
+	bases, err := mergeBases(head, sha)
+	if err != nil {
+		return false, err
+	}
+	baseTree := "" //unrelated histories merge against the empty tree
+	if len(bases) == 0 {
+		if !allowUnrelated {
+			return false, fmt.Errorf("refusing to merge unrelated histories")
+		}
+	} else if baseTree, err = commitTree(bases[0]); err != nil {
+		return false, err
+	}
+	headTree, err := commitTree(head)
+	if err != nil {
+		return false, err
+	}
+	theirTree, err := commitTree(sha)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := mergeTrees(baseTree, headTree, theirTree, "HEAD", name, favorNone)
+	if err != nil {
+		return false, err
+	}
+	if err := applyMergeResult(headTree, result); err != nil {
+		return false, err
+	}
+
+	if len(result.conflicts) > 0 {
+		msg := message + "\n\n# Conflicts:\n"
+		for _, conflict := range result.conflicts {
+			msg += "#\t" + conflict.path + "\n"
+		}
+		if err := os.WriteFile(mergeHeadPath, []byte(sha+"\n"), 0644); err != nil {
+			return false, err
+		}
+		return true, os.WriteFile(mergeMsgPath, []byte(msg), 0644)
+	}
+
+	if edit {
+		if message, err = editMergeMessage(sha, message); err != nil {
+			return false, err
+		}
+	}
+	commitSha, err := commit_tree(result.tree, []string{head, sha}, message)
+	if err != nil {
+		return false, err
+	}
+	fmt.Println("Merge made by the 'recursive' strategy.")
+	return false, updateHead(fmt.Sprintf("%x", commitSha), "merge "+name+": Merge made by the 'recursive' strategy.")
+}
+
+const mergeMessageHelp = `# Please enter a commit message to explain why this merge is necessary,
+# especially if it merges an updated upstream into a topic branch.
+#
+# Lines starting with '#' will be ignored, and an empty message aborts
+# the commit.
+`
+
+// editMergeMessage lets the user edit a clean merge's message. MERGE_HEAD and MERGE_MSG are
+// written first, so if the editor fails or the message comes back empty the merge is left for
+// "merge --continue" to commit, and errMergeNotCommitted is returned.
+func editMergeMessage(sha string, message string) (string, error) {
+	if err := os.WriteFile(mergeHeadPath, []byte(sha+"\n"), 0644); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(mergeMsgPath, []byte(message+"\n"+mergeMessageHelp), 0644); err != nil {
+		return "", err
+	}
+	if err := launchEditor(mergeMsgPath); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return "", errMergeNotCommitted
+	}
+	edited, err := os.ReadFile(mergeMsgPath)
+	if err != nil {
+		return "", err
+	}
+	if message = cleanupMessage(string(edited)); message == "" {
+		return "", errMergeNotCommitted
+	}
+	os.Remove(mergeHeadPath)
+	os.Remove(mergeMsgPath)
+	return message, nil
+}
+
+type mergeConflict struct {
+	path     string
+	entries  [3]*treeEntry //base, ours, theirs; nil where the file is absent
+	contents []byte        //what to leave in the working tree
+}
+
+type mergeResult struct {
+	tree      string //merged tree, with conflicted paths as they are in ours
+	conflicts []mergeConflict
+}
+
+// mergeOptions are the strategy (-s) and strategy options (-X) steering a merge
+type mergeOptions struct {
+	strategy string     //"recursive" (the default), "resolve" or "ours"
+	favor    mergeFavor //-X ours or -X theirs
+}
+
+// parseMergeStrategy checks a -s argument
+func parseMergeStrategy(strategy string, options *mergeOptions) error {
+	switch strategy {
+	case "recursive", "resolve", "ours":
+		options.strategy = strategy
+		return nil
+	}
+	return fmt.Errorf("Could not find merge strategy '%s'.\nAvailable strategies are: recursive resolve ours.", strategy)
+}
+
+// parseStrategyOption applies a -X argument. There is only one diff algorithm, so the options
+// choosing one are accepted and make no difference.
+func parseStrategyOption(option string, options *mergeOptions) error {
+	switch option {
+	case "ours":
+		options.favor = favorOurs
+		return nil
+	case "theirs":
+		options.favor = favorTheirs
+		return nil
+	case "patience", "histogram", "minimal":
+		return nil
+	}
+	if algorithm, ok := strings.CutPrefix(option, "diff-algorithm="); ok {
+		switch algorithm {
+		case "myers", "default", "minimal", "patience", "histogram":
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown strategy option: -X%s", option)
+}
+
+// mergeTrees does a three-way merge of two trees against a base tree. Paths changed on only one
+// side take that side; paths changed on both are merged line by line when they are regular
+// files, and are conflicts otherwise. favor settles conflicting chunks within a file.
+func mergeTrees(baseTree, ourTree, theirTree string, ourLabel, theirLabel string, favor mergeFavor) (*mergeResult, error) {
+	var sides [3]map[string]treeEntry
+	for i, tree := range []string{baseTree, ourTree, theirTree} {
+		files, err := treeFiles(tree)
+		if err != nil {
+			return nil, err
+		}
+		sides[i] = files
+	}
+	paths := map[string]bool{}
+	for _, files := range sides {
+		for filePath := range files {
+			paths[filePath] = true
+		}
+	}
+	sorted := make([]string, 0, len(paths))
+	for filePath := range paths {
+		sorted = append(sorted, filePath)
+	}
+	sort.Strings(sorted)
+
+	merged := map[string]treeEntry{}
+	result := &mergeResult{}
+	for _, filePath := range sorted {
+		base, inBase := sides[0][filePath]
+		ours, inOurs := sides[1][filePath]
+		theirs, inTheirs := sides[2][filePath]
+		take := func(entry treeEntry, present bool) {
+			if present {
+				merged[filePath] = entry
+			}
+		}
+		switch {
+		case sameEntry(ours, inOurs, theirs, inTheirs), sameEntry(base, inBase, theirs, inTheirs):
+			take(ours, inOurs)
+			continue
+		case sameEntry(base, inBase, ours, inOurs):
+			take(theirs, inTheirs)
+			continue
+		}
+
+		conflict := mergeConflict{path: filePath}
+		for i, files := range sides {
+			if entry, ok := files[filePath]; ok {
+				entry := entry
+				conflict.entries[i] = &entry
+			}
+		}
+		if !inOurs || !inTheirs {
+			// changed on one side and deleted on the other; the surviving version stays
+			deletedIn, kept, keptIn := ourLabel, theirs, theirLabel
+			if inOurs {
+				deletedIn, kept, keptIn = theirLabel, ours, ourLabel
+			}
+			_, contents, err := parseObject(kept.sha)
+			if err != nil {
+				return nil, err
+			}
+			conflict.contents = contents
+			take(ours, inOurs)
+			result.conflicts = append(result.conflicts, conflict)
+			fmt.Printf("CONFLICT (modify/delete): %s deleted in %s and modified in %s. Version %s of %s left in tree.\n",
+				filePath, deletedIn, keptIn, keptIn, filePath)
+			continue
+		}
+
+		mode := ours.mode
+		if ours.mode != theirs.mode && inBase && ours.mode == base.mode {
+			mode = theirs.mode
+		}
+		isFile := func(e treeEntry) bool { return e.mode == 0o100644 || e.mode == 0o100755 }
+		if !isFile(ours) || !isFile(theirs) {
+			_, contents, err := parseObject(ours.sha)
+			if err != nil {
+				return nil, err
+			}
+			conflict.contents = contents
+			take(ours, true)
+			result.conflicts = append(result.conflicts, conflict)
+			fmt.Printf("CONFLICT (content): Merge conflict in %s\n", filePath)
+			continue
+		}
+
+		var baseContents []byte
+		if inBase {
+			if _, contents, err := parseObject(base.sha); err == nil {
+				baseContents = contents
+			} else {
+				return nil, err
+			}
+		}
+		_, ourContents, err := parseObject(ours.sha)
+		if err != nil {
+			return nil, err
+		}
+		_, theirContents, err := parseObject(theirs.sha)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Auto-merging %s\n", filePath)
+		contents, conflicts := mergeLines(baseContents, ourContents, theirContents, ourLabel, theirLabel, favor)
+		if conflicts > 0 {
+			conflict.contents = contents
+			take(ours, true)
+			result.conflicts = append(result.conflicts, conflict)
+			kind := "content"
+			if !inBase {
+				kind = "add/add"
+			}
+			fmt.Printf("CONFLICT (%s): Merge conflict in %s\n", kind, filePath)
+			continue
+		}
+		sha, err := writeObject("blob", contents)
+		if err != nil {
+			return nil, err
+		}
+		take(treeEntry{mode: mode, name: filePath, sha: fmt.Sprintf("%x", sha)}, true)
+	}
+
+	tree, err := writeTreeFiles(merged)
+	if err != nil {
+		return nil, err
+	}
+	result.tree = tree
+	return result, nil
+}
+
+// applyMergeResult checks out a merge result over HEAD's tree, then writes the conflicted files
+// into the working tree and their base/ours/theirs versions into index stages 1-3
+func applyMergeResult(headTree string, result *mergeResult) error {
+	// conflicted paths stay as in HEAD during the checkout, so check them for local changes here
+	head, err := treeFiles(headTree)
+	if err != nil {
+		return err
+	}
+	entries, err := loadIndex(head)
+	if err != nil {
+		return err
+	}
+	index := indexByPath(entries)
+	var dirty []string
+	for _, conflict := range result.conflicts {
+		target := conflict.entries[2]
+		if target == nil {
+			target = conflict.entries[1]
+		}
+		to := map[string]treeEntry{conflict.path: *target}
+		modified, err := locallyModified(conflict.path, head, to, index)
+		if err != nil {
+			return err
+		}
+		if modified {
+			dirty = append(dirty, conflict.path)
+		}
+	}
+	if len(dirty) > 0 {
+		return &checkoutConflictError{paths: dirty}
+	}
+
+	if err := checkoutTree(headTree, result.tree, false); err != nil {
+		return err
+	}
+	if len(result.conflicts) == 0 {
+		return nil
+	}
+
+	if entries, err = readIndex(); err != nil {
+		return err
+	}
+	conflicted := map[string]bool{}
+	for _, conflict := range result.conflicts {
+		conflicted[conflict.path] = true
+	}
+	var newEntries []indexEntry
+	for _, entry := range entries {
+		if !conflicted[entry.path] {
+			newEntries = append(newEntries, entry)
+		}
+	}
+	for _, conflict := range result.conflicts {
+		mode := uint32(0o100644)
+		for _, entry := range conflict.entries {
+			if entry != nil {
+				mode = entry.mode
+			}
+		}
+		if err := os.MkdirAll(path.Dir(conflict.path), 0755); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(conflict.path); err != nil {
+			return err
+		}
+		if mode == 0o120000 {
+			err = os.Symlink(string(conflict.contents), conflict.path)
+		} else {
+			err = os.WriteFile(conflict.path, conflict.contents, os.FileMode(mode&0o777))
+		}
+		if err != nil {
+			return err
+		}
+		for stage, entry := range conflict.entries {
+			if entry != nil {
+				newEntries = append(newEntries, indexEntry{
+					mode:  entry.mode,
+					sha:   entry.sha,
+					flags: uint16(stage+1) << 12,
+					path:  conflict.path,
+				})
+			}
+		}
+	}
+	if err := writeIndex(newEntries); err != nil {
+		return err
+	}
+	return rerereConflicts(result.conflicts)
+}
+
+// mergeContinue commits a merge whose conflicts have been resolved and staged
+func mergeContinue() error {
+	theirs, err := os.ReadFile(mergeHeadPath)
+	if err != nil {
+		return errors.New("There is no merge in progress (MERGE_HEAD missing).")
+	}
+	head, err := headCommit()
+	if err != nil {
+		return err
+	}
+	entries, err := readIndex()
+	if err != nil {
+		return err
+	}
+	tree, err := indexTree(entries)
+	if err == errUnmerged {
+		return errors.New("Committing is not possible because you have unmerged files; " +
+			"fix them up in the work tree and use 'mygit add <file>' to mark resolution")
+	} else if err != nil {
+		return err
+	}
+	if err := rerereRecordResolutions(); err != nil {
+		return err
+	}
+
+	message, _ := os.ReadFile(mergeMsgPath)
+	cleaned := cleanupMessage(string(message))
+	if cleaned == "" {
+		return errors.New("Aborting commit due to empty commit message.")
+	}
+	commitSha, err := commit_tree(tree, []string{head, strings.TrimSpace(string(theirs))}, cleaned)
+	if err != nil {
+		return err
+	}
+	subject, _, _ := strings.Cut(cleaned, "\n")
+	if err := updateHead(fmt.Sprintf("%x", commitSha), "commit (merge): "+subject); err != nil {
+		return err
+	}
+	os.Remove(mergeHeadPath)
+	os.Remove(mergeMsgPath)
+	return nil
+}
+
+// mergeAbort puts the index and working tree back to HEAD and forgets the merge
+func mergeAbort() error {
+	if _, err := os.Stat(mergeHeadPath); err != nil {
+		return errors.New("There is no merge to abort (MERGE_HEAD missing).")
+	}
+	head, err := headCommit()
+	if err != nil {
+		return err
+	}
+	headTree, err := commitTree(head)
+	if err != nil {
+		return err
+	}
+	if err := resetWorktree(headTree); err != nil {
+		return err
+	}
+	os.Remove(mergeHeadPath)
+	os.Remove(mergeMsgPath)
+	return rerereClear()
+}
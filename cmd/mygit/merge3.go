@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+/*
+A three-way merge lines both sides up against the base. Base lines matched on both sides are
+stable; between them are chunks where at least one side changed. A chunk only one side changed
+takes that side, a chunk both sides changed the same way is taken once, and anything else is a
+conflict, written out between markers:
+
+	<<<<<<< ours
+	...
+	=======
+	...
+	>>>>>>> theirs
+*/
+
+// mergeFavor is how mergeLines settles a chunk both sides changed in different ways
+type mergeFavor int
+
+const (
+	favorNone   mergeFavor = iota //leave a conflict
+	favorOurs                     //take our side of the chunk
+	favorTheirs                   //take their side of the chunk
+	favorUnion                    //take our side of the chunk, then theirs
+)
+
+// conflictMarkers are the labels on a conflict's markers. With diff3, the base's side of the
+// chunk is shown too, after a "|||||||" marker.
+type conflictMarkers struct {
+	ours, base, theirs string
+	diff3              bool
+}
+
+// mergeLines merges ours and theirs against base, labelling conflict markers with the given
+// names, and returns the result and the number of conflicts
+func mergeLines(base, ours, theirs []byte, ourLabel, theirLabel string, favor mergeFavor) ([]byte, int) {
+	return mergeLinesWithMarkers(base, ours, theirs, conflictMarkers{ours: ourLabel, theirs: theirLabel}, favor)
+}
+
+// mergeLinesWithMarkers is mergeLines with full control over how conflicts are written
+func mergeLinesWithMarkers(base, ours, theirs []byte, markers conflictMarkers, favor mergeFavor) ([]byte, int) {
+	baseLines, ourLines, theirLines := splitLines(base), splitLines(ours), splitLines(theirs)
+	ourMatch := map[int]int{}
+	for _, m := range matchLines(baseLines, ourLines) {
+		ourMatch[m.a] = m.b
+	}
+	theirMatch := map[int]int{}
+	for _, m := range matchLines(baseLines, theirLines) {
+		theirMatch[m.a] = m.b
+	}
+
+	var out bytes.Buffer
+	conflicts := 0
+	i, j, k := 0, 0, 0
+	for {
+		// next base line both sides kept
+		o := i
+		for o < len(baseLines) {
+			_, inOurs := ourMatch[o]
+			_, inTheirs := theirMatch[o]
+			if inOurs && inTheirs {
+				break
+			}
+			o++
+		}
+		nextJ, nextK := len(ourLines), len(theirLines)
+		if o < len(baseLines) {
+			nextJ, nextK = ourMatch[o], theirMatch[o]
+		}
+
+		if o > i || nextJ > j || nextK > k {
+			baseChunk := baseLines[i:o]
+			ourChunk := ourLines[j:nextJ]
+			theirChunk := theirLines[k:nextK]
+			switch {
+			case equalLines(ourChunk, baseChunk):
+				out.WriteString(strings.Join(theirChunk, ""))
+			case equalLines(theirChunk, baseChunk), equalLines(ourChunk, theirChunk):
+				out.WriteString(strings.Join(ourChunk, ""))
+			case favor == favorOurs:
+				out.WriteString(strings.Join(ourChunk, ""))
+			case favor == favorTheirs:
+				out.WriteString(strings.Join(theirChunk, ""))
+			case favor == favorUnion:
+				writeLines(&out, ourChunk)
+				out.WriteString(strings.Join(theirChunk, ""))
+			default:
+				conflicts++
+				out.WriteString(conflictMarker("<<<<<<<", markers.ours))
+				writeLines(&out, ourChunk)
+				if markers.diff3 {
+					out.WriteString(conflictMarker("|||||||", markers.base))
+					writeLines(&out, baseChunk)
+				}
+				out.WriteString("=======\n")
+				writeLines(&out, theirChunk)
+				out.WriteString(conflictMarker(">>>>>>>", markers.theirs))
+			}
+		}
+		if o == len(baseLines) {
+			break
+		}
+		out.WriteString(baseLines[o])
+		i, j, k = o+1, nextJ+1, nextK+1
+	}
+	return out.Bytes(), conflicts
+}
+
+// conflictMarker is a marker line, with its label when there is one
+func conflictMarker(marker string, label string) string {
+	if label == "" {
+		return marker + "\n"
+	}
+	return marker + " " + label + "\n"
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeLines writes one side of a conflict; the marker after it needs a line of its own, so a
+// missing final newline is added back
+func writeLines(out *bytes.Buffer, lines []string) {
+	for _, line := range lines {
+		out.WriteString(line)
+	}
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		out.WriteString("\n")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// forkPoint finds where commit forked from ref, judging by every value the ref's reflog says
+// it has had as well as its current one, so that a branch rebuilt on a rewritten upstream still
+// finds the upstream commit it was built on. It returns "" when there is no single merge base
+// that ref once pointed at.
+func forkPoint(ref string, commit string) (string, error) {
+	tip, err := readRef(ref)
+	if err != nil {
+		return "", err
+	}
+	entries, err := readReflog(ref)
+	if err != nil {
+		return "", err
+	}
+	candidates := []string{tip}
+	known := map[string]bool{tip: true}
+	for i := len(entries) - 1; i >= 0; i-- {
+		sha := entries[i].new
+		if known[sha] || sha == zeroSHA || !objectExists(sha) {
+			continue
+		}
+		if objType, _, err := parseObject(sha); err != nil || objType != "commit" {
+			continue
+		}
+		known[sha] = true
+		candidates = append(candidates, sha)
+	}
+	bases, err := mergeBases(commit, candidates...)
+	if err != nil || len(bases) != 1 || !known[bases[0]] {
+		return "", err
+	}
+	return bases[0], nil
+}
+
+// Usage:
+//
+//	mygit merge-base [-a|--all] <commit> <commit>...
+//	mygit merge-base [-a|--all] --octopus <commit>...
+//	mygit merge-base --is-ancestor <commit> <commit>
+//	mygit merge-base --fork-point <ref> [<commit>]
+//
+// Prints the best common ancestor of the first commit and the others, taken as if merged
+// together, or with --all every best common ancestor. --octopus finds those of all the commits
+// at once, as an octopus merge of them would use. --is-ancestor prints nothing and exits with 0
+// when the first commit is an ancestor of the second and 1 otherwise. --fork-point finds where
+// commit, HEAD by default, forked from ref, taking into account the earlier values of ref in its
+// reflog.
+//
+// It exits with 1 when there is no common ancestor.
+func cmdMergeBase(args []string) {
+	usage := "usage: mygit merge-base [-a | --all] <commit> <commit>...\n" +
+		"   or: mygit merge-base [-a | --all] --octopus <commit>...\n" +
+		"   or: mygit merge-base --is-ancestor <commit> <commit>\n" +
+		"   or: mygit merge-base --fork-point <ref> [<commit>]\n"
+	all, mode := false, ""
+	var revs []string
+	for _, arg := range args {
+		switch {
+		case arg == "-a" || arg == "--all":
+			all = true
+		case arg == "--octopus" || arg == "--is-ancestor" || arg == "--fork-point":
+			if mode != "" && mode != arg {
+				fmt.Fprintf(os.Stderr, "error: options '%s' and '%s' cannot be used together\n", mode, arg)
+				os.Exit(129)
+			}
+			mode = arg
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(129)
+		default:
+			revs = append(revs, arg)
+		}
+	}
+	switch {
+	case mode == "" && len(revs) < 2,
+		mode == "--octopus" && len(revs) < 1,
+		mode == "--is-ancestor" && (len(revs) != 2 || all),
+		mode == "--fork-point" && (len(revs) < 1 || len(revs) > 2 || all):
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(129)
+	}
+	resolve := func(rev string) string {
+		sha, err := resolveObjectArg(rev)
+		if err == nil {
+			sha, err = peelToCommit(sha)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: Not a valid commit name %s\n", rev)
+			os.Exit(128)
+		}
+		return sha
+	}
+	fatal := func(err error) {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+
+	var bases []string
+	switch mode {
+	case "--is-ancestor":
+		ancestor, err := isAncestor(resolve(revs[0]), resolve(revs[1]))
+		if err != nil {
+			fatal(err)
+		}
+		if !ancestor {
+			os.Exit(1)
+		}
+		return
+	case "--fork-point":
+		ref, ok := fullRefName(revs[0])
+		if !ok {
+			fmt.Fprintf(os.Stderr, "fatal: Not a valid object name: '%s'\n", revs[0])
+			os.Exit(128)
+		}
+		commit := "HEAD"
+		if len(revs) == 2 {
+			commit = revs[1]
+		}
+		point, err := forkPoint(ref, resolve(commit))
+		if err != nil {
+			fatal(err)
+		}
+		if point != "" {
+			bases = []string{point}
+		}
+	case "--octopus":
+		bases = []string{resolve(revs[0])}
+		for _, rev := range revs[1:] {
+			var err error
+			if bases, err = mergeBases(resolve(rev), bases...); err != nil {
+				fatal(err)
+			}
+		}
+	default:
+		others := make([]string, len(revs)-1)
+		for i, rev := range revs[1:] {
+			others[i] = resolve(rev)
+		}
+		var err error
+		if bases, err = mergeBases(resolve(revs[0]), others...); err != nil {
+			fatal(err)
+		}
+	}
+	if len(bases) == 0 {
+		os.Exit(1)
+	}
+	if !all {
+		bases = bases[:1]
+	}
+	for _, sha := range bases {
+		fmt.Println(sha)
+	}
+}
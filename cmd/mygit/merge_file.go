@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Usage: mygit merge-file [-p] [-q] [--diff3] [--union | --ours | --theirs] [-L <label> [-L <label> [-L <label>]]] <current> <base> <other>
+//
+// Merges the changes from base to other into current, the same three-way merge merge does for
+// each file, and writes the result back to current, or to stdout with -p. Conflicts are left
+// between "<<<<<<< current", "=======" and ">>>>>>> other" markers, with the base's version
+// after "||||||| base" too with --diff3. The labels are the file names unless given with -L.
+// --ours and --theirs settle conflicts in favour of one side, and --union takes both.
+//
+// It exits with 0 for a clean merge and 1 when there are conflicts.
+func cmdMergeFile(args []string) {
+	usage := "usage: mygit merge-file [-p] [-q] [--diff3] [--union | --ours | --theirs] [-L <label> [-L <label> [-L <label>]]] <current> <base> <other>\n"
+	toStdout, quiet := false, false
+	favor := favorNone
+	var markers conflictMarkers
+	var labels, files []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-p" || arg == "--stdout":
+			toStdout = true
+		case arg == "-q" || arg == "--quiet":
+			quiet = true
+		case arg == "--diff3":
+			markers.diff3 = true
+		case arg == "--ours":
+			favor = favorOurs
+		case arg == "--theirs":
+			favor = favorTheirs
+		case arg == "--union":
+			favor = favorUnion
+		case arg == "-L" && i+1 < len(args) && len(labels) < 3:
+			i++
+			labels = append(labels, args[i])
+		case strings.HasPrefix(arg, "-L") && len(arg) > 2 && len(labels) < 3:
+			labels = append(labels, arg[2:])
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(129)
+		default:
+			files = append(files, arg)
+		}
+	}
+	if len(files) != 3 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(129)
+	}
+	labels = append(labels, files[len(labels):]...)
+	markers.ours, markers.base, markers.theirs = labels[0], labels[1], labels[2]
+
+	var contents [3][]byte
+	for i, filename := range files {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: could not read %s: %s\n", filename, err)
+			os.Exit(255)
+		}
+		if isBinary(data) {
+			if !quiet {
+				fmt.Fprintf(os.Stderr, "warning: Cannot merge binary files: %s (%s vs. %s)\n", files[0], labels[0], labels[2])
+			}
+			os.Exit(255)
+		}
+		contents[i] = data
+	}
+
+	merged, conflicts := mergeLinesWithMarkers(contents[1], contents[0], contents[2], markers, favor)
+	if toStdout {
+		os.Stdout.Write(merged)
+	} else if err := os.WriteFile(files[0], merged, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "error: could not write %s: %s\n", files[0], err)
+		os.Exit(255)
+	}
+	if conflicts > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMergeFastForwardsAndMergesThreeWays(t *testing.T) {
+	initTestRepo(t)
+	base := testCommit(t, 0, "base", map[string]string{"a": "1\n2\n3\n", "b": "b\n"})
+	ahead := testCommit(t, 100, "ahead", map[string]string{"a": "1\n2\n3\n", "b": "b\n", "new": "new\n"}, base)
+	side := testCommit(t, 200, "side", map[string]string{"a": "1\n2\nside\n", "b": "b\n"}, base)
+	for ref, sha := range map[string]string{"refs/heads/master": base, "refs/heads/ahead": ahead, "refs/heads/side": side} {
+		if err := updateRef(ref, sha, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runTestCommand(t, "checkout", "-f", "master")
+
+	// a descendant of HEAD is fast-forwarded to, with no merge commit
+	if stdout := runTestCommand(t, "merge", "ahead"); !strings.Contains(stdout, "Fast-forward") {
+		t.Errorf("merge ahead printed %q, want a fast-forward", stdout)
+	}
+	if sha, _ := readRef("refs/heads/master"); sha != ahead {
+		t.Errorf("master is at %s after the fast-forward, want %s", sha, ahead)
+	}
+	if contents, err := os.ReadFile("new"); err != nil || string(contents) != "new\n" {
+		t.Errorf("new is %q (%v) after the fast-forward", contents, err)
+	}
+
+	// otherwise the changes on each side since the merge base are combined in a merge commit
+	writeTestFile(t, "a", "master\n2\n3\n")
+	runTestCommand(t, "add", "a")
+	runTestCommand(t, "commit", "-m", "master")
+	before, _ := readRef("refs/heads/master")
+	runTestCommand(t, "merge", "side")
+	head, _ := readRef("refs/heads/master")
+	commit, err := readCommit(head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(commit.Parents, " ") != before+" "+side {
+		t.Errorf("the merge has parents %v, want %s and %s", commit.Parents, before, side)
+	}
+	for name, want := range map[string]string{"a": "master\n2\nside\n", "b": "b\n", "new": "new\n"} {
+		if contents, _ := os.ReadFile(name); string(contents) != want {
+			t.Errorf("%s is %q after the merge, want %q", name, contents, want)
+		}
+	}
+	tree, err := treeFiles(commit.Tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := tree["a"]; entry.sha != hashObject("blob", []byte("master\n2\nside\n")) {
+		t.Errorf("the merge committed a as %s", entry.sha)
+	}
+
+	// the same line changed on both sides is a conflict, left marked up in the file
+	conflicting := testCommit(t, 300, "other", map[string]string{"a": "1\n2\nother\n", "b": "b\n"}, base)
+	if err := updateRef("refs/heads/other", conflicting, ""); err != nil {
+		t.Fatal(err)
+	}
+	runTestCommand(t, "checkout", "-f", "other")
+	stdout, _, code := runMygit(t, "merge", "side")
+	if code == 0 || !strings.Contains(stdout, "CONFLICT (content): Merge conflict in a") {
+		t.Errorf("merge with a conflict: exit %d, %q", code, stdout)
+	}
+	if contents, _ := os.ReadFile("a"); string(contents) != "1\n2\n<<<<<<< HEAD\nother\n=======\nside\n>>>>>>> side\n" {
+		t.Errorf("a is %q after the conflict", contents)
+	}
+	if sha, _ := readRef("refs/heads/other"); sha != conflicting {
+		t.Errorf("other moved to %s despite the conflict", sha)
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+/*
+Notes attach text to objects without changing them. They live in a commit history of their own,
+refs/notes/commits by default, whose tree holds a blob for each annotated object, named after the
+object's SHA. git splits those names into fanout directories ("ab/cdef...") once there are many
+notes; both layouts are read, and notes are written flat.
+*/
+
+// notesRef is the notes ref to use: --ref when given, then $GIT_NOTES_REF, core.notesRef, and
+// refs/notes/commits. A short name is taken to be under refs/notes/.
+func notesRef(ref string) string {
+	if ref == "" {
+		ref = os.Getenv("GIT_NOTES_REF")
+	}
+	if ref == "" {
+		ref, _ = configGet("core.notesRef")
+	}
+	if ref == "" {
+		return "refs/notes/commits"
+	}
+	if !strings.HasPrefix(ref, "refs/") {
+		ref = path.Join("refs", "notes", ref)
+	}
+	return ref
+}
+
+// notesTree is the notes on one notes ref, by the SHA of the object they annotate
+type notesTree struct {
+	ref    string
+	commit string //the ref's commit, "" before any note was added
+	notes  map[string]treeEntry
+}
+
+func readNotes(ref string) (*notesTree, error) {
+	t := &notesTree{ref: ref, notes: map[string]treeEntry{}}
+	if !refExists(ref) {
+		return t, nil
+	}
+	var err error
+	if t.commit, err = readRef(ref); err != nil {
+		return nil, err
+	}
+	tree, err := commitTree(t.commit)
+	if err != nil {
+		return nil, err
+	}
+	files, err := treeFiles(tree)
+	if err != nil {
+		return nil, err
+	}
+	for filePath, entry := range files {
+		if object := strings.ReplaceAll(filePath, "/", ""); isHexSHA(object) {
+			entry.name = object
+			t.notes[object] = entry
+		}
+	}
+	return t, nil
+}
+
+// commitNotes records the notes as a new commit on the notes ref
+func (t *notesTree) commitNotes(message string) error {
+	tree, err := writeTreeFiles(t.notes)
+	if err != nil {
+		return err
+	}
+	var parents []string
+	if t.commit != "" {
+		parents = []string{t.commit}
+	}
+	author, committer, err := commitSignatures()
+	if err != nil {
+		return err
+	}
+	sha, err := writeCommit(&Commit{Tree: tree, Parents: parents, Author: author, Committer: committer, Message: message + "\n"})
+	if err != nil {
+		return err
+	}
+	t.commit = sha
+	return updateRef(t.ref, sha, "")
+}
+
+// copyNote attaches the note on from to to as well; without force, to may not have one yet
+func (t *notesTree) copyNote(from string, to string, force bool) error {
+	note, ok := t.notes[from]
+	if !ok {
+		return fmt.Errorf("missing notes on source object %s. Cannot copy.", from)
+	}
+	if _, exists := t.notes[to]; exists && !force {
+		return fmt.Errorf("Cannot copy notes. Found existing notes for object %s. Use '-f' to overwrite existing notes", to)
+	}
+	note.name = to
+	t.notes[to] = note
+	return nil
+}
+
+// copyFromReader copies notes for the pairs of objects read from r, "<from> <to>" on each line
+// or, with nulTerminated, alternate NUL-separated names. A pair that can't be copied is reported
+// and skipped; the result reports whether any were.
+func (t *notesTree) copyFromReader(r io.Reader, nulTerminated bool, force bool) (bool, error) {
+	var names []string
+	scanner := bufio.NewScanner(r)
+	if nulTerminated {
+		scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+			if i := strings.IndexByte(string(data), 0); i >= 0 {
+				return i + 1, data[:i], nil
+			}
+			if atEOF && len(data) > 0 {
+				return len(data), data, nil
+			}
+			return 0, nil, nil
+		})
+	} else {
+		scanner.Split(bufio.ScanWords)
+	}
+	for scanner.Scan() {
+		if name := strings.TrimSpace(scanner.Text()); name != "" {
+			names = append(names, name)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	if len(names)%2 != 0 {
+		return false, fmt.Errorf("malformed input line: '%s'.", names[len(names)-1])
+	}
+
+	failed := false
+	for i := 0; i < len(names); i += 2 {
+		from, err := resolveObjectArg(names[i])
+		if err != nil {
+			return false, fmt.Errorf("failed to resolve '%s' as a valid ref.", names[i])
+		}
+		to, err := resolveObjectArg(names[i+1])
+		if err != nil {
+			return false, fmt.Errorf("failed to resolve '%s' as a valid ref.", names[i+1])
+		}
+		if err := t.copyNote(from, to, force); err != nil {
+			fmt.Fprintf(os.Stderr, "error: failed to copy notes from '%s' to '%s'\n", names[i], names[i+1])
+			failed = true
+		}
+	}
+	return failed, nil
+}
+
+// Usage:
+//
+//	mygit notes [--ref <notes-ref>] [list [<object>]]
+//	mygit notes [--ref <notes-ref>] add [-f] [-m <msg> | -F <file>] [<object>]
+//	mygit notes [--ref <notes-ref>] copy [-f] <from-object> <to-object>
+//	mygit notes [--ref <notes-ref>] copy [-f] --stdin [-z]
+//	mygit notes [--ref <notes-ref>] show [<object>]
+//
+// list prints "<note blob> <object>" for each note, or the note blob of one object. add attaches
+// a note to an object, HEAD by default, opening the editor when no message is given; -f replaces
+// a note it already has. copy attaches the note of one object to another, which must not have
+// one unless -f is given. With --stdin it copies a batch, reading "<from> <to>" pairs a line at
+// a time or, with -z, as NUL-separated names, the way history rewriting carries notes over to
+// the rewritten commits. show prints an object's note.
+func cmdNotes(args []string) {
+	usage := "usage: mygit notes [--ref <notes-ref>] [list [<object>]]\n" +
+		"   or: mygit notes [--ref <notes-ref>] add [-f] [-m <msg> | -F <file>] [<object>]\n" +
+		"   or: mygit notes [--ref <notes-ref>] copy [-f] <from-object> <to-object>\n" +
+		"   or: mygit notes [--ref <notes-ref>] copy [-f] --stdin [-z]\n" +
+		"   or: mygit notes [--ref <notes-ref>] show [<object>]\n"
+	ref := ""
+	for len(args) > 0 && strings.HasPrefix(args[0], "--ref") {
+		if value, ok := strings.CutPrefix(args[0], "--ref="); ok {
+			ref, args = value, args[1:]
+		} else if args[0] == "--ref" && len(args) > 1 {
+			ref, args = args[1], args[2:]
+		} else {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(129)
+		}
+	}
+	action := "list"
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+
+	force, stdin, nulTerminated, messageGiven := false, false, false, false
+	var messages []string
+	var objects []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case (arg == "-f" || arg == "--force") && (action == "add" || action == "copy"):
+			force = true
+		case arg == "--stdin" && action == "copy":
+			stdin = true
+		case arg == "-z" && action == "copy":
+			nulTerminated = true
+		case (arg == "-m" || arg == "--message") && i+1 < len(args) && action == "add":
+			i++
+			messages, messageGiven = append(messages, args[i]), true
+		case (arg == "-F" || arg == "--file") && i+1 < len(args) && action == "add":
+			i++
+			contents, err := os.ReadFile(args[i])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: could not read '%s': %s\n", args[i], err)
+				os.Exit(128)
+			}
+			messages, messageGiven = append(messages, string(contents)), true
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(129)
+		default:
+			objects = append(objects, arg)
+		}
+	}
+	fatal := func(err error) {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+	resolve := func(name string) string {
+		sha, err := resolveObjectArg(name)
+		if err != nil {
+			fatal(fmt.Errorf("failed to resolve '%s' as a valid ref.", name))
+		}
+		return sha
+	}
+	// object is the single, optional object an action takes, HEAD by default
+	object := func() string {
+		if len(objects) > 1 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(129)
+		}
+		if len(objects) == 0 {
+			return resolve("HEAD")
+		}
+		return resolve(objects[0])
+	}
+
+	t, err := readNotes(notesRef(ref))
+	if err != nil {
+		fatal(err)
+	}
+	switch action {
+	case "list":
+		if len(objects) > 0 {
+			sha := object()
+			note, ok := t.notes[sha]
+			if !ok {
+				fail(fmt.Errorf("no note found for object %s.", sha))
+			}
+			fmt.Println(note.sha)
+			return
+		}
+		annotated := make([]string, 0, len(t.notes))
+		for sha := range t.notes {
+			annotated = append(annotated, sha)
+		}
+		sort.Strings(annotated)
+		for _, sha := range annotated {
+			fmt.Printf("%s %s\n", t.notes[sha].sha, sha)
+		}
+
+	case "show":
+		sha := object()
+		note, ok := t.notes[sha]
+		if !ok {
+			fail(fmt.Errorf("no note found for object %s.", sha))
+		}
+		_, contents, err := parseObject(note.sha)
+		if err != nil {
+			fatal(err)
+		}
+		os.Stdout.Write(contents)
+
+	case "add":
+		sha := object()
+		if _, exists := t.notes[sha]; exists && !force {
+			fail(fmt.Errorf("Cannot add notes. Found existing notes for object %s. Use '-f' to overwrite existing notes", sha))
+		}
+		message := ""
+		for _, m := range messages {
+			if message != "" {
+				message += "\n"
+			}
+			message += strings.TrimRight(m, "\n") + "\n"
+		}
+		if !messageGiven {
+			editPath := path.Join(".git", "NOTES_EDITMSG")
+			if err := os.WriteFile(editPath, []byte("\n# Write/edit the notes for the following object:\n"), 0644); err != nil {
+				fatal(err)
+			}
+			if err := launchEditor(editPath); err != nil {
+				fatal(err)
+			}
+			edited, err := os.ReadFile(editPath)
+			if err != nil {
+				fatal(err)
+			}
+			if message = cleanupMessage(string(edited)); message != "" {
+				message += "\n"
+			}
+		}
+		if strings.TrimSpace(message) == "" {
+			fmt.Fprintln(os.Stderr, "Removing note for object", sha)
+			delete(t.notes, sha)
+		} else {
+			blob, err := writeObject("blob", []byte(message))
+			if err != nil {
+				fatal(err)
+			}
+			t.notes[sha] = treeEntry{mode: 0o100644, name: sha, sha: fmt.Sprintf("%x", blob)}
+		}
+		if err := t.commitNotes("Notes added by 'mygit notes add'"); err != nil {
+			fatal(err)
+		}
+
+	case "copy":
+		failed := false
+		if stdin {
+			if len(objects) > 0 {
+				fmt.Fprint(os.Stderr, usage)
+				os.Exit(129)
+			}
+			if failed, err = t.copyFromReader(os.Stdin, nulTerminated, force); err != nil {
+				fatal(err)
+			}
+		} else {
+			if len(objects) != 2 || nulTerminated {
+				fmt.Fprint(os.Stderr, usage)
+				os.Exit(129)
+			}
+			if err := t.copyNote(resolve(objects[0]), resolve(objects[1]), force); err != nil {
+				fail(err)
+			}
+		}
+		if err := t.commitNotes("Notes added by 'mygit notes copy'"); err != nil {
+			fatal(err)
+		}
+		if failed {
+			os.Exit(1)
+		}
+
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(129)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// validateSHA checks that a string is a full object name: exactly 40 hex digits
+func validateSHA(sha string) error {
+	if len(sha) != 40 {
+		return fmt.Errorf("'%s' is not a valid object name: expected 40 hex digits, got %d", sha, len(sha))
+	}
+	for _, c := range sha {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return fmt.Errorf("'%s' is not a valid object name: '%c' is not a hex digit", sha, c)
+		}
+	}
+	return nil
+}
+
+// resolveObjectArg turns an object argument from the command line (a SHA, a ref, or
+// <rev>:<path>) into the SHA of an object that exists
+func resolveObjectArg(arg string) (string, error) {
+	sha, err := resolveObjectSpec(arg)
+	if err != nil {
+		if errors.As(err, &ambiguousObjectError{}) {
+			return "", err
+		}
+		if rev, _, _ := strings.Cut(arg, ":"); len(rev) > 40 && strings.Trim(rev, "0123456789abcdefABCDEF") == "" {
+			return "", validateSHA(rev) //looks like a mistyped SHA rather than a ref name
+		}
+		return "", fmt.Errorf("Not a valid object name %s", arg)
+	}
+	if err := validateSHA(sha); err != nil {
+		return "", err
+	}
+	sha = strings.ToLower(sha)
+	if !hasObject(sha) {
+		return "", fmt.Errorf("Not a valid object name %s", arg)
+	}
+	return sha, nil
+}
+
+// emptyTreeSHA is the SHA of the tree with nothing in it. Like git, every repository has it,
+// whether or not it was ever written.
+const emptyTreeSHA = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// minAbbrev is the fewest hex digits an abbreviated object name can have
+const minAbbrev = 4
+
+// resolveObject expands an abbreviated object name, at least minAbbrev hex digits long, to
+// the full SHA of the one object, loose or packed, that starts with it
+func resolveObject(prefix string) (string, error) {
+	prefix = strings.ToLower(prefix)
+	if len(prefix) < minAbbrev || len(prefix) > 40 || !isHex(prefix) {
+		return "", fmt.Errorf("Not a valid object name %s", prefix)
+	}
+	files, err := os.ReadDir(path.Join(".git", "objects", prefix[:2]))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	var matches []string
+	found := map[string]bool{}
+	for _, file := range files {
+		if sha := prefix[:2] + file.Name(); isHexSHA(sha) && strings.HasPrefix(sha, prefix) {
+			matches, found[sha] = append(matches, sha), true
+		}
+	}
+	for _, sha := range packedObjectsWithPrefix(prefix) {
+		if !found[sha] {
+			matches, found[sha] = append(matches, sha), true
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("Not a valid object name %s", prefix)
+	case 1:
+		return matches[0], nil
+	}
+	return "", ambiguousObjectError{prefix}
+}
+
+// ambiguousObjectError is an abbreviated object name that more than one object starts with
+type ambiguousObjectError struct {
+	prefix string
+}
+
+func (e ambiguousObjectError) Error() string {
+	return fmt.Sprintf("short object ID %s is ambiguous", e.prefix)
+}
+
+func objectPath(sha string) string {
+	return path.Join(".git", "objects", sha[:2], sha[2:])
+}
+
+// objectExists reports whether the object store has an object, loose or packed
+func objectExists(sha string) bool {
+	if _, err := os.Stat(objectPath(sha)); err == nil {
+		return true
+	}
+	_, _, packed := findPackedObject(sha)
+	return packed
+}
+
+var looseCompression = struct {
+	once  sync.Once
+	level int
+}{}
+
+// looseCompressionLevel is the zlib level loose objects are written with: core.looseCompression,
+// or else core.compression. Levels outside -1..9 are ignored with a warning.
+func looseCompressionLevel() int {
+	looseCompression.once.Do(func() {
+		looseCompression.level = zlib.DefaultCompression
+		for _, key := range []string{"core.compression", "core.looseCompression"} {
+			value, ok := configGet(key)
+			if !ok {
+				continue
+			}
+			level, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || level < zlib.DefaultCompression || level > zlib.BestCompression {
+				fmt.Fprintf(os.Stderr, "warning: bad zlib compression level '%s' for %s, ignoring\n", value, key)
+				continue
+			}
+			looseCompression.level = level
+		}
+	})
+	return looseCompression.level
+}
+
+// hashObject returns the hex SHA an object would be stored under, without writing it
+func hashObject(objType string, data []byte) string {
+	header := fmt.Sprintf("%s %d\x00", objType, len(data))
+	return fmt.Sprintf("%x", sha1.Sum(append([]byte(header), data...)))
+}
+
+// objectFormatError is why a payload isn't a well-formed object: the reason git gives up
+// with, and for a commit or tag any error git reports on the way, naming the object by the
+// zero SHA as git does before it has hashed it
+type objectFormatError struct {
+	detail string
+	reason string
+}
+
+func (e *objectFormatError) Error() string { return e.reason }
+
+// checkObjectFormat checks a tree, commit or tag payload the way git's hash-object does
+// before hashing one: entry by entry for a tree, and the header lines git parses for a
+// commit or tag
+func checkObjectFormat(objType string, data []byte) error {
+	switch objType {
+	case "tree":
+		for len(data) > 0 {
+			if len(data) < 23 || data[len(data)-21] != 0 {
+				return &objectFormatError{reason: "too-short tree object"}
+			}
+			space := 0
+			for ; data[space] != ' '; space++ {
+				if data[space] < '0' || data[space] > '7' {
+					return &objectFormatError{reason: "malformed mode in tree entry"}
+				}
+			}
+			if space == 0 {
+				return &objectFormatError{reason: "malformed mode in tree entry"}
+			}
+			name := bytes.IndexByte(data[space+1:], 0)
+			if name == 0 {
+				return &objectFormatError{reason: "empty filename in tree entry"}
+			}
+			end := space + 1 + name + 1 + 20
+			if end > len(data) {
+				return &objectFormatError{reason: "too-short tree file"}
+			}
+			data = data[end:]
+		}
+	case "commit":
+		// a tree line, then any parent lines, each with a full SHA
+		rest, ok := bytes.CutPrefix(data, []byte("tree "))
+		if !ok || len(data) <= 46 || data[45] != '\n' {
+			return &objectFormatError{"bogus commit object " + zeroSHA, "corrupt commit"}
+		}
+		if validateSHA(string(rest[:40])) != nil {
+			return &objectFormatError{"bad tree pointer in commit " + zeroSHA, "corrupt commit"}
+		}
+		for rest = rest[41:]; len(rest) > 48 && bytes.HasPrefix(rest, []byte("parent ")); rest = rest[48:] {
+			if len(rest) <= 49 || validateSHA(string(rest[7:47])) != nil || rest[47] != '\n' {
+				return &objectFormatError{"bad parents in commit " + zeroSHA, "corrupt commit"}
+			}
+		}
+	case "tag":
+		// object, type and tag lines, with a type git knows
+		rest, ok := bytes.CutPrefix(data, []byte("object "))
+		if !ok || len(data) < 64 || validateSHA(string(rest[:40])) != nil || rest[40] != '\n' {
+			return &objectFormatError{reason: "corrupt tag"}
+		}
+		rest, ok = bytes.CutPrefix(rest[41:], []byte("type "))
+		typeName, rest, found := bytes.Cut(rest, []byte("\n"))
+		if !ok || !found || len(typeName) >= 20 {
+			return &objectFormatError{reason: "corrupt tag"}
+		}
+		switch string(typeName) {
+		case "blob", "tree", "commit", "tag":
+		default:
+			return &objectFormatError{fmt.Sprintf("unknown tag type '%s' in %s", typeName, zeroSHA), "corrupt tag"}
+		}
+		rest, ok = bytes.CutPrefix(rest, []byte("tag "))
+		if !ok || len(rest) == 0 || bytes.IndexByte(rest, '\n') < 0 {
+			return &objectFormatError{reason: "corrupt tag"}
+		}
+	}
+	return nil
+}
+
+func writeObject(objType string, data []byte) ([20]byte, error) {
+	header := fmt.Sprintf("%s %d\x00", objType, len(data))
+	storeContents := append([]byte(header), data...)
+
+	rawSha := sha1.Sum(storeContents)
+	sha := fmt.Sprintf("%x", rawSha)
+
+	//zlib
+	var b bytes.Buffer
+	w, _ := zlib.NewWriterLevel(&b, looseCompressionLevel())
+	w.Write(storeContents)
+	w.Close()
+
+	if err := os.MkdirAll(path.Join(".git", "objects", sha[:2]), 0755); err != nil {
+		return [20]byte{}, err
+	}
+	if err := writeFileAtomic(objectPath(sha), b.Bytes(), 0644); err != nil {
+		return [20]byte{}, err
+	}
+	return rawSha, nil
+}
+
+// readObjectHeader reads just the type and size of a loose object, without inflating the rest.
+// A packed object is read whole.
+func readObjectHeader(sha string) (string, int, error) {
+	if err := validateSHA(sha); err != nil {
+		return "", 0, err
+	}
+	if packFile, offset, ok := findPackedLooseFirst(sha); ok {
+		objType, contents, err := readPackObject(packFile, offset)
+		return objType, len(contents), err
+	}
+	reader, err := openObject(sha)
+	if os.IsNotExist(err) && sha == emptyTreeSHA {
+		return "tree", 0, nil
+	} else if err != nil {
+		return "", 0, err
+	}
+	defer reader.Close()
+	zlibreader, err := zlib.NewReader(reader)
+	if err != nil {
+		return "", 0, fmt.Errorf("object %s: %w", sha, err)
+	}
+	header, err := bufio.NewReader(io.LimitReader(zlibreader, 64)).ReadString(0)
+	if err != nil {
+		return "", 0, fmt.Errorf("object %s: malformed header", sha)
+	}
+	objType, sizeField, ok := strings.Cut(strings.TrimSuffix(header, "\x00"), " ")
+	size, err := strconv.Atoi(sizeField)
+	if !ok || err != nil {
+		return "", 0, fmt.Errorf("object %s: malformed header", sha)
+	}
+	return objType, size, nil
+}
+
+// validateObject checks that sha names an object of the expected type in the object store
+func validateObject(sha string, expectedType string) error {
+	objType, _, err := readObjectHeader(sha)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s is not a valid '%s' object: no such object", sha, expectedType)
+	}
+	if err != nil {
+		return err
+	}
+	if objType != expectedType {
+		return fmt.Errorf("%s is not a valid '%s' object: it is a %s", sha, expectedType, objType)
+	}
+	return nil
+}
+
+// findPackedLooseFirst finds an object in the packs, unless it is loose, which is read first
+// the way git does
+func findPackedLooseFirst(sha string) (string, int64, bool) {
+	if fileExists(objectPath(sha)) {
+		return "", 0, false
+	}
+	return findPackedObject(sha)
+}
+
+// openObject opens a loose object's file. In a partial clone, an object that isn't here is
+// fetched from the promisor remote first.
+func openObject(sha string) (*os.File, error) {
+	f, err := os.Open(objectPath(sha))
+	if os.IsNotExist(err) && promisorRemote() != "" {
+		if fetchErr := fetchPromisedObject(sha); fetchErr != nil {
+			return nil, fmt.Errorf("object %s is missing and could not be fetched: %w", sha, fetchErr)
+		}
+		f, err = os.Open(objectPath(sha))
+	}
+	return f, err
+}
+
+// parseObject reads an object, loose or packed, and splits it into its type and payload
+func parseObject(sha string) (string, []byte, error) {
+	if err := validateSHA(sha); err != nil {
+		return "", nil, err
+	}
+	if packFile, offset, ok := findPackedLooseFirst(sha); ok {
+		return readPackObject(packFile, offset)
+	}
+	reader, err := openObject(sha)
+	if os.IsNotExist(err) && sha == emptyTreeSHA {
+		return "tree", nil, nil
+	} else if err != nil {
+		return "", nil, err
+	}
+	defer reader.Close()
+
+	zlibreader, err := zlib.NewReader(reader)
+	if err != nil {
+		return "", nil, fmt.Errorf("object %s: %w", sha, err)
+	}
+	contents, err := io.ReadAll(zlibreader)
+	if err != nil {
+		return "", nil, fmt.Errorf("object %s: %w", sha, err)
+	}
+
+	//Header is "<type> <size>\0"
+	nullIndex := bytes.IndexByte(contents, 0)
+	spaceIndex := bytes.IndexByte(contents, ' ')
+	if nullIndex < 0 || spaceIndex < 0 || spaceIndex > nullIndex {
+		return "", nil, fmt.Errorf("object %s: malformed header", sha)
+	}
+	objType := string(contents[:spaceIndex])
+	size, err := strconv.Atoi(string(contents[spaceIndex+1 : nullIndex]))
+	if err != nil {
+		return "", nil, fmt.Errorf("object %s: malformed size", sha)
+	}
+	payload := contents[nullIndex+1:]
+	if size != len(payload) {
+		return "", nil, fmt.Errorf("object %s: size mismatch", sha)
+	}
+	return objType, payload, nil
+}
+
+// looseObjects lists the SHAs of every loose object, sorted
+func looseObjects() ([]string, error) {
+	dirs, err := os.ReadDir(path.Join(".git", "objects"))
+	if err != nil {
+		return nil, err
+	}
+	var shas []string
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 || !isHex(dir.Name()) {
+			continue
+		}
+		files, err := os.ReadDir(path.Join(".git", "objects", dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if len(file.Name()) == 38 && isHex(file.Name()) {
+				shas = append(shas, dir.Name()+file.Name())
+			}
+		}
+	}
+	return shas, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEmptyTree(t *testing.T) {
+	if got := hashObject("tree", nil); emptyTreeSHA != "4b825dc642cb6eb9a060e54bf8d69288fbee4904" || got != emptyTreeSHA {
+		t.Fatalf("emptyTreeSHA is %s, and an empty tree hashes to %s", emptyTreeSHA, got)
+	}
+
+	initTestRepo(t)
+	head := testCommit(t, 0, "first", map[string]string{"a": "one\n"})
+	if err := updateRef("refs/heads/master", head, ""); err != nil {
+		t.Fatal(err)
+	}
+	if objectExists(emptyTreeSHA) {
+		t.Fatal("the empty tree was written; the test needs a repository without it")
+	}
+
+	addedA := "diff --git a/a b/a\nnew file mode 100644\nindex 0000000..5626abf\n--- /dev/null\n+++ b/a\n@@ -0,0 +1 @@\n+one\n"
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"cat-file", "-t", emptyTreeSHA}, "tree\n"},
+		{[]string{"cat-file", "-s", emptyTreeSHA}, "0\n"},
+		{[]string{"ls-tree", emptyTreeSHA}, ""},
+		{[]string{"diff-tree", "-r", emptyTreeSHA, "HEAD"}, ":000000 100644 " + zeroSHA + " 5626abf0f72e58d7a153368ba57db4c673c0e171 A\ta\n"},
+		{
+			[]string{"diff-tree", "-p", "HEAD", emptyTreeSHA},
+			"diff --git a/a b/a\ndeleted file mode 100644\nindex 5626abf..0000000\n--- a/a\n+++ /dev/null\n@@ -1 +0,0 @@\n-one\n",
+		},
+		{[]string{"diff", emptyTreeSHA, "HEAD"}, addedA},
+	}
+	for _, test := range tests {
+		t.Run(strings.Join(test.args, " "), func(t *testing.T) {
+			if got := runTestCommand(t, test.args...); got != test.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHashObjectChecksFormat(t *testing.T) {
+	initTestRepo(t)
+	tree := "100644 a\x00" + strings.Repeat("\x01", 20)
+	commit := "tree " + emptyTreeSHA + "\nauthor A <a> 0 +0000\ncommitter A <a> 0 +0000\n\nm\n"
+	tests := []struct {
+		objType  string
+		contents string
+		want     string //the error, or "" when it hashes
+	}{
+		{"tree", "", ""},
+		{"tree", tree, ""},
+		{"tree", "abc", "fatal: too-short tree object\n"},
+		{"tree", "10x644 a\x00" + strings.Repeat("\x01", 20), "fatal: malformed mode in tree entry\n"},
+		{"tree", "100644 \x00" + strings.Repeat("\x01", 20), "fatal: empty filename in tree entry\n"},
+		{"commit", commit, ""},
+		{"commit", "garbage\n", "error: bogus commit object " + zeroSHA + "\nfatal: corrupt commit\n"},
+		{"tag", "object " + emptyTreeSHA + "\ntype tree\ntag v1\n", ""},
+		{"tag", "object " + emptyTreeSHA + "\ntype tree\n", "fatal: corrupt tag\n"},
+	}
+	for i, test := range tests {
+		t.Run(test.objType+" "+test.want, func(t *testing.T) {
+			file := fmt.Sprintf("payload%d", i)
+			writeTestFile(t, file, test.contents)
+			stdout, stderr, code := runMygit(t, "hash-object", "-t", test.objType, "-w", file)
+			if test.want != "" {
+				if code != 128 || stderr != test.want || stdout != "" {
+					t.Errorf("exit %d, %q, %q; want 128 and %q", code, stdout, stderr, test.want)
+				}
+				return
+			}
+			if want := hashObject(test.objType, []byte(test.contents)) + "\n"; code != 0 || stdout != want {
+				t.Fatalf("exit %d, %q, %q; want %s", code, stdout, stderr, want)
+			}
+			if objType, payload, err := parseObject(strings.TrimSpace(stdout)); err != nil || objType != test.objType || string(payload) != test.contents {
+				t.Errorf("stored as %s %q (%v)", objType, payload, err)
+			}
+		})
+	}
+
+	// --literally hashes what it is given, whatever the type
+	writeTestFile(t, "junk", "abc")
+	for _, objType := range []string{"tree", "bogus"} {
+		if out := runTestCommand(t, "hash-object", "-t", objType, "--literally", "junk"); out != hashObject(objType, []byte("abc"))+"\n" {
+			t.Errorf("hash-object -t %s --literally printed %q", objType, out)
+		}
+	}
+}
+
+func TestParseObject(t *testing.T) {
+	initTestRepo(t)
+	head := testCommit(t, 0, "first", map[string]string{"a": "one\n"})
+	tree, err := commitTree(head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := hashObject("blob", []byte("one\n"))
+	for sha, want := range map[string]string{blob: "blob", tree: "tree", head: "commit"} {
+		objType, payload, err := parseObject(sha)
+		if err != nil || objType != want || hashObject(objType, payload) != sha {
+			t.Errorf("parseObject(%s) = %s, %q, %v; want the %s back", sha, objType, payload, err, want)
+		}
+	}
+
+	// loose objects that are damaged, under made-up SHAs
+	tests := []struct {
+		stored string
+		err    string
+	}{
+		{"blob 4 one\n", "malformed header"},
+		{"blob4\x00one\n", "malformed header"},
+		{"blob x\x00one\n", "malformed size"},
+		{"blob 5\x00one\n", "size mismatch"},
+	}
+	for i, test := range tests {
+		sha := strings.Repeat(fmt.Sprint(i+1), 40)
+		var compressed bytes.Buffer
+		w := zlib.NewWriter(&compressed)
+		w.Write([]byte(test.stored))
+		w.Close()
+		if err := os.MkdirAll(filepath.Dir(objectPath(sha)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(objectPath(sha), compressed.Bytes(), 0444); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := parseObject(sha); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("parseObject of %q: %v, want %s", test.stored, err, test.err)
+		}
+		// the commands that read objects report it rather than showing anything
+		if stdout, _, code := runMygit(t, "cat-file", "-p", sha); code == 0 || stdout != "" {
+			t.Errorf("cat-file -p of %q: exit %d, %q", test.stored, code, stdout)
+		}
+	}
+	if _, _, err := parseObject("not a sha"); err == nil {
+		t.Error("parseObject took a malformed SHA")
+	}
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+/*
+A pack is "PACK", a version, an object count, the objects, then a SHA-1 of all of it. Each
+object starts with its type and inflated size packed into a varint, followed by the zlib
+stream. Deltas name their base either by offset back into the pack (ofs-delta) or by SHA
+(ref-delta).
+*/
+
+const (
+	objCommit   = 1
+	objTree     = 2
+	objBlob     = 3
+	objTag      = 4
+	objOfsDelta = 6
+	objRefDelta = 7
+)
+
+var packTypeNames = map[int]string{objCommit: "commit", objTree: "tree", objBlob: "blob", objTag: "tag"}
+
+// countingReader tracks the offset into the pack and hashes everything read. It is also an
+// io.ByteReader, so zlib stops exactly at the end of each compressed object.
+type countingReader struct {
+	r    *bufio.Reader
+	n    int64
+	hash hash.Hash
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	c.hash.Write(p[:n])
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+		c.hash.Write([]byte{b})
+	}
+	return b, err
+}
+
+func inflate(r io.Reader, size int64) ([]byte, error) {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != size {
+		return nil, fmt.Errorf("inflated size mismatch")
+	}
+	return data, nil
+}
+
+type pendingDelta struct {
+	offset int64
+	base   string
+	delta  []byte
+}
+
+// unpackObjects reads a pack stream and writes every object in it as a loose object,
+// returning the number of objects unpacked
+func unpackObjects(r io.Reader) (int, error) {
+	cr := &countingReader{r: bufio.NewReader(r), hash: sha1.New()}
+	var header [12]byte
+	if _, err := io.ReadFull(cr, header[:]); err != nil {
+		return 0, err
+	}
+	if string(header[:4]) != "PACK" {
+		return 0, fmt.Errorf("not a pack stream")
+	}
+	if version := binary.BigEndian.Uint32(header[4:8]); version != 2 && version != 3 {
+		return 0, fmt.Errorf("pack version %d not supported", version)
+	}
+	count := int(binary.BigEndian.Uint32(header[8:12]))
+
+	progress := newProgress("Receiving objects", count)
+	written := map[int64]string{} //offset -> SHA, for ofs-delta bases
+	var pending []pendingDelta
+	for i := 0; i < count; i++ {
+		offset := cr.n
+		objType, size, err := readPackObjectHeader(cr)
+		if err != nil {
+			return 0, err
+		}
+
+		switch objType {
+		case objCommit, objTree, objBlob, objTag:
+			data, err := inflate(cr, size)
+			if err != nil {
+				return 0, err
+			}
+			sha, err := writeObject(packTypeNames[objType], data)
+			if err != nil {
+				return 0, err
+			}
+			written[offset] = fmt.Sprintf("%x", sha)
+
+		case objOfsDelta, objRefDelta:
+			var base string
+			if objType == objOfsDelta {
+				distance, err := readOfsDeltaDistance(cr)
+				if err != nil {
+					return 0, err
+				}
+				base = written[offset-distance]
+			} else {
+				var rawSha [20]byte
+				if _, err := io.ReadFull(cr, rawSha[:]); err != nil {
+					return 0, err
+				}
+				base = fmt.Sprintf("%x", rawSha)
+			}
+			delta, err := inflate(cr, size)
+			if err != nil {
+				return 0, err
+			}
+			sha, err := writeDeltaObject(base, delta)
+			if err != nil {
+				// base comes later in the pack; try again at the end
+				pending = append(pending, pendingDelta{offset, base, delta})
+				continue
+			}
+			written[offset] = sha
+
+		default:
+			return 0, fmt.Errorf("unknown pack object type %d", objType)
+		}
+		progress.update(i+1, cr.n)
+	}
+	progress.done(count, cr.n)
+
+	checksum := cr.hash.Sum(nil)
+	var trailer [20]byte
+	if _, err := io.ReadFull(cr.r, trailer[:]); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(checksum, trailer[:]) {
+		return 0, fmt.Errorf("pack checksum mismatch")
+	}
+
+	for len(pending) > 0 {
+		var remaining []pendingDelta
+		for _, p := range pending {
+			sha, err := writeDeltaObject(p.base, p.delta)
+			if err != nil {
+				remaining = append(remaining, p)
+				continue
+			}
+			written[p.offset] = sha
+		}
+		if len(remaining) == len(pending) {
+			return 0, fmt.Errorf("pack has %d deltas with missing bases", len(remaining))
+		}
+		pending = remaining
+	}
+	return count, nil
+}
+
+// storePack keeps a pack stream whole in .git/objects/pack, with an index written for it,
+// instead of unpacking it into loose objects. A promisor pack, fetched from the remote a
+// partial clone can fetch missing objects from, also gets a .promisor file: it tells git
+// fsck and gc that objects the pack refers to but leaves out are owed by that remote, not
+// lost. It returns the number of objects in the pack.
+func storePack(r io.Reader, promisor bool) (int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < 32 || string(data[:4]) != "PACK" {
+		return 0, fmt.Errorf("not a pack stream")
+	}
+	if version := binary.BigEndian.Uint32(data[4:8]); version != 2 && version != 3 {
+		return 0, fmt.Errorf("pack version %d not supported", version)
+	}
+	checksum := sha1.Sum(data[:len(data)-20])
+	if !bytes.Equal(checksum[:], data[len(data)-20:]) {
+		return 0, fmt.Errorf("pack checksum mismatch")
+	}
+	objects, err := indexPackData(data)
+	if err != nil {
+		return 0, err
+	}
+
+	// the index goes last, so the pack is only looked in once it is all there
+	base := filepath.Join(".git", "objects", "pack", fmt.Sprintf("pack-%x", checksum))
+	if err := os.MkdirAll(filepath.Dir(base), 0755); err != nil {
+		return 0, err
+	}
+	if err := writeFileAtomic(base+".pack", data, 0444); err != nil {
+		return 0, err
+	}
+	if promisor {
+		if err := os.WriteFile(base+".promisor", nil, 0644); err != nil {
+			return 0, err
+		}
+	}
+	if err := writePackIndex(base+".idx", objects, checksum); err != nil {
+		return 0, err
+	}
+	return len(objects), nil
+}
+
+// indexedObject is an object found reading through a pack, and, for a delta, what its base is
+type indexedObject struct {
+	sha     string
+	offset  int64
+	crc     uint32
+	objType int
+	size    int64
+	start   int64 //where the zlib stream starts
+	base    int64 //the base's offset, for an ofs-delta
+	baseSHA string
+}
+
+// indexPackData finds the SHA, offset and CRC of each object in a pack, rebuilding deltas from
+// their bases, which must be in the pack too
+func indexPackData(data []byte) ([]indexedObject, error) {
+	count := int(binary.BigEndian.Uint32(data[8:12]))
+	progress := newProgress("Receiving objects", count)
+	objects := make([]indexedObject, count)
+	byOffset := map[int64]int{}
+	offset := int64(12)
+	for i := range objects {
+		if offset >= int64(len(data)-20) {
+			return nil, fmt.Errorf("pack is truncated")
+		}
+		r := bytes.NewReader(data[offset : len(data)-20])
+		objType, size, err := readPackObjectHeader(r)
+		if err != nil {
+			return nil, err
+		}
+		object := indexedObject{offset: offset, objType: objType, size: size}
+		switch objType {
+		case objCommit, objTree, objBlob, objTag:
+		case objOfsDelta:
+			distance, err := readOfsDeltaDistance(r)
+			if err != nil {
+				return nil, err
+			}
+			object.base = offset - distance
+		case objRefDelta:
+			var rawSha [20]byte
+			if _, err := io.ReadFull(r, rawSha[:]); err != nil {
+				return nil, err
+			}
+			object.baseSHA = fmt.Sprintf("%x", rawSha)
+		default:
+			return nil, fmt.Errorf("unknown pack object type %d", objType)
+		}
+		object.start = int64(len(data)-20) - int64(r.Len())
+		contents, err := inflate(r, size)
+		if err != nil {
+			return nil, err
+		}
+		if name := packTypeNames[objType]; name != "" {
+			object.sha = hashObject(name, contents)
+		}
+		end := int64(len(data)-20) - int64(r.Len())
+		object.crc = crc32.ChecksumIEEE(data[offset:end])
+		objects[i] = object
+		byOffset[offset] = i
+		offset = end
+		progress.update(i+1, offset)
+	}
+	progress.done(count, offset)
+	if offset != int64(len(data)-20) {
+		return nil, fmt.Errorf("pack has junk at the end")
+	}
+
+	// a delta's SHA needs its base's contents, and a ref-delta's base is only found once its
+	// SHA is known, so deltas are resolved over as many rounds as the chains are long
+	bySHA := map[string]int{}
+	for i, object := range objects {
+		if object.sha != "" {
+			bySHA[object.sha] = i
+		}
+	}
+	cache := map[int]packedObject{}
+	var contentsOf func(i int, depth int) (packedObject, error)
+	contentsOf = func(i int, depth int) (packedObject, error) {
+		if cached, ok := cache[i]; ok {
+			return cached, nil
+		}
+		object := objects[i]
+		contents, err := inflate(bytes.NewReader(data[object.start:len(data)-20]), object.size)
+		if err != nil {
+			return packedObject{}, err
+		}
+		if name := packTypeNames[object.objType]; name != "" {
+			return packedObject{name, contents}, nil
+		}
+		b, ok := byOffset[object.base]
+		if object.objType == objRefDelta {
+			b, ok = bySHA[object.baseSHA]
+		}
+		if !ok || depth > len(objects) {
+			return packedObject{}, errMissingDeltaBase
+		}
+		base, err := contentsOf(b, depth+1)
+		if err != nil {
+			return packedObject{}, err
+		}
+		result, err := applyDelta(base.data, contents)
+		if err != nil {
+			return packedObject{}, err
+		}
+		if len(cache) >= maxDeltaBases {
+			cache = map[int]packedObject{}
+		}
+		cache[i] = packedObject{base.objType, result}
+		return cache[i], nil
+	}
+	for {
+		missing, resolved := 0, 0
+		for i := range objects {
+			if objects[i].sha != "" {
+				continue
+			}
+			object, err := contentsOf(i, 0)
+			if err == errMissingDeltaBase {
+				missing++
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			objects[i].sha = hashObject(object.objType, object.data)
+			bySHA[objects[i].sha] = i
+			resolved++
+		}
+		if missing == 0 {
+			return objects, nil
+		}
+		if resolved == 0 {
+			return nil, fmt.Errorf("pack has %d deltas with missing bases", missing)
+		}
+	}
+}
+
+var errMissingDeltaBase = errors.New("delta base not found")
+
+func readPackObjectHeader(r io.ByteReader) (int, int64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	objType := int(b>>4) & 0x7
+	size := int64(b & 0x0f)
+	shift := 4
+	for b&0x80 != 0 {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, 0, err
+		}
+		size |= int64(b&0x7f) << shift
+		shift += 7
+	}
+	return objType, size, nil
+}
+
+// readOfsDeltaDistance reads the offset encoding ofs-delta uses, where each continuation
+// byte also adds one so that there is only one encoding per value
+func readOfsDeltaDistance(r io.ByteReader) (int64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	distance := int64(b & 0x7f)
+	for b&0x80 != 0 {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+		distance = ((distance + 1) << 7) | int64(b&0x7f)
+	}
+	return distance, nil
+}
+
+func writeDeltaObject(base string, delta []byte) (string, error) {
+	if base == "" {
+		return "", fmt.Errorf("delta base not found")
+	}
+	baseType, baseData, err := parseObject(base)
+	if err != nil {
+		return "", err
+	}
+	data, err := applyDelta(baseData, delta)
+	if err != nil {
+		return "", err
+	}
+	sha, err := writeObject(baseType, data)
+	return fmt.Sprintf("%x", sha), err
+}
+
+func applyDelta(base []byte, delta []byte) ([]byte, error) {
+	r := bytes.NewReader(delta)
+	readSize := func() (int64, error) {
+		var size int64
+		for shift := 0; ; shift += 7 {
+			b, err := r.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			size |= int64(b&0x7f) << shift
+			if b&0x80 == 0 {
+				return size, nil
+			}
+		}
+	}
+	baseSize, err := readSize()
+	if err != nil || baseSize != int64(len(base)) {
+		return nil, fmt.Errorf("delta base size mismatch")
+	}
+	resultSize, err := readSize()
+	if err != nil {
+		return nil, fmt.Errorf("corrupt delta")
+	}
+
+	result := make([]byte, 0, resultSize)
+	for r.Len() > 0 {
+		op, _ := r.ReadByte()
+		if op&0x80 == 0 {
+			// insert the next op bytes
+			if op == 0 || int(op) > r.Len() {
+				return nil, fmt.Errorf("corrupt delta")
+			}
+			insert := make([]byte, op)
+			r.Read(insert)
+			result = append(result, insert...)
+			continue
+		}
+		// copy from base; the low bits say which offset/size bytes follow
+		var offset, size int64
+		for i := 0; i < 4; i++ {
+			if op&(1<<i) != 0 {
+				b, err := r.ReadByte()
+				if err != nil {
+					return nil, fmt.Errorf("corrupt delta")
+				}
+				offset |= int64(b) << (8 * i)
+			}
+		}
+		for i := 0; i < 3; i++ {
+			if op&(0x10<<i) != 0 {
+				b, err := r.ReadByte()
+				if err != nil {
+					return nil, fmt.Errorf("corrupt delta")
+				}
+				size |= int64(b) << (8 * i)
+			}
+		}
+		if size == 0 {
+			size = 0x10000
+		}
+		if offset+size > int64(len(base)) {
+			return nil, fmt.Errorf("corrupt delta")
+		}
+		result = append(result, base[offset:offset+size]...)
+	}
+	if int64(len(result)) != resultSize {
+		return nil, fmt.Errorf("delta result size mismatch")
+	}
+	return result, nil
+}
+
+var packTypeNumbers = map[string]int{"commit": objCommit, "tree": objTree, "blob": objBlob, "tag": objTag}
+
+// writePack writes the given objects as an undeltified pack
+func writePack(w io.Writer, shas []string) error {
+	checksum := sha1.New()
+	out := io.MultiWriter(w, checksum)
+
+	var header [12]byte
+	copy(header[:4], "PACK")
+	binary.BigEndian.PutUint32(header[4:8], 2)
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(shas)))
+	if _, err := out.Write(header[:]); err != nil {
+		return err
+	}
+
+	for _, sha := range shas {
+		objType, data, err := parseObject(sha)
+		if err != nil {
+			return err
+		}
+		// type and size varint: 3 type bits and 4 size bits first, then 7 size bits per byte
+		size := len(data)
+		b := byte(packTypeNumbers[objType]<<4) | byte(size&0x0f)
+		size >>= 4
+		var typeAndSize []byte
+		for size > 0 {
+			typeAndSize = append(typeAndSize, b|0x80)
+			b = byte(size & 0x7f)
+			size >>= 7
+		}
+		typeAndSize = append(typeAndSize, b)
+		if _, err := out.Write(typeAndSize); err != nil {
+			return err
+		}
+
+		zw := zlib.NewWriter(out)
+		if _, err := zw.Write(data); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(checksum.Sum(nil))
+	return err
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+/*
+A pack is redundant when the objects it holds are all in other packs that are kept. Finding the
+fewest packs that hold everything is a set cover problem, so this picks them the way git does:
+first every pack holding an object no other pack has, then, while objects are left over, the
+pack holding the most of them. Whatever wasn't picked is redundant.
+*/
+
+type redundantPack struct {
+	indexFile string
+	objects   map[string]bool
+	remaining map[string]bool //objects still to be accounted for when choosing packs
+	size      int64           //of the pack and its index
+}
+
+func loadRedundantPack(indexFile string) (*redundantPack, error) {
+	idx, err := readPackIndex(indexFile)
+	if err != nil {
+		return nil, err
+	}
+	p := &redundantPack{indexFile: indexFile, objects: map[string]bool{}, remaining: map[string]bool{}}
+	for _, sha := range idx.shas {
+		p.objects[sha] = true
+		p.remaining[sha] = true
+	}
+	for _, filename := range []string{indexFile, packFileFor(indexFile)} {
+		if fi, err := os.Stat(filename); err == nil {
+			p.size += fi.Size()
+		}
+	}
+	return p, nil
+}
+
+// minimalPacks chooses packs that between them hold every object in packs, and returns them
+func minimalPacks(packs []*redundantPack) []*redundantPack {
+	var chosen, rest []*redundantPack
+	for _, p := range packs {
+		unique := false
+		for sha := range p.remaining {
+			if !inOtherPack(sha, p, packs) {
+				unique = true
+				break
+			}
+		}
+		if unique {
+			chosen = append(chosen, p)
+		} else {
+			rest = append(rest, p)
+		}
+	}
+
+	covered := map[string]bool{}
+	for _, p := range chosen {
+		for sha := range p.remaining {
+			covered[sha] = true
+		}
+	}
+	for _, p := range rest {
+		for sha := range covered {
+			delete(p.remaining, sha)
+		}
+	}
+	for len(rest) > 0 {
+		sort.SliceStable(rest, func(i, j int) bool { return len(rest[i].remaining) > len(rest[j].remaining) })
+		best := rest[0]
+		if len(best.remaining) == 0 {
+			break
+		}
+		chosen = append(chosen, best)
+		rest = rest[1:]
+		for _, p := range rest {
+			for sha := range best.remaining {
+				delete(p.remaining, sha)
+			}
+		}
+	}
+	return chosen
+}
+
+func inOtherPack(sha string, p *redundantPack, packs []*redundantPack) bool {
+	for _, other := range packs {
+		if other != p && other.remaining[sha] {
+			return true
+		}
+	}
+	return false
+}
+
+// Usage: mygit pack-redundant [--verbose] [--alt-odb] (--all | <pack-filename>...)
+//
+// Prints the packs, with their indexes, that can be deleted because other packs hold all of
+// their objects, ready for "xargs rm -f". Only the packs named are considered, or every pack
+// with --all; loose objects never are. Objects listed on stdin, when it isn't a terminal, are ignored: a pack holding
+// only those is redundant too. --verbose describes the packs kept on stderr.
+func cmdPackRedundant(args []string) {
+	usage := "usage: mygit pack-redundant [--verbose] [--alt-odb] (--all | <pack-filename>...)\n"
+	all, verbose := false, false
+	var names []string
+	for i, arg := range args {
+		switch {
+		case arg == "--all":
+			all = true
+		case arg == "--verbose":
+			verbose = true
+		case arg == "--alt-odb":
+			//there are no alternate object stores to look in
+		case arg == "--":
+			names = append(names, args[i+1:]...)
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(129)
+		default:
+			names = append(names, arg)
+		}
+		if arg == "--" {
+			break
+		}
+	}
+	fatal := func(err error) {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+
+	indexFiles, err := packIndexFiles()
+	if err != nil {
+		fatal(err)
+	}
+	if !all {
+		var named []string
+		for _, name := range names {
+			if len(name) < 40 {
+				fatal(fmt.Errorf("Bad pack filename: %s", name))
+			}
+			found := ""
+			for _, indexFile := range indexFiles {
+				if strings.Contains(packFileFor(indexFile), name) {
+					found = indexFile
+					break
+				}
+			}
+			if found == "" {
+				fatal(fmt.Errorf("Filename %s not found in packed_git", name))
+			}
+			named = append(named, found)
+		}
+		indexFiles = named
+	}
+	var packs []*redundantPack
+	seen := map[string]bool{}
+	for _, indexFile := range indexFiles {
+		if seen[indexFile] {
+			continue
+		}
+		seen[indexFile] = true
+		p, err := loadRedundantPack(indexFile)
+		if err != nil {
+			fatal(err)
+		}
+		packs = append(packs, p)
+	}
+	if len(packs) == 0 {
+		fatal(fmt.Errorf("Zero packs found!"))
+	}
+
+	objects := map[string]bool{}
+	for _, p := range packs {
+		for sha := range p.objects {
+			objects[sha] = true
+		}
+	}
+	if !isTerminal(os.Stdin) {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			line := strings.ToLower(scanner.Text())
+			if len(line) < 40 || !isHexSHA(line[:40]) {
+				fatal(fmt.Errorf("Bad object ID on stdin: %s", scanner.Text()))
+			}
+			sha := line[:40]
+			delete(objects, sha)
+			for _, p := range packs {
+				delete(p.remaining, sha)
+			}
+		}
+	}
+
+	chosen := minimalPacks(packs)
+	kept := map[*redundantPack]bool{}
+	for _, p := range chosen {
+		kept[p] = true
+	}
+	if verbose {
+		duplicates, size := 0, int64(0)
+		for i, p := range chosen {
+			size += p.size
+			for _, other := range chosen[i+1:] {
+				for sha := range p.objects {
+					if other.objects[sha] {
+						duplicates++
+					}
+				}
+			}
+		}
+		fmt.Fprintln(os.Stderr, "There are 0 packs available in alt-odbs.")
+		fmt.Fprintln(os.Stderr, "The smallest (bytewise) set of packs is:")
+		for _, p := range chosen {
+			fmt.Fprintf(os.Stderr, "\t%s\n", packFileFor(p.indexFile))
+		}
+		fmt.Fprintf(os.Stderr, "containing %d duplicate objects with a total size of %dkb.\n", duplicates, size/1024)
+		fmt.Fprintf(os.Stderr, "A total of %d unique objects were considered.\n", len(objects))
+		fmt.Fprintln(os.Stderr, "Redundant packs (with indexes):")
+	}
+	redundantSize := int64(0)
+	for _, p := range packs {
+		if !kept[p] {
+			redundantSize += p.size
+			fmt.Printf("%s\n%s\n", p.indexFile, packFileFor(p.indexFile))
+		}
+	}
+	if verbose {
+		fmt.Fprintf(os.Stderr, "%dMB of redundant packs in total.\n", redundantSize/(1024*1024))
+	}
+}
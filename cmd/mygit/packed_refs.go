@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+/*
+.git/packed-refs holds refs that aren't worth a file each, typically written by clone or gc:
+
+	# pack-refs with: peeled fully-peeled sorted
+	<sha> refs/heads/main
+	<sha> refs/tags/v1.0
+	^<sha the tag peels to>
+
+A loose ref file overrides a packed entry with the same name.
+*/
+
+var packedRefsPath = path.Join(".git", "packed-refs")
+
+type packedRef struct {
+	name   string
+	sha    string
+	peeled string //for an annotated tag, what it points at; "" if unknown or not a tag
+}
+
+// readPackedRefs parses packed-refs, returning its header line (if any) and its refs in file
+// order. A missing file has no refs.
+func readPackedRefs() (string, []packedRef, error) {
+	contents, err := os.ReadFile(packedRefsPath)
+	if os.IsNotExist(err) {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	header := ""
+	var refs []packedRef
+	for _, line := range strings.Split(string(contents), "\n") {
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#"):
+			header = line
+		case strings.HasPrefix(line, "^"):
+			if len(refs) > 0 {
+				refs[len(refs)-1].peeled = line[1:]
+			}
+		default:
+			sha, name, ok := strings.Cut(line, " ")
+			if ok {
+				refs = append(refs, packedRef{name: name, sha: sha})
+			}
+		}
+	}
+	return header, refs, nil
+}
+
+// writePackedRefs replaces packed-refs atomically
+func writePackedRefs(header string, refs []packedRef) error {
+	var b strings.Builder
+	if header != "" {
+		b.WriteString(header + "\n")
+	}
+	for _, ref := range refs {
+		b.WriteString(ref.sha + " " + ref.name + "\n")
+		if ref.peeled != "" {
+			b.WriteString("^" + ref.peeled + "\n")
+		}
+	}
+	return writeFileAtomic(packedRefsPath, []byte(b.String()), 0644)
+}
+
+// packedRefSHA looks a ref up in packed-refs
+func packedRefSHA(name string) (string, bool) {
+	_, refs, err := readPackedRefs()
+	if err != nil {
+		return "", false
+	}
+	for _, ref := range refs {
+		if ref.name == name {
+			return ref.sha, true
+		}
+	}
+	return "", false
+}
+
+// deletePackedRef removes a ref and its peeled line from packed-refs, reporting whether it was there
+func deletePackedRef(name string) (bool, error) {
+	header, refs, err := readPackedRefs()
+	if err != nil {
+		return false, err
+	}
+	var kept []packedRef
+	for _, ref := range refs {
+		if ref.name != name {
+			kept = append(kept, ref)
+		}
+	}
+	if len(kept) == len(refs) {
+		return false, nil
+	}
+	return true, writePackedRefs(header, kept)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+/*
+Objects that aren't loose are looked up in the packs in .git/objects/pack: the index of each
+gives the object's offset in its pack, where it is read like an object in a pack stream. A
+delta is rebuilt from its base, found by offset in the same pack for an ofs-delta, or by SHA
+anywhere in the object store for a ref-delta, which is itself read the same way, down the
+chain.
+*/
+
+// packIndexes are the indexes read so far, by .idx file
+var packIndexes = map[string]*packIndex{}
+
+// loadPackIndexes reads the indexes of packs that have appeared since the last call
+func loadPackIndexes() error {
+	files, err := packIndexFiles()
+	if err != nil {
+		return err
+	}
+	for _, indexFile := range files {
+		if packIndexes[indexFile] != nil {
+			continue
+		}
+		idx, err := readPackIndex(indexFile)
+		if err != nil {
+			return err
+		}
+		packIndexes[indexFile] = idx
+	}
+	return nil
+}
+
+// offset is where an object is in the pack, when the pack has it
+func (idx *packIndex) offset(sha string) (int64, bool) {
+	i := sort.SearchStrings(idx.shas, sha)
+	if i < len(idx.shas) && idx.shas[i] == sha {
+		return idx.offsets[i], true
+	}
+	return 0, false
+}
+
+// findPackedObject returns the pack holding an object and its offset there. The packs are
+// listed again when the object isn't in any known one, in case it was packed since.
+func findPackedObject(sha string) (string, int64, bool) {
+	for attempt := 0; attempt < 2; attempt++ {
+		if attempt > 0 || len(packIndexes) == 0 {
+			if err := loadPackIndexes(); err != nil {
+				return "", 0, false
+			}
+		}
+		for indexFile, idx := range packIndexes {
+			if offset, ok := idx.offset(sha); ok {
+				return packFileFor(indexFile), offset, true
+			}
+		}
+	}
+	return "", 0, false
+}
+
+// packedObjectsWithPrefix lists the packed objects whose SHA starts with prefix
+func packedObjectsWithPrefix(prefix string) []string {
+	if err := loadPackIndexes(); err != nil {
+		return nil
+	}
+	var shas []string
+	for _, idx := range packIndexes {
+		for i := sort.SearchStrings(idx.shas, prefix); i < len(idx.shas) && strings.HasPrefix(idx.shas[i], prefix); i++ {
+			shas = append(shas, idx.shas[i])
+		}
+	}
+	return shas
+}
+
+// deltaBases caches the objects deltas were last rebuilt from, by pack and offset, since
+// objects next to each other in history tend to share them
+var deltaBases = map[string]packedObject{}
+
+// maxDeltaBases bounds deltaBases; when it is full it starts over
+const maxDeltaBases = 256
+
+type packedObject struct {
+	objType string
+	data    []byte
+}
+
+// readPackObject reads the object at offset in a pack, rebuilding it if it is a delta
+func readPackObject(packFile string, offset int64) (string, []byte, error) {
+	f, err := os.Open(packFile)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return "", nil, err
+	}
+	r := bufio.NewReader(f)
+	objType, size, err := readPackObjectHeader(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: bad object header at offset %d: %w", packFile, offset, err)
+	}
+
+	var base packedObject
+	switch objType {
+	case objCommit, objTree, objBlob, objTag:
+		data, err := inflate(r, size)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: object at offset %d: %w", packFile, offset, err)
+		}
+		return packTypeNames[objType], data, nil
+
+	case objOfsDelta:
+		distance, err := readOfsDeltaDistance(r)
+		if err != nil {
+			return "", nil, err
+		}
+		if distance <= 0 || distance > offset {
+			return "", nil, fmt.Errorf("%s: delta at offset %d has a bad base offset", packFile, offset)
+		}
+		key := fmt.Sprintf("%s:%d", packFile, offset-distance)
+		var ok bool
+		if base, ok = deltaBases[key]; !ok {
+			if base.objType, base.data, err = readPackObject(packFile, offset-distance); err != nil {
+				return "", nil, err
+			}
+			if len(deltaBases) >= maxDeltaBases {
+				deltaBases = map[string]packedObject{}
+			}
+			deltaBases[key] = base
+		}
+
+	case objRefDelta:
+		var rawSha [20]byte
+		if _, err := io.ReadFull(r, rawSha[:]); err != nil {
+			return "", nil, err
+		}
+		if base.objType, base.data, err = parseObject(fmt.Sprintf("%x", rawSha)); err != nil {
+			return "", nil, err
+		}
+
+	default:
+		return "", nil, fmt.Errorf("%s: unknown object type %d at offset %d", packFile, objType, offset)
+	}
+
+	delta, err := inflate(r, size)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: object at offset %d: %w", packFile, offset, err)
+	}
+	data, err := applyDelta(base.data, delta)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: object at offset %d: %w", packFile, offset, err)
+	}
+	return base.objType, data, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+A pack index (.idx) lets objects in the matching .pack be found by SHA. Version 2 is:
+- "\377tOc" and the version
+- a 256 entry fanout table: entry i counts the objects whose first byte is <= i
+- the sorted SHAs, then a CRC32 and a 4 byte offset per object
+- 8 byte offsets for objects past 2GiB, referenced from offsets with the high bit set
+- the pack's checksum, then the index's own
+Version 1 has no header, and stores a 4 byte offset before each SHA instead.
+*/
+
+type packIndex struct {
+	version      int
+	shas         []string
+	offsets      []int64
+	crcs         []uint32 //version 2 only
+	packChecksum string
+}
+
+func readPackIndex(filename string) (*packIndex, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parsePackIndex(data, filename)
+}
+
+// parsePackIndex parses the contents of an index; filename is only for errors
+func parsePackIndex(data []byte, filename string) (*packIndex, error) {
+	if len(data) < 256*4+40 {
+		return nil, fmt.Errorf("%s: index file too small", filename)
+	}
+	checksum := sha1.Sum(data[:len(data)-20])
+	if !bytes.Equal(checksum[:], data[len(data)-20:]) {
+		return nil, fmt.Errorf("%s: index checksum mismatch", filename)
+	}
+
+	idx := &packIndex{version: 1}
+	body := data
+	if bytes.HasPrefix(data, []byte("\377tOc")) {
+		idx.version = int(binary.BigEndian.Uint32(data[4:8]))
+		if idx.version != 2 {
+			return nil, fmt.Errorf("%s: index version %d not supported", filename, idx.version)
+		}
+		body = data[8:]
+	}
+	count := int(binary.BigEndian.Uint32(body[255*4:]))
+	body = body[256*4:]
+	idx.shas = make([]string, count)
+	idx.offsets = make([]int64, count)
+
+	if idx.version == 1 {
+		if len(body) < count*24+40 {
+			return nil, fmt.Errorf("%s: index file truncated", filename)
+		}
+		for i := 0; i < count; i++ {
+			entry := body[i*24:]
+			idx.offsets[i] = int64(binary.BigEndian.Uint32(entry[:4]))
+			idx.shas[i] = hex.EncodeToString(entry[4:24])
+		}
+		idx.packChecksum = hex.EncodeToString(body[count*24 : count*24+20])
+		return idx, nil
+	}
+
+	if len(body) < count*28+40 {
+		return nil, fmt.Errorf("%s: index file truncated", filename)
+	}
+	names, crcs, offsets := body[:count*20], body[count*20:count*24], body[count*24:count*28]
+	large := body[count*28 : len(body)-40]
+	idx.crcs = make([]uint32, count)
+	for i := 0; i < count; i++ {
+		idx.shas[i] = hex.EncodeToString(names[i*20 : i*20+20])
+		idx.crcs[i] = binary.BigEndian.Uint32(crcs[i*4:])
+		offset := binary.BigEndian.Uint32(offsets[i*4:])
+		if offset&0x80000000 == 0 {
+			idx.offsets[i] = int64(offset)
+			continue
+		}
+		at := int(offset&0x7fffffff) * 8
+		if at+8 > len(large) {
+			return nil, fmt.Errorf("%s: bad large offset", filename)
+		}
+		idx.offsets[i] = int64(binary.BigEndian.Uint64(large[at:]))
+	}
+	idx.packChecksum = hex.EncodeToString(body[len(body)-40 : len(body)-20])
+	return idx, nil
+}
+
+// writePackIndex writes a version 2 index of a pack's objects, given the pack's checksum
+func writePackIndex(filename string, objects []indexedObject, packChecksum [20]byte) error {
+	sorted := append([]indexedObject(nil), objects...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].sha < sorted[j].sha })
+
+	var buf bytes.Buffer
+	buf.WriteString("\377tOc")
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+	var fanout [256]uint32
+	for _, object := range sorted {
+		first, err := hex.DecodeString(object.sha[:2])
+		if err != nil {
+			return err
+		}
+		for b := int(first[0]); b < 256; b++ {
+			fanout[b]++
+		}
+	}
+	binary.Write(&buf, binary.BigEndian, fanout)
+	for _, object := range sorted {
+		sha, err := hex.DecodeString(object.sha)
+		if err != nil {
+			return err
+		}
+		buf.Write(sha)
+	}
+	for _, object := range sorted {
+		binary.Write(&buf, binary.BigEndian, object.crc)
+	}
+	var large []uint64
+	for _, object := range sorted {
+		if object.offset < 0x80000000 {
+			binary.Write(&buf, binary.BigEndian, uint32(object.offset))
+			continue
+		}
+		binary.Write(&buf, binary.BigEndian, uint32(0x80000000|len(large)))
+		large = append(large, uint64(object.offset))
+	}
+	binary.Write(&buf, binary.BigEndian, large)
+	buf.Write(packChecksum[:])
+	checksum := sha1.Sum(buf.Bytes())
+	buf.Write(checksum[:])
+	return writeFileAtomic(filename, buf.Bytes(), 0444)
+}
+
+// contains reports whether the pack has an object, by binary search over the sorted SHAs
+func (idx *packIndex) contains(sha string) bool {
+	i := sort.SearchStrings(idx.shas, sha)
+	return i < len(idx.shas) && idx.shas[i] == sha
+}
+
+// packIndexFiles lists the .idx files in the object store
+func packIndexFiles() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(".git", "objects", "pack", "pack-*.idx"))
+	sort.Strings(files)
+	return files, err
+}
+
+// packFileFor is the .pack that goes with an .idx
+func packFileFor(indexFile string) string {
+	return strings.TrimSuffix(indexFile, ".idx") + ".pack"
+}
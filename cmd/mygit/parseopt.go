@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+/*
+rev-parse --parseopt lets a shell script parse its arguments the way git's own commands do. The
+script describes its options on stdin:
+
+	<usage line>
+	<more usage lines...>
+	--
+	<opt-spec><flags><arg-hint> <help>
+	...
+
+An opt-spec is a short name, a long name, or "s,long" for both. Flags follow it: "=" for an
+option taking a value, "?" for an optional one, "!" to refuse --no-<name> and "*" to leave it
+out of the help. A line starting with a space, or with no help at all, is a group header for the
+help. A second "--" ends the options, and the lines after it, describing the positional
+arguments, are shown at the end of the help.
+*/
+
+// parseoptOption is an option, or a group header, from a --parseopt spec
+type parseoptOption struct {
+	short    byte
+	long     string
+	takesArg bool
+	optional bool //the value is optional, and has to be stuck to the option
+	noNegate bool
+	hidden   bool
+	argHint  string
+	help     string
+	group    bool //a header in the help, with help as its text
+}
+
+// parseoptSpec is the usage and options read from a --parseopt spec
+type parseoptSpec struct {
+	usage      []string
+	options    []parseoptOption
+	positional []string
+}
+
+// readParseoptSpec reads a --parseopt spec
+func readParseoptSpec(r io.Reader) (*parseoptSpec, error) {
+	spec := &parseoptSpec{}
+	scanner := bufio.NewScanner(r)
+	for {
+		if !scanner.Scan() {
+			return nil, errors.New("premature end of input")
+		}
+		if scanner.Text() == "--" {
+			break
+		}
+		spec.usage = append(spec.usage, scanner.Text())
+	}
+	if len(spec.usage) == 0 {
+		return nil, errors.New("no usage string given before the `--' separator")
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "--" {
+			for scanner.Scan() {
+				spec.positional = append(spec.positional, scanner.Text())
+			}
+			break
+		}
+		if line == "" {
+			continue
+		}
+		space := strings.IndexAny(line, " \t")
+		if space <= 0 {
+			spec.options = append(spec.options, parseoptOption{group: true, help: strings.TrimLeft(line, " \t")})
+			continue
+		}
+		optSpec := line[:space]
+		opt := parseoptOption{help: strings.TrimLeft(line[space:], " \t")}
+		names := optSpec
+		flags := ""
+		if i := strings.IndexAny(optSpec, "=?!*"); i >= 0 {
+			names, flags = optSpec[:i], optSpec[i:]
+		}
+		switch {
+		case names == "":
+			return nil, errors.New("missing opt-spec before option flags")
+		case len(names) == 1:
+			opt.short = names[0]
+		case names[1] == ',':
+			opt.short, opt.long = names[0], names[2:]
+		default:
+			opt.long = names
+		}
+	flags:
+		for i := 0; i < len(flags); i++ {
+			switch flags[i] {
+			case '=':
+				opt.takesArg = true
+			case '?':
+				opt.takesArg, opt.optional = true, true
+			case '!':
+				opt.noNegate = true
+			case '*':
+				opt.hidden = true
+			default:
+				opt.argHint = flags[i:]
+				break flags
+			}
+		}
+		spec.options = append(spec.options, opt)
+	}
+	return spec, scanner.Err()
+}
+
+// usageText is the help for a spec: the usage lines, then a line per option
+func (spec *parseoptSpec) usageText() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "usage: %s\n", spec.usage[0])
+	usage := spec.usage[1:]
+	for len(usage) > 0 && usage[0] != "" {
+		fmt.Fprintf(&b, "   or: %s\n", usage[0])
+		usage = usage[1:]
+	}
+	for _, line := range usage {
+		if line != "" {
+			fmt.Fprintf(&b, "    %s", line)
+		}
+		b.WriteByte('\n')
+	}
+
+	needNewline := true
+	for _, opt := range spec.options {
+		if opt.group {
+			b.WriteByte('\n')
+			needNewline = false
+			if opt.help != "" {
+				fmt.Fprintf(&b, "%s\n", opt.help)
+			}
+			continue
+		}
+		if opt.hidden {
+			continue
+		}
+		if needNewline {
+			b.WriteByte('\n')
+			needNewline = false
+		}
+		names := "    "
+		if opt.short != 0 {
+			names += "-" + string(opt.short)
+			if opt.long != "" {
+				names += ", "
+			}
+		}
+		if opt.long != "" {
+			names += "--" + opt.long
+		}
+		if opt.takesArg {
+			names += opt.argUsage()
+		}
+		// the help lines up in a column, unless the names are too wide and it goes below them
+		b.WriteString(names)
+		if len(names) <= 24 {
+			b.WriteString(strings.Repeat(" ", 26-len(names)))
+		} else {
+			b.WriteString("\n" + strings.Repeat(" ", 26))
+		}
+		fmt.Fprintf(&b, "%s\n", opt.help)
+	}
+	if len(spec.positional) > 0 {
+		b.WriteByte('\n')
+		for _, line := range spec.positional {
+			fmt.Fprintf(&b, "    %s\n", strings.TrimLeft(line, " \t"))
+		}
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// argUsage shows an option's value in the help: the hint in angle brackets, unless it already
+// has brackets of its own, or "..." without one
+func (opt parseoptOption) argUsage() string {
+	hint := opt.argHint
+	if hint == "" {
+		hint = "..."
+	} else if !strings.ContainsAny(hint, "()<>[]|") {
+		hint = "<" + hint + ">"
+	}
+	switch {
+	case !opt.optional:
+		return " " + hint
+	case opt.long != "":
+		return "[=" + hint + "]"
+	}
+	return "[" + hint + "]"
+}
+
+// parsedOption is an option found in the arguments
+type parsedOption struct {
+	option   *parseoptOption
+	negated  bool
+	value    string
+	hasValue bool
+}
+
+func (p parsedOption) longName() string {
+	if p.negated {
+		return "no-" + p.option.long
+	}
+	return p.option.long
+}
+
+// shellWords is the option in its normalized form, with the value quoted for the shell. The
+// short name is used when there is one, and the long name with the value after "=" when stuck.
+func (p parsedOption) shellWords(stuckLong bool) string {
+	var word string
+	switch {
+	case p.negated:
+		word = "--" + p.longName()
+	case p.option.short != 0 && (p.option.long == "" || !stuckLong):
+		word = "-" + string(p.option.short)
+	default:
+		word = "--" + p.option.long
+	}
+	if !p.hasValue {
+		return word
+	}
+	switch {
+	case !stuckLong:
+		word += " "
+	case p.option.long != "":
+		word += "="
+	}
+	return word + shellQuote(p.value)
+}
+
+// shellQuote quotes s for the shell, in single quotes
+func shellQuote(s string) string {
+	s = strings.ReplaceAll(s, "'", `'\''`)
+	s = strings.ReplaceAll(s, "!", `'\!'`)
+	return "'" + s + "'"
+}
+
+// a parseoptError is a failure parsing the arguments, and what to show for it
+type parseoptError struct {
+	message string
+	kind    int
+}
+
+const (
+	parseoptHelp      = iota //-h or --help: the usage, and no message
+	parseoptUnknown          //the message, and the usage as for a mistake
+	parseoptAmbiguous        //the message, and the usage as for -h
+	parseoptBadValue         //just the message
+)
+
+// parse finds the options in args, returning them and the other arguments. Options and other
+// arguments can be mixed unless stopAtNonOption, and a "--" ends the options.
+func (spec *parseoptSpec) parse(args []string, keepDashdash bool, stopAtNonOption bool) ([]parsedOption, []string, *parseoptError) {
+	var parsed []parsedOption
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			if keepDashdash {
+				rest = append(rest, arg)
+			}
+			return parsed, append(rest, args[i+1:]...), nil
+		case arg == "-" || !strings.HasPrefix(arg, "-"):
+			if stopAtNonOption {
+				return parsed, append(rest, args[i:]...), nil
+			}
+			rest = append(rest, arg)
+		case arg == "--help", arg == "-h" && len(args) == 1:
+			return nil, nil, &parseoptError{kind: parseoptHelp}
+		case strings.HasPrefix(arg, "--"):
+			option, consumed, err := spec.parseLong(arg[2:], args[i+1:])
+			if err != nil {
+				return nil, nil, err
+			}
+			parsed = append(parsed, option)
+			i += consumed
+		default:
+			options, consumed, err := spec.parseShort(arg[1:], args[i+1:])
+			if err != nil {
+				return nil, nil, err
+			}
+			parsed = append(parsed, options...)
+			i += consumed
+		}
+	}
+	return parsed, rest, nil
+}
+
+// parseLong parses "--<arg>", which can be any unambiguous prefix of an option's long name or of
+// its --no- form, returning how many of the following arguments it took as its value
+func (spec *parseoptSpec) parseLong(arg string, next []string) (parsedOption, int, *parseoptError) {
+	name, value, hasValue := strings.Cut(arg, "=")
+	var abbrev, ambiguous *parsedOption
+	for i := range spec.options {
+		opt := &spec.options[i]
+		if opt.group || opt.long == "" {
+			continue
+		}
+		forms := []parsedOption{{option: opt}}
+		if !opt.noNegate {
+			forms = append(forms, parsedOption{option: opt, negated: true})
+		}
+		for _, form := range forms {
+			if form.longName() == name {
+				return form.withValue(value, hasValue, next, "option `"+form.longName()+"'")
+			}
+			if strings.HasPrefix(form.longName(), name) {
+				form := form
+				ambiguous, abbrev = abbrev, &form
+				break
+			}
+		}
+	}
+	switch {
+	case ambiguous != nil:
+		return parsedOption{}, 0, &parseoptError{kind: parseoptAmbiguous, message: fmt.Sprintf(
+			"ambiguous option: %s (could be --%s or --%s)", arg, ambiguous.longName(), abbrev.longName())}
+	case abbrev != nil:
+		return abbrev.withValue(value, hasValue, next, "option `"+abbrev.longName()+"'")
+	}
+	return parsedOption{}, 0, &parseoptError{kind: parseoptUnknown, message: fmt.Sprintf("unknown option `%s'", arg)}
+}
+
+// parseShort parses a cluster of short options such as "-abc". An option taking a value takes
+// the rest of the cluster, or the next argument when it is the last.
+func (spec *parseoptSpec) parseShort(cluster string, next []string) ([]parsedOption, int, *parseoptError) {
+	var parsed []parsedOption
+	for i := 0; i < len(cluster); i++ {
+		var opt *parseoptOption
+		for j := range spec.options {
+			if !spec.options[j].group && spec.options[j].short == cluster[i] {
+				opt = &spec.options[j]
+			}
+		}
+		if opt == nil {
+			if cluster[i] == 'h' {
+				return nil, 0, &parseoptError{kind: parseoptHelp}
+			}
+			return nil, 0, &parseoptError{kind: parseoptUnknown, message: fmt.Sprintf("unknown switch `%c'", cluster[i])}
+		}
+		option := parsedOption{option: opt}
+		if !opt.takesArg {
+			parsed = append(parsed, option)
+			continue
+		}
+		stuck := cluster[i+1:]
+		option, consumed, err := option.withValue(stuck, stuck != "", next, fmt.Sprintf("switch `%c'", opt.short))
+		if err != nil {
+			return nil, 0, err
+		}
+		return append(parsed, option), consumed, nil
+	}
+	return parsed, 0, nil
+}
+
+// withValue gives an option its value, from the option itself or else the next argument
+func (p parsedOption) withValue(value string, hasValue bool, next []string, name string) (parsedOption, int, *parseoptError) {
+	if p.negated || !p.option.takesArg {
+		if hasValue {
+			return p, 0, &parseoptError{kind: parseoptBadValue, message: name + " takes no value"}
+		}
+		return p, 0, nil
+	}
+	switch {
+	case hasValue:
+		p.value, p.hasValue = value, true
+	case p.option.optional:
+	case len(next) > 0:
+		p.value, p.hasValue = next[0], true
+		return p, 1, nil
+	default:
+		return p, 0, &parseoptError{kind: parseoptBadValue, message: name + " requires a value"}
+	}
+	return p, 0, nil
+}
+
+// revParseParseoptSpec is rev-parse --parseopt's own options
+var revParseParseoptSpec = &parseoptSpec{
+	usage: []string{"mygit rev-parse --parseopt [<options>] -- [<args>...]"},
+	options: []parseoptOption{
+		{long: "keep-dashdash", help: "keep the `--` passed as an arg"},
+		{long: "stop-at-non-option", help: "stop parsing after the first non-option argument"},
+		{long: "stuck-long", help: "output in stuck long form"},
+	},
+}
+
+// Usage: mygit rev-parse --parseopt [--keep-dashdash] [--stop-at-non-option] [--stuck-long] -- [<args>...]
+//
+// Parses a shell script's arguments against the option spec on stdin, printing them back
+// normalized as a "set -- <options> -- <args>" line for the script to eval. For -h, --help or an
+// ambiguous option it prints a command showing the usage instead, and exits with 129.
+func cmdRevParseParseopt(args []string) {
+	options, rest, perr := revParseParseoptSpec.parse(args, true, false)
+	if perr != nil || len(rest) == 0 || rest[0] != "--" {
+		if perr != nil && perr.message != "" {
+			fmt.Fprintf(os.Stderr, "error: %s\n", perr.message)
+		}
+		out := os.Stderr
+		if perr != nil && perr.kind == parseoptHelp {
+			out = os.Stdout
+		}
+		fmt.Fprint(out, revParseParseoptSpec.usageText())
+		os.Exit(129)
+	}
+	keepDashdash, stopAtNonOption, stuckLong := false, false, false
+	for _, option := range options {
+		switch option.option.long {
+		case "keep-dashdash":
+			keepDashdash = !option.negated
+		case "stop-at-non-option":
+			stopAtNonOption = !option.negated
+		case "stuck-long":
+			stuckLong = !option.negated
+		}
+	}
+
+	spec, err := readParseoptSpec(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	parsed, rest, perr := spec.parse(rest[1:], keepDashdash, stopAtNonOption)
+	if perr != nil {
+		if perr.message != "" {
+			fmt.Fprintf(os.Stderr, "error: %s\n", perr.message)
+		}
+		switch perr.kind {
+		case parseoptHelp, parseoptAmbiguous:
+			fmt.Printf("cat <<\\EOF\n%sEOF\n", spec.usageText())
+		case parseoptUnknown:
+			fmt.Fprint(os.Stderr, spec.usageText())
+		}
+		os.Exit(129)
+	}
+
+	var line strings.Builder
+	line.WriteString("set --")
+	for _, option := range parsed {
+		line.WriteString(" " + option.shellWords(stuckLong))
+	}
+	line.WriteString(" --")
+	for _, arg := range rest {
+		line.WriteString(" " + shellQuote(arg))
+	}
+	fmt.Println(line.String())
+}
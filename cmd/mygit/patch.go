@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+/*
+Patches are written the way git writes them:
+
+	diff --git a/<path> b/<path>
+	index <old sha>..<new sha> <mode>
+	--- a/<path>
+	+++ b/<path>
+	@@ -<old start>,<old count> +<new start>,<new count> @@ <enclosing function>
+	 context
+	-removed
+	+added
+
+with new files coming from /dev/null, deleted files going to it, and mode changes on lines of
+their own.
+*/
+
+const diffContext = 3 //lines of context around each change
+
+// diffSide is one version of a file in a diff; a nil *diffSide means the file doesn't exist
+type diffSide struct {
+	mode     uint32
+	sha      string
+	contents []byte
+}
+
+// diffOptions changes how a patch is computed and shown
+type diffOptions struct {
+	ignoreCRAtEOL    bool   //a CR before the newline doesn't make lines differ
+	ignoreSubmodules string //which submodule changes to leave out, as for --ignore-submodules
+	context          int    //lines of context around each change, when setContext
+	setContext       bool   //context was given, with -U; otherwise it is diffContext
+	wordRegex        *regexp.Regexp
+	colors           diffColors
+}
+
+// contextLines is how many lines of context a hunk has around its changes
+func (opts diffOptions) contextLines() int {
+	if opts.setContext {
+		return opts.context
+	}
+	return diffContext
+}
+
+// writePatch writes the git-style patch turning a into b
+func writePatch(w io.Writer, filePath string, a, b *diffSide, opts diffOptions) {
+	writePatchPaths(w, filePath, filePath, a, b, opts)
+}
+
+// writePatchPaths writes the patch turning a, at oldPath, into b, at newPath, as when two
+// blobs given by name are compared
+func writePatchPaths(w io.Writer, oldPath string, newPath string, a, b *diffSide, opts diffOptions) {
+	oldName, newName := "a/"+oldPath, "b/"+newPath
+	var header []string
+	var oldContents, newContents []byte
+	switch {
+	case a == nil:
+		header = append(header, fmt.Sprintf("new file mode %o", b.mode), fmt.Sprintf("index %s..%s", zeroSHA[:7], b.sha[:7]))
+		oldName, newContents = "/dev/null", b.contents
+	case b == nil:
+		header = append(header, fmt.Sprintf("deleted file mode %o", a.mode), fmt.Sprintf("index %s..%s", a.sha[:7], zeroSHA[:7]))
+		newName, oldContents = "/dev/null", a.contents
+	default:
+		if a.mode != b.mode {
+			header = append(header, fmt.Sprintf("old mode %o", a.mode), fmt.Sprintf("new mode %o", b.mode))
+		}
+		if a.sha != b.sha {
+			index := fmt.Sprintf("index %s..%s", a.sha[:7], b.sha[:7])
+			if a.mode == b.mode {
+				index += fmt.Sprintf(" %o", a.mode)
+			}
+			header = append(header, index)
+		}
+		if a.sha != b.sha || !bytes.Equal(a.contents, b.contents) { //a dirty submodule keeps its SHA
+			oldContents, newContents = a.contents, b.contents
+		}
+	}
+
+	binary := isBinary(oldContents) || isBinary(newContents)
+	var oldLines, newLines []string
+	var script []diffLine
+	if !binary {
+		oldLines, newLines = splitLines(oldContents), splitLines(newContents)
+		script = diffScript(oldLines, newLines, opts)
+		// a file whose only changes are being ignored isn't shown at all
+		if opts.ignoreCRAtEOL && a != nil && b != nil && a.mode == b.mode && !hasChanges(script) {
+			return
+		}
+	}
+
+	c := opts.colors
+	fmt.Fprintln(w, c.paint(c.meta, fmt.Sprintf("diff --git a/%s b/%s", oldPath, newPath)))
+	for _, line := range header {
+		fmt.Fprintln(w, c.paint(c.meta, line))
+	}
+	if len(oldContents) == 0 && len(newContents) == 0 {
+		return
+	}
+	if binary {
+		fmt.Fprintf(w, "Binary files %s and %s differ\n", oldName, newName)
+		return
+	}
+	fmt.Fprintln(w, c.paint(c.meta, "--- "+oldName))
+	fmt.Fprintln(w, c.paint(c.meta, "+++ "+newName))
+	var ws whitespaceRules
+	if c.enabled {
+		ws = pathWhitespaceRules(newPath) //only needed to highlight errors
+	}
+	writeHunks(w, oldLines, script, opts, ws)
+}
+
+// isBinary uses git's heuristic: a NUL in the first 8000 bytes
+func isBinary(contents []byte) bool {
+	if len(contents) > 8000 {
+		contents = contents[:8000]
+	}
+	return bytes.IndexByte(contents, 0) >= 0
+}
+
+// diffLine is one line of an edit script: ' ' kept, '-' removed from a, '+' added from b
+type diffLine struct {
+	kind byte
+	text string
+	a, b int //index of the line in a and b, or of the next line on the side it isn't in
+}
+
+// editScript turns the common lines of a and b into the full sequence of kept, removed and
+// added lines
+func editScript(a, b []string) []diffLine {
+	var script []diffLine
+	i, j := 0, 0
+	for _, m := range append(matchLines(a, b), lineMatch{len(a), len(b)}) {
+		for ; i < m.a; i++ {
+			script = append(script, diffLine{'-', a[i], i, j})
+		}
+		for ; j < m.b; j++ {
+			script = append(script, diffLine{'+', b[j], i, j})
+		}
+		if m.a < len(a) {
+			script = append(script, diffLine{' ', a[i], i, j})
+			i, j = i+1, j+1
+		}
+	}
+	return script
+}
+
+// diffScript is the edit script turning a into b, comparing lines as opts says to
+func diffScript(a, b []string, opts diffOptions) []diffLine {
+	if !opts.ignoreCRAtEOL {
+		return editScript(a, b)
+	}
+	stripCR := func(lines []string) []string {
+		stripped := make([]string, len(lines))
+		for i, line := range lines {
+			if body, ok := strings.CutSuffix(line, "\r\n"); ok {
+				line = body + "\n"
+			}
+			stripped[i] = line
+		}
+		return stripped
+	}
+	// the lines are shown as they are; kept lines as they are in b
+	script := editScript(stripCR(a), stripCR(b))
+	for i, line := range script {
+		if line.kind == '-' {
+			script[i].text = a[line.a]
+		} else {
+			script[i].text = b[line.b]
+		}
+	}
+	return script
+}
+
+func hasChanges(script []diffLine) bool {
+	for _, line := range script {
+		if line.kind != ' ' {
+			return true
+		}
+	}
+	return false
+}
+
+// writeHunks writes an edit script against a as unified diff hunks; changes separated by no
+// more than twice the context share a hunk. With a word regex, each hunk is written as a word
+// diff instead.
+func writeHunks(w io.Writer, a []string, script []diffLine, opts diffOptions, ws whitespaceRules) {
+	diffContext := opts.contextLines()
+	for start := 0; start < len(script); {
+		for start < len(script) && script[start].kind == ' ' {
+			start++
+		}
+		if start == len(script) {
+			return
+		}
+		// extend the hunk while the next change is close enough for the contexts to touch
+		end := start
+		for next := start; next < len(script); next++ {
+			if script[next].kind == ' ' {
+				continue
+			}
+			if next > end+2*diffContext {
+				break
+			}
+			end = next + 1
+		}
+		from := start - diffContext
+		if from < 0 {
+			from = 0
+		}
+		to := end + diffContext
+		if to > len(script) {
+			to = len(script)
+		}
+		if opts.wordRegex != nil {
+			writeWordHunk(w, a, script[from:to], opts.wordRegex, opts.colors)
+		} else {
+			writeHunk(w, a, script[from:to], opts.colors, ws)
+		}
+		start = to
+	}
+}
+
+func writeHunk(w io.Writer, a []string, hunk []diffLine, c diffColors, ws whitespaceRules) {
+	writeHunkHeader(w, a, hunk, c)
+	for _, line := range hunk {
+		text, newline := strings.CutSuffix(line.text, "\n")
+		switch line.kind {
+		case ' ':
+			fmt.Fprint(w, c.paintLine(c.context, " "+text))
+		case '-':
+			fmt.Fprint(w, c.paintLine(c.old, "-"+text))
+		case '+':
+			fmt.Fprint(w, c.addedLine(text, ws))
+		}
+		if !newline {
+			fmt.Fprint(w, "\n"+c.paint(c.context, "\\ No newline at end of file"))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// writeHunkHeader writes the "@@ -<old range> +<new range> @@ <function>" line starting a hunk
+func writeHunkHeader(w io.Writer, a []string, hunk []diffLine, c diffColors) {
+	oldCount, newCount := 0, 0
+	for _, line := range hunk {
+		if line.kind != '+' {
+			oldCount++
+		}
+		if line.kind != '-' {
+			newCount++
+		}
+	}
+	fmt.Fprint(w, c.paint(c.frag, fmt.Sprintf("@@ -%s +%s @@", hunkRange(hunk[0].a, oldCount), hunkRange(hunk[0].b, newCount))))
+	if name := functionName(a, hunk[0].a); name != "" {
+		fmt.Fprint(w, c.paint(c.context, " ")+c.paint(c.function, name))
+	}
+	fmt.Fprintln(w)
+}
+
+// hunkRange formats a hunk's start line and length; an empty range starts at the line before it
+func hunkRange(start int, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprint(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// functionName finds the line a hunk starting at line start of a is in, with git's default rule:
+// the nearest line above it that starts with a letter, "_" or "$"
+func functionName(a []string, start int) string {
+	for i := start - 1; i >= 0; i-- {
+		line := a[i]
+		if line == "" {
+			continue
+		}
+		c := line[0]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$' {
+			if len(line) > 80 {
+				line = line[:80]
+			}
+			return strings.TrimRight(line, " \t\r\n")
+		}
+	}
+	return ""
+}
+
+// diffColors holds the escape sequences a colored diff uses, from color.diff.<slot>. The zero
+// value paints nothing.
+type diffColors struct {
+	enabled                                             bool
+	meta, frag, function, context, old, new, whitespace string
+}
+
+// newDiffColors reads the diff color scheme
+func newDiffColors(enabled bool) diffColors {
+	if !enabled {
+		return diffColors{}
+	}
+	return diffColors{
+		enabled:    true,
+		meta:       configColor("color.diff.meta", "\x1b[1m"),
+		frag:       configColor("color.diff.frag", "\x1b[36m"),
+		function:   configColor("color.diff.func", ""),
+		context:    configColor("color.diff.context", ""),
+		old:        configColor("color.diff.old", "\x1b[31m"),
+		new:        configColor("color.diff.new", "\x1b[32m"),
+		whitespace: configColor("color.diff.whitespace", "\x1b[41m"),
+	}
+}
+
+// paint wraps text in color like git does, resetting after it even when color is ""
+func (c diffColors) paint(color string, text string) string {
+	if !c.enabled || text == "" {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// paintLine paints a line of a hunk, leaving a CR at its end outside the color like the newline
+func (c diffColors) paintLine(color string, text string) string {
+	if body, ok := strings.CutSuffix(text, "\r"); ok && c.enabled {
+		return c.paint(color, body) + "\r"
+	}
+	return c.paint(color, text)
+}
+
+// addedLine colors an added line, highlighting trailing whitespace as an error. A CR at the
+// end is part of the line ending rather than an error when cr-at-eol is in effect.
+func (c diffColors) addedLine(text string, ws whitespaceRules) string {
+	if !c.enabled {
+		return "+" + text
+	}
+	cr := ""
+	if ws["cr-at-eol"] && strings.HasSuffix(text, "\r") {
+		text, cr = text[:len(text)-1], "\r"
+	}
+	body, trailing := text, ""
+	if ws["blank-at-eol"] {
+		body = strings.TrimRight(text, " \t\r")
+		trailing = text[len(body):]
+	}
+	return c.paint(c.new, "+") + c.paint(c.new, body) + c.paint(c.whitespace, trailing) + cr
+}
+
+// whitespaceRules are the whitespace problems (git's core.whitespace rules, e.g.
+// "blank-at-eol") that are in effect for a file
+type whitespaceRules map[string]bool
+
+// pathWhitespaceRules reads core.whitespace, or the file's whitespace attribute, which takes
+// precedence: set for every rule, unset for none, or a list of rules like core.whitespace
+func pathWhitespaceRules(filePath string) whitespaceRules {
+	rules := whitespaceRules{"blank-at-eol": true, "space-before-tab": true, "blank-at-eof": true}
+	list, _ := configGet("core.whitespace")
+	switch attr := pathAttributes(filePath)["whitespace"]; attr {
+	case "":
+	case attrSet:
+		rules["indent-with-non-tab"] = true
+		return rules
+	case attrUnset:
+		return whitespaceRules{}
+	default:
+		list = attr
+	}
+	for _, rule := range strings.Split(list, ",") {
+		name, negate := strings.CutPrefix(strings.TrimSpace(rule), "-")
+		names := []string{name}
+		if name == "trailing-space" {
+			names = []string{"blank-at-eol", "blank-at-eof"}
+		}
+		for _, name := range names {
+			if negate {
+				delete(rules, name)
+			} else if name != "" {
+				rules[name] = true
+			}
+		}
+	}
+	return rules
+}
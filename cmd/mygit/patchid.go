@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// patchID identifies the change a commit makes to its parent independently of where it was
+// made: the SHA-1 of its patch with whitespace, index lines and hunk positions left out, so a
+// commit and a cherry-picked copy of it share one. Merges have no patch ID and get "".
+func patchID(commit *Commit) (string, error) {
+	if len(commit.Parents) > 1 {
+		return "", nil
+	}
+	parentTree := ""
+	if len(commit.Parents) == 1 {
+		var err error
+		if parentTree, err = commitTree(commit.Parents[0]); err != nil {
+			return "", err
+		}
+	}
+	before, err := treeFiles(parentTree)
+	if err != nil {
+		return "", err
+	}
+	after, err := treeFiles(commit.Tree)
+	if err != nil {
+		return "", err
+	}
+	paths := map[string]bool{}
+	for filePath := range before {
+		paths[filePath] = true
+	}
+	for filePath := range after {
+		paths[filePath] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for filePath := range paths {
+		sorted = append(sorted, filePath)
+	}
+	sort.Strings(sorted)
+
+	var patch bytes.Buffer
+	for _, filePath := range sorted {
+		a, err := patchIDSide(before, filePath)
+		if err != nil {
+			return "", err
+		}
+		b, err := patchIDSide(after, filePath)
+		if err != nil {
+			return "", err
+		}
+		if a != nil && b != nil && a.sha == b.sha && a.mode == b.mode {
+			continue
+		}
+		writePatch(&patch, filePath, a, b, diffOptions{})
+	}
+
+	h := sha1.New()
+	for _, line := range strings.Split(patch.String(), "\n") {
+		if strings.HasPrefix(line, "index ") || strings.HasPrefix(line, "@@ ") {
+			continue
+		}
+		h.Write([]byte(strings.Map(func(r rune) rune {
+			if unicode.IsSpace(r) {
+				return -1
+			}
+			return r
+		}, line)))
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// patchIDSide is the version of filePath in files, or nil when it isn't there
+func patchIDSide(files map[string]treeEntry, filePath string) (*diffSide, error) {
+	return entrySide(files[filePath])
+}
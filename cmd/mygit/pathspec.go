@@ -0,0 +1,152 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+/*
+A pathspec limits a command to some paths. A plain one matches the path itself and everything
+under it, or, when it has wildcards, the paths the pattern matches as a whole, with "*" and "?"
+matching slashes too, so "*.go" matches .go files in any directory. A pathspec is relative to
+the directory mygit was started in, unless it starts with "/", which refers to the top of the
+working tree.
+
+Pathspecs can start with magic: ":(glob)" matches like .gitignore patterns anchored at the
+top, where "*" stays within a directory and "**" crosses them, and ":(top)" or ":/" is relative
+to the top. Several are separated by commas, as in ":(top,glob)docs/*.md".
+*/
+
+// pathspecWildcards are the characters that make a pathspec a pattern
+const pathspecWildcards = "*?["
+
+type pathspec struct {
+	pattern string
+	glob    bool
+	regex   *regexp.Regexp //for patterns with wildcards; nil for plain paths
+}
+
+// parsePathspec reads a pathspec's magic and compiles its pattern, made relative to the top.
+// Magic it doesn't know is left in the pattern.
+func parsePathspec(spec string) pathspec {
+	var p pathspec
+	top := strings.HasPrefix(spec, "/")
+	switch {
+	case strings.HasPrefix(spec, ":("):
+		end := strings.IndexByte(spec, ')')
+		if end < 0 {
+			break
+		}
+		known := true
+		for _, magic := range strings.Split(spec[2:end], ",") {
+			switch magic {
+			case "glob":
+				p.glob = true
+			case "top":
+				top = true
+			default:
+				known = false
+			}
+		}
+		if known {
+			spec = spec[end+1:]
+		}
+	case strings.HasPrefix(spec, ":/"):
+		spec, top = spec[2:], true
+	}
+	p.pattern = strings.TrimPrefix(spec, "/")
+	if !top && cwdPrefix != "" {
+		p.pattern = cwdPrefix + p.pattern
+	}
+	if p.pattern == "" {
+		p.pattern = "."
+	}
+	switch {
+	case p.glob:
+		p.regex = compileIgnoreGlob("/" + p.pattern)
+	case strings.ContainsAny(p.pattern, pathspecWildcards):
+		p.regex = compilePathspecGlob(p.pattern)
+	default:
+		p.pattern = path.Clean(p.pattern)
+	}
+	return p
+}
+
+// compilePathspecGlob turns a plain pathspec with wildcards into a regex matching whole paths;
+// unlike in .gitignore, "*" and "?" match slashes too
+func compilePathspecGlob(glob string) *regexp.Regexp {
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case c == '*':
+			re.WriteString(".*")
+		case c == '?':
+			re.WriteString(".")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			re.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return regexp.MustCompile(`^` + regexp.QuoteMeta(glob) + `$`)
+	}
+	return compiled
+}
+
+// matches reports whether the pathspec matches filePath, or a directory filePath is in
+func (p pathspec) matches(filePath string) bool {
+	if p.regex == nil {
+		return p.pattern == "." || filePath == p.pattern || strings.HasPrefix(filePath, p.pattern+"/")
+	}
+	for prefix := filePath; ; prefix = path.Dir(prefix) {
+		if p.regex.MatchString(prefix) {
+			return true
+		}
+		if !strings.Contains(prefix, "/") {
+			return false
+		}
+	}
+}
+
+// isPattern reports whether the pathspec can match more than a path and what is under it
+func (p pathspec) isPattern() bool {
+	return p.regex != nil
+}
+
+// pathspecMatch reports whether filePath, relative to the top of the working tree, is matched
+// by a pathspec
+func pathspecMatch(spec string, filePath string) bool {
+	return parsePathspec(spec).matches(filePath)
+}
+
+// matchesPathspec reports whether filePath is matched by any of the pathspecs given; no
+// pathspecs at all matches everything
+func matchesPathspec(filePath string, pathspecs []string) bool {
+	if len(pathspecs) == 0 {
+		return true
+	}
+	for _, spec := range pathspecs {
+		if pathspecMatch(spec, filePath) {
+			return true
+		}
+	}
+	return false
+}
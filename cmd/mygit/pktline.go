@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+/*
+The smart protocol frames everything as pkt-lines: 4 hex digits giving the length of the line
+including those 4 digits, then the payload. "0000" is a flush packet that ends a section.
+*/
+
+func writePktLine(w io.Writer, line string) error {
+	_, err := fmt.Fprintf(w, "%04x%s", len(line)+4, line)
+	return err
+}
+
+func writeFlush(w io.Writer) error {
+	_, err := io.WriteString(w, "0000")
+	return err
+}
+
+// readPktLine returns the next payload, or nil for a flush packet
+func readPktLine(r io.Reader) ([]byte, error) {
+	var lengthHex [4]byte
+	if _, err := io.ReadFull(r, lengthHex[:]); err != nil {
+		return nil, err
+	}
+	length, err := strconv.ParseUint(string(lengthHex[:]), 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pkt-line length %q", lengthHex)
+	}
+	if length < 4 {
+		return nil, nil //flush (and v2 delimiters, which we never ask for)
+	}
+	payload := make([]byte, length-4)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// sidebandReader demultiplexes side-band-64k: band 1 is pack data, band 2 progress text from the
+// remote, band 3 a fatal error
+type sidebandReader struct {
+	r        *bufio.Reader
+	pending  []byte
+	progress []byte //remote text not yet ended by \r or \n
+	done     bool
+}
+
+func (s *sidebandReader) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		line, err := readPktLine(s.r)
+		if err != nil {
+			return 0, err
+		}
+		if err := s.demux(line); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *sidebandReader) demux(line []byte) error {
+	if line == nil {
+		s.done = true
+		return nil
+	}
+	if len(line) == 0 {
+		return nil
+	}
+	switch line[0] {
+	case 1:
+		s.pending = line[1:]
+	case 2:
+		// progress arrives in arbitrary chunks; prefix each line, or \r-terminated update, once
+		s.progress = append(s.progress, line[1:]...)
+		for {
+			end := bytes.IndexAny(s.progress, "\r\n")
+			if end < 0 {
+				break
+			}
+			fmt.Fprintf(os.Stderr, "remote: %s", s.progress[:end+1])
+			s.progress = s.progress[end+1:]
+		}
+	case 3:
+		return fmt.Errorf("remote error: %s", line[1:])
+	default:
+		return fmt.Errorf("invalid side-band channel %d", line[0])
+	}
+	return nil
+}
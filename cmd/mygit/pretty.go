@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// formatCommit expands the placeholders of a pretty format, as in "%h %s", for one commit:
+//
+//	%H, %h       commit hash, abbreviated
+//	%T, %t       tree hash, abbreviated
+//	%P, %p       parent hashes, abbreviated
+//	%an, %ae     author name and email; %cn and %ce for the committer
+//	%ad, %aD     author date, in log's format and RFC 2822; %cd and %cD for the committer
+//	%ai, %at     author date, ISO 8601-like and as a Unix timestamp; %ci and %ct likewise
+//	%s, %b, %B   subject, body and raw message
+//	%n, %%       newline and a literal %
+//
+// Anything else is left as it is.
+func formatCommit(format string, sha string, commit *Commit) string {
+	var b strings.Builder
+	for format != "" {
+		i := strings.IndexByte(format, '%')
+		if i < 0 || i == len(format)-1 {
+			b.WriteString(format)
+			break
+		}
+		b.WriteString(format[:i])
+		format = format[i+1:]
+
+		n := 1 //length of the placeholder after the %
+		switch format[0] {
+		case '%':
+			b.WriteByte('%')
+		case 'n':
+			b.WriteByte('\n')
+		case 'H':
+			b.WriteString(sha)
+		case 'h':
+			b.WriteString(sha[:7])
+		case 'T':
+			b.WriteString(commit.Tree)
+		case 't':
+			b.WriteString(commit.Tree[:7])
+		case 'P', 'p':
+			parents := make([]string, len(commit.Parents))
+			for i, parent := range commit.Parents {
+				parents[i] = parent
+				if format[0] == 'p' {
+					parents[i] = parent[:7]
+				}
+			}
+			b.WriteString(strings.Join(parents, " "))
+		case 's':
+			b.WriteString(commit.Subject())
+		case 'b':
+			if _, body, ok := strings.Cut(commit.Message, "\n\n"); ok {
+				b.WriteString(body)
+			}
+		case 'B':
+			b.WriteString(commit.Message)
+		case 'a', 'c':
+			signature := commit.Author
+			if format[0] == 'c' {
+				signature = commit.Committer
+			}
+			if len(format) < 2 {
+				b.WriteString("%" + format)
+				format = ""
+				continue
+			}
+			value, ok := signatureField(signature, format[1])
+			if !ok {
+				b.WriteString("%" + format[:2])
+			}
+			b.WriteString(value)
+			n = 2
+		default:
+			b.WriteString("%" + format[:1])
+		}
+		format = format[n:]
+	}
+	return b.String()
+}
+
+// signatureField is one part of an author or committer line, for the %a and %c placeholders
+func signatureField(signature string, field byte) (string, bool) {
+	identity := signatureIdentity(signature)
+	name, email, _ := strings.Cut(identity, " <")
+	when := signatureTime(signature)
+	switch field {
+	case 'n':
+		return name, true
+	case 'e':
+		return strings.TrimSuffix(email, ">"), true
+	case 'd':
+		return when.Format(logDateFormat), true
+	case 'D':
+		return when.Format("Mon, 2 Jan 2006 15:04:05 -0700"), true
+	case 'i':
+		return when.Format("2006-01-02 15:04:05 -0700"), true
+	case 't':
+		return strconv.FormatInt(when.Unix(), 10), true
+	}
+	return "", false
+}
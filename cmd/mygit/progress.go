@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// progress draws a git-style "Title:  42% (42/100), 1.20 MiB" line on stderr, but only when
+// stderr is a terminal so scripts and logs don't fill up with carriage returns
+type progress struct {
+	title   string
+	total   int
+	enabled bool
+	last    time.Time
+}
+
+func newProgress(title string, total int) *progress {
+	return &progress{
+		title:   title,
+		total:   total,
+		enabled: isTerminal(os.Stderr),
+	}
+}
+
+func (p *progress) update(count int, bytes int64) {
+	if !p.enabled || time.Since(p.last) < 100*time.Millisecond {
+		return
+	}
+	p.last = time.Now()
+	p.draw(count, bytes, "")
+}
+
+func (p *progress) done(count int, bytes int64) {
+	if p.enabled {
+		p.draw(count, bytes, ", done.\n")
+	}
+}
+
+func (p *progress) draw(count int, bytes int64, suffix string) {
+	percent := 100
+	if p.total > 0 {
+		percent = count * 100 / p.total
+	}
+	fmt.Fprintf(os.Stderr, "\r%s: %3d%% (%d/%d)", p.title, percent, count, p.total)
+	if bytes > 0 {
+		fmt.Fprintf(os.Stderr, ", %s", humanBytes(bytes))
+	}
+	fmt.Fprint(os.Stderr, suffix)
+}
+
+func humanBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.2f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.2f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.2f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
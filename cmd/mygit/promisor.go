@@ -0,0 +1,170 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+/*
+A partial clone fetches with a filter such as blob:none, so the remote leaves some objects out.
+The remote named by extensions.partialClone promises to supply them later: reading one that isn't
+here fetches it from there on the spot. The objects still owed are listed, one SHA per line, in
+.git/objects/info/promised-objects. What the promisor remote sends is kept as a pack with a
+.promisor file beside it, as git keeps it, so git itself knows the objects those packs refer to
+but leave out are owed rather than lost.
+*/
+
+var promisedObjectsPath = path.Join(".git", "objects", "info", "promised-objects")
+
+// fetchingPromised guards against fetching recursively while a promised object is unpacked
+var fetchingPromised bool
+
+// promisorRemote is the remote missing objects can be fetched from, or "" outside a partial clone
+func promisorRemote() string {
+	remote, _ := configGet("extensions.partialClone")
+	return remote
+}
+
+// hasObject reports whether an object is here, fetching it first when a partial clone is
+// missing it
+func hasObject(sha string) bool {
+	return objectExists(sha) || sha == emptyTreeSHA || (promisorRemote() != "" && fetchPromisedObject(sha) == nil)
+}
+
+// receivePack stores a fetched pack: kept as a promisor pack in a partial clone, or else
+// unpacked into loose objects
+func receivePack(r io.Reader) (int, error) {
+	if promisorRemote() != "" {
+		return storePack(r, true)
+	}
+	return unpackObjects(r)
+}
+
+// registerPromisor makes a repository a partial clone of remote, fetched with filter
+func registerPromisor(remote string, filter string) error {
+	for _, setting := range [][2]string{
+		{"core.repositoryformatversion", "1"}, //so tools that can't fetch missing objects stay away
+		{"extensions.partialClone", remote},
+		{"remote." + remote + ".promisor", "true"},
+		{"remote." + remote + ".partialclonefilter", filter},
+	} {
+		if err := configSet(setting[0], setting[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchPromisedObject fetches one missing object from the promisor remote
+func fetchPromisedObject(sha string) error {
+	if fetchingPromised {
+		return os.ErrNotExist
+	}
+	fetchingPromised = true
+	defer func() { fetchingPromised = false }()
+
+	url := remoteURL(promisorRemote())
+	remoteServicePrograms(promisorRemote())
+	_, caps, err := discoverRefs(url, "git-upload-pack")
+	if err != nil {
+		return err
+	}
+	// the filter keeps a missing commit or tree from dragging in everything below it
+	pack, err := fetchPack(url, caps, []string{sha}, nil, "blob:none")
+	if err != nil {
+		return err
+	}
+	defer pack.Close()
+	if _, err := receivePack(pack); err != nil {
+		return err
+	}
+	return forgetPromisedObjects(map[string]bool{sha: true})
+}
+
+// readPromisedObjects returns the objects the promisor remote still owes
+func readPromisedObjects() map[string]bool {
+	promised := map[string]bool{}
+	contents, _ := os.ReadFile(promisedObjectsPath)
+	for _, sha := range strings.Fields(string(contents)) {
+		promised[sha] = true
+	}
+	return promised
+}
+
+func writePromisedObjects(promised map[string]bool) error {
+	shas := make([]string, 0, len(promised))
+	for sha := range promised {
+		shas = append(shas, sha)
+	}
+	sort.Strings(shas)
+	if err := os.MkdirAll(path.Dir(promisedObjectsPath), 0755); err != nil {
+		return err
+	}
+	var contents string
+	if len(shas) > 0 {
+		contents = strings.Join(shas, "\n") + "\n"
+	}
+	return writeFileAtomic(promisedObjectsPath, []byte(contents), 0644)
+}
+
+// forgetPromisedObjects drops objects that have now been fetched from the promised list
+func forgetPromisedObjects(fetched map[string]bool) error {
+	promised := readPromisedObjects()
+	changed := false
+	for sha := range fetched {
+		if promised[sha] {
+			delete(promised, sha)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return writePromisedObjects(promised)
+}
+
+// recordPromisedObjects walks everything reachable from HEAD and the refs and lists the
+// objects that are referred to but missing, which a filtered fetch has left to the promisor
+func recordPromisedObjects() error {
+	var queue []string
+	if head, err := readRef("HEAD"); err == nil {
+		queue = append(queue, head)
+	}
+	refs, err := listRefs("refs")
+	if err != nil {
+		return err
+	}
+	for _, sha := range refs {
+		queue = append(queue, sha)
+	}
+
+	promised := map[string]bool{}
+	seen := map[string]bool{}
+	for len(queue) > 0 {
+		sha := queue[0]
+		queue = queue[1:]
+		if seen[sha] {
+			continue
+		}
+		seen[sha] = true
+		if !objectExists(sha) {
+			promised[sha] = true
+			continue
+		}
+		objType, contents, err := parseObject(sha)
+		if err != nil {
+			return err
+		}
+		links, err := objectLinks(objType, contents)
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			queue = append(queue, link.sha)
+		}
+	}
+	return writePromisedObjects(promised)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Usage: mygit pull [--rebase|--no-rebase] [<remote> [<branch>]]
+//
+// Fetches the current branch's upstream (branch.<name>.remote and branch.<name>.merge) and
+// merges FETCH_HEAD into the branch, or rebases the branch onto it with --rebase, pull.rebase
+// or branch.<name>.rebase.
+func cmdPull(args []string) {
+	usage := "usage: mygit pull [--rebase|--no-rebase] [<remote> [<branch>]]\n"
+	branch, err := currentBranch()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading HEAD: %s\n", err)
+		os.Exit(1)
+	}
+	rebase := configBool("pull.rebase", false)
+	rebase = configBool("branch."+branch+".rebase", rebase)
+	var positional []string
+	for _, arg := range args {
+		switch arg {
+		case "-r", "--rebase":
+			rebase = true
+		case "--no-rebase":
+			rebase = false
+		default:
+			if strings.HasPrefix(arg, "-") {
+				fmt.Fprint(os.Stderr, usage)
+				os.Exit(1)
+			}
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) > 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+
+	remote, mergeRef, _ := branchUpstream(branch)
+	if len(positional) > 0 {
+		remote, mergeRef = positional[0], ""
+	}
+	if len(positional) > 1 {
+		mergeRef = positional[1]
+		if !strings.HasPrefix(mergeRef, "refs/") {
+			mergeRef = "refs/heads/" + mergeRef
+		}
+	}
+	if remote == "" || mergeRef == "" {
+		fmt.Fprintf(os.Stderr, "There is no tracking information for the current branch.\n"+
+			"Please specify which branch you want to merge with:\n\n"+
+			"    mygit pull <remote> <branch>\n")
+		os.Exit(1)
+	}
+
+	// like git, fetch the merge ref as if it had been given on the command line, so it is the
+	// one FETCH_HEAD leads with, then merge or rebase onto FETCH_HEAD
+	_, failed, err := fetchRemote(remote, []string{mergeRef}, configGetAll("remote."+remote+".fetch"), false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	if failed {
+		os.Exit(1)
+	}
+	sha, err := readRef("FETCH_HEAD")
+	if err == nil {
+		sha, err = peelToCommit(sha)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+
+	if rebase {
+		if _, err := os.Stat(rebaseDir); err == nil {
+			fmt.Fprintf(os.Stderr, "fatal: It seems that there is already a rebase-merge directory.\n")
+			os.Exit(128)
+		}
+		exitOnRebaseFailure(rebaseStart(sha, sha, false, "", nil))
+		return
+	}
+
+	if _, err := os.Stat(mergeHeadPath); err == nil {
+		fmt.Fprintf(os.Stderr, "fatal: You have not concluded your merge (MERGE_HEAD exists).\n")
+		os.Exit(128)
+	}
+	conflicted, err := mergeCommit(sha, sha, mergeMessage("FETCH_HEAD", sha), false, false)
+	exitOnMergeFailure(conflicted, err)
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+type pushUpdate struct {
+	src    string //local name as given, for display
+	dst    string //remote ref
+	old    string //remote value before the push, zeroSHA if the ref is new
+	new    string //zeroSHA to delete
+	force  bool
+	status string //"" while pending, "ok", "up to date", or why it was rejected
+	remote bool   //rejected by the remote rather than by us
+}
+
+// Usage: mygit push [-f|--force] [--atomic [--no-atomic-fallback]] [--receive-pack=<program>] [<remote> [<refspec>...]]
+//
+// --receive-pack runs program as the remote's receive-pack over ssh or locally, in place of
+// remote.<name>.receivepack or git-receive-pack.
+func cmdPush(args []string) {
+	usage := "usage: mygit push [--force] [--atomic [--no-atomic-fallback]] [--receive-pack=<program>] [<remote> [<refspec>...]]\n"
+	force, atomic, noAtomicFallback := false, false, false
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-f" || arg == "--force":
+			force = true
+		case arg == "--atomic":
+			atomic = true
+		case arg == "--no-atomic":
+			atomic = false
+		case arg == "--no-atomic-fallback":
+			noAtomicFallback = true
+		case (arg == "--receive-pack" || arg == "--exec") && i+1 < len(args):
+			i++
+			servicePrograms["git-receive-pack"] = args[i]
+		case strings.HasPrefix(arg, "--receive-pack=") || strings.HasPrefix(arg, "--exec="):
+			_, servicePrograms["git-receive-pack"], _ = strings.Cut(arg, "=")
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	branch, err := currentBranch()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading HEAD: %s\n", err)
+		os.Exit(1)
+	}
+	remote := "origin"
+	if configured, ok := configGet("branch." + branch + ".remote"); ok && branch != "" {
+		remote = configured
+	}
+	var refspecs []string
+	if len(positional) > 0 {
+		remote, refspecs = positional[0], positional[1:]
+	}
+	if len(refspecs) == 0 {
+		if branch == "" {
+			fmt.Fprintf(os.Stderr, "fatal: You are not currently on a branch.\n")
+			os.Exit(128)
+		}
+		refspecs = []string{branch}
+	}
+	url := remoteURL(remote)
+	remoteServicePrograms(remote)
+
+	refs, caps, err := discoverRefs(url, "git-receive-pack")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	if _, ok := capabilityValue(caps, "atomic"); atomic && !ok {
+		if noAtomicFallback {
+			fmt.Fprintf(os.Stderr, "fatal: the receiving end does not support --atomic push\n")
+			os.Exit(128)
+		}
+		fmt.Fprintf(os.Stderr, "warning: the receiving end does not support --atomic push; pushing non-atomically\n")
+		atomic = false
+	}
+	remoteRefs := map[string]string{}
+	for _, ref := range refs {
+		remoteRefs[ref.name] = ref.sha
+	}
+
+	var updates []*pushUpdate
+	for _, spec := range refspecs {
+		update, err := parsePushRefspec(spec, force)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(1)
+		}
+		update.old = zeroSHA
+		if sha, ok := remoteRefs[update.dst]; ok {
+			update.old = sha
+		}
+		if err := checkPushUpdate(update); err != nil {
+			fmt.Fprintf(os.Stderr, "Error checking %s: %s\n", update.dst, err)
+			os.Exit(1)
+		}
+		updates = append(updates, update)
+	}
+
+	// with --atomic a single rejection stops every update before anything is sent
+	var pending []*pushUpdate
+	for _, update := range updates {
+		if update.status == "" {
+			pending = append(pending, update)
+		} else if atomic && update.status != "up to date" {
+			for _, other := range updates {
+				if other.status == "" {
+					other.status = "atomic push failed"
+				}
+			}
+			pending = nil
+			break
+		}
+	}
+
+	if len(pending) > 0 {
+		var haves []string
+		for _, sha := range remoteRefs {
+			if objType, _, err := parseObject(sha); err == nil && objType == "commit" {
+				haves = append(haves, sha)
+			}
+		}
+		if err := sendPack(url, caps, pending, haves, atomic); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+	} else {
+		hangUpService(url, "git-receive-pack")
+	}
+
+	failed := printPushReport(url, updates)
+
+	// keep the remote-tracking refs in step with what the remote now has
+	if _, named := configGet("remote." + remote + ".url"); named {
+		for _, update := range updates {
+			branch, isBranch := strings.CutPrefix(update.dst, "refs/heads/")
+			if update.status != "ok" || !isBranch {
+				continue
+			}
+			tracking := path.Join("refs", "remotes", remote, branch)
+			if update.new == zeroSHA {
+				os.Remove(path.Join(".git", tracking))
+			} else if err := updateRef(tracking, update.new, ""); err != nil {
+				fmt.Fprintf(os.Stderr, "Error updating %s: %s\n", tracking, err)
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// parsePushRefspec handles "[+]<src>[:<dst>]", where an empty src deletes dst
+func parsePushRefspec(spec string, force bool) (*pushUpdate, error) {
+	update := &pushUpdate{force: force}
+	if strings.HasPrefix(spec, "+") {
+		update.force = true
+		spec = spec[1:]
+	}
+	src, dst, hasDst := strings.Cut(spec, ":")
+	update.src = src
+	if !hasDst {
+		dst = src
+	}
+
+	if src == "" {
+		update.new = zeroSHA
+	} else {
+		sha, err := resolveRef(src)
+		if err != nil {
+			return nil, fmt.Errorf("src refspec %s does not match any", src)
+		}
+		update.new = sha
+	}
+
+	switch {
+	case strings.HasPrefix(dst, "refs/"):
+		update.dst = dst
+	case src != "" && !strings.HasPrefix(src, "refs/") && branchExists(src) || src == "" || src == "HEAD":
+		update.dst = path.Join("refs", "heads", dst)
+	default:
+		if _, err := readRef(path.Join("refs", "tags", src)); err == nil {
+			update.dst = path.Join("refs", "tags", dst)
+		} else {
+			update.dst = path.Join("refs", "heads", dst)
+		}
+	}
+	return update, nil
+}
+
+// checkPushUpdate rejects updates the remote would refuse anyway: non-fast-forwards without force
+func checkPushUpdate(update *pushUpdate) error {
+	switch {
+	case update.new == update.old:
+		update.status = "up to date"
+	case update.new == zeroSHA && update.old == zeroSHA:
+		update.status = "remote ref does not exist"
+	case update.new == zeroSHA || update.old == zeroSHA || update.force:
+		// deletions, new refs and forced updates need no ancestry check
+	case strings.HasPrefix(update.dst, "refs/tags/"):
+		update.status = "already exists"
+	default:
+		if !objectExists(update.old) {
+			update.status = "fetch first"
+			return nil
+		}
+		fastForward, err := isAncestor(update.old, update.new)
+		if err != nil {
+			return err
+		}
+		if !fastForward {
+			update.status = "non-fast-forward"
+		}
+	}
+	return nil
+}
+
+// sendPack runs the receive-pack exchange: ref update commands, then a pack with the objects
+// the remote is missing, then the remote's per-ref report
+func sendPack(url string, caps []string, updates []*pushUpdate, haves []string, atomic bool) error {
+	var request bytes.Buffer
+	requested := []string{"report-status", "agent=mygit/0.1"}
+	_, sideband := capabilityValue(caps, "side-band-64k")
+	if sideband {
+		requested = append(requested, "side-band-64k")
+	}
+	if atomic {
+		requested = append(requested, "atomic")
+	}
+	var tips []string
+	for i, update := range updates {
+		command := fmt.Sprintf("%s %s %s", update.old, update.new, update.dst)
+		if i == 0 {
+			command += "\x00" + strings.Join(requested, " ")
+		}
+		writePktLine(&request, command+"\n")
+		if update.new != zeroSHA {
+			tips = append(tips, update.new)
+		}
+	}
+	writeFlush(&request)
+	if len(tips) > 0 {
+		objects, err := reachableObjects(tips, haves)
+		if err != nil {
+			return err
+		}
+		if err := writePack(&request, objects); err != nil {
+			return err
+		}
+	}
+
+	body, err := postService(url, "git-receive-pack", &request)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	var report io.Reader = bufio.NewReader(body)
+	if sideband {
+		report = &sidebandReader{r: report.(*bufio.Reader)}
+	}
+	byRef := map[string]*pushUpdate{}
+	for _, update := range updates {
+		byRef[update.dst] = update
+	}
+	for {
+		line, err := readPktLine(report)
+		if err == io.EOF || (err == nil && line == nil) {
+			break
+		} else if err != nil {
+			return err
+		}
+		status := strings.TrimRight(string(line), "\n")
+		switch {
+		case strings.HasPrefix(status, "unpack "):
+			if result := strings.TrimPrefix(status, "unpack "); result != "ok" {
+				return fmt.Errorf("remote unpack failed: %s", result)
+			}
+		case strings.HasPrefix(status, "ok "):
+			if update := byRef[strings.TrimPrefix(status, "ok ")]; update != nil {
+				update.status = "ok"
+			}
+		case strings.HasPrefix(status, "ng "):
+			ref, reason, _ := strings.Cut(strings.TrimPrefix(status, "ng "), " ")
+			if update := byRef[ref]; update != nil {
+				update.status, update.remote = reason, true
+			}
+		}
+	}
+	for _, update := range updates {
+		if update.status == "" {
+			update.status, update.remote = "no report from remote", true
+		}
+	}
+	return nil
+}
+
+// printPushReport prints one line per ref in git's format and reports whether any update failed
+func printPushReport(url string, updates []*pushUpdate) bool {
+	failed := false
+	upToDate := true
+	for _, update := range updates {
+		if update.status != "up to date" {
+			upToDate = false
+		}
+	}
+	if upToDate {
+		fmt.Fprintln(os.Stderr, "Everything up-to-date")
+		return false
+	}
+
+	fmt.Fprintf(os.Stderr, "To %s\n", url)
+	for _, update := range updates {
+		name := shortRefName(update.dst)
+		refs := fmt.Sprintf("%s -> %s", update.src, name)
+		switch {
+		case update.status == "up to date":
+			fmt.Fprintf(os.Stderr, " = %-17s %s\n", "[up to date]", refs)
+		case update.status != "ok":
+			failed = true
+			summary := "[rejected]"
+			if update.remote {
+				summary = "[remote rejected]"
+			}
+			fmt.Fprintf(os.Stderr, " ! %-17s %s (%s)\n", summary, refs, update.status)
+		case update.new == zeroSHA:
+			fmt.Fprintf(os.Stderr, " - %-17s %s\n", "[deleted]", name)
+		case update.old == zeroSHA:
+			kind := "[new branch]"
+			if strings.HasPrefix(update.dst, "refs/tags/") {
+				kind = "[new tag]"
+			}
+			fmt.Fprintf(os.Stderr, " * %-17s %s\n", kind, refs)
+		case update.force:
+			fmt.Fprintf(os.Stderr, " + %-17s %s (forced update)\n", update.old[:7]+"..."+update.new[:7], refs)
+		default:
+			fmt.Fprintf(os.Stderr, "   %-17s %s\n", update.old[:7]+".."+update.new[:7], refs)
+		}
+	}
+	return failed
+}
+
+func shortRefName(ref string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/"} {
+		if short, ok := strings.CutPrefix(ref, prefix); ok {
+			return short
+		}
+	}
+	return ref
+}
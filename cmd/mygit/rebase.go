@@ -0,0 +1,596 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+/*
+A rebase in progress keeps its state in .git/rebase-merge, like git:
+- head-name: the branch being rebased, or "detached HEAD"
+- orig-head: where that branch was before the rebase
+- onto: the commit the branch is being replayed onto
+- squash-onto: for --root without --onto, an empty commit standing in for "no parent", so that
+  the root commit is replayed as a root again
+- git-rebase-todo: "<command> <sha> <subject>" lines still to replay, the command being pick
+  unless an interactive rebase changed it
+- done: the lines taken from git-rebase-todo so far, the last one the commit being replayed
+- stopped-sha: the commit whose changes stopped with conflicts, if any
+- strategy, strategy_opts: the -s strategy and the -X options, one per line, when given
+- current-fixups: the run of squashes and fixups being folded into one commit, if any
+*/
+
+const rebaseDir = ".git/rebase-merge"
+
+// errRebaseStopped means a commit didn't apply cleanly; the rebase waits for --continue
+var errRebaseStopped = errors.New("rebase stopped")
+
+// Usage:
+//
+//	mygit rebase [-i] [-s <strategy>] [-X <strategy-option>] [--onto <newbase>] [<upstream> | --root]
+//	mygit rebase (--continue|--skip|--abort)
+//
+// The commits upstream doesn't have are replayed onto upstream, or onto newbase with --onto.
+// --root replays every commit on the branch, its root commit included: onto newbase, or as a
+// new history of its own without --onto. A commit whose parent is already where it would be
+// replayed is kept as it is rather than recreated.
+//
+// -i opens the list of commits to replay in the editor first, where they can be reordered,
+// dropped, reworded, or squashed into the commit before them.
+//
+// Each commit is replayed with the recursive strategy unless -s picks another: resolve, which
+// works the same way here, or ours, which keeps upstream's tree and so drops every commit.
+// -X ours and -X theirs settle conflicting hunks in favour of upstream or of the commit being
+// replayed, respectively.
+func cmdRebase(args []string) {
+	usage := "usage: mygit rebase [-i] [-s <strategy>] [-X <strategy-option>] [--onto <newbase>] [<upstream> | --root]\n" +
+		"   or: mygit rebase (--continue|--skip|--abort)\n"
+	action, onto, root, interactive := "", "", false, false
+	var strategy string
+	var strategyOptions []string
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--continue", arg == "--skip", arg == "--abort":
+			action = arg
+		case arg == "--onto" && i+1 < len(args):
+			i++
+			onto = args[i]
+		case strings.HasPrefix(arg, "--onto="):
+			onto = strings.TrimPrefix(arg, "--onto=")
+		case arg == "--root":
+			root = true
+		case arg == "-i" || arg == "--interactive":
+			interactive = true
+		case (arg == "-s" || arg == "--strategy") && i+1 < len(args):
+			i++
+			strategy = args[i]
+		case strings.HasPrefix(arg, "--strategy="):
+			strategy = strings.TrimPrefix(arg, "--strategy=")
+		case (arg == "-X" || arg == "--strategy-option") && i+1 < len(args):
+			i++
+			strategyOptions = append(strategyOptions, args[i])
+		case strings.HasPrefix(arg, "--strategy-option="):
+			strategyOptions = append(strategyOptions, strings.TrimPrefix(arg, "--strategy-option="))
+		case strings.HasPrefix(arg, "-X") && len(arg) > 2:
+			strategyOptions = append(strategyOptions, arg[2:])
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	var options mergeOptions
+	if strategy != "" {
+		if err := parseMergeStrategy(strategy, &options); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+	}
+	for _, option := range strategyOptions {
+		if err := parseStrategyOption(option, &options); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+	}
+	if len(positional) > 1 || (root && len(positional) > 0) ||
+		(action != "" && (len(positional) > 0 || strategy != "" || len(strategyOptions) > 0 || onto != "" || root || interactive)) {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+
+	_, statErr := os.Stat(rebaseDir)
+	inProgress := statErr == nil
+	var err error
+	switch {
+	case action != "" && !inProgress:
+		fmt.Fprintf(os.Stderr, "fatal: No rebase in progress?\n")
+		os.Exit(128)
+	case action == "--continue":
+		err = rebaseContinue()
+	case action == "--skip":
+		err = rebaseSkip()
+	case action == "--abort":
+		err = rebaseAbort()
+	case inProgress:
+		fmt.Fprintf(os.Stderr, "fatal: It seems that there is already a rebase-merge directory.\n"+
+			"Use \"mygit rebase (--continue|--skip|--abort)\" to finish or abandon it.\n")
+		os.Exit(128)
+	case root:
+		ontoSHA := ""
+		if onto != "" {
+			ontoSHA = rebaseCommitArg(onto, "Does not point to a valid commit '%s'")
+		}
+		err = rebaseStart("", ontoSHA, interactive, strategy, strategyOptions)
+	default:
+		upstream := ""
+		if len(positional) == 1 {
+			upstream = positional[0]
+		} else {
+			branch, _ := currentBranch()
+			remote, mergeRef, ok := branchUpstream(branch)
+			if ok {
+				upstream = trackingRef(remote, mergeRef)
+			}
+			if upstream == "" {
+				fmt.Fprintf(os.Stderr, "There is no tracking information for the current branch.\n"+
+					"Please specify which branch you want to rebase against.\n")
+				os.Exit(1)
+			}
+		}
+		sha := rebaseCommitArg(upstream, "invalid upstream '%s'")
+		ontoSHA := sha
+		if onto != "" {
+			ontoSHA = rebaseCommitArg(onto, "Does not point to a valid commit '%s'")
+		}
+		err = rebaseStart(sha, ontoSHA, interactive, strategy, strategyOptions)
+	}
+	exitOnRebaseFailure(err)
+}
+
+// rebaseCommitArg resolves a commit given to rebase, or exits with message about it
+func rebaseCommitArg(arg string, message string) string {
+	sha, err := resolveObjectArg(arg)
+	if err == nil {
+		sha, err = peelToCommit(sha)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: "+message+"\n", arg)
+		os.Exit(128)
+	}
+	return sha
+}
+
+// exitOnRebaseFailure reports a rebase that stopped on a conflict or failed, and exits
+func exitOnRebaseFailure(err error) {
+	if err == errRebaseStopped {
+		fmt.Fprintf(os.Stderr, "hint: Resolve all conflicts manually, mark them as resolved with\n"+
+			"hint: \"mygit add <conflicted_files>\", then run \"mygit rebase --continue\".\n"+
+			"hint: You can instead skip this commit: run \"mygit rebase --skip\".\n"+
+			"hint: To abort and get back to the state before \"mygit rebase\", run \"mygit rebase --abort\".\n")
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func readRebaseState(name string) string {
+	contents, _ := os.ReadFile(path.Join(rebaseDir, name))
+	return strings.TrimSpace(string(contents))
+}
+
+func writeRebaseState(name string, value string) error {
+	return os.WriteFile(path.Join(rebaseDir, name), []byte(value+"\n"), 0644)
+}
+
+// rebaseStart replays the commits on the current branch that upstream doesn't have on top of
+// onto, then moves the branch to the result. An empty upstream replays them all, for --root,
+// and an empty onto then starts a new history. interactive has the user edit the todo list
+// first. strategy and strategyOptions are the -s and -X arguments, already checked.
+func rebaseStart(upstream string, onto string, interactive bool, strategy string, strategyOptions []string) error {
+	head, err := headCommit()
+	if err != nil {
+		return err
+	}
+	if head == "" {
+		return errors.New("cannot rebase: the current branch has no commits yet")
+	}
+	headTree, err := commitTree(head)
+	if err != nil {
+		return err
+	}
+	if changes, err := uncommittedChanges(headTree); err != nil {
+		return err
+	} else if len(changes) > 0 {
+		return errors.New("cannot rebase: You have unstaged changes.\nerror: Please commit or stash them.")
+	}
+
+	isBranch, ref, err := ReadHEAD(".git")
+	if err != nil {
+		return err
+	}
+	headName := "detached HEAD"
+	if isBranch {
+		headName = ref
+	}
+	if upstream != "" && onto == upstream && !interactive {
+		if upToDate, err := isAncestor(upstream, head); err != nil {
+			return err
+		} else if upToDate {
+			fmt.Printf("Current branch %s is up to date.\n", strings.TrimPrefix(headName, "refs/heads/"))
+			return nil
+		}
+	}
+
+	exclude := upstream
+	if upstream == "" {
+		exclude = onto
+	}
+	picks, err := commitsToReplay(head, exclude)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(rebaseDir, 0755); err != nil {
+		return err
+	}
+	var todo []string
+	for _, sha := range picks {
+		commit, err := readCommit(sha)
+		if err != nil {
+			return err
+		}
+		todo = append(todo, fmt.Sprintf("pick %s %s", sha, commit.Subject()))
+	}
+	if onto == "" {
+		emptyTree, err := writeTreeFiles(map[string]treeEntry{})
+		if err != nil {
+			return err
+		}
+		author, committer, err := commitSignatures()
+		if err != nil {
+			return err
+		}
+		if onto, err = writeCommit(&Commit{Tree: emptyTree, Author: author, Committer: committer}); err != nil {
+			return err
+		}
+		if err := writeRebaseState("squash-onto", onto); err != nil {
+			return err
+		}
+	}
+	if interactive {
+		if todo, err = editRebaseTodo(todo, upstream, head, onto); err != nil {
+			os.RemoveAll(rebaseDir)
+			return err
+		}
+	}
+	state := map[string]string{
+		"head-name":       headName,
+		"orig-head":       head,
+		"onto":            onto,
+		"git-rebase-todo": strings.Join(todo, "\n"),
+	}
+	if strategy != "" {
+		state["strategy"] = strategy
+	}
+	if len(strategyOptions) > 0 {
+		state["strategy_opts"] = strings.Join(strategyOptions, "\n")
+	}
+	for name, value := range state {
+		if err := writeRebaseState(name, value); err != nil {
+			return err
+		}
+	}
+
+	if err := checkoutCommit(head, onto); err != nil {
+		return err
+	}
+	if err := detachHead(onto, "rebase (start): checkout "+onto); err != nil {
+		return err
+	}
+	return rebaseRun()
+}
+
+// commitsToReplay lists the non-merge commits reachable from head but not from upstream,
+// oldest first; all of them when upstream is empty
+func commitsToReplay(head string, upstream string) ([]string, error) {
+	upstreamHistory := map[string]*Commit{}
+	if upstream != "" {
+		var err error
+		if upstreamHistory, err = ancestors([]string{upstream}); err != nil {
+			return nil, err
+		}
+	}
+	order, commits, err := topoOrder([]string{head})
+	if err != nil {
+		return nil, err
+	}
+	var picks []string
+	for i := len(order) - 1; i >= 0; i-- {
+		sha := order[i]
+		if _, upstreamHas := upstreamHistory[sha]; upstreamHas || len(commits[sha].Parents) > 1 {
+			continue
+		}
+		picks = append(picks, sha)
+	}
+	return picks, nil
+}
+
+// rebaseMergeOptions reads back the strategy and strategy options the rebase was started with
+func rebaseMergeOptions() (mergeOptions, error) {
+	var options mergeOptions
+	if strategy := readRebaseState("strategy"); strategy != "" {
+		if err := parseMergeStrategy(strategy, &options); err != nil {
+			return options, err
+		}
+	}
+	if opts := readRebaseState("strategy_opts"); opts != "" {
+		for _, option := range strings.Split(opts, "\n") {
+			if err := parseStrategyOption(option, &options); err != nil {
+				return options, err
+			}
+		}
+	}
+	return options, nil
+}
+
+// rebaseRun works through the todo list until it is empty or a commit stops with conflicts
+func rebaseRun() error {
+	options, err := rebaseMergeOptions()
+	if err != nil {
+		return err
+	}
+	for {
+		todo := strings.Split(readRebaseState("git-rebase-todo"), "\n")
+		if todo[0] == "" {
+			return rebaseFinish()
+		}
+		fields := strings.Fields(todo[0])
+		if err := writeRebaseState("git-rebase-todo", strings.Join(todo[1:], "\n")); err != nil {
+			return err
+		}
+		if err := appendRebaseDone(todo[0]); err != nil {
+			return err
+		}
+		if len(fields) < 2 || rebaseCommands[fields[0]] != fields[0] {
+			return fmt.Errorf("invalid line in the todo list: %s", todo[0])
+		}
+
+		command, sha := fields[0], fields[1]
+		if command == "drop" {
+			continue
+		}
+		if command == "pick" {
+			if forwarded, err := rebaseFastForward(sha); err != nil {
+				return err
+			} else if forwarded {
+				os.Remove(path.Join(rebaseDir, "current-fixups"))
+				continue
+			}
+		}
+		commit, result, err := replayCommit(sha, options)
+		if err != nil {
+			return err
+		}
+		if len(result.conflicts) > 0 {
+			if err := writeRebaseState("stopped-sha", sha); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "error: could not apply %s... %s\n", sha[:7], commit.Subject())
+			return errRebaseStopped
+		}
+		if err := rebaseCommit(command, sha, commit, result.tree); err != nil {
+			return err
+		}
+	}
+}
+
+// appendRebaseDone records a line taken from the todo list in done
+func appendRebaseDone(line string) error {
+	f, err := os.OpenFile(path.Join(rebaseDir, "done"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, line)
+	return err
+}
+
+// rebaseFastForward moves HEAD to the commit sha when its parent is HEAD already, or when it
+// is a root commit and HEAD is the stand-in for one, since replaying it would only recreate it.
+// It reports whether it did.
+func rebaseFastForward(sha string) (bool, error) {
+	commit, err := readCommit(sha)
+	if err != nil {
+		return false, err
+	}
+	head, err := headCommit()
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case len(commit.Parents) == 1 && commit.Parents[0] == head:
+	case len(commit.Parents) == 0 && head == readRebaseState("squash-onto"):
+	default:
+		return false, nil
+	}
+	if err := checkoutCommit(head, sha); err != nil {
+		return false, err
+	}
+	return true, detachHead(sha, "rebase (pick): "+commit.Subject())
+}
+
+// replayCommit applies the changes a commit made to its first parent onto HEAD, as a
+// three-way merge with that parent as the base. The ours strategy ignores the commit's changes
+// altogether and leaves HEAD as it is.
+func replayCommit(sha string, options mergeOptions) (*Commit, *mergeResult, error) {
+	commit, err := readCommit(sha)
+	if err != nil {
+		return nil, nil, err
+	}
+	baseTree := ""
+	if len(commit.Parents) > 0 {
+		if baseTree, err = commitTree(commit.Parents[0]); err != nil {
+			return nil, nil, err
+		}
+	}
+	head, err := headCommit()
+	if err != nil {
+		return nil, nil, err
+	}
+	headTree, err := commitTree(head)
+	if err != nil {
+		return nil, nil, err
+	}
+	if options.strategy == "ours" {
+		return commit, &mergeResult{tree: headTree}, nil
+	}
+	label := fmt.Sprintf("%s (%s)", sha[:7], commit.Subject())
+	result, err := mergeTrees(baseTree, headTree, commit.Tree, "HEAD", label, options.favor)
+	if err != nil {
+		return nil, nil, err
+	}
+	return commit, result, applyMergeResult(headTree, result)
+}
+
+// commitReplayed commits tree on top of HEAD with the original commit's author and message,
+// for the todo list command given. A commit whose changes are already in HEAD is dropped.
+func commitReplayed(original *Commit, tree string, command string) error {
+	head, err := headCommit()
+	if err != nil {
+		return err
+	}
+	headTree, err := commitTree(head)
+	if err != nil {
+		return err
+	}
+	committer, err := signature("committer")
+	if err != nil {
+		return err
+	}
+	parents := []string{head}
+	if head == readRebaseState("squash-onto") {
+		parents = nil //the stand-in for no parent isn't kept
+	} else if tree == headTree {
+		return nil
+	}
+	sha, err := writeCommit(&Commit{
+		Tree:      tree,
+		Parents:   parents,
+		Author:    original.Author,
+		Committer: committer,
+		Message:   original.Message,
+	})
+	if err != nil {
+		return err
+	}
+	return detachHead(sha, "rebase ("+command+"): "+original.Subject())
+}
+
+func rebaseFinish() error {
+	headName := readRebaseState("head-name")
+	if branch, ok := strings.CutPrefix(headName, "refs/heads/"); ok {
+		head, err := headCommit()
+		if err != nil {
+			return err
+		}
+		if err := updateRef(headName, head, "rebase (finish): "+headName+" onto "+readRebaseState("onto")); err != nil {
+			return err
+		}
+		if err := setHead(branch, "rebase (finish): returning to "+headName); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(rebaseDir); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Successfully rebased and updated %s.\n", headName)
+	return nil
+}
+
+// rebaseContinue commits the resolution of the commit that stopped, then carries on
+func rebaseContinue() error {
+	if stopped := readRebaseState("stopped-sha"); stopped != "" {
+		entries, err := readIndex()
+		if err != nil {
+			return err
+		}
+		tree, err := indexTree(entries)
+		if err == errUnmerged {
+			return errors.New("You must edit all merge conflicts and then\n" +
+				"mark them as resolved using mygit add")
+		} else if err != nil {
+			return err
+		}
+		if err := rerereRecordResolutions(); err != nil {
+			return err
+		}
+		original, err := readCommit(stopped)
+		if err != nil {
+			return err
+		}
+		done := strings.Split(readRebaseState("done"), "\n")
+		command := "pick"
+		if fields := strings.Fields(done[len(done)-1]); len(fields) > 0 {
+			command = fields[0]
+		}
+		if err := rebaseCommit(command, stopped, original, tree); err != nil {
+			return err
+		}
+		os.Remove(path.Join(rebaseDir, "stopped-sha"))
+	}
+	return rebaseRun()
+}
+
+// rebaseSkip throws away the commit that stopped and carries on with the next
+func rebaseSkip() error {
+	head, err := headCommit()
+	if err != nil {
+		return err
+	}
+	headTree, err := commitTree(head)
+	if err != nil {
+		return err
+	}
+	if err := resetWorktree(headTree); err != nil {
+		return err
+	}
+	if err := rerereClear(); err != nil {
+		return err
+	}
+	os.Remove(path.Join(rebaseDir, "stopped-sha"))
+	return rebaseRun()
+}
+
+// rebaseAbort returns the branch, index and working tree to where they were before the rebase
+func rebaseAbort() error {
+	origHead := readRebaseState("orig-head")
+	origTree, err := commitTree(origHead)
+	if err != nil {
+		return err
+	}
+	if err := resetWorktree(origTree); err != nil {
+		return err
+	}
+	if err := rerereClear(); err != nil {
+		return err
+	}
+	headName := readRebaseState("head-name")
+	if branch, ok := strings.CutPrefix(headName, "refs/heads/"); ok {
+		if err := updateRef(headName, origHead, ""); err != nil {
+			return err
+		}
+		err = setHead(branch, "rebase (abort): returning to "+headName)
+	} else {
+		err = detachHead(origHead, "rebase (abort): returning to "+origHead)
+	}
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(rebaseDir)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+/*
+An interactive rebase lets the user edit the todo list before anything is replayed. Each line
+is a command and the commit it applies to: pick replays it as it is, reword replays it with a
+new message, squash and fixup fold it into the commit before, with or without its message, and
+drop leaves it out. Lines are read back with their commits' full SHAs, so the list in
+rebase-merge always names them exactly.
+
+A run of squashes and fixups is tracked in current-fixups, one "<command> <sha>" line per
+commit, starting with the commit they are folded into, so that the combined message can be
+put together again once the run ends, even after a conflict stopped it halfway.
+*/
+
+// rebaseCommands maps the commands, and their one-letter abbreviations, to their full names
+var rebaseCommands = map[string]string{
+	"p": "pick", "pick": "pick",
+	"r": "reword", "reword": "reword",
+	"s": "squash", "squash": "squash",
+	"f": "fixup", "fixup": "fixup",
+	"d": "drop", "drop": "drop",
+}
+
+const rebaseTodoHelp = `
+# Commands:
+# p, pick <commit> = use commit
+# r, reword <commit> = use commit, but edit the commit message
+# s, squash <commit> = use commit, but meld into previous commit
+# f, fixup <commit> = like "squash" but keep only the previous
+#                    commit's log message
+# d, drop <commit> = remove commit
+#
+# These lines can be re-ordered; they are executed from top to bottom.
+#
+# If you remove a line here THAT COMMIT WILL BE LOST.
+#
+# However, if you remove everything, the rebase will be aborted.
+#
+`
+
+// editRebaseTodo has the user edit the todo list in the editor and returns it as edited.
+// upstream, head and onto only describe the rebase in the help underneath.
+func editRebaseTodo(todo []string, upstream string, head string, onto string) ([]string, error) {
+	var b strings.Builder
+	for _, line := range todo {
+		fields := strings.SplitN(line, " ", 3)
+		fields[1] = fields[1][:7]
+		b.WriteString(strings.Join(fields, " ") + "\n")
+	}
+	if len(todo) == 0 {
+		b.WriteString("noop\n")
+	}
+	if upstream == "" {
+		upstream = onto
+	}
+	commands := "commands"
+	if len(todo) == 1 {
+		commands = "command"
+	}
+	fmt.Fprintf(&b, "\n# Rebase %s..%s onto %s (%d %s)\n#%s", upstream[:7], head[:7], onto[:7], len(todo), commands, rebaseTodoHelp)
+	todoPath := path.Join(rebaseDir, "git-rebase-todo")
+	if err := os.WriteFile(todoPath, []byte(b.String()), 0644); err != nil {
+		return nil, err
+	}
+	if err := launchEditor(todoPath); err != nil {
+		return nil, err
+	}
+	edited, err := os.ReadFile(todoPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseRebaseTodo(string(edited))
+}
+
+// parseRebaseTodo reads an edited todo list, skipping comments and blank lines, with each
+// command written out in full and each commit by its full SHA
+func parseRebaseTodo(text string) ([]string, error) {
+	var todo []string
+	for n, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || line == "noop" {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 3)
+		command := rebaseCommands[fields[0]]
+		if command == "" || len(fields) < 2 {
+			return nil, fmt.Errorf("invalid line %d: %s", n+1, line)
+		}
+		sha, err := resolveObjectArg(fields[1])
+		if err == nil {
+			sha, err = peelToCommit(sha)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid line %d: %s", n+1, line)
+		}
+		if len(todo) == 0 && (command == "squash" || command == "fixup") {
+			return nil, fmt.Errorf("cannot '%s' without a previous commit", command)
+		}
+		fields[0], fields[1] = command, sha
+		todo = append(todo, strings.Join(fields, " "))
+	}
+	if len(todo) == 0 {
+		return nil, errors.New("nothing to do")
+	}
+	return todo, nil
+}
+
+// rebaseCommit records tree, the result of replaying the commit sha, the way command says to
+func rebaseCommit(command string, sha string, original *Commit, tree string) error {
+	switch command {
+	case "squash", "fixup":
+		return rebaseSquash(command, sha, tree)
+	case "reword":
+		os.Remove(path.Join(rebaseDir, "current-fixups"))
+		message, err := editRebaseMessage(original.Message)
+		if err != nil {
+			return err
+		}
+		reworded := *original
+		reworded.Message = message
+		return commitReplayed(&reworded, tree, command)
+	default:
+		os.Remove(path.Join(rebaseDir, "current-fixups"))
+		return commitReplayed(original, tree, command)
+	}
+}
+
+// rebaseSquash replaces HEAD with a commit of tree that has HEAD's parents and author, adding
+// the commit sha to the run of squashes and fixups. The message is HEAD's with, for a squash,
+// the commit's appended; when the run ends and had a squash in it, the user edits it.
+func rebaseSquash(command string, sha string, tree string) error {
+	head, err := headCommit()
+	if err != nil {
+		return err
+	}
+	if head == readRebaseState("squash-onto") {
+		return fmt.Errorf("cannot '%s' without a previous commit", command)
+	}
+	previous, err := readCommit(head)
+	if err != nil {
+		return err
+	}
+	fixups := readRebaseState("current-fixups")
+	if fixups == "" {
+		fixups = "pick " + head
+	}
+	fixups += "\n" + command + " " + sha
+	if err := writeRebaseState("current-fixups", fixups); err != nil {
+		return err
+	}
+
+	template, squashed, err := squashMessageTemplate(strings.Split(fixups, "\n"))
+	if err != nil {
+		return err
+	}
+	message := stripMessageSpace(cleanupMessage(template))
+	next := strings.Fields(readRebaseState("git-rebase-todo"))
+	if squashed && (len(next) == 0 || (next[0] != "squash" && next[0] != "fixup")) {
+		template += "\n# Please enter the commit message for your changes. Lines starting\n" +
+			"# with '#' will be ignored, and an empty message aborts the commit.\n"
+		if message, err = editCommitMessage(template); err != nil {
+			return err
+		}
+	}
+	committer, err := signature("committer")
+	if err != nil {
+		return err
+	}
+	commit := &Commit{
+		Tree:      tree,
+		Parents:   previous.Parents,
+		Author:    previous.Author,
+		Committer: committer,
+		Message:   message,
+	}
+	squashedSHA, err := writeCommit(commit)
+	if err != nil {
+		return err
+	}
+	return detachHead(squashedSHA, "rebase ("+command+"): "+commit.Subject())
+}
+
+// squashMessageTemplate puts together the message of a run of squashes and fixups the way git
+// presents it, each commit's message under a comment, the fixups' commented out. It also
+// reports whether there was a squash among them.
+func squashMessageTemplate(fixups []string) (string, bool, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# This is a combination of %d commits.\n", len(fixups))
+	squashed := false
+	for i, line := range fixups {
+		command, sha, _ := strings.Cut(line, " ")
+		commit, err := readCommit(sha)
+		if err != nil {
+			return "", false, err
+		}
+		message := strings.TrimRight(commit.Message, "\n")
+		switch {
+		case i == 0:
+			fmt.Fprintf(&b, "# This is the 1st commit message:\n\n%s\n", message)
+		case command == "fixup":
+			fmt.Fprintf(&b, "\n# The commit message #%d will be skipped:\n\n", i+1)
+			for _, messageLine := range strings.Split(message, "\n") {
+				b.WriteString(strings.TrimRight("# "+messageLine, " ") + "\n")
+			}
+		default:
+			squashed = true
+			fmt.Fprintf(&b, "\n# This is the commit message #%d:\n\n%s\n", i+1, message)
+		}
+	}
+	return b.String(), squashed, nil
+}
+
+// editRebaseMessage has the user reword a commit's message in the editor
+func editRebaseMessage(message string) (string, error) {
+	return editCommitMessage(message + "\n# Please enter the commit message for your changes. Lines starting\n" +
+		"# with '#' will be ignored, and an empty message aborts the commit.\n")
+}
+
+// editCommitMessage opens template in the editor as COMMIT_EDITMSG and returns the message
+// written there, failing when it is empty
+func editCommitMessage(template string) (string, error) {
+	editPath := path.Join(".git", "COMMIT_EDITMSG")
+	if err := os.WriteFile(editPath, []byte(template), 0644); err != nil {
+		return "", err
+	}
+	if err := launchEditor(editPath); err != nil {
+		return "", err
+	}
+	edited, err := os.ReadFile(editPath)
+	if err != nil {
+		return "", err
+	}
+	message := stripMessageSpace(cleanupMessage(string(edited)))
+	if message == "" {
+		return "", errors.New("Aborting commit due to empty commit message.")
+	}
+	return message, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// refFilter selects refs by how their commits relate to others: --merged keeps those
+// reachable from one of the given commits, --no-merged those reachable from none of them, and
+// --contains those that can reach one of them. Refs that don't point at a commit, even
+// through a tag, are dropped once any of these is given.
+type refFilter struct {
+	merged, noMerged, contains []string //revisions as given
+
+	mergedInto    map[string]*Commit
+	notMergedInto map[string]*Commit
+	containsSHAs  []string
+}
+
+// parseOption takes a filter option at args[i], returning how many arguments it used, or 0
+// when it isn't one. Like git, "--merged <commit>" takes the next argument as the commit,
+// unless it is the last argument and so means HEAD.
+func (f *refFilter) parseOption(args []string, i int) int {
+	for _, option := range []struct {
+		name string
+		revs *[]string
+	}{{"--merged", &f.merged}, {"--no-merged", &f.noMerged}, {"--contains", &f.contains}} {
+		switch {
+		case args[i] == option.name && i+1 < len(args):
+			*option.revs = append(*option.revs, args[i+1])
+			return 2
+		case args[i] == option.name:
+			*option.revs = append(*option.revs, "HEAD")
+			return 1
+		case strings.HasPrefix(args[i], option.name+"="):
+			*option.revs = append(*option.revs, strings.TrimPrefix(args[i], option.name+"="))
+			return 1
+		}
+	}
+	return 0
+}
+
+func (f *refFilter) active() bool {
+	return len(f.merged) > 0 || len(f.noMerged) > 0 || len(f.contains) > 0
+}
+
+// resolveFilterCommits resolves the revisions given to a filter to commits
+func resolveFilterCommits(revs []string) ([]string, error) {
+	var shas []string
+	for _, rev := range revs {
+		sha, err := resolveRevision(rev)
+		if err == nil {
+			sha, err = peelToCommit(sha)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed object name %s", rev)
+		}
+		shas = append(shas, sha)
+	}
+	return shas, nil
+}
+
+// prepare resolves the filter's commits and walks the history --merged and --no-merged need
+func (f *refFilter) prepare() error {
+	merged, err := resolveFilterCommits(f.merged)
+	if err != nil {
+		return err
+	}
+	noMerged, err := resolveFilterCommits(f.noMerged)
+	if err != nil {
+		return err
+	}
+	if f.containsSHAs, err = resolveFilterCommits(f.contains); err != nil {
+		return err
+	}
+	if len(merged) > 0 {
+		if f.mergedInto, err = ancestors(merged); err != nil {
+			return err
+		}
+	}
+	if len(noMerged) > 0 {
+		if f.notMergedInto, err = ancestors(noMerged); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches reports whether a ref pointing at sha passes the filter; prepare must come first
+func (f *refFilter) matches(sha string) (bool, error) {
+	if !f.active() {
+		return true, nil
+	}
+	commit, err := peelToCommit(sha)
+	if err != nil {
+		return false, nil
+	}
+	if f.mergedInto != nil && f.mergedInto[commit] == nil {
+		return false, nil
+	}
+	if f.notMergedInto != nil && f.notMergedInto[commit] != nil {
+		return false, nil
+	}
+	if len(f.containsSHAs) == 0 {
+		return true, nil
+	}
+	reachable, err := ancestors([]string{commit})
+	if err != nil {
+		return false, err
+	}
+	for _, sha := range f.containsSHAs {
+		if reachable[sha] != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+/*
+Every move of HEAD and of a branch is appended to the ref's log under .git/logs, one line each:
+"<old-sha> <new-sha> <name> <<email>> <timestamp> <offset>\t<message>", with forty zeros for the
+old SHA of a ref that didn't exist. The message says what moved the ref, "<action>: <details>"
+as git words it, such as "commit: <subject>" or "checkout: moving from main to topic". As in a
+non-bare git repository, logs are started for HEAD and refs under refs/heads, refs/remotes and
+refs/notes; core.logAllRefUpdates=false only keeps up the logs that exist already, and "always"
+starts them for every ref.
+*/
+
+// logRefUpdate appends a move of ref from old to new to its reflog. Nothing is logged for an
+// empty message, or when no committer identity is set, where git would make one up.
+func logRefUpdate(ref string, old string, new string, message string) error {
+	if message == "" {
+		return nil
+	}
+	logPath := path.Join(".git", "logs", ref)
+	if !fileExists(logPath) && !autoCreateReflog(ref) {
+		return nil
+	}
+	committer, err := signature("committer")
+	if err != nil {
+		return nil
+	}
+	if old == "" {
+		old = strings.Repeat("0", 40)
+	}
+	message, _, _ = strings.Cut(message, "\n")
+	if err := os.MkdirAll(path.Dir(logPath), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "%s %s %s\t%s\n", old, new, committer, message)
+	return err
+}
+
+// autoCreateReflog reports whether a ref without a reflog gets one when it moves
+func autoCreateReflog(ref string) bool {
+	setting, _ := configGet("core.logAllRefUpdates")
+	switch strings.ToLower(setting) {
+	case "always":
+		return true
+	case "false", "no", "off", "0":
+		return false
+	}
+	return ref == "HEAD" || strings.HasPrefix(ref, "refs/heads/") ||
+		strings.HasPrefix(ref, "refs/remotes/") || strings.HasPrefix(ref, "refs/notes/")
+}
+
+// headDescription is how a reflog message names where HEAD is: the current branch, or the
+// commit it is detached at
+func headDescription() string {
+	_, target, _ := ReadHEAD(".git")
+	return strings.TrimPrefix(target, "refs/heads/")
+}
+
+// reflogRevision resolves "<ref>@{<n>}", the value ref had n moves ago, 0 being its current
+// one. A bare "@{<n>}" means the current branch's, or HEAD's when it is detached. ok is false
+// when name isn't of that form.
+func reflogRevision(name string) (sha string, ok bool, err error) {
+	at := strings.LastIndex(name, "@{")
+	if at < 0 || !strings.HasSuffix(name, "}") {
+		return "", false, nil
+	}
+	n, err := strconv.Atoi(name[at+2 : len(name)-1])
+	if err != nil || n < 0 {
+		return "", false, nil
+	}
+	ref, refName := "HEAD", name[:at]
+	switch {
+	case refName == "":
+		if branch, _ := currentBranch(); branch != "" {
+			ref = path.Join("refs", "heads", branch)
+		}
+	case refName != "HEAD":
+		var found bool
+		if ref, found = fullRefName(refName); !found {
+			return "", true, fmt.Errorf("unknown revision %s", name)
+		}
+	}
+	entries, err := readReflog(ref)
+	if err != nil {
+		return "", true, err
+	}
+	if n >= len(entries) {
+		return "", true, fmt.Errorf("log for '%s' only has %d entries", refName, len(entries))
+	}
+	return entries[len(entries)-1-n].new, true, nil
+}
+
+// Usage: mygit reflog [show] [<ref>]
+//
+// Lists where a ref has been, HEAD by default, newest first, as "<sha> <ref>@{<n>}: <message>":
+// the commit the ref moved to and what moved it there. "<ref>@{<n>}" can be given to any
+// command that takes a commit, to get back to a commit that no branch points at anymore.
+func cmdReflog(args []string) {
+	usage := "usage: mygit reflog [show] [<ref>]\n"
+	if len(args) > 0 && args[0] == "show" {
+		args = args[1:]
+	}
+	if len(args) > 1 || len(args) == 1 && strings.HasPrefix(args[0], "-") {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(129)
+	}
+	name := "HEAD"
+	if len(args) == 1 {
+		name = args[0]
+	}
+	ref, ok := fullRefName(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "fatal: ambiguous argument '%s': unknown revision or path not in the working tree.\n"+
+			"Use '--' to separate paths from revisions, like this:\n"+
+			"'mygit <command> [<revision>...] -- [<file>...]'\n", name)
+		os.Exit(128)
+	}
+	entries, err := readReflog(ref)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		fmt.Printf("%s %s@{%d}: %s\n", entries[i].new[:7], name, len(entries)-1-i, entries[i].message)
+	}
+}
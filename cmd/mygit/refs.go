@@ -0,0 +1,442 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// readRef returns the SHA a ref points at, following symbolic refs like HEAD
+func readRef(ref string) (string, error) {
+	for depth := 0; depth < 5; depth++ {
+		contents, err := os.ReadFile(path.Join(".git", ref))
+		if os.IsNotExist(err) && strings.HasPrefix(ref, "refs/") {
+			if sha, ok := packedRefSHA(ref); ok {
+				return sha, nil
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		value := strings.TrimSpace(string(contents))
+		if !strings.HasPrefix(value, "ref: ") {
+			// FETCH_HEAD holds a line per fetched ref; it names the first one
+			if fields := strings.Fields(value); len(fields) > 0 {
+				return fields[0], nil
+			}
+			return value, nil
+		}
+		ref = strings.TrimPrefix(value, "ref: ")
+	}
+	return "", fmt.Errorf("symbolic ref %s nested too deeply", ref)
+}
+
+// updateRef points ref at sha, recording the move in the ref's reflog with message unless
+// message is empty
+func updateRef(ref string, sha string, message string) error {
+	refPath := path.Join(".git", ref)
+	old, _ := readRef(ref)
+	if err := os.MkdirAll(path.Dir(refPath), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(refPath, []byte(sha+"\n"), 0644); err != nil {
+		return err
+	}
+	return logRefUpdate(ref, old, sha, message)
+}
+
+// ReadHEAD reads HEAD in the git directory gitDir. When it names a branch, isBranch is set and
+// target is the branch's ref, such as refs/heads/main, whether or not the branch has any
+// commits yet; when HEAD is detached, target is the SHA it holds.
+func ReadHEAD(gitDir string) (isBranch bool, target string, err error) {
+	contents, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return false, "", err
+	}
+	value := strings.TrimSpace(string(contents))
+	if ref, ok := strings.CutPrefix(value, "ref: "); ok {
+		return true, ref, nil
+	}
+	if !isHexSHA(value) {
+		return false, "", fmt.Errorf("invalid HEAD: %q", value)
+	}
+	return false, value, nil
+}
+
+// currentBranch returns the branch HEAD points at, or "" when HEAD is detached
+func currentBranch() (string, error) {
+	isBranch, target, err := ReadHEAD(".git")
+	if err != nil || !isBranch || !strings.HasPrefix(target, "refs/heads/") {
+		return "", err
+	}
+	return strings.TrimPrefix(target, "refs/heads/"), nil
+}
+
+// headCommit returns the commit HEAD resolves to, or "" on an unborn branch
+func headCommit() (string, error) {
+	isBranch, target, err := ReadHEAD(".git")
+	if err != nil || !isBranch {
+		return target, err
+	}
+	sha, err := readRef(target)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return sha, err
+}
+
+// setHead points HEAD at a branch, logging the move in HEAD's reflog with message, unless it
+// is empty or the branch has no commits yet
+func setHead(branch string, message string) error {
+	old, _ := headCommit()
+	if err := os.WriteFile(path.Join(".git", "HEAD"), []byte("ref: refs/heads/"+branch+"\n"), 0644); err != nil {
+		return err
+	}
+	if sha, err := readRef(path.Join("refs", "heads", branch)); err == nil {
+		return logRefUpdate("HEAD", old, sha, message)
+	}
+	return nil
+}
+
+func branchExists(branch string) bool {
+	return refExists(path.Join("refs", "heads", branch))
+}
+
+// refExists reports whether a ref is present, loose or packed
+func refExists(ref string) bool {
+	if fileExists(path.Join(".git", ref)) {
+		return true
+	}
+	_, ok := packedRefSHA(ref)
+	return ok
+}
+
+// deleteRef removes a ref wherever it is stored: the loose file first, then its entry in
+// packed-refs, so an older packed value can't show through once the loose one is gone
+func deleteRef(ref string) error {
+	os.Remove(path.Join(".git", "logs", ref)) //its history goes with it
+	err := os.Remove(path.Join(".git", ref))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	loose := err == nil
+	packed, err := deletePackedRef(ref)
+	if err != nil {
+		return err
+	}
+	if !loose && !packed {
+		return fmt.Errorf("ref %s not found", ref)
+	}
+	return nil
+}
+
+// detachHead points HEAD straight at a commit, logging the move in HEAD's reflog with message
+// unless it is empty
+func detachHead(sha string, message string) error {
+	old, _ := headCommit()
+	if err := os.WriteFile(path.Join(".git", "HEAD"), []byte(sha+"\n"), 0644); err != nil {
+		return err
+	}
+	return logRefUpdate("HEAD", old, sha, message)
+}
+
+func isHexSHA(name string) bool {
+	return len(name) == 40 && isHex(name)
+}
+
+// isHex reports whether name is made up only of lowercase hex digits
+func isHex(name string) bool {
+	for _, c := range name {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// validBranchName applies git's ref name rules (see git check-ref-format) to refs/heads/<name>
+func validBranchName(name string) bool {
+	if name == "" || name == "HEAD" || name[0] == '-' || strings.HasSuffix(name, ".lock") ||
+		strings.HasSuffix(name, ".") || strings.Contains(name, "..") || strings.Contains(name, "@{") {
+		return false
+	}
+	for _, component := range strings.Split(name, "/") {
+		if component == "" || component[0] == '.' {
+			return false
+		}
+	}
+	for _, c := range name {
+		if c < 0o40 || c == 0o177 || strings.ContainsRune(" ~^:?*[\\", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// isPseudoRef matches top-level refs such as HEAD, ORIG_HEAD and FETCH_HEAD
+func isPseudoRef(name string) bool {
+	return name != "" && strings.Trim(name, "ABCDEFGHIJKLMNOPQRSTUVWXYZ_") == ""
+}
+
+// resolveRef turns HEAD, a ref name, a branch or tag name, or a full SHA into an object SHA
+func resolveRef(name string) (string, error) {
+	if isHexSHA(name) {
+		if hasObject(name) {
+			return name, nil
+		}
+	}
+	for _, ref := range refCandidates(name) {
+		if sha, err := readRef(ref); err == nil {
+			return sha, nil
+		}
+	}
+	if len(name) >= minAbbrev && len(name) < 40 && isHex(strings.ToLower(name)) {
+		sha, err := resolveObject(name)
+		if errors.As(err, &ambiguousObjectError{}) {
+			return "", err
+		} else if err == nil {
+			return sha, nil
+		}
+	}
+	if name == "HEAD" {
+		if branch, err := currentBranch(); err == nil && branch != "" {
+			return "", unbornBranchError{branch}
+		}
+	}
+	return "", fmt.Errorf("unknown revision %s", name)
+}
+
+// unbornBranchError is resolving HEAD on a branch that has no commits yet
+type unbornBranchError struct {
+	branch string
+}
+
+func (e unbornBranchError) Error() string {
+	return fmt.Sprintf("your current branch '%s' does not have any commits yet", e.branch)
+}
+
+// resolveRevision resolves a ref or SHA followed by any number of ancestry suffixes: "^" or
+// "^<n>" for the first or nth parent, "~<n>" for the nth first-parent ancestor, and "^{<type>}"
+// or "^{}" to peel tags, see peelObject
+func resolveRevision(rev string) (string, error) {
+	end := strings.IndexAny(rev, "^~")
+	if end < 0 {
+		end = len(rev)
+	}
+	sha, ok, err := reflogRevision(rev[:end])
+	if !ok {
+		sha, err = resolveRef(rev[:end])
+	}
+	if err != nil {
+		return "", err
+	}
+	for rest := rev[end:]; rest != ""; {
+		op := rest[0]
+		rest = rest[1:]
+		if op == '^' && strings.HasPrefix(rest, "{") {
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				return "", fmt.Errorf("unknown revision %s", rev)
+			}
+			if sha, err = peelObject(sha, rest[1:end]); err != nil {
+				return "", err
+			}
+			rest = rest[end+1:]
+			continue
+		}
+		digits := len(rest) - len(strings.TrimLeft(rest, "0123456789"))
+		n := 1
+		if digits > 0 {
+			if n, err = strconv.Atoi(rest[:digits]); err != nil {
+				return "", fmt.Errorf("unknown revision %s", rev)
+			}
+			rest = rest[digits:]
+		}
+		if op != '^' && op != '~' {
+			return "", fmt.Errorf("unknown revision %s", rev)
+		}
+		if sha, err = peelToCommit(sha); err != nil {
+			return "", err
+		}
+		if op == '^' && n > 1 {
+			// nth parent, rather than n generations back
+			commit, err := readCommit(sha)
+			if err != nil {
+				return "", err
+			}
+			if n > len(commit.Parents) {
+				return "", fmt.Errorf("unknown revision %s", rev)
+			}
+			sha = commit.Parents[n-1]
+			continue
+		}
+		if op == '^' && n == 0 {
+			continue //the commit itself
+		}
+		for ; n > 0; n-- {
+			commit, err := readCommit(sha)
+			if err != nil {
+				return "", err
+			}
+			if len(commit.Parents) == 0 {
+				return "", fmt.Errorf("unknown revision %s", rev)
+			}
+			sha = commit.Parents[0]
+		}
+	}
+	return sha, nil
+}
+
+// peelObject follows tags from sha to an object of objType, as "<rev>^{<type>}" does: commit
+// or tree, which a commit peels to as well, blob or tag, or object for sha itself. An empty
+// objType means whatever object the tags end at.
+func peelObject(sha string, objType string) (string, error) {
+	switch objType {
+	case "commit":
+		return peelToCommit(sha)
+	case "tree":
+		return peelToTree(sha)
+	case "object":
+		return sha, nil
+	case "", "blob", "tag":
+	default:
+		return "", fmt.Errorf("unknown revision %s^{%s}", sha, objType)
+	}
+	for {
+		found, contents, err := parseObject(sha)
+		if err != nil {
+			return "", err
+		}
+		if found == objType || found != "tag" && objType == "" {
+			return sha, nil
+		}
+		if found != "tag" {
+			return "", fmt.Errorf("%s is a %s, not a %s", sha, found, objType)
+		}
+		sha = tagTarget(contents)
+	}
+}
+
+// resolveObjectSpec resolves a revision, or a "<rev>:<path>" naming an entry in that revision's tree
+func resolveObjectSpec(spec string) (string, error) {
+	rev, entryPath, hasPath := strings.Cut(spec, ":")
+	sha, err := resolveRevision(rev)
+	if err != nil || !hasPath {
+		return sha, err
+	}
+	treeSha, err := peelToTree(sha)
+	if err != nil {
+		return "", err
+	}
+	entry, err := lookupTreePath(treeSha, entryPath)
+	if err != nil {
+		return "", fmt.Errorf("path '%s' does not exist in '%s'", entryPath, rev)
+	}
+	return entry.sha, nil
+}
+
+// listRefs returns every ref under prefix (e.g. "refs/heads") with the SHA it holds. Loose
+// refs take precedence over packed ones of the same name.
+func listRefs(prefix string) (map[string]string, error) {
+	refs := map[string]string{}
+	_, packed, err := readPackedRefs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range packed {
+		if strings.HasPrefix(ref.name, prefix+"/") {
+			refs[ref.name] = ref.sha
+		}
+	}
+	err = filepath.WalkDir(path.Join(".git", prefix), func(p string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name := strings.TrimPrefix(filepath.ToSlash(p), ".git/")
+		sha, err := readRef(name)
+		if err != nil {
+			return err
+		}
+		refs[name] = sha
+		return nil
+	})
+	return refs, err
+}
+
+// updateHead moves the current branch to sha, or HEAD itself when it is detached, logging the
+// move with message in the reflogs of both unless it is empty
+func updateHead(sha string, message string) error {
+	branch, err := currentBranch()
+	if err != nil {
+		return err
+	}
+	if branch == "" {
+		return detachHead(sha, message)
+	}
+	old, _ := headCommit()
+	if err := updateRef(path.Join("refs", "heads", branch), sha, message); err != nil {
+		return err
+	}
+	return logRefUpdate("HEAD", old, sha, message)
+}
+
+// refCandidates are the refs a name might mean, in the order git looks them up: exact, refs/,
+// tags, heads, remotes
+func refCandidates(name string) []string {
+	var refs []string
+	for _, ref := range []string{
+		name,
+		path.Join("refs", name),
+		path.Join("refs", "tags", name),
+		path.Join("refs", "heads", name),
+		path.Join("refs", "remotes", name),
+	} {
+		if strings.HasPrefix(ref, "refs/") || isPseudoRef(ref) {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// fullRefName finds the ref a short name such as "main" or "origin/main" refers to
+func fullRefName(name string) (string, bool) {
+	for _, ref := range refCandidates(name) {
+		if refExists(ref) {
+			return ref, true
+		}
+	}
+	return "", false
+}
+
+// reflogEntry is one line of a ref's log in .git/logs: a change of the ref from old to new
+type reflogEntry struct {
+	old, new  string
+	signature string //who made the change and when
+	message   string
+}
+
+// readReflog reads the log of a ref's past values, oldest first; a ref without one has none
+func readReflog(ref string) ([]reflogEntry, error) {
+	contents, err := os.ReadFile(path.Join(".git", "logs", ref))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var entries []reflogEntry
+	for _, line := range strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n") {
+		fields, message, _ := strings.Cut(line, "\t")
+		if parts := strings.SplitN(fields, " ", 3); len(parts) == 3 {
+			entries = append(entries, reflogEntry{old: parts[0], new: parts[1], signature: parts[2], message: message})
+		}
+	}
+	return entries, nil
+}
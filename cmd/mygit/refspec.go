@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+A refspec maps remote refs to local ones, e.g. "+refs/heads/*:refs/remotes/origin/*". A leading
+"+" allows non-fast-forward updates, and a "*" in the source matches any run of characters,
+which is substituted for the "*" in the destination. An empty destination fetches without
+storing anything but FETCH_HEAD.
+*/
+
+type refspec struct {
+	force bool
+	src   string
+	dst   string
+}
+
+func parseRefspec(spec string) (refspec, error) {
+	var r refspec
+	if strings.HasPrefix(spec, "+") {
+		r.force = true
+		spec = spec[1:]
+	}
+	r.src, r.dst, _ = strings.Cut(spec, ":")
+	if r.src == "" {
+		return r, fmt.Errorf("invalid refspec '%s'", spec)
+	}
+	if strings.Count(r.src, "*") > 1 || strings.Count(r.dst, "*") != strings.Count(r.src, "*") && r.dst != "" {
+		return r, fmt.Errorf("invalid refspec '%s'", spec)
+	}
+	return r, nil
+}
+
+func (r refspec) isGlob() bool {
+	return strings.Contains(r.src, "*")
+}
+
+// mapRef returns the local ref a remote ref is stored under, and whether the refspec matches it
+// at all. A non-glob source may be a short name, which matches under refs/, refs/heads/ and
+// refs/tags/ like on the command line.
+func (r refspec) mapRef(name string) (string, bool) {
+	if r.isGlob() {
+		prefix, suffix, _ := strings.Cut(r.src, "*")
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) || len(name) < len(prefix)+len(suffix) {
+			return "", false
+		}
+		match := name[len(prefix) : len(name)-len(suffix)]
+		return strings.Replace(r.dst, "*", match, 1), true
+	}
+	for _, candidate := range []string{r.src, "refs/" + r.src, "refs/heads/" + r.src, "refs/tags/" + r.src} {
+		if name == candidate {
+			return r.dst, true
+		}
+	}
+	return "", false
+}
+
+// trackingRef returns the local ref a remote's fetch refspecs store one of its refs under, or ""
+func trackingRef(remote string, ref string) string {
+	for _, spec := range configGetAll("remote." + remote + ".fetch") {
+		r, err := parseRefspec(spec)
+		if err != nil {
+			continue
+		}
+		if local, ok := r.mapRef(ref); ok && local != "" {
+			return local
+		}
+	}
+	return ""
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type remoteRef struct {
+	sha  string
+	name string
+}
+
+// discoverRefs does the ref advertisement phase of the smart protocol for a service
+// (git-upload-pack or git-receive-pack) and returns the advertised refs and capabilities. Over
+// ssh or locally, the service started is kept for the request that follows.
+func discoverRefs(url string, service string) ([]remoteRef, []string, error) {
+	if !isHTTPURL(url) {
+		p, err := startService(url, service)
+		if err != nil {
+			return nil, nil, err
+		}
+		refs, caps, err := readAdvertisement(p.r)
+		if err != nil {
+			p.Close()
+			return nil, nil, err
+		}
+		openServices[url+" "+service] = p
+		return refs, caps, nil
+	}
+
+	resp, err := http.Get(url + "/info/refs?service=" + service)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("repository '%s' not found (HTTP %d)", url, resp.StatusCode)
+	}
+	if resp.Header.Get("Content-Type") != "application/x-"+service+"-advertisement" {
+		return nil, nil, dumbHTTPError{url}
+	}
+
+	r := bufio.NewReader(resp.Body)
+	line, err := readPktLine(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if string(bytes.TrimRight(line, "\n")) != "# service="+service {
+		return nil, nil, fmt.Errorf("invalid ref advertisement from %s", url)
+	}
+	if line, err = readPktLine(r); err != nil || line != nil {
+		return nil, nil, fmt.Errorf("invalid ref advertisement from %s", url)
+	}
+	return readAdvertisement(r)
+}
+
+// readAdvertisement reads the advertised refs, the first with the capabilities, up to the flush
+func readAdvertisement(r *bufio.Reader) ([]remoteRef, []string, error) {
+	var refs []remoteRef
+	var caps []string
+	for {
+		line, err := readPktLine(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil, errHungUp
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if line == nil {
+			break
+		}
+		// the first line carries the capabilities after a NUL
+		refLine, capList, hasCaps := strings.Cut(strings.TrimRight(string(line), "\n"), "\x00")
+		if hasCaps {
+			caps = strings.Fields(capList)
+		}
+		sha, name, _ := strings.Cut(refLine, " ")
+		if name == "capabilities^{}" {
+			continue //empty repository
+		}
+		refs = append(refs, remoteRef{sha: sha, name: name})
+	}
+	return refs, caps, nil
+}
+
+func capabilityValue(caps []string, name string) (string, bool) {
+	for _, c := range caps {
+		if c == name {
+			return "", true
+		}
+		if value, ok := strings.CutPrefix(c, name+"="); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// fetchPack asks the remote's upload-pack for the wanted commits, telling it which ones we
+// already have, and returns the pack stream it sends back. A non-empty filter, such as
+// "blob:none", asks the remote to leave matching objects out of the pack.
+func fetchPack(url string, caps []string, wants []string, haves []string, filter string) (io.ReadCloser, error) {
+	var request bytes.Buffer
+	requested := []string{"ofs-delta", "agent=mygit/0.1"}
+	_, sideband := capabilityValue(caps, "side-band-64k")
+	if sideband {
+		requested = append(requested, "side-band-64k")
+	}
+	if filter != "" {
+		if _, ok := capabilityValue(caps, "filter"); ok {
+			requested = append(requested, "filter")
+		} else {
+			fmt.Fprintf(os.Stderr, "warning: filtering not recognized by server, ignoring\n")
+			filter = ""
+		}
+	}
+	for i, want := range wants {
+		if i == 0 {
+			writePktLine(&request, fmt.Sprintf("want %s %s\n", want, strings.Join(requested, " ")))
+		} else {
+			writePktLine(&request, fmt.Sprintf("want %s\n", want))
+		}
+	}
+	if filter != "" {
+		writePktLine(&request, fmt.Sprintf("filter %s\n", filter))
+	}
+	writeFlush(&request)
+	for _, have := range haves {
+		writePktLine(&request, fmt.Sprintf("have %s\n", have))
+	}
+	writePktLine(&request, "done\n")
+
+	body, err := postService(url, "git-upload-pack", &request)
+	if err != nil {
+		return nil, err
+	}
+
+	// skip the ACK/NAK negotiation lines; the pack follows
+	r := bufio.NewReader(body)
+	for {
+		line, err := readPktLine(r)
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		if bytes.HasPrefix(line, []byte("NAK")) || bytes.HasPrefix(line, []byte("ACK ")) {
+			if !sideband {
+				return readCloser{r, body}, nil
+			}
+			continue
+		}
+		s := &sidebandReader{r: r}
+		if err := s.demux(line); err != nil {
+			body.Close()
+			return nil, err
+		}
+		return readCloser{s, body}, nil
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// repository is where the repository containing the current directory lives
+type repository struct {
+	gitDir       string //absolute
+	workTree     string //absolute; "" for a bare repository
+	bare         bool
+	insideGitDir bool //the current directory is in gitDir rather than the working tree
+	cwd          string
+}
+
+var errNotRepository = errors.New("not a git repository (or any of the parent directories): .git")
+
+// discoverRepository finds the repository the current directory belongs to, looking for a .git
+// in it and then in each parent, the way git does. A directory that is itself a git directory,
+// such as a bare repository or a .git one, also counts.
+func discoverRepository() (*repository, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	for dir := cwd; ; dir = filepath.Dir(dir) {
+		if dotGit := filepath.Join(dir, ".git"); isGitDir(dotGit) {
+			return &repository{gitDir: dotGit, workTree: dir, cwd: cwd}, nil
+		}
+		if isGitDir(dir) {
+			repo := &repository{gitDir: dir, insideGitDir: true, cwd: cwd}
+			repo.bare = configFileBool(filepath.Join(dir, "config"), "core.bare")
+			if !repo.bare && filepath.Base(dir) == ".git" {
+				repo.workTree = filepath.Dir(dir)
+			}
+			return repo, nil
+		}
+		if dir == filepath.Dir(dir) {
+			return nil, errNotRepository
+		}
+	}
+}
+
+// cwdPrefix is the directory mygit was started in, relative to the top of the working tree,
+// with a trailing slash; setupRepository sets it, and it stays "" at the top
+var cwdPrefix string
+
+// setupRepository finds the repository the current directory belongs to and changes to the top
+// of its working tree, where commands find .git and the paths they keep, remembering the way
+// back in cwdPrefix. Outside any repository it fails as git does, unless gentle, when it leaves
+// the current directory alone for commands that can do without a repository.
+func setupRepository(gentle bool) {
+	repo, err := discoverRepository()
+	if err != nil {
+		if gentle && errors.Is(err, errNotRepository) {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	if repo.workTree == "" { //a bare repository has no working tree to go to
+		return
+	}
+	cwdPrefix = repo.prefix()
+	if err := os.Chdir(repo.workTree); err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: cannot change to '%s': %s\n", repo.workTree, err)
+		os.Exit(128)
+	}
+}
+
+// repoPath is a path given on the command line, which is relative to where mygit was started,
+// as a path from the top of the working tree
+func repoPath(p string) string {
+	if cwdPrefix == "" || path.IsAbs(p) {
+		return p
+	}
+	return path.Join(cwdPrefix, p)
+}
+
+// isGitDir reports whether dir looks like a git directory: HEAD, objects and refs
+func isGitDir(dir string) bool {
+	for _, name := range []string{"objects", "refs"} {
+		if fi, err := os.Stat(filepath.Join(dir, name)); err != nil || !fi.IsDir() {
+			return false
+		}
+	}
+	fi, err := os.Stat(filepath.Join(dir, "HEAD"))
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// configFileBool reads a boolean from one config file, false when it isn't set
+func configFileBool(filename string, key string) bool {
+	value := "false"
+	for _, entry := range readConfigFile(filename) {
+		if entry.key == normalizeConfigKey(key) {
+			value = entry.value
+		}
+	}
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true
+	}
+	return false
+}
+
+// prefix is the current directory relative to the top of the working tree, with a trailing
+// slash, or "" at the top or outside the working tree
+func (r *repository) prefix() string {
+	if r.insideGitDir || r.workTree == "" {
+		return ""
+	}
+	rel, err := filepath.Rel(r.workTree, r.cwd)
+	if err != nil || rel == "." {
+		return ""
+	}
+	return filepath.ToSlash(rel) + "/"
+}
+
+// gitDirDisplay is the git directory as rev-parse --git-dir shows it: relative when it is the
+// current directory or its .git, absolute otherwise
+func (r *repository) gitDirDisplay() string {
+	switch {
+	case r.gitDir == r.cwd:
+		return "."
+	case r.gitDir == filepath.Join(r.cwd, ".git"):
+		return ".git"
+	}
+	return r.gitDir
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCommandsFindTheRepositoryFromASubdirectory(t *testing.T) {
+	dir := initTestRepo(t)
+	writeTestFile(t, "top", "top\n")
+	writeTestFile(t, "d/a", "a\n")
+	writeTestFile(t, "d/e/b", "b\n")
+
+	chdirTest(t, "d")
+	runTestCommand(t, "add", "a", "e", "../top")
+	if _, err := os.Stat(".git"); err == nil {
+		t.Error("add in a subdirectory made d/.git")
+	}
+	if _, stderr, code := runMygit(t, "add", "missing"); code != 128 || !strings.Contains(stderr, "pathspec 'missing'") {
+		t.Errorf("add missing: exit %d, %q; want 128 and the pathspec as given", code, stderr)
+	}
+
+	chdirTest(t, dir)
+	entries, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var staged []string
+	for _, entry := range entries {
+		staged = append(staged, entry.path)
+	}
+	if got, want := strings.Join(staged, " "), "d/a d/e/b top"; got != want {
+		t.Errorf("index has %s, want %s", got, want)
+	}
+
+	chdirTest(t, t.TempDir())
+	if _, stderr, code := runMygit(t, "add", "a"); code != 128 || !strings.Contains(stderr, "not a git repository") {
+		t.Errorf("add outside a repository: exit %d, %q; want 128 and not a git repository", code, stderr)
+	}
+}
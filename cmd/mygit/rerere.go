@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+rerere ("reuse recorded resolution") remembers how conflicts were resolved, so the same conflict
+coming back, say when a topic branch is rebased again, is resolved the same way. Like git, it
+keeps:
+- .git/rr-cache/<id>/preimage: a conflicted file with its markers normalized
+- .git/rr-cache/<id>/postimage: the same file once resolved
+- .git/MERGE_RR: "<id>\t<path>\0" for each conflicted path of the merge in progress
+
+The id hashes the two sides of each conflict, in sorted order, leaving out the labels on the
+markers and everything outside them. So the conflict is recognized whichever branch is merged
+into which, and whatever the commits and the rest of the file look like.
+*/
+
+const (
+	rrCacheDir  = ".git/rr-cache"
+	mergeRRPath = ".git/MERGE_RR"
+)
+
+// rerereEnabled is rerere.enabled, on by default once an rr-cache exists
+func rerereEnabled() bool {
+	fi, err := os.Stat(rrCacheDir)
+	return configBool("rerere.enabled", err == nil && fi.IsDir())
+}
+
+// normalizeConflicts rewrites the conflicts in a file with bare markers and their sides in
+// sorted order, returning that, the conflict id and the number of conflicts. A diff3 base
+// section is dropped. Markers that don't pair up give -1: the file is neither a conflict
+// rerere can record nor resolved.
+func normalizeConflicts(contents []byte) ([]byte, string, int) {
+	var out bytes.Buffer
+	hash := sha1.New()
+	conflicts := 0
+	var sides [2]strings.Builder
+	state := 0 //0 outside a conflict, 1 in our side, 2 in the base, 3 in their side
+	for _, line := range splitLines(contents) {
+		marker := func(m string) bool {
+			return strings.HasPrefix(line, m) && (len(line) == 7 || strings.ContainsRune(" \n", rune(line[7])))
+		}
+		switch {
+		case state == 0 && marker("<<<<<<<"):
+			state = 1
+			sides[0].Reset()
+			sides[1].Reset()
+		case state == 1 && marker("|||||||"):
+			state = 2
+		case (state == 1 || state == 2) && marker("======="):
+			state = 3
+		case state == 3 && marker(">>>>>>>"):
+			state = 0
+			conflicts++
+			a, b := sides[0].String(), sides[1].String()
+			if a > b {
+				a, b = b, a
+			}
+			fmt.Fprintf(&out, "<<<<<<<\n%s=======\n%s>>>>>>>\n", a, b)
+			hash.Write([]byte(a + "\x00" + b + "\x00"))
+		case state == 0:
+			out.WriteString(line)
+		case state == 1:
+			sides[0].WriteString(line)
+		case state == 3:
+			sides[1].WriteString(line)
+		}
+	}
+	if state != 0 {
+		return nil, "", -1
+	}
+	return out.Bytes(), fmt.Sprintf("%x", hash.Sum(nil)), conflicts
+}
+
+// rerereEntry is a conflicted path rerere is tracking, and the id of its conflict
+type rerereEntry struct {
+	id   string
+	path string
+}
+
+func readMergeRR() []rerereEntry {
+	data, err := os.ReadFile(mergeRRPath)
+	if err != nil {
+		return nil
+	}
+	var entries []rerereEntry
+	for _, record := range strings.Split(string(data), "\x00") {
+		if id, filePath, ok := strings.Cut(record, "\t"); ok {
+			entries = append(entries, rerereEntry{id, filePath})
+		}
+	}
+	return entries
+}
+
+func writeMergeRR(entries []rerereEntry) error {
+	if len(entries) == 0 {
+		if err := os.Remove(mergeRRPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	var b strings.Builder
+	for _, entry := range entries {
+		fmt.Fprintf(&b, "%s\t%s\x00", entry.id, entry.path)
+	}
+	return writeFileAtomic(mergeRRPath, []byte(b.String()), 0644)
+}
+
+// trackRerereEntry adds an entry to the list, replacing any for the same path
+func trackRerereEntry(entries []rerereEntry, entry rerereEntry) []rerereEntry {
+	for i := range entries {
+		if entries[i].path == entry.path {
+			entries[i] = entry
+			return entries
+		}
+	}
+	return append(entries, entry)
+}
+
+func rrCacheFile(id string, name string) string {
+	return filepath.Join(rrCacheDir, id, name)
+}
+
+// rerereConflicts runs after a merge leaves conflicts in the working tree: a conflict with a
+// recorded resolution is resolved with it, and any other has its preimage recorded so its
+// resolution can be recorded later. Resolved files are left for the user to check and add.
+func rerereConflicts(conflicts []mergeConflict) error {
+	if !rerereEnabled() {
+		return nil
+	}
+	entries := readMergeRR()
+	for _, conflict := range conflicts {
+		if conflict.entries[1] != nil && conflict.entries[1].mode == 0o120000 {
+			continue
+		}
+		preimage, id, count := normalizeConflicts(conflict.contents)
+		if count <= 0 {
+			continue //not a conflict in the file's text, such as a modify/delete
+		}
+		entries = trackRerereEntry(entries, rerereEntry{id, conflict.path})
+
+		if postimage, err := os.ReadFile(rrCacheFile(id, "postimage")); err == nil {
+			recorded, err := os.ReadFile(rrCacheFile(id, "preimage"))
+			if err != nil {
+				recorded = preimage
+			}
+			// the conflicts match; the rest of the file may not, so apply the resolution as a merge
+			resolved, conflicts := mergeLines(recorded, preimage, postimage, "", "", favorNone)
+			if conflicts == 0 {
+				if err := os.WriteFile(conflict.path, resolved, 0644); err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stderr, "Resolved '%s' using previous resolution.\n", conflict.path)
+				continue
+			}
+		}
+		if err := os.MkdirAll(filepath.Join(rrCacheDir, id), 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(rrCacheFile(id, "preimage"), preimage, 0644); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Recorded preimage for '%s'\n", conflict.path)
+	}
+	return writeMergeRR(entries)
+}
+
+// rerereRecordResolutions records the resolution of each tracked path that no longer has
+// conflict markers, and stops tracking it
+func rerereRecordResolutions() error {
+	entries := readMergeRR()
+	if len(entries) == 0 {
+		return nil
+	}
+	var remaining []rerereEntry
+	for _, entry := range entries {
+		contents, err := os.ReadFile(entry.path)
+		if err != nil {
+			continue //deleted as the resolution; there is nothing to replay
+		}
+		if _, _, count := normalizeConflicts(contents); count != 0 {
+			remaining = append(remaining, entry)
+			continue
+		}
+		postimagePath := rrCacheFile(entry.id, "postimage")
+		previous, err := os.ReadFile(postimagePath)
+		if err == nil && bytes.Equal(previous, contents) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Join(rrCacheDir, entry.id), 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(postimagePath, contents, 0644); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Recorded resolution for '%s'.\n", entry.path)
+	}
+	return writeMergeRR(remaining)
+}
+
+// rerereClear forgets the conflicts of the merge in progress, along with the preimages of the
+// ones that were never resolved
+func rerereClear() error {
+	for _, entry := range readMergeRR() {
+		if _, err := os.Stat(rrCacheFile(entry.id, "postimage")); os.IsNotExist(err) {
+			if err := os.RemoveAll(filepath.Join(rrCacheDir, entry.id)); err != nil {
+				return err
+			}
+		}
+	}
+	return writeMergeRR(nil)
+}
+
+// rerereForget throws away the recorded resolutions of the conflicted paths matching
+// pathspecs, recording their conflicts afresh from the index so the next resolution is kept
+func rerereForget(pathspecs []string) error {
+	entries, err := readIndex()
+	if err != nil {
+		return err
+	}
+	stages := map[string]*[3][]byte{}
+	var paths []string
+	for _, entry := range entries {
+		if entry.stage() == 0 || !matchesPathspec(entry.path, pathspecs) {
+			continue
+		}
+		if stages[entry.path] == nil {
+			stages[entry.path] = &[3][]byte{}
+			paths = append(paths, entry.path)
+		}
+		_, contents, err := parseObject(entry.sha)
+		if err != nil {
+			return err
+		}
+		stages[entry.path][entry.stage()-1] = contents
+	}
+	if len(paths) == 0 {
+		return errors.New("no remembered resolution for the given paths, or they are not conflicted")
+	}
+	sort.Strings(paths)
+
+	tracked := readMergeRR()
+	for _, filePath := range paths {
+		sides := stages[filePath]
+		conflicted, _ := mergeLines(sides[0], sides[1], sides[2], "", "", favorNone)
+		preimage, id, count := normalizeConflicts(conflicted)
+		if count <= 0 {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(rrCacheDir, id)); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(rrCacheDir, id), 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(rrCacheFile(id, "preimage"), preimage, 0644); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Updated preimage for '%s'\n", filePath)
+		fmt.Fprintf(os.Stderr, "Forgot resolution for '%s'\n", filePath)
+		tracked = trackRerereEntry(tracked, rerereEntry{id, filePath})
+	}
+	return writeMergeRR(tracked)
+}
+
+// Usage: mygit rerere [clear | forget <pathspec>... | status]
+//
+// With rerere.enabled, merges and rebases record each conflict, and how it was resolved when
+// the result is committed, and resolve the same conflict the same way when it comes up again.
+// Without arguments, rerere records the resolutions of the conflicts resolved so far. clear
+// forgets the conflicts of the merge in progress, forget throws away the recorded resolutions
+// of the given conflicted paths, and status lists the paths rerere is tracking.
+func cmdRerere(args []string) {
+	usage := "usage: mygit rerere [clear | forget <pathspec>... | status]\n"
+	var err error
+	switch {
+	case len(args) == 0:
+		err = rerereRecordResolutions()
+	case args[0] == "clear" && len(args) == 1:
+		err = rerereClear()
+	case args[0] == "forget" && len(args) > 1:
+		err = rerereForget(args[1:])
+	case args[0] == "status" && len(args) == 1:
+		for _, entry := range readMergeRR() {
+			fmt.Println(entry.path)
+		}
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+}
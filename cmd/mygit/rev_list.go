@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// countCommits counts the commits in the range, split into those reachable from its left-hand
+// tips and the rest. Unlike walk, it doesn't put them in order.
+func (r *revisionRange) countCommits() (left int, right int, err error) {
+	included, err := ancestors(r.include)
+	if err != nil {
+		return 0, 0, err
+	}
+	excluded, err := ancestors(r.exclude)
+	if err != nil {
+		return 0, 0, err
+	}
+	leftSide, err := ancestors(r.left)
+	if err != nil {
+		return 0, 0, err
+	}
+	for sha := range included {
+		switch {
+		case excluded[sha] != nil:
+		case leftSide[sha] != nil:
+			left++
+		default:
+			right++
+		}
+	}
+	return left, right, nil
+}
+
+// Usage: mygit rev-list [--count] [--left-right] [--all] [-n <count>] <revision-range>... [-- <path>...]
+//
+// Lists the commits in a range newest first, one SHA a line. --left-right marks each with "<"
+// or ">" for the side of a symmetric range <rev1>...<rev2> it is on. --count prints how many
+// commits there are instead, and, with --left-right, the counts for each side separated by a
+// tab, which is how far two branches have diverged: "rev-list --left-right --count
+// main...origin/main" is "<ahead>\t<behind>".
+func cmdRevList(args []string) {
+	usage := "usage: mygit rev-list [--count] [--left-right] [--all] [-n <count>] <revision-range>... [-- <path>...]\n"
+	count, leftRight, all := false, false, false
+	maxCount := -1
+	var revs, pathspecs []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			pathspecs = args[i+1:]
+			i = len(args)
+		case arg == "--count":
+			count = true
+		case arg == "--left-right":
+			leftRight = true
+		case arg == "--all":
+			all = true
+		case arg == "-n" && i+1 < len(args):
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil {
+				fmt.Fprint(os.Stderr, usage)
+				os.Exit(129)
+			}
+			maxCount = n
+		case strings.HasPrefix(arg, "--max-count="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--max-count="))
+			if err != nil {
+				fmt.Fprint(os.Stderr, usage)
+				os.Exit(129)
+			}
+			maxCount = n
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(129)
+		default:
+			revs = append(revs, arg)
+		}
+	}
+	if len(revs) == 0 && !all {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(129)
+	}
+	revRange, err := parseRevisionRange(revs)
+	if err == nil && all {
+		err = revRange.includeAllRefs()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "Error walking history: %s\n", err)
+		os.Exit(1)
+	}
+
+	// counting needs neither the commits' order nor their SHAs, unless paths or a limit
+	// decide which are counted
+	if count && len(pathspecs) == 0 && maxCount < 0 {
+		left, right, err := revRange.countCommits()
+		if err != nil {
+			fail(err)
+		}
+		if leftRight {
+			fmt.Printf("%d\t%d\n", left, right)
+		} else {
+			fmt.Println(left + right)
+		}
+		return
+	}
+
+	order, commits, err := revRange.walk()
+	if err == nil && len(pathspecs) > 0 {
+		order, err = commitsTouching(order, commits, pathspecs)
+	}
+	var leftSide map[string]*Commit
+	if err == nil {
+		leftSide, err = ancestors(revRange.left)
+	}
+	if err != nil {
+		fail(err)
+	}
+	if maxCount >= 0 && len(order) > maxCount {
+		order = order[:maxCount]
+	}
+	left, right := 0, 0
+	for _, sha := range order {
+		if leftSide[sha] != nil {
+			left++
+		} else {
+			right++
+		}
+		switch {
+		case count:
+		case !leftRight:
+			fmt.Println(sha)
+		case leftSide[sha] != nil:
+			fmt.Println("<" + sha)
+		default:
+			fmt.Println(">" + sha)
+		}
+	}
+	if count && leftRight {
+		fmt.Printf("%d\t%d\n", left, right)
+	} else if count {
+		fmt.Println(left + right)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Usage: mygit rev-parse [--show-toplevel] [--show-cdup] [--show-prefix] [--git-dir] [--is-inside-work-tree] [--is-inside-git-dir] [--is-bare-repository] [--verify] [--short[=<n>]] [<rev>...]
+//
+// Answers questions about where the repository is, for scripts, printing a line per flag in the
+// order given. It works from anywhere inside the working tree or the git directory.
+//
+// Each <rev>, a ref, SHA or abbreviated SHA with any suffixes such as "~2" or "^{tree}", is
+// printed as the full SHA of the object it names, or with --short abbreviated to n digits, 7
+// by default. --verify, which --short implies, insists on exactly one that can be resolved,
+// failing with "Needed a single revision" otherwise.
+//
+// With --parseopt it parses a script's arguments instead; see cmdRevParseParseopt.
+func cmdRevParse(args []string) {
+	if len(args) > 0 && args[0] == "--parseopt" {
+		cmdRevParseParseopt(args[1:])
+		return
+	}
+	usage := "usage: mygit rev-parse [--show-toplevel] [--show-cdup] [--show-prefix] [--git-dir] [--is-inside-work-tree] [--is-inside-git-dir] [--is-bare-repository] [--verify] [--short[=<n>]] [<rev>...]\n"
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+	repo, err := discoverRepository()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	verify, short := false, 0
+	revs := 0
+	for _, arg := range args {
+		switch {
+		case arg == "--verify":
+			verify = true
+		case arg == "--short":
+			verify, short = true, 7
+		case strings.HasPrefix(arg, "--short="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--short="))
+			if err != nil {
+				fmt.Fprint(os.Stderr, usage)
+				os.Exit(1)
+			}
+			verify, short = true, n
+		case !strings.HasPrefix(arg, "-"):
+			revs++
+		}
+	}
+	if short != 0 && short < minAbbrev {
+		short = minAbbrev
+	}
+	if verify && revs != 1 {
+		fmt.Fprintf(os.Stderr, "fatal: Needed a single revision\n")
+		os.Exit(128)
+	}
+
+	for _, arg := range args {
+		if arg == "--verify" || arg == "--short" || strings.HasPrefix(arg, "--short=") {
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") {
+			sha, err := resolveObjectArg(arg)
+			switch {
+			case err != nil && verify:
+				fmt.Fprintf(os.Stderr, "fatal: Needed a single revision\n")
+				os.Exit(128)
+			case err != nil:
+				fmt.Println(arg)
+				fmt.Fprintf(os.Stderr, "fatal: ambiguous argument '%s': unknown revision or path not in the working tree.\n"+
+					"Use '--' to separate paths from revisions, like this:\n"+
+					"'mygit <command> [<revision>...] -- [<file>...]'\n", arg)
+				os.Exit(128)
+			case short > 0 && short < len(sha):
+				sha = sha[:short]
+			}
+			fmt.Println(sha)
+			continue
+		}
+		switch arg {
+		case "--show-toplevel":
+			if repo.insideGitDir || repo.workTree == "" {
+				fmt.Fprintf(os.Stderr, "fatal: this operation must be run in a work tree\n")
+				os.Exit(128)
+			}
+			fmt.Println(repo.workTree)
+		case "--show-cdup":
+			if !repo.insideGitDir {
+				fmt.Println(strings.Repeat("../", strings.Count(repo.prefix(), "/")))
+			}
+		case "--show-prefix":
+			fmt.Println(repo.prefix())
+		case "--git-dir":
+			fmt.Println(repo.gitDirDisplay())
+		case "--is-inside-work-tree":
+			fmt.Println(!repo.insideGitDir && repo.workTree != "")
+		case "--is-inside-git-dir":
+			fmt.Println(repo.insideGitDir)
+		case "--is-bare-repository":
+			fmt.Println(repo.bare)
+		default:
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// ancestors returns every commit reachable from the given commits, including the commits themselves
+func ancestors(shas []string) (map[string]*Commit, error) {
+	commits := map[string]*Commit{}
+	queue := append([]string{}, shas...)
+	for len(queue) > 0 {
+		sha := queue[0]
+		queue = queue[1:]
+		if _, seen := commits[sha]; seen {
+			continue
+		}
+		commit, err := readCommit(sha)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("commit %s is missing from .git/objects", sha)
+		} else if err != nil {
+			return nil, err
+		}
+		commits[sha] = commit
+		queue = append(queue, commit.Parents...)
+	}
+	return commits, nil
+}
+
+// sortByDate orders commit SHAs newest first by committer date
+func sortByDate(shas []string, commits map[string]*Commit) {
+	sort.SliceStable(shas, func(i, j int) bool {
+		return signatureTime(commits[shas[i]].Committer).After(signatureTime(commits[shas[j]].Committer))
+	})
+}
+
+// commitQueue hands out commits newest first, and those with the same date in the order they
+// were pushed, as git's walk does
+type commitQueue struct {
+	shas    []string
+	commits map[string]*Commit
+	pushed  map[string]int
+}
+
+func (q *commitQueue) Len() int { return len(q.shas) }
+func (q *commitQueue) Less(i, j int) bool {
+	a, b := signatureTime(q.commits[q.shas[i]].Committer), signatureTime(q.commits[q.shas[j]].Committer)
+	if a.Equal(b) {
+		return q.pushed[q.shas[i]] < q.pushed[q.shas[j]]
+	}
+	return a.After(b)
+}
+func (q *commitQueue) Swap(i, j int) { q.shas[i], q.shas[j] = q.shas[j], q.shas[i] }
+func (q *commitQueue) Push(x any) {
+	if q.pushed == nil {
+		q.pushed = map[string]int{}
+	}
+	q.pushed[x.(string)] = len(q.pushed)
+	q.shas = append(q.shas, x.(string))
+}
+func (q *commitQueue) Pop() any {
+	sha := q.shas[len(q.shas)-1]
+	q.shas = q.shas[:len(q.shas)-1]
+	return sha
+}
+
+// topoOrder returns the commits reachable from starts newest first, never showing a parent
+// before all of its children
+func topoOrder(starts []string) ([]string, map[string]*Commit, error) {
+	commits, err := ancestors(starts)
+	if err != nil {
+		return nil, nil, err
+	}
+	children := map[string]int{}
+	for _, commit := range commits {
+		for _, parent := range commit.Parents {
+			children[parent]++
+		}
+	}
+
+	// only starting points can have no children; take them in the order given
+	queue := &commitQueue{commits: commits}
+	for _, sha := range starts {
+		if _, queued := queue.pushed[sha]; !queued && children[sha] == 0 {
+			heap.Push(queue, sha)
+		}
+	}
+
+	var order []string
+	for queue.Len() > 0 {
+		sha := heap.Pop(queue).(string)
+		order = append(order, sha)
+		for _, parent := range commits[sha].Parents {
+			if children[parent]--; children[parent] == 0 {
+				heap.Push(queue, parent)
+			}
+		}
+	}
+	return order, commits, nil
+}
+
+// graphOrder reorders commits listed newest first the way git's --topo-order does: no commit
+// before its children, and each line of history followed as far as it goes before going back
+// for the next, with lines taken up in the order the list reaches them
+func graphOrder(list []string, commits map[string]*Commit) []string {
+	// a commit's count is one more than the number of its children still to come
+	indegree := map[string]int{}
+	for _, sha := range list {
+		indegree[sha] = 1
+	}
+	for _, sha := range list {
+		for _, parent := range commits[sha].Parents {
+			if indegree[parent] > 0 {
+				indegree[parent]++
+			}
+		}
+	}
+	var stack []string
+	for i := len(list) - 1; i >= 0; i-- {
+		if indegree[list[i]] == 1 {
+			stack = append(stack, list[i])
+		}
+	}
+	order := make([]string, 0, len(list))
+	for len(stack) > 0 {
+		sha := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, parent := range commits[sha].Parents {
+			if indegree[parent] == 0 {
+				continue
+			}
+			if indegree[parent]--; indegree[parent] == 1 {
+				stack = append(stack, parent)
+			}
+		}
+		order = append(order, sha)
+	}
+	return order
+}
+
+// boundaryCommits lists the parents of the shown commits that aren't shown themselves, in the
+// order git shows them: git's walk pushes each onto a list as the first of its children is
+// shown, and then puts the list in graph order
+func boundaryCommits(shown []string, commits map[string]*Commit) []string {
+	seen := map[string]bool{}
+	for _, sha := range shown {
+		seen[sha] = true
+	}
+	var found []string
+	for _, sha := range shown {
+		for _, parent := range commits[sha].Parents {
+			if !seen[parent] {
+				seen[parent] = true
+				found = append([]string{parent}, found...)
+			}
+		}
+	}
+	return graphOrder(found, commits)
+}
+
+// isAncestor reports whether ancestor is reachable from descendant (a commit counts as its own ancestor)
+func isAncestor(ancestor string, descendant string) (bool, error) {
+	reachable, err := ancestors([]string{descendant})
+	if err != nil {
+		return false, err
+	}
+	_, ok := reachable[ancestor]
+	return ok, nil
+}
+
+// reachableObjects lists every object reachable from include that the other side doesn't
+// already have through exclude, commits first: what a pack has to contain
+func reachableObjects(include []string, exclude []string) ([]string, error) {
+	excluded, err := ancestors(exclude)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	// trees of the excluded tips are assumed present, so unchanged subtrees are skipped
+	for _, sha := range exclude {
+		if err := markTree(excluded[sha].Tree, seen, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	var objects, trees []string
+	var tips []string
+	for _, sha := range include {
+		// annotated tags are sent along with whatever they point at
+		for {
+			objType, contents, err := parseObject(sha)
+			if err != nil {
+				return nil, err
+			}
+			if objType != "tag" {
+				break
+			}
+			if !seen[sha] {
+				seen[sha] = true
+				objects = append(objects, sha)
+			}
+			sha = tagTarget(contents)
+		}
+		tips = append(tips, sha)
+	}
+	commits, err := ancestors(tips)
+	if err != nil {
+		return nil, err
+	}
+	for sha, commit := range commits {
+		if _, ok := excluded[sha]; ok || seen[sha] {
+			continue
+		}
+		seen[sha] = true
+		objects = append(objects, sha)
+		trees = append(trees, commit.Tree)
+	}
+	for _, tree := range trees {
+		if err := markTree(tree, seen, &objects); err != nil {
+			return nil, err
+		}
+	}
+	return objects, nil
+}
+
+// markTree records a tree and everything under it as seen, appending newly seen objects to
+// objects when it is non-nil
+func markTree(sha string, seen map[string]bool, objects *[]string) error {
+	if seen[sha] {
+		return nil
+	}
+	seen[sha] = true
+	if objects != nil {
+		*objects = append(*objects, sha)
+	}
+	entries, err := readTree(sha)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		switch {
+		case entry.mode == 0o160000:
+			continue //submodule commit, lives in another repository
+		case entry.isTree():
+			if err := markTree(entry.sha, seen, objects); err != nil {
+				return err
+			}
+		case !seen[entry.sha]:
+			seen[entry.sha] = true
+			if objects != nil {
+				*objects = append(*objects, entry.sha)
+			}
+		}
+	}
+	return nil
+}
+
+// mergeBases returns the best common ancestors of a and the others, as if they were merged
+// together first, newest first: the commits reachable from a and from one of the others that
+// aren't themselves ancestors of another such commit. It is empty when the histories are
+// unrelated.
+func mergeBases(a string, others ...string) ([]string, error) {
+	fromA, err := ancestors([]string{a})
+	if err != nil {
+		return nil, err
+	}
+	fromB, err := ancestors(others)
+	if err != nil {
+		return nil, err
+	}
+	common := map[string]*Commit{}
+	for sha, commit := range fromA {
+		if _, ok := fromB[sha]; ok {
+			common[sha] = commit
+		}
+	}
+	// every ancestor of a common ancestor is common too, so the redundant ones are exactly
+	// those that are a parent of another
+	redundant := map[string]bool{}
+	for _, commit := range common {
+		for _, parent := range commit.Parents {
+			redundant[parent] = true
+		}
+	}
+	var bases []string
+	for sha := range common {
+		if !redundant[sha] {
+			bases = append(bases, sha)
+		}
+	}
+	sort.Strings(bases)
+	sortByDate(bases, common)
+	return bases, nil
+}
+
+// revisionRange is the set of commits a list of revision arguments selects: those reachable
+// from include but not from exclude. left holds the left-hand tips of symmetric ranges.
+type revisionRange struct {
+	include []string
+	names   []string //how each of include was given, for log --source
+	exclude []string
+	left    []string
+}
+
+// parseRevisionRange resolves revision arguments the way log takes them: <rev>, ^<rev>,
+// <rev1>..<rev2> (reachable from rev2 but not rev1) and <rev1>...<rev2> (reachable from either
+// but not both). A missing end of a range means HEAD.
+func parseRevisionRange(args []string) (*revisionRange, error) {
+	r := &revisionRange{}
+	resolve := func(rev string, arg string) (string, error) {
+		if rev == "" {
+			rev = "HEAD"
+		}
+		sha, err := resolveObjectArg(rev)
+		if err == nil {
+			sha, err = peelToCommit(sha)
+		}
+		if err != nil {
+			return "", fmt.Errorf("bad revision '%s'", arg)
+		}
+		return sha, nil
+	}
+	for _, arg := range args {
+		if from, to, ok := strings.Cut(arg, "..."); ok {
+			left, err := resolve(from, arg)
+			if err != nil {
+				return nil, err
+			}
+			right, err := resolve(to, arg)
+			if err != nil {
+				return nil, err
+			}
+			bases, err := mergeBases(left, right)
+			if err != nil {
+				return nil, err
+			}
+			r.include = append(r.include, left, right)
+			r.names = append(r.names, revOrHead(from), revOrHead(to))
+			r.exclude = append(r.exclude, bases...)
+			r.left = append(r.left, left)
+		} else if from, to, ok := strings.Cut(arg, ".."); ok {
+			excluded, err := resolve(from, arg)
+			if err != nil {
+				return nil, err
+			}
+			included, err := resolve(to, arg)
+			if err != nil {
+				return nil, err
+			}
+			r.include = append(r.include, included)
+			r.names = append(r.names, revOrHead(to))
+			r.exclude = append(r.exclude, excluded)
+		} else if rev, ok := strings.CutPrefix(arg, "^"); ok {
+			sha, err := resolve(rev, arg)
+			if err != nil {
+				return nil, err
+			}
+			r.exclude = append(r.exclude, sha)
+		} else {
+			sha, err := resolve(arg, arg)
+			if err != nil {
+				return nil, err
+			}
+			r.include = append(r.include, sha)
+			r.names = append(r.names, arg)
+		}
+	}
+	return r, nil
+}
+
+func revOrHead(rev string) string {
+	if rev == "" {
+		return "HEAD"
+	}
+	return rev
+}
+
+// includeAllRefs adds every ref, and then HEAD, as log --all does. Refs that don't lead to a
+// commit, such as a tag of a blob, are left out.
+func (r *revisionRange) includeAllRefs() error {
+	refs, err := listRefs("refs")
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(refs))
+	for ref := range refs {
+		names = append(names, ref)
+	}
+	sort.Strings(names)
+	if head, err := headCommit(); err == nil && head != "" {
+		refs["HEAD"] = head
+		names = append(names, "HEAD")
+	}
+	for _, name := range names {
+		if sha, err := peelToCommit(refs[name]); err == nil {
+			r.include = append(r.include, sha)
+			r.names = append(r.names, name)
+		}
+	}
+	return nil
+}
+
+// sources names, for each commit reachable from the range's starting points, the one it was
+// reached from first. Like git, the commits are visited newest first, and a commit passes its
+// source on to any parent not yet reached.
+func (r *revisionRange) sources(commits map[string]*Commit) map[string]string {
+	sources := map[string]string{}
+	queue := &commitQueue{commits: commits}
+	for i, sha := range r.include {
+		if _, ok := sources[sha]; !ok {
+			sources[sha] = r.names[i]
+			heap.Push(queue, sha)
+		}
+	}
+	for queue.Len() > 0 {
+		sha := heap.Pop(queue).(string)
+		for _, parent := range commits[sha].Parents {
+			if _, ok := sources[parent]; !ok && commits[parent] != nil {
+				sources[parent] = sources[sha]
+				heap.Push(queue, parent)
+			}
+		}
+	}
+	return sources
+}
+
+// walk returns the commits in the range in topoOrder, along with every commit read on the way
+func (r *revisionRange) walk() ([]string, map[string]*Commit, error) {
+	order, commits, err := topoOrder(r.include)
+	if err != nil || len(r.exclude) == 0 {
+		return order, commits, err
+	}
+	excluded, err := ancestors(r.exclude)
+	if err != nil {
+		return nil, nil, err
+	}
+	var kept []string
+	for _, sha := range order {
+		if _, ok := excluded[sha]; !ok {
+			kept = append(kept, sha)
+		}
+	}
+	return kept, commits, nil
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// emailPatch is a patch file in the mbox format format-patch writes
+type emailPatch struct {
+	from    string
+	subject string
+	cc      []string
+	body    string
+}
+
+// readEmailPatch reads a patch file: an optional "From <sha> <date>" line, the mail headers
+// up to a blank line, and the body
+func readEmailPatch(filename string) (*emailPatch, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	if strings.HasPrefix(text, "From ") {
+		_, text, _ = strings.Cut(text, "\n")
+	}
+	headers, body, ok := strings.Cut(text, "\n\n")
+	if !ok {
+		return nil, fmt.Errorf("%s: not a patch mail, no blank line after the headers", filename)
+	}
+
+	patch := &emailPatch{body: body}
+	var fields []string
+	for _, line := range strings.Split(headers, "\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(fields) > 0 {
+			fields[len(fields)-1] += " " + strings.TrimSpace(line) //a folded header continues
+			continue
+		}
+		fields = append(fields, line)
+	}
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s: not a patch mail, bad header line %q", filename, field)
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(key) {
+		case "from":
+			patch.from = value
+		case "subject":
+			patch.subject = value
+		case "cc":
+			patch.cc = append(patch.cc, value)
+		}
+	}
+	if patch.subject == "" {
+		return nil, fmt.Errorf("%s: not a patch mail, no Subject", filename)
+	}
+	return patch, nil
+}
+
+// smtpSettings are where and how mail is sent, from the sendemail.* config
+type smtpSettings struct {
+	server     string
+	port       string
+	user       string
+	pass       string
+	encryption string //"ssl" for a TLS connection, "tls" for STARTTLS, or "" for none
+}
+
+func readSMTPSettings() (smtpSettings, error) {
+	settings := smtpSettings{server: "localhost"}
+	if server, ok := configGet("sendemail.smtpServer"); ok {
+		settings.server = server
+	}
+	settings.user, _ = configGet("sendemail.smtpUser")
+	settings.pass, _ = configGet("sendemail.smtpPass")
+	encryption, _ := configGet("sendemail.smtpEncryption")
+	switch strings.ToLower(encryption) {
+	case "ssl", "tls":
+		settings.encryption = strings.ToLower(encryption)
+	case "", "none":
+	default:
+		return settings, fmt.Errorf("invalid sendemail.smtpEncryption '%s'", encryption)
+	}
+	settings.port = "25"
+	if settings.encryption == "ssl" {
+		settings.port = "465"
+	}
+	if port, ok := configGet("sendemail.smtpServerPort"); ok {
+		settings.port = port
+	}
+	return settings, nil
+}
+
+// senderAddress is who the mail is from: sendemail.from, or the user's identity
+func senderAddress() (string, error) {
+	if from, ok := configGet("sendemail.from"); ok {
+		return from, nil
+	}
+	return userIdentity("committer")
+}
+
+// recipientList parses the addresses in values, each of which may hold several separated by
+// commas, leaving out any already in seen
+func recipientList(values []string, seen map[string]bool) ([]*mail.Address, error) {
+	var list []*mail.Address
+	for _, value := range values {
+		addresses, err := mail.ParseAddressList(value)
+		if err != nil {
+			return nil, fmt.Errorf("bad address '%s': %s", value, err)
+		}
+		for _, address := range addresses {
+			if !seen[strings.ToLower(address.Address)] {
+				seen[strings.ToLower(address.Address)] = true
+				list = append(list, address)
+			}
+		}
+	}
+	return list, nil
+}
+
+func joinAddresses(addresses []*mail.Address) string {
+	var formatted []string
+	for _, address := range addresses {
+		if address.Name == "" {
+			formatted = append(formatted, address.Address)
+		} else {
+			formatted = append(formatted, address.String())
+		}
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// outgoingEmail is a patch ready to send
+type outgoingEmail struct {
+	subject    string
+	headers    []string
+	body       string
+	recipients []string //bare addresses, for RCPT TO
+}
+
+func (e *outgoingEmail) message() []byte {
+	var b bytes.Buffer
+	for _, header := range e.headers {
+		b.WriteString(header + "\n")
+	}
+	b.WriteString("\n" + e.body)
+	return b.Bytes()
+}
+
+// Usage: mygit send-email [--to=<address>] [--cc=<address>] [--dry-run] <patch-file>...
+//
+// Mails patches written by format-patch, inline as text/plain, over SMTP with the server from
+// sendemail.smtpServer (and sendemail.smtpServerPort, sendemail.smtpUser, sendemail.smtpPass,
+// and sendemail.smtpEncryption of ssl, tls or none). Recipients come from --to and --cc, which
+// can be repeated, sendemail.to and sendemail.cc, and the Cc lines of each patch.
+//
+// The mails form a thread: the first, usually a cover letter, starts it and the rest are
+// replies to it. When a patch's author isn't the sender, a From line in the body keeps the
+// authorship. --dry-run shows what would be sent without connecting to the server.
+func cmdSendEmail(args []string) {
+	usage := "usage: mygit send-email [--to=<address>] [--cc=<address>] [--dry-run] <patch-file>...\n"
+	var to, cc, files []string
+	dryRun := false
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--dry-run":
+			dryRun = true
+		case (arg == "--to" || arg == "--cc") && i+1 < len(args):
+			i++
+			if arg == "--to" {
+				to = append(to, args[i])
+			} else {
+				cc = append(cc, args[i])
+			}
+		case strings.HasPrefix(arg, "--to="):
+			to = append(to, strings.TrimPrefix(arg, "--to="))
+		case strings.HasPrefix(arg, "--cc="):
+			cc = append(cc, strings.TrimPrefix(arg, "--cc="))
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		default:
+			files = append(files, repoPath(arg))
+		}
+	}
+	if len(files) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+	fatal := func(err error) {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	to = append(configGetAll("sendemail.to"), to...)
+	cc = append(configGetAll("sendemail.cc"), cc...)
+	if len(to) == 0 {
+		fatal(errors.New("no recipients, give them with --to or sendemail.to"))
+	}
+	settings, err := readSMTPSettings()
+	if err != nil {
+		fatal(err)
+	}
+	from, err := senderAddress()
+	if err != nil {
+		fatal(err)
+	}
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		fatal(fmt.Errorf("bad sender address '%s': %s", from, err))
+	}
+
+	emails, err := buildEmails(files, sender, to, cc)
+	if err != nil {
+		fatal(err)
+	}
+	var client *smtp.Client
+	if !dryRun {
+		if client, err = dialSMTP(settings); err != nil {
+			fatal(err)
+		}
+		defer client.Quit()
+	}
+	for _, email := range emails {
+		if dryRun {
+			fmt.Print("Dry-")
+		} else if err := sendEmail(client, sender.Address, email); err != nil {
+			fatal(fmt.Errorf("failed to send '%s': %s", email.subject, err))
+		}
+		fmt.Printf("OK. Log says:\nServer: %s\nMAIL FROM:<%s>\n", settings.server, sender.Address)
+		for _, recipient := range email.recipients {
+			fmt.Printf("RCPT TO:<%s>\n", recipient)
+		}
+		fmt.Printf("%s\n\nResult: OK\n", strings.Join(email.headers, "\n"))
+	}
+}
+
+// buildEmails turns the patch files into a thread of mails
+func buildEmails(files []string, sender *mail.Address, to []string, cc []string) ([]*outgoingEmail, error) {
+	now := time.Now()
+	threadID := ""
+	var emails []*outgoingEmail
+	for i, filename := range files {
+		patch, err := readEmailPatch(filename)
+		if err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		toList, err := recipientList(to, seen)
+		if err != nil {
+			return nil, err
+		}
+		ccList, err := recipientList(append(append([]string{}, cc...), patch.cc...), seen)
+		if err != nil {
+			return nil, err
+		}
+
+		// one second apart, so that mail readers sorting by date keep the series in order
+		date := now.Add(time.Duration(i) * time.Second)
+		messageID := fmt.Sprintf("<%s.%d-%d-%s>", date.UTC().Format("20060102150405"), os.Getpid(), i+1, sender.Address)
+		email := &outgoingEmail{subject: patch.subject, headers: []string{
+			"From: " + joinAddresses([]*mail.Address{sender}),
+			"To: " + joinAddresses(toList),
+		}}
+		if len(ccList) > 0 {
+			email.headers = append(email.headers, "Cc: "+joinAddresses(ccList))
+		}
+		email.headers = append(email.headers,
+			"Subject: "+mime.QEncoding.Encode("UTF-8", patch.subject),
+			"Date: "+date.Format(time.RFC1123Z),
+			"Message-ID: "+messageID,
+		)
+		if threadID == "" {
+			threadID = messageID
+		} else {
+			email.headers = append(email.headers, "In-Reply-To: "+threadID, "References: "+threadID)
+		}
+		email.headers = append(email.headers,
+			"MIME-Version: 1.0",
+			"Content-Type: text/plain; charset=UTF-8",
+			"Content-Transfer-Encoding: 8bit",
+		)
+
+		email.body = patch.body
+		if author, err := mail.ParseAddress(patch.from); err == nil && !strings.EqualFold(author.Address, sender.Address) {
+			from, err := new(mime.WordDecoder).DecodeHeader(patch.from)
+			if err != nil {
+				from = patch.from
+			}
+			email.body = "From: " + from + "\n\n" + email.body
+		}
+		for _, address := range append(toList, ccList...) {
+			email.recipients = append(email.recipients, address.Address)
+		}
+		emails = append(emails, email)
+	}
+	return emails, nil
+}
+
+// dialSMTP connects and logs in to the SMTP server
+func dialSMTP(settings smtpSettings) (*smtp.Client, error) {
+	address := net.JoinHostPort(settings.server, settings.port)
+	tlsConfig := &tls.Config{ServerName: settings.server}
+	var client *smtp.Client
+	if settings.encryption == "ssl" {
+		conn, err := tls.Dial("tcp", address, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		if client, err = smtp.NewClient(conn, settings.server); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	} else {
+		var err error
+		if client, err = smtp.Dial(address); err != nil {
+			return nil, err
+		}
+	}
+	if settings.encryption == "tls" {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	if settings.user != "" {
+		if err := client.Auth(smtp.PlainAuth("", settings.user, settings.pass, settings.server)); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+func sendEmail(client *smtp.Client, from string, email *outgoingEmail) error {
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range email.recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	//Data turns the newlines into CRLFs and escapes leading dots
+	if _, err := w.Write(email.message()); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
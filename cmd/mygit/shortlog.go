@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Usage: mygit shortlog [-n] [-s] [-e] [--[no-]mailmap] [<rev>...]
+//
+// Summarizes history by author: each author's commit subjects, or with -s just the counts.
+func cmdShortlog(args []string) {
+	usage := "usage: mygit shortlog [-n] [-s] [-e] [--[no-]mailmap] [<rev>...]\n"
+	byCount, summary, showEmail := false, false, false
+	useMailmap := configBool("log.mailmap", true)
+	var revs []string
+	for _, arg := range args {
+		switch arg {
+		case "-n", "--numbered":
+			byCount = true
+		case "-s", "--summary":
+			summary = true
+		case "-e", "--email":
+			showEmail = true
+		case "--mailmap", "--use-mailmap":
+			useMailmap = true
+		case "--no-mailmap", "--no-use-mailmap":
+			useMailmap = false
+		default:
+			if strings.HasPrefix(arg, "-") {
+				fmt.Fprint(os.Stderr, usage)
+				os.Exit(1)
+			}
+			revs = append(revs, arg)
+		}
+	}
+	if len(revs) == 0 {
+		if head, err := headCommit(); err == nil && head == "" {
+			return //an unborn branch has no history to show
+		}
+		revs = []string{"HEAD"}
+	}
+	var starts []string
+	for _, rev := range revs {
+		sha, err := resolveObjectArg(rev)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: bad revision '%s'\n", rev)
+			os.Exit(128)
+		}
+		starts = append(starts, sha)
+	}
+	order, commits, err := topoOrder(starts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error walking history: %s\n", err)
+		os.Exit(1)
+	}
+
+	mm := &mailmap{}
+	if useMailmap {
+		mm = readMailmap()
+	}
+	subjects := map[string][]string{}
+	var authors []string
+	// oldest first within each author, like git
+	for i := len(order) - 1; i >= 0; i-- {
+		author := commits[order[i]].Author
+		if useMailmap {
+			author = mm.mapSignature(author)
+		}
+		author = signatureIdentity(author)
+		if !showEmail {
+			author = strings.TrimSpace(author[:strings.LastIndexByte(author, '<')])
+		}
+		if _, seen := subjects[author]; !seen {
+			authors = append(authors, author)
+		}
+		subjects[author] = append(subjects[author], commits[order[i]].Subject())
+	}
+
+	sort.Slice(authors, func(i, j int) bool {
+		if byCount && len(subjects[authors[i]]) != len(subjects[authors[j]]) {
+			return len(subjects[authors[i]]) > len(subjects[authors[j]])
+		}
+		return authors[i] < authors[j]
+	})
+	for _, author := range authors {
+		if summary {
+			fmt.Printf("%6d\t%s\n", len(subjects[author]), author)
+			continue
+		}
+		fmt.Printf("%s (%d):\n", author, len(subjects[author]))
+		for _, subject := range subjects[author] {
+			fmt.Printf("      %s\n", subject)
+		}
+		fmt.Println()
+	}
+}
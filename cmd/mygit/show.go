@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Usage: mygit show [<object>...]
+//
+// Shows each object, HEAD by default, the way git show does: a commit as log shows it, followed
+// by its patch against its parent, or a combined diff for a merge; an annotated tag as its
+// tagger and message, then the object it tags; a tree as the names in it, a directory's with a
+// "/"; and a blob as its contents. Objects can be named any way cat-file takes, <rev>:<path>
+// included.
+func cmdShow(args []string) {
+	usage := "usage: mygit show [<object>...]\n"
+	names := args
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(129)
+		}
+	}
+	if len(names) == 0 {
+		names = []string{"HEAD"}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for n, name := range names {
+		sha, err := resolveObjectArg(name)
+		if err == nil {
+			err = showObject(out, name, sha, n > 0)
+		}
+		if err != nil {
+			out.Flush()
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(128)
+		}
+	}
+}
+
+// showObject writes one object for show; separate puts a blank line before a commit or tag
+// that follows other objects
+func showObject(w io.Writer, name string, sha string, separate bool) error {
+	objType, contents, err := parseObject(sha)
+	if err != nil {
+		return err
+	}
+	switch objType {
+	case "blob":
+		_, err = w.Write(contents)
+		return err
+	case "tree":
+		entries, err := parseTree(contents)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "tree %s\n\n", name)
+		for _, entry := range entries {
+			if entry.isTree() {
+				fmt.Fprintf(w, "%s/\n", entry.name)
+			} else {
+				fmt.Fprintln(w, entry.name)
+			}
+		}
+		return nil
+	case "tag":
+		if separate {
+			fmt.Fprintln(w)
+		}
+		header, message, _ := strings.Cut(string(contents), "\n\n")
+		for _, line := range strings.Split(header, "\n") {
+			if tag, ok := strings.CutPrefix(line, "tag "); ok {
+				fmt.Fprintf(w, "tag %s\n", tag)
+			} else if tagger, ok := strings.CutPrefix(line, "tagger "); ok {
+				fmt.Fprintf(w, "Tagger: %s\nDate:   %s\n", signatureIdentity(tagger), signatureTime(tagger).Format(logDateFormat))
+			}
+		}
+		fmt.Fprintf(w, "\n%s\n", message)
+		return showObject(w, tagTarget(contents), tagTarget(contents), false)
+	}
+
+	if separate {
+		fmt.Fprintln(w)
+	}
+	commit, err := parseCommit(contents)
+	if err != nil {
+		return err
+	}
+	for _, line := range formatLogEntry(sha, commit, false, 0, "", nil, logColors{}) {
+		fmt.Fprintln(w, line)
+	}
+	var diff strings.Builder
+	if len(commit.Parents) > 1 {
+		parentTrees := make([]string, len(commit.Parents))
+		for i, parent := range commit.Parents {
+			if parentTrees[i], err = commitTree(parent); err != nil {
+				return err
+			}
+		}
+		changes, err := combinedTreeChanges(parentTrees, commit.Tree)
+		if err != nil {
+			return err
+		}
+		opts := diffTreeOptions{recursive: true, patch: true, combined: true, dense: true}
+		for _, change := range changes {
+			if err := writeCombinedChange(&diff, change, opts); err != nil {
+				return err
+			}
+		}
+	} else {
+		parentTree := ""
+		if len(commit.Parents) == 1 {
+			if parentTree, err = commitTree(commit.Parents[0]); err != nil {
+				return err
+			}
+		}
+		if err := writeTreeDiff(&diff, parentTree, commit.Tree, diffTreeOptions{recursive: true, patch: true}); err != nil {
+			return err
+		}
+	}
+	if diff.Len() > 0 {
+		fmt.Fprintf(w, "\n%s", diff.String())
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Usage: mygit show-index [<file>]
+//
+// Reads a pack index from <file>, or stdin, and prints a line per object in pack order:
+// "<offset> <sha> (<crc32>)", without the CRC for a version 1 index, which doesn't store one.
+func cmdShowIndex(args []string) {
+	var data []byte
+	var err error
+	name := "<stdin>"
+	switch len(args) {
+	case 0:
+		data, err = io.ReadAll(os.Stdin)
+	case 1:
+		name = args[0]
+		data, err = os.ReadFile(name)
+	default:
+		fmt.Fprintf(os.Stderr, "usage: mygit show-index [<file>]\n")
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	idx, err := parsePackIndex(data, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+
+	order := make([]int, len(idx.shas))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return idx.offsets[order[i]] < idx.offsets[order[j]] })
+	for _, i := range order {
+		if idx.version == 1 {
+			fmt.Printf("%d %s\n", idx.offsets[i], idx.shas[i])
+		} else {
+			fmt.Printf("%d %s (%08x)\n", idx.offsets[i], idx.shas[i], idx.crcs[i])
+		}
+	}
+}
+
+// Usage: mygit verify-pack [-v | --verbose] [--index-version] <pack>.idx...
+//
+// Checks that each index and its pack are intact: the checksums of both files, and that the
+// index was written for that pack. --index-version prints the index format version instead.
+// -v lists the objects in pack order, as "<sha> <type> <size> <size-in-pack> <offset>", with
+// the depth and base of a delta after it, then how many objects are whole and how many at
+// each delta depth, and says whether the pack is ok.
+func cmdVerifyPack(args []string) {
+	usage := "usage: mygit verify-pack [-v | --verbose] [--index-version] <pack>.idx...\n"
+	indexVersion, verbose := false, false
+	var files []string
+	for _, arg := range args {
+		switch {
+		case arg == "--index-version":
+			indexVersion = true
+		case arg == "-v" || arg == "--verbose":
+			verbose = true
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		default:
+			files = append(files, arg)
+		}
+	}
+	if len(files) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, file := range files {
+		indexFile := strings.TrimSuffix(file, ".pack")
+		indexFile = strings.TrimSuffix(indexFile, ".idx") + ".idx"
+		idx, err := readPackIndex(indexFile)
+		if err == nil && indexVersion {
+			fmt.Printf("%s: version %d\n", indexFile, idx.version)
+			if !verbose {
+				continue
+			}
+		}
+		packFile := packFileFor(indexFile)
+		if err == nil {
+			err = verifyPackChecksum(packFile, idx)
+		}
+		if err == nil && verbose {
+			err = printPackEntries(packFile, idx)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			failed = true
+		}
+		if verbose {
+			if err != nil {
+				fmt.Printf("%s: bad\n", packFile)
+			} else {
+				fmt.Printf("%s: ok\n", packFile)
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// packEntry is how an object is stored in a pack
+type packEntry struct {
+	sha     string
+	objType string //the object's own type; a delta has its base's
+	size    int64  //before compression, which for a delta is the size of the delta
+	offset  int64
+	length  int64 //what the entry takes up in the pack
+	depth   int   //how many deltas deep it is, 0 when it is whole
+	base    string
+}
+
+// packEntries lists the entries of a pack in the order they are stored, following each
+// delta down to the whole object at the bottom of its chain, which must be in the same pack
+func packEntries(data []byte, idx *packIndex) ([]packEntry, error) {
+	order := make([]int, len(idx.shas))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return idx.offsets[order[i]] < idx.offsets[order[j]] })
+	entries := make([]packEntry, len(order))
+	byOffset, bySHA := map[int64]int{}, map[string]int{}
+	for n, i := range order {
+		end := int64(len(data) - 20)
+		if n+1 < len(order) {
+			end = idx.offsets[order[n+1]]
+		}
+		entries[n] = packEntry{sha: idx.shas[i], offset: idx.offsets[i], length: end - idx.offsets[i]}
+		byOffset[entries[n].offset], bySHA[entries[n].sha] = n, n
+	}
+
+	bases := make([]int, len(entries))
+	for n := range entries {
+		e := &entries[n]
+		if e.offset < 12 || e.length <= 0 || e.offset+e.length > int64(len(data)-20) {
+			return nil, fmt.Errorf("bad offset %d for %s", e.offset, e.sha)
+		}
+		r := bytes.NewReader(data[e.offset : e.offset+e.length])
+		objType, size, err := readPackObjectHeader(r)
+		if err != nil {
+			return nil, fmt.Errorf("bad object header at offset %d: %w", e.offset, err)
+		}
+		e.size = size
+		ok := true
+		switch objType {
+		case objOfsDelta:
+			distance, err := readOfsDeltaDistance(r)
+			if err != nil {
+				return nil, err
+			}
+			bases[n], ok = byOffset[e.offset-distance]
+		case objRefDelta:
+			var rawSha [20]byte
+			if _, err := io.ReadFull(r, rawSha[:]); err != nil {
+				return nil, err
+			}
+			bases[n], ok = bySHA[hex.EncodeToString(rawSha[:])]
+		default:
+			if e.objType = packTypeNames[objType]; e.objType == "" {
+				return nil, fmt.Errorf("unknown object type %d at offset %d", objType, e.offset)
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("the base of the delta at offset %d isn't in the pack", e.offset)
+		}
+	}
+	for n := range entries {
+		var chain []int
+		for m := n; entries[m].objType == ""; m = bases[m] {
+			if chain = append(chain, m); len(chain) > len(entries) {
+				return nil, fmt.Errorf("delta chain at offset %d loops", entries[n].offset)
+			}
+		}
+		for k := len(chain) - 1; k >= 0; k-- {
+			delta, base := &entries[chain[k]], entries[bases[chain[k]]]
+			delta.objType, delta.depth, delta.base = base.objType, base.depth+1, base.sha
+		}
+	}
+	return entries, nil
+}
+
+// printPackEntries prints verify-pack -v's list of a pack's entries, and how many objects are
+// whole and at each delta depth
+func printPackEntries(packFile string, idx *packIndex) error {
+	data, err := os.ReadFile(packFile)
+	if err != nil {
+		return err
+	}
+	entries, err := packEntries(data, idx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", packFile, err)
+	}
+	whole := 0
+	var depths []int
+	for _, e := range entries {
+		line := fmt.Sprintf("%s %-6s %d %d %d", e.sha, e.objType, e.size, e.length, e.offset)
+		if e.depth == 0 {
+			whole++
+		} else {
+			line += fmt.Sprintf(" %d %s", e.depth, e.base)
+			for len(depths) < e.depth {
+				depths = append(depths, 0)
+			}
+			depths[e.depth-1]++
+		}
+		fmt.Println(line)
+	}
+	objects := func(n int) string {
+		if n == 1 {
+			return "1 object"
+		}
+		return fmt.Sprintf("%d objects", n)
+	}
+	if whole > 0 {
+		fmt.Printf("non delta: %s\n", objects(whole))
+	}
+	for i, n := range depths {
+		if n > 0 {
+			fmt.Printf("chain length = %d: %s\n", i+1, objects(n))
+		}
+	}
+	return nil
+}
+
+// verifyPackChecksum checks a pack's trailing checksum, and that it is the one idx was made for
+func verifyPackChecksum(packFile string, idx *packIndex) error {
+	data, err := os.ReadFile(packFile)
+	if err != nil {
+		return err
+	}
+	if len(data) < 32 || !bytes.HasPrefix(data, []byte("PACK")) {
+		return fmt.Errorf("%s is not a pack file", packFile)
+	}
+	checksum := sha1.Sum(data[:len(data)-20])
+	if !bytes.Equal(checksum[:], data[len(data)-20:]) {
+		return fmt.Errorf("%s: pack checksum mismatch", packFile)
+	}
+	if hex.EncodeToString(checksum[:]) != idx.packChecksum {
+		return fmt.Errorf("packfile %s does not match index", packFile)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestVerifyPackVerbose(t *testing.T) {
+	initTestRepo(t)
+	var long, longer strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&long, "line %d\n", i)
+	}
+	longer.WriteString(long.String() + "one more\n")
+	first := testCommit(t, 0, "first", map[string]string{"a": long.String()})
+	second := testCommit(t, 1, "second", map[string]string{"a": longer.String()}, first)
+	if err := updateRef("refs/heads/master", second, ""); err != nil {
+		t.Fatal(err)
+	}
+	shas := []string{second, first, hashObject("blob", []byte(longer.String())), hashObject("blob", []byte(long.String()))}
+	for _, commit := range []string{second, first} {
+		tree, err := commitTree(commit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shas = append(shas, tree)
+	}
+	var pack bytes.Buffer
+	if err := writePack(&pack, shas); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storePack(&pack, false); err != nil {
+		t.Fatal(err)
+	}
+	indexFiles, _ := filepath.Glob(filepath.Join(".git", "objects", "pack", "*.idx"))
+	if len(indexFiles) != 1 {
+		t.Fatalf("%d pack indexes, want 1", len(indexFiles))
+	}
+	indexFile := indexFiles[0]
+
+	// -v goes along with --index-version, which is printed first
+	lines := strings.Split(strings.TrimSuffix(runTestCommand(t, "verify-pack", "-v", "--index-version", indexFile), "\n"), "\n")
+	if len(lines) != len(shas)+3 {
+		t.Fatalf("verify-pack -v printed:\n%s", strings.Join(lines, "\n"))
+	}
+	if want := indexFile + ": version 2"; lines[0] != want {
+		t.Errorf("first line %q, want %q", lines[0], want)
+	}
+	for i, sha := range shas {
+		objType, data, err := parseObject(sha)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// objects are listed in pack order, which is the order they were written in
+		fields := strings.Fields(lines[i+1])
+		if len(fields) != 5 || fields[0] != sha || fields[1] != objType || fields[2] != strconv.Itoa(len(data)) {
+			t.Errorf("line %q, want %s %s %d and where it is in the pack", lines[i+1], sha, objType, len(data))
+		}
+	}
+	if want := fmt.Sprintf("non delta: %d objects", len(shas)); lines[len(lines)-2] != want {
+		t.Errorf("line %q, want %q", lines[len(lines)-2], want)
+	}
+	if want := packFileFor(indexFile) + ": ok"; lines[len(lines)-1] != want {
+		t.Errorf("last line %q, want %q", lines[len(lines)-1], want)
+	}
+
+	// git agrees, with the pack as mygit wrote it and once git has made a delta of one blob
+	git, err := exec.LookPath("git")
+	if err != nil {
+		return
+	}
+	compare := func() {
+		t.Helper()
+		indexFiles, _ := filepath.Glob(filepath.Join(".git", "objects", "pack", "*.idx"))
+		for _, indexFile := range indexFiles {
+			want, err := exec.Command(git, "verify-pack", "-v", indexFile).Output()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := runTestCommand(t, "verify-pack", "--verbose", indexFile); got != string(want) {
+				t.Errorf("verify-pack -v printed:\n%s\ngit printed:\n%s", got, want)
+			}
+		}
+	}
+	compare()
+	if out, err := exec.Command(git, "repack", "-a", "-d", "-f", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git repack: %v\n%s", err, out)
+	}
+	compare()
+}
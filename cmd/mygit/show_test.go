@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShow(t *testing.T) {
+	initTestRepo(t)
+	first := testCommit(t, 0, "first", map[string]string{"a": "one\n", "d/f": "x\n"})
+	second := testCommit(t, 1, "second", map[string]string{"a": "one\ntwo\n", "d/f": "x\n"}, first)
+	if err := updateRef("refs/heads/master", second, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"HEAD:a"}, "one\ntwo\n"},
+		{[]string{"HEAD~1:a", "HEAD:a"}, "one\none\ntwo\n"},
+		{[]string{"HEAD:"}, "tree HEAD:\n\na\nd/\n"},
+		{[]string{"HEAD:d"}, "tree HEAD:d\n\nf\n"},
+		{
+			nil,
+			"commit " + second + "\n" +
+				"Author: A U Thor <author@example.com>\n" +
+				"Date:   Tue Nov 14 22:13:21 2023 +0000\n\n" +
+				"    second\n\n" +
+				"diff --git a/a b/a\nindex 5626abf..814f4a4 100644\n--- a/a\n+++ b/a\n@@ -1 +1,2 @@\n one\n+two\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(strings.Join(test.args, " "), func(t *testing.T) {
+			if got := runTestCommand(t, append([]string{"show"}, test.args...)...); got != test.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
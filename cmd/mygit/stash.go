@@ -0,0 +1,450 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+A stash is stored like git's: a commit whose tree is the working tree's tracked files, with HEAD
+as its first parent and a commit of the index as its second. With -u or -a, the untracked files,
+and with -a the ignored ones too, are committed as a root commit of their own and become the
+third parent. refs/stash points at the newest stash, and .git/logs/refs/stash keeps them all,
+oldest first, one reflog line each:
+
+	<previous stash> <stash> <signature>\t<message>
+*/
+
+const stashRef = "refs/stash"
+
+var stashLogPath = path.Join(".git", "logs", stashRef)
+
+// stashEntry is one stash in the stash log
+type stashEntry struct {
+	sha       string
+	signature string //who stashed it and when
+	message   string
+}
+
+// readStashLog lists the stashes, newest first
+func readStashLog() ([]stashEntry, error) {
+	contents, err := os.ReadFile(stashLogPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var stashes []stashEntry
+	for _, line := range strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n") {
+		fields, message, _ := strings.Cut(line, "\t")
+		if parts := strings.SplitN(fields, " ", 3); len(parts) == 3 {
+			stashes = append([]stashEntry{{sha: parts[1], signature: parts[2], message: message}}, stashes...)
+		}
+	}
+	return stashes, nil
+}
+
+// writeStashLog writes the stashes back, newest first as readStashLog gives them, and points
+// refs/stash at the newest; with none left, both go
+func writeStashLog(stashes []stashEntry) error {
+	if len(stashes) == 0 {
+		if err := os.Remove(stashLogPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return deleteRef(stashRef)
+	}
+	var b strings.Builder
+	previous := strings.Repeat("0", 40)
+	for i := len(stashes) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "%s %s %s\t%s\n", previous, stashes[i].sha, stashes[i].signature, stashes[i].message)
+		previous = stashes[i].sha
+	}
+	if err := os.MkdirAll(path.Dir(stashLogPath), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(stashLogPath, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	return updateRef(stashRef, stashes[0].sha, "")
+}
+
+// stashUntrackedFiles lists the files in the working tree that aren't in the index, leaving
+// out ignored ones unless all is set
+func stashUntrackedFiles(index map[string]indexEntry, all bool) ([]string, error) {
+	ignore := newIgnoreMatcher()
+	var files []string
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			filePath := path.Join(dir, entry.Name())
+			_, tracked := index[filePath]
+			switch {
+			case entry.Name() == ".git", tracked:
+			case !all && ignore.isIgnored(filePath, entry.IsDir()):
+			case entry.IsDir():
+				if err := walk(filePath); err != nil {
+					return err
+				}
+			default:
+				files = append(files, filePath)
+			}
+		}
+		return nil
+	}
+	err := walk(".")
+	return files, err
+}
+
+// worktreeFileEntry writes the blob for a file in the working tree and returns its tree entry
+func worktreeFileEntry(filePath string) (treeEntry, error) {
+	contents, fi, err := readWorktreeFile(filePath)
+	if err != nil {
+		return treeEntry{}, err
+	}
+	sha, err := writeObject("blob", contents)
+	if err != nil {
+		return treeEntry{}, err
+	}
+	return treeEntry{mode: fileMode(fi), name: filePath, sha: fmt.Sprintf("%x", sha)}, nil
+}
+
+// stashPush saves the index and working tree, and the untracked files with untracked, or
+// the ignored ones as well with all, then resets them to HEAD. It reports false when there
+// was nothing to save.
+func stashPush(message string, untracked bool, all bool) (bool, error) {
+	head, err := headCommit()
+	if err != nil {
+		return false, err
+	}
+	if head == "" {
+		return false, errors.New("You do not have the initial commit yet")
+	}
+	headCommitObj, err := readCommit(head)
+	if err != nil {
+		return false, err
+	}
+	headFiles, err := treeFiles(headCommitObj.Tree)
+	if err != nil {
+		return false, err
+	}
+	entries, err := loadIndex(headFiles)
+	if err != nil {
+		return false, err
+	}
+	indexSHA, err := indexTree(entries)
+	if err == errUnmerged {
+		return false, errors.New("could not save the current index state: you have unmerged files")
+	} else if err != nil {
+		return false, err
+	}
+
+	worktree := map[string]treeEntry{}
+	for _, entry := range entries {
+		changed, err := worktreeChanged(entry)
+		if err != nil {
+			return false, err
+		}
+		if !changed {
+			worktree[entry.path] = treeEntry{mode: entry.mode, name: entry.path, sha: entry.sha}
+		} else if fileExists(entry.path) {
+			if worktree[entry.path], err = worktreeFileEntry(entry.path); err != nil {
+				return false, err
+			}
+		}
+	}
+	worktreeSHA, err := writeTreeFiles(worktree)
+	if err != nil {
+		return false, err
+	}
+
+	var untrackedFiles []string
+	if untracked || all {
+		if untrackedFiles, err = stashUntrackedFiles(indexByPath(entries), all); err != nil {
+			return false, err
+		}
+	}
+	if indexSHA == headCommitObj.Tree && worktreeSHA == headCommitObj.Tree && len(untrackedFiles) == 0 {
+		return false, nil
+	}
+
+	branch, err := currentBranch()
+	if err != nil {
+		return false, err
+	}
+	if branch == "" {
+		branch = "(no branch)"
+	}
+	on := fmt.Sprintf("%s: %s %s", branch, head[:7], headCommitObj.Subject())
+	author, committer, err := commitSignatures()
+	if err != nil {
+		return false, err
+	}
+	newCommit := func(tree string, parents []string, message string) (string, error) {
+		return writeCommit(&Commit{Tree: tree, Parents: parents, Author: author, Committer: committer, Message: message + "\n"})
+	}
+	indexCommit, err := newCommit(indexSHA, []string{head}, "index on "+on)
+	if err != nil {
+		return false, err
+	}
+	parents := []string{head, indexCommit}
+	if len(untrackedFiles) > 0 {
+		files := map[string]treeEntry{}
+		for _, filePath := range untrackedFiles {
+			if files[filePath], err = worktreeFileEntry(filePath); err != nil {
+				return false, err
+			}
+		}
+		untrackedSHA, err := writeTreeFiles(files)
+		if err != nil {
+			return false, err
+		}
+		untrackedCommit, err := newCommit(untrackedSHA, nil, "untracked files on "+on)
+		if err != nil {
+			return false, err
+		}
+		parents = append(parents, untrackedCommit)
+	}
+	if message == "" {
+		message = "WIP on " + on
+	} else {
+		message = "On " + branch + ": " + message
+	}
+	stash, err := newCommit(worktreeSHA, parents, message)
+	if err != nil {
+		return false, err
+	}
+	stashes, err := readStashLog()
+	if err != nil {
+		return false, err
+	}
+	if err := writeStashLog(append([]stashEntry{{sha: stash, signature: committer, message: message}}, stashes...)); err != nil {
+		return false, err
+	}
+	fmt.Printf("Saved working directory and index state %s\n", message)
+
+	if err := resetWorktree(headCommitObj.Tree); err != nil {
+		return false, err
+	}
+	for _, filePath := range untrackedFiles {
+		if err := removeWorktreeFile(filePath); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// stashIndex reads which stash an argument names, as stash@{<n>} or just <n>, 0 by default
+func stashIndex(args []string, stashes []stashEntry) (int, error) {
+	if len(args) == 0 {
+		if len(stashes) == 0 {
+			return 0, errors.New("No stash entries found.")
+		}
+		return 0, nil
+	}
+	spec := args[0]
+	n := strings.TrimSuffix(strings.TrimPrefix(spec, "stash@{"), "}")
+	i, err := strconv.Atoi(n)
+	if err != nil || i < 0 || i >= len(stashes) {
+		return 0, fmt.Errorf("%s is not a valid reference", spec)
+	}
+	return i, nil
+}
+
+// stashApply merges a stash's changes into the working tree, leaving them unstaged except for
+// new files, and writes back its untracked files, none of which may be in the way. It reports
+// whether there were conflicts.
+func stashApply(sha string) (bool, error) {
+	stash, err := readCommit(sha)
+	if err != nil {
+		return false, err
+	}
+	if len(stash.Parents) < 2 {
+		return false, fmt.Errorf("'%s' is not a stash-like commit", sha)
+	}
+	var untracked map[string]treeEntry
+	if len(stash.Parents) > 2 {
+		untrackedTree, err := commitTree(stash.Parents[2])
+		if err != nil {
+			return false, err
+		}
+		if untracked, err = treeFiles(untrackedTree); err != nil {
+			return false, err
+		}
+		var existing []string
+		for filePath := range untracked {
+			if _, err := os.Lstat(filePath); err == nil {
+				existing = append(existing, filePath)
+			}
+		}
+		if len(existing) > 0 {
+			sort.Strings(existing)
+			for _, filePath := range existing {
+				fmt.Fprintf(os.Stderr, "%s already exists, no checkout\n", filePath)
+			}
+			return false, errors.New("could not restore untracked files from stash")
+		}
+	}
+
+	entries, err := readIndex()
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	currentTree, err := indexTree(entries)
+	if err == errUnmerged {
+		return false, errors.New("could not write index: you have unmerged files")
+	} else if err != nil {
+		return false, err
+	}
+	baseTree, err := commitTree(stash.Parents[0])
+	if err != nil {
+		return false, err
+	}
+	result, err := mergeTrees(baseTree, currentTree, stash.Tree, "Updated upstream", "Stashed changes", favorNone)
+	if err != nil {
+		return false, err
+	}
+	if err := applyMergeResult(currentTree, result); err != nil {
+		return false, err
+	}
+
+	if len(result.conflicts) == 0 {
+		// only the files the stash adds stay staged, the way git leaves them
+		merged, err := readIndex()
+		if err != nil {
+			return false, err
+		}
+		before := indexByPath(entries)
+		kept := entries
+		for _, entry := range merged {
+			if _, ok := before[entry.path]; !ok {
+				kept = append(kept, entry)
+			}
+		}
+		if err := writeIndex(kept); err != nil {
+			return false, err
+		}
+	}
+	for filePath, entry := range untracked {
+		if err := writeWorktreeFile(filePath, entry); err != nil {
+			return false, err
+		}
+	}
+	return len(result.conflicts) > 0, nil
+}
+
+// Usage:
+//
+//	mygit stash [push] [-u|--include-untracked] [-a|--all] [-m <message>]
+//	mygit stash list
+//	mygit stash (apply|pop|drop) [<stash>]
+//
+// push saves the local changes in the index and working tree as a new stash and resets them to
+// HEAD. -u saves and removes the untracked files as well, and -a the ignored ones too. apply
+// puts the changes of a stash, the newest unless stash@{<n>} is given, back in the working tree,
+// along with any untracked files it saved, and pop then drops it unless there were conflicts.
+func cmdStash(args []string) {
+	usage := "usage: mygit stash [push] [-u|--include-untracked] [-a|--all] [-m <message>]\n" +
+		"   or: mygit stash list\n" +
+		"   or: mygit stash (apply|pop|drop) [<stash>]\n"
+	fatal := func(err error) {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	action := "push"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+
+	switch action {
+	case "push":
+		message, untracked, all := "", false, false
+		for i := 0; i < len(args); i++ {
+			switch arg := args[i]; {
+			case arg == "-u" || arg == "--include-untracked":
+				untracked = true
+			case arg == "-a" || arg == "--all":
+				all = true
+			case (arg == "-m" || arg == "--message") && i+1 < len(args):
+				i++
+				message = args[i]
+			case strings.HasPrefix(arg, "--message="):
+				message = strings.TrimPrefix(arg, "--message=")
+			default:
+				fmt.Fprint(os.Stderr, usage)
+				os.Exit(129)
+			}
+		}
+		if untracked && all {
+			fatal(errors.New("options '--include-untracked' and '--all' cannot be used together"))
+		}
+		saved, err := stashPush(message, untracked, all)
+		if err != nil {
+			fatal(err)
+		}
+		if !saved {
+			fmt.Println("No local changes to save")
+		}
+
+	case "list":
+		stashes, err := readStashLog()
+		if err != nil {
+			fatal(err)
+		}
+		for i, stash := range stashes {
+			fmt.Printf("stash@{%d}: %s\n", i, stash.message)
+		}
+
+	case "apply", "pop", "drop":
+		if len(args) > 1 || (len(args) == 1 && strings.HasPrefix(args[0], "-")) {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(129)
+		}
+		stashes, err := readStashLog()
+		if err != nil {
+			fatal(err)
+		}
+		i, err := stashIndex(args, stashes)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(1)
+		}
+		if action != "drop" {
+			conflicted, err := stashApply(stashes[i].sha)
+			var overwritten *checkoutConflictError
+			if errors.As(err, &overwritten) {
+				overwritten.operation = "merge"
+				fmt.Fprintf(os.Stderr, "error: %s\nAborting\n", err)
+				os.Exit(1)
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %s\n", err)
+				os.Exit(1)
+			}
+			if conflicted {
+				if action == "pop" {
+					fmt.Println("The stash entry is kept in case you need it again.")
+				}
+				os.Exit(1)
+			}
+			if action == "apply" {
+				return
+			}
+		}
+		if err := writeStashLog(append(stashes[:i:i], stashes[i+1:]...)); err != nil {
+			fatal(err)
+		}
+		fmt.Printf("Dropped refs/stash@{%d} (%s)\n", i, stashes[i].sha)
+
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(129)
+	}
+}
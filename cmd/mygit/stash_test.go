@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStashPushListAndPop(t *testing.T) {
+	initTestRepo(t)
+	writeTestFile(t, "a", "a\n")
+	writeTestFile(t, "b", "b\n")
+	runTestCommand(t, "add", "a", "b")
+	runTestCommand(t, "commit", "-m", "first")
+	head, _ := readRef("refs/heads/master")
+	entry := "WIP on master: " + head[:7] + " first"
+	checkFiles := func(when string, want map[string]string) {
+		t.Helper()
+		for name, contents := range want {
+			if got, _ := os.ReadFile(name); string(got) != contents {
+				t.Errorf("%s: %s is %q, want %q", when, name, got, contents)
+			}
+		}
+	}
+
+	// the staged and unstaged changes are both saved, and the tree goes back to HEAD
+	writeTestFile(t, "a", "staged\n")
+	runTestCommand(t, "add", "a")
+	writeTestFile(t, "b", "worktree\n")
+	if stdout := runTestCommand(t, "stash"); stdout != "Saved working directory and index state "+entry+"\n" {
+		t.Errorf("stash printed %q", stdout)
+	}
+	checkFiles("after stash", map[string]string{"a": "a\n", "b": "b\n"})
+	if status := runTestCommand(t, "status", "--short"); status != "" {
+		t.Errorf("status after stash:\n%s", status)
+	}
+	stash, err := readRef("refs/stash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := readCommit(stash)
+	if err != nil || len(commit.Parents) != 2 || commit.Parents[0] != head {
+		t.Fatalf("the stash is %s (%v), want a commit on HEAD and the index", stash, err)
+	}
+	index, err := readCommit(commit.Parents[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := treeFiles(index.Tree); files["a"].sha != hashObject("blob", []byte("staged\n")) {
+		t.Error("the stash's index commit doesn't have the staged a")
+	}
+
+	writeTestFile(t, "b", "again\n")
+	runTestCommand(t, "stash")
+	if list := runTestCommand(t, "stash", "list"); list != "stash@{0}: "+entry+"\nstash@{1}: "+entry+"\n" {
+		t.Errorf("stash list:\n%s", list)
+	}
+
+	// pop applies the newest and drops it
+	runTestCommand(t, "stash", "pop")
+	checkFiles("after pop", map[string]string{"a": "a\n", "b": "again\n"})
+	if list := runTestCommand(t, "stash", "list"); list != "stash@{0}: "+entry+"\n" {
+		t.Errorf("stash list after pop:\n%s", list)
+	}
+
+	// a conflict is left marked up and the stash is kept
+	writeTestFile(t, "b", "conflict\n")
+	runTestCommand(t, "add", "b")
+	runTestCommand(t, "commit", "-m", "conflict")
+	stdout, stderr, code := runMygit(t, "stash", "pop")
+	if code == 0 || !strings.Contains(stdout+stderr, "CONFLICT (content): Merge conflict in b") {
+		t.Errorf("stash pop with a conflict: exit %d, %q, %q", code, stdout, stderr)
+	}
+	checkFiles("after the conflict", map[string]string{"b": "<<<<<<< Updated upstream\nconflict\n=======\nworktree\n>>>>>>> Stashed changes\n"})
+	if list := runTestCommand(t, "stash", "list"); list != "stash@{0}: "+entry+"\n" {
+		t.Errorf("stash list after the conflict:\n%s", list)
+	}
+}
@@ -0,0 +1,29 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+func fillStatData(entry *indexEntry, fi os.FileInfo) {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	entry.ctimeSec = uint32(stat.Ctim.Sec)
+	entry.ctimeNsec = uint32(stat.Ctim.Nsec)
+	entry.dev = uint32(stat.Dev)
+	entry.ino = uint32(stat.Ino)
+	entry.uid = stat.Uid
+	entry.gid = stat.Gid
+}
+
+// diskUsage is the space a file takes up on disk, as git counts it
+func diskUsage(fi os.FileInfo) int64 {
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return stat.Blocks * 512
+	}
+	return fi.Size()
+}
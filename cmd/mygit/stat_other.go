@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// Without a portable stat, fall back to the ctime-less fields from os.FileInfo
+func fillStatData(entry *indexEntry, fi os.FileInfo) {
+	entry.ctimeSec = uint32(fi.ModTime().Unix())
+	entry.ctimeNsec = uint32(fi.ModTime().Nanosecond())
+}
+
+func diskUsage(fi os.FileInfo) int64 {
+	return fi.Size()
+}
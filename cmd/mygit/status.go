@@ -0,0 +1,749 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+/*
+Each changed path gets a two-letter status, as in "git status --short": the first letter
+compares the index with HEAD and the second the working tree with the index. " " is unchanged,
+"M" modified, "T" changed in type, "A" added, "D" deleted and "R" renamed. Unmerged paths have
+a pair made from "U" (updated by a side), "A" (added by it) and "D" (deleted by it), such as
+"UU" when both sides changed the file, and untracked paths are "??".
+*/
+
+// statusEntry is one line of the short status
+type statusEntry struct {
+	code    string
+	path    string
+	renamed string //the path it was renamed from, for "R"
+}
+
+// unmergedCode gives the status pair for a path with conflicts from the stages it has
+func unmergedCode(stages map[uint16]bool) string {
+	switch {
+	case stages[1] && stages[2] && stages[3]:
+		return "UU"
+	case stages[2] && stages[3]:
+		return "AA"
+	case stages[1] && stages[2]:
+		return "UD"
+	case stages[1] && stages[3]:
+		return "DU"
+	case stages[2]:
+		return "AU"
+	case stages[3]:
+		return "UA"
+	}
+	return "DD"
+}
+
+// changeCode is the status letter for a path going from entry a to entry b, " " when it
+// didn't change
+func changeCode(a, b *treeEntry) byte {
+	switch {
+	case a == nil && b == nil:
+		return ' '
+	case a == nil:
+		return 'A'
+	case b == nil:
+		return 'D'
+	case a.mode&0o170000 != b.mode&0o170000:
+		return 'T'
+	case a.sha != b.sha || a.mode != b.mode:
+		return 'M'
+	}
+	return ' '
+}
+
+// similarity estimates how much of two files is the same, from 0 to 1: the bytes in the lines
+// they share over the size of the larger one
+func similarity(a, b []byte) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	aLines, bLines := splitLines(a), splitLines(b)
+	shared := 0
+	for _, m := range matchLines(aLines, bLines) {
+		shared += len(aLines[m.a])
+	}
+	larger := len(a)
+	if len(b) > larger {
+		larger = len(b)
+	}
+	return float64(shared) / float64(larger)
+}
+
+// findRenames pairs paths deleted from HEAD with paths added to the index that have the same
+// contents or, failing that, are at least half the same, as git does by default. It returns
+// the source of each added path that was renamed.
+func findRenames(deleted, added map[string]treeEntry) map[string]string {
+	sources := map[string]string{}
+	used := map[string]bool{}
+	var addedPaths, deletedPaths []string
+	for filePath := range added {
+		addedPaths = append(addedPaths, filePath)
+	}
+	for filePath := range deleted {
+		deletedPaths = append(deletedPaths, filePath)
+	}
+	sort.Strings(addedPaths)
+	sort.Strings(deletedPaths)
+
+	for _, to := range addedPaths {
+		for _, from := range deletedPaths {
+			if !used[from] && deleted[from].sha == added[to].sha {
+				sources[to], used[from] = from, true
+				break
+			}
+		}
+	}
+	for _, to := range addedPaths {
+		if sources[to] != "" {
+			continue
+		}
+		_, newContents, err := parseObject(added[to].sha)
+		if err != nil || isBinary(newContents) {
+			continue
+		}
+		best, bestScore := "", 0.5
+		for _, from := range deletedPaths {
+			if used[from] {
+				continue
+			}
+			_, oldContents, err := parseObject(deleted[from].sha)
+			if err != nil || isBinary(oldContents) {
+				continue
+			}
+			if score := similarity(oldContents, newContents); score >= bestScore {
+				best, bestScore = from, score
+			}
+		}
+		if best != "" {
+			sources[to], used[best] = best, true
+		}
+	}
+	return sources
+}
+
+// untrackedPaths lists the files in the working tree that aren't in the index or ignored. A
+// directory with nothing tracked in it is listed as a whole, with a trailing slash, as long as
+// there is something in it that isn't ignored.
+func untrackedPaths(tracked map[string]bool) ([]string, error) {
+	trackedDirs := map[string]bool{}
+	for filePath := range tracked {
+		for dir := path.Dir(filePath); dir != "."; dir = path.Dir(dir) {
+			trackedDirs[dir] = true
+		}
+	}
+	ignore := newIgnoreMatcher()
+	var untracked []string
+	// hasFiles reports whether dir has anything in it that isn't ignored
+	var hasFiles func(dir string) (bool, error)
+	hasFiles = func(dir string) (bool, error) {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return false, err
+		}
+		for _, file := range files {
+			filePath := path.Join(dir, file.Name())
+			if file.Name() == ".git" || ignore.isIgnored(filePath, file.IsDir()) {
+				continue
+			}
+			if !file.IsDir() {
+				return true, nil
+			}
+			if found, err := hasFiles(filePath); found || err != nil {
+				return found, err
+			}
+		}
+		return false, nil
+	}
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			filePath := path.Join(dir, file.Name())
+			switch {
+			case file.Name() == ".git", tracked[filePath]:
+			case ignore.isIgnored(filePath, file.IsDir()):
+			case file.IsDir() && trackedDirs[filePath]:
+				if err := walk(filePath); err != nil {
+					return err
+				}
+			case file.IsDir():
+				if found, err := hasFiles(filePath); err != nil {
+					return err
+				} else if found {
+					untracked = append(untracked, filePath+"/")
+				}
+			default:
+				untracked = append(untracked, filePath)
+			}
+		}
+		return nil
+	}
+	err := walk(".")
+	return untracked, err
+}
+
+// shortStatus lists the changed, unmerged and untracked paths the pathspecs match, sorted by
+// path with the untracked ones last
+func shortStatus(renames bool, pathspecs []string) ([]statusEntry, error) {
+	entries, err := readIndex()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	headFiles := map[string]treeEntry{}
+	if head, err := headCommit(); err != nil {
+		return nil, err
+	} else if head != "" {
+		commit, err := readCommit(head)
+		if err != nil {
+			return nil, err
+		}
+		if err := flattenTree(commit.Tree, "", headFiles); err != nil {
+			return nil, err
+		}
+	}
+
+	indexFiles := map[string]treeEntry{}
+	unmerged := map[string]map[uint16]bool{}
+	tracked := map[string]bool{}
+	for _, entry := range entries {
+		tracked[entry.path] = true
+		if stage := entry.stage(); stage != 0 {
+			if unmerged[entry.path] == nil {
+				unmerged[entry.path] = map[uint16]bool{}
+			}
+			unmerged[entry.path][stage] = true
+			continue
+		}
+		indexFiles[entry.path] = treeEntry{mode: entry.mode, name: entry.path, sha: entry.sha}
+	}
+
+	// the second letter, comparing the working tree with the index
+	worktreeCodes := map[string]byte{}
+	for _, entry := range entries {
+		if entry.stage() != 0 {
+			continue
+		}
+		var changed bool
+		if entry.mode == 0o160000 {
+			side, err := submoduleSide(entry, "")
+			if err != nil {
+				return nil, err
+			}
+			changed = side != nil
+		} else if changed, err = worktreeChanged(entry); err != nil {
+			return nil, err
+		}
+		if !changed {
+			continue
+		}
+		contents, fi, err := readWorktreeFile(entry.path)
+		switch {
+		case os.IsNotExist(err):
+			worktreeCodes[entry.path] = 'D'
+		case err != nil && entry.mode != 0o160000:
+			return nil, err
+		case entry.mode == 0o160000:
+			worktreeCodes[entry.path] = 'M'
+		default:
+			worktree := treeEntry{mode: fileMode(fi), sha: hashObject("blob", contents)}
+			index := indexFiles[entry.path]
+			worktreeCodes[entry.path] = changeCode(&index, &worktree)
+		}
+	}
+
+	var sources map[string]string
+	if renames {
+		deleted, added := map[string]treeEntry{}, map[string]treeEntry{}
+		for filePath, entry := range headFiles {
+			if _, ok := indexFiles[filePath]; !ok && unmerged[filePath] == nil {
+				deleted[filePath] = entry
+			}
+		}
+		for filePath, entry := range indexFiles {
+			if _, ok := headFiles[filePath]; !ok {
+				added[filePath] = entry
+			}
+		}
+		sources = findRenames(deleted, added)
+	}
+	renamedFrom := map[string]bool{}
+	for _, from := range sources {
+		renamedFrom[from] = true
+	}
+
+	paths := map[string]bool{}
+	for filePath := range headFiles {
+		paths[filePath] = true
+	}
+	for filePath := range tracked {
+		paths[filePath] = true
+	}
+	var status []statusEntry
+	for filePath := range paths {
+		from := sources[filePath]
+		if !matchesPathspec(filePath, pathspecs) && (from == "" || !matchesPathspec(from, pathspecs)) {
+			continue
+		}
+		if stages := unmerged[filePath]; stages != nil {
+			status = append(status, statusEntry{code: unmergedCode(stages), path: filePath})
+			continue
+		}
+		if renamedFrom[filePath] {
+			continue
+		}
+		var head, index *treeEntry
+		if entry, ok := headFiles[filePath]; ok {
+			head = &entry
+		}
+		if entry, ok := indexFiles[filePath]; ok {
+			index = &entry
+		}
+		staged := changeCode(head, index)
+		if sources[filePath] != "" {
+			staged = 'R'
+		}
+		unstaged, ok := worktreeCodes[filePath]
+		if !ok {
+			unstaged = ' '
+		}
+		if staged != ' ' || unstaged != ' ' {
+			status = append(status, statusEntry{code: string(staged) + string(unstaged), path: filePath, renamed: sources[filePath]})
+		}
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].path < status[j].path })
+
+	untracked, err := untrackedPaths(tracked)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(untracked)
+	for _, filePath := range untracked {
+		if !matchesPathspec(strings.TrimSuffix(filePath, "/"), pathspecs) {
+			continue
+		}
+		status = append(status, statusEntry{code: "??", path: filePath})
+	}
+	return status, nil
+}
+
+// upstreamState is how a branch compares to the remote-tracking branch it follows
+type upstreamState struct {
+	tracking      string //the remote-tracking ref
+	gone          bool   //the remote-tracking ref doesn't exist
+	ahead, behind int
+}
+
+// upstreamStatus compares a branch with its upstream; ok is false when it has none
+func upstreamStatus(branch string, head string) (state upstreamState, ok bool, err error) {
+	remote, mergeRef, ok := branchUpstream(branch)
+	if !ok {
+		return state, false, nil
+	}
+	if state.tracking = trackingRef(remote, mergeRef); state.tracking == "" {
+		return state, false, nil
+	}
+	upstream, err := readRef(state.tracking)
+	if err != nil {
+		state.gone = true
+		return state, true, nil
+	}
+
+	ours, err := ancestors([]string{head})
+	if err != nil {
+		return state, false, err
+	}
+	theirs, err := ancestors([]string{upstream})
+	if err != nil {
+		return state, false, err
+	}
+	for sha := range ours {
+		if theirs[sha] == nil {
+			state.ahead++
+		}
+	}
+	for sha := range theirs {
+		if ours[sha] == nil {
+			state.behind++
+		}
+	}
+	return state, true, nil
+}
+
+// branchStatus is the "## ..." line status --branch starts with: the branch, and how it
+// compares to its upstream, e.g. "## main...origin/main [ahead 1, behind 2]"
+func branchStatus() (string, error) {
+	isBranch, ref, err := ReadHEAD(".git")
+	if err != nil {
+		return "", err
+	}
+	head, err := headCommit()
+	if err != nil {
+		return "", err
+	}
+	branch := strings.TrimPrefix(ref, "refs/heads/")
+	switch {
+	case !isBranch:
+		return "## HEAD (no branch)", nil
+	case head == "":
+		return "## No commits yet on " + branch, nil
+	}
+	line := "## " + branch
+	state, ok, err := upstreamStatus(branch, head)
+	if err != nil || !ok {
+		return line, err
+	}
+	line += "..." + shortRefName(state.tracking)
+	if state.gone {
+		return line + " [gone]", nil
+	}
+	var counts []string
+	if state.ahead > 0 {
+		counts = append(counts, fmt.Sprintf("ahead %d", state.ahead))
+	}
+	if state.behind > 0 {
+		counts = append(counts, fmt.Sprintf("behind %d", state.behind))
+	}
+	if len(counts) > 0 {
+		line += " [" + strings.Join(counts, ", ") + "]"
+	}
+	return line, nil
+}
+
+// isUnmerged reports whether the entry is a path with conflicts
+func (e statusEntry) isUnmerged() bool {
+	return strings.Contains(e.code, "U") || e.code == "AA" || e.code == "DD"
+}
+
+// detachedStatus describes a detached HEAD the way git does, from the last checkout in HEAD's
+// reflog: "HEAD detached at <what was checked out>", or "from" once HEAD has moved on
+func detachedStatus(head string) (string, error) {
+	entries, err := readReflog("HEAD")
+	if err != nil {
+		return "", err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		moved, ok := strings.CutPrefix(entries[i].message, "checkout: moving from ")
+		if !ok {
+			continue
+		}
+		_, to, ok := strings.Cut(moved, " to ")
+		if !ok {
+			break
+		}
+		// a ref is named as it was checked out, as long as it still points there
+		from := entries[i].new[:7]
+		if ref, ok := fullRefName(to); ok && to != "HEAD" {
+			if sha, err := readRef(ref); err == nil {
+				if commit, err := peelToCommit(sha); err == nil && commit == entries[i].new {
+					from = strings.TrimPrefix(strings.TrimPrefix(ref, "refs/tags/"), "refs/remotes/")
+				}
+			}
+		}
+		if head == entries[i].new {
+			return "HEAD detached at " + from, nil
+		}
+		return "HEAD detached from " + from, nil
+	}
+	return "Not currently on any branch.", nil
+}
+
+// printLongStatus prints the status the way "git status" does by default: the branch, then
+// sections of staged, unmerged, unstaged and untracked paths, with hints on what to do next
+// unless advice.statusHints is false
+func printLongStatus(status []statusEntry) error {
+	hints := configBool("advice.statusHints", true)
+	hint := func(format string, a ...any) {
+		if hints {
+			fmt.Printf("  ("+format+")\n", a...)
+		}
+	}
+	isBranch, ref, err := ReadHEAD(".git")
+	if err != nil {
+		return err
+	}
+	head, err := headCommit()
+	if err != nil {
+		return err
+	}
+	branch := ""
+	if isBranch {
+		branch = strings.TrimPrefix(ref, "refs/heads/")
+		fmt.Printf("On branch %s\n", branch)
+	} else {
+		line, err := detachedStatus(head)
+		if err != nil {
+			return err
+		}
+		fmt.Println(line)
+	}
+
+	if state, ok, err := upstreamStatus(branch, head); err != nil {
+		return err
+	} else if ok && head != "" {
+		upstream := shortRefName(state.tracking)
+		plural := func(n int) string {
+			if n == 1 {
+				return ""
+			}
+			return "s"
+		}
+		switch {
+		case state.gone:
+			fmt.Printf("Your branch is based on '%s', but the upstream is gone.\n", upstream)
+			hint(`use "git branch --unset-upstream" to fixup`)
+		case state.ahead > 0 && state.behind > 0:
+			fmt.Printf("Your branch and '%s' have diverged,\nand have %d and %d different commits each, respectively.\n", upstream, state.ahead, state.behind)
+			hint(`use "git pull" to merge the remote branch into yours`)
+		case state.ahead > 0:
+			fmt.Printf("Your branch is ahead of '%s' by %d commit%s.\n", upstream, state.ahead, plural(state.ahead))
+			hint(`use "git push" to publish your local commits`)
+		case state.behind > 0:
+			fmt.Printf("Your branch is behind '%s' by %d commit%s, and can be fast-forwarded.\n", upstream, state.behind, plural(state.behind))
+			hint(`use "git pull" to update your local branch`)
+		default:
+			fmt.Printf("Your branch is up to date with '%s'.\n", upstream)
+		}
+		fmt.Println()
+	}
+
+	var staged, unmerged, unstaged, untracked []statusEntry
+	for _, entry := range status {
+		switch {
+		case entry.code == "??":
+			untracked = append(untracked, entry)
+		case entry.isUnmerged():
+			unmerged = append(unmerged, entry)
+		default:
+			if entry.code[0] != ' ' {
+				staged = append(staged, entry)
+			}
+			if entry.code[1] != ' ' {
+				unstaged = append(unstaged, entry)
+			}
+		}
+	}
+
+	merging := fileExists(mergeHeadPath)
+	switch {
+	case merging && len(unmerged) > 0:
+		fmt.Println("You have unmerged paths.")
+		hint(`fix conflicts and run "git commit"`)
+		hint(`use "git merge --abort" to abort the merge`)
+		fmt.Println()
+	case merging:
+		fmt.Println("All conflicts fixed but you are still merging.")
+		hint(`use "git commit" to conclude merge`)
+		fmt.Println()
+	case fileExists(bisectStartPath):
+		start, err := os.ReadFile(bisectStartPath)
+		if err != nil {
+			return err
+		}
+		from := strings.TrimSpace(string(start))
+		if isHexSHA(from) {
+			from = from[:7]
+		}
+		fmt.Printf("You are currently bisecting, started from branch '%s'.\n", from)
+		hint(`use "git bisect reset" to get back to the original branch`)
+		fmt.Println()
+	}
+	if head == "" {
+		fmt.Print("\nNo commits yet\n\n")
+	}
+
+	// unstageHint is how to take a change out of the index, which git leaves out while merging
+	unstageHint := func() {
+		switch {
+		case merging:
+		case head == "":
+			hint(`use "git rm --cached <file>..." to unstage`)
+		default:
+			hint(`use "git restore --staged <file>..." to unstage`)
+		}
+	}
+	if len(staged) > 0 {
+		fmt.Println("Changes to be committed:")
+		unstageHint()
+		labels := map[byte]string{'A': "new file:", 'M': "modified:", 'D': "deleted:", 'T': "typechange:", 'R': "renamed:"}
+		for _, entry := range staged {
+			if entry.renamed != "" {
+				fmt.Printf("\t%-12s%s -> %s\n", labels[entry.code[0]], entry.renamed, entry.path)
+			} else {
+				fmt.Printf("\t%-12s%s\n", labels[entry.code[0]], entry.path)
+			}
+		}
+		fmt.Println()
+	}
+	if len(unmerged) > 0 {
+		fmt.Println("Unmerged paths:")
+		unstageHint()
+		bothDeleted, deleteConflict, notDeleted := false, false, false
+		for _, entry := range unmerged {
+			switch entry.code {
+			case "DD":
+				bothDeleted = true
+			case "UD", "DU":
+				deleteConflict = true
+			default:
+				notDeleted = true
+			}
+		}
+		switch {
+		case !bothDeleted && !deleteConflict:
+			hint(`use "git add <file>..." to mark resolution`)
+		case bothDeleted && !deleteConflict && !notDeleted:
+			hint(`use "git rm <file>..." to mark resolution`)
+		default:
+			hint(`use "git add/rm <file>..." as appropriate to mark resolution`)
+		}
+		labels := map[string]string{
+			"UU": "both modified:", "AA": "both added:", "DD": "both deleted:", "AU": "added by us:",
+			"UA": "added by them:", "DU": "deleted by us:", "UD": "deleted by them:",
+		}
+		for _, entry := range unmerged {
+			fmt.Printf("\t%-17s%s\n", labels[entry.code], entry.path)
+		}
+		fmt.Println()
+	}
+	if len(unstaged) > 0 {
+		fmt.Println("Changes not staged for commit:")
+		deleted := false
+		for _, entry := range unstaged {
+			deleted = deleted || entry.code[1] == 'D'
+		}
+		if deleted {
+			hint(`use "git add/rm <file>..." to update what will be committed`)
+		} else {
+			hint(`use "git add <file>..." to update what will be committed`)
+		}
+		hint(`use "git restore <file>..." to discard changes in working directory`)
+		labels := map[byte]string{'M': "modified:", 'D': "deleted:", 'T': "typechange:"}
+		for _, entry := range unstaged {
+			fmt.Printf("\t%-12s%s\n", labels[entry.code[1]], entry.path)
+		}
+		fmt.Println()
+	}
+	if len(untracked) > 0 {
+		fmt.Println("Untracked files:")
+		hint(`use "git add <file>..." to include in what will be committed`)
+		for _, entry := range untracked {
+			fmt.Printf("\t%s\n", entry.path)
+		}
+		fmt.Println()
+	}
+
+	switch {
+	case len(staged) > 0:
+	case len(unstaged) > 0 || len(unmerged) > 0:
+		if hints {
+			fmt.Println(`no changes added to commit (use "git add" and/or "git commit -a")`)
+		} else {
+			fmt.Println("no changes added to commit")
+		}
+	case len(untracked) > 0:
+		if hints {
+			fmt.Println(`nothing added to commit but untracked files present (use "git add" to track)`)
+		} else {
+			fmt.Println("nothing added to commit but untracked files present")
+		}
+	case head == "":
+		if hints {
+			fmt.Println(`nothing to commit (create/copy files and use "git add" to track)`)
+		} else {
+			fmt.Println("nothing to commit")
+		}
+	default:
+		fmt.Println("nothing to commit, working tree clean")
+	}
+	return nil
+}
+
+// Usage: mygit status [-s | --short | --porcelain | --long] [-b | --branch] [--[no-]renames] [--] [<pathspec>...]
+//
+// Shows the paths that differ between HEAD, the index and the working tree. By default, or
+// with --long, they are listed under "Changes to be committed", "Unmerged paths", "Changes
+// not staged for commit" and "Untracked files", after the branch and how it compares to its
+// upstream, as git does. -s, or status.short, switches to the short format: "XY <path>" for
+// each, with X the status in the index and Y in the working tree (see above), and
+// "R  <old> -> <new>" for a staged rename. With --branch, the short status starts with a line
+// for the current branch and how many commits it is ahead of and behind its upstream.
+// Renames are found unless --no-renames is given, or status.renames, or failing that
+// diff.renames, is false. Pathspecs limit the status to the paths they match.
+func cmdStatus(args []string) {
+	usage := "usage: mygit status [-s | --short | --porcelain | --long] [-b | --branch] [--[no-]renames] [--] [<pathspec>...]\n"
+	showBranch := false
+	short := configBool("status.short", false)
+	renames := configBool("diff.renames", true)
+	renames = configBool("status.renames", renames)
+	var pathspecs []string
+	for i, arg := range args {
+		if arg == "--" {
+			pathspecs = append(pathspecs, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			pathspecs = append(pathspecs, arg)
+			continue
+		}
+		switch arg {
+		case "--short", "--porcelain":
+			short = true
+		case "--long":
+			short = false
+		case "--branch":
+			showBranch = true
+		case "--renames":
+			renames = true
+		case "--no-renames":
+			renames = false
+		default:
+			// short options can be combined, as in -sb
+			if strings.HasPrefix(arg, "--") || strings.Trim(arg[1:], "sb") != "" {
+				fmt.Fprint(os.Stderr, usage)
+				os.Exit(129)
+			}
+			showBranch = showBranch || strings.Contains(arg, "b")
+			short = short || strings.Contains(arg, "s")
+		}
+	}
+	fatal := func(err error) {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	status, err := shortStatus(renames, pathspecs)
+	if err != nil {
+		fatal(err)
+	}
+	if !short {
+		if err := printLongStatus(status); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if showBranch {
+		line, err := branchStatus()
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Println(line)
+	}
+	for _, entry := range status {
+		if entry.renamed != "" {
+			fmt.Printf("%s %s -> %s\n", entry.code, entry.renamed, entry.path)
+		} else {
+			fmt.Printf("%s %s\n", entry.code, entry.path)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// The expected output is what git status prints for the same repository.
+func TestStatusComparesHeadIndexAndWorktree(t *testing.T) {
+	initTestRepo(t)
+	writeTestFile(t, "a", "a\n")
+	writeTestFile(t, "b", "b\n")
+	writeTestFile(t, "c", "c\n")
+	runTestCommand(t, "add", "a", "b", "c")
+	runTestCommand(t, "commit", "-m", "first")
+	if got, want := runTestCommand(t, "status"), "On branch master\nnothing to commit, working tree clean\n"; got != want {
+		t.Errorf("status of a clean tree:\n%s\nwant:\n%s", got, want)
+	}
+
+	writeTestFile(t, "a", "changed\n")
+	if err := os.Remove("b"); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, "c", "staged\n")
+	runTestCommand(t, "add", "c")
+	writeTestFile(t, "u", "u\n")
+	writeTestFile(t, "d/x", "x\n")
+	writeTestFile(t, "i.log", "ignored\n")
+	writeTestFile(t, ".gitignore", "*.log\n")
+
+	want := "On branch master\n" +
+		"Changes to be committed:\n" +
+		"  (use \"git restore --staged <file>...\" to unstage)\n" +
+		"\tmodified:   c\n" +
+		"\n" +
+		"Changes not staged for commit:\n" +
+		"  (use \"git add/rm <file>...\" to update what will be committed)\n" +
+		"  (use \"git restore <file>...\" to discard changes in working directory)\n" +
+		"\tmodified:   a\n" +
+		"\tdeleted:    b\n" +
+		"\n" +
+		"Untracked files:\n" +
+		"  (use \"git add <file>...\" to include in what will be committed)\n" +
+		"\t.gitignore\n" +
+		"\td/\n" +
+		"\tu\n" +
+		"\n"
+	if got := runTestCommand(t, "status"); got != want {
+		t.Errorf("status:\n%s\nwant:\n%s", got, want)
+	}
+	want = " M a\n D b\nM  c\n?? .gitignore\n?? d/\n?? u\n"
+	if got := runTestCommand(t, "status", "--short"); got != want {
+		t.Errorf("status --short:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+A submodule is recorded in its superproject as a gitlink, a tree or index entry with mode
+160000 holding the SHA of a commit in the submodule's own repository. The submodule's working
+tree is checked out at the entry's path, with a .git that is either its git directory or a
+"gitdir: <path>" file pointing at one, usually under the superproject's .git/modules.
+*/
+
+// ignoreSubmodulesValue checks a --ignore-submodules or diff.ignoreSubmodules value: "none"
+// shows every change, "untracked" ignores untracked files in submodules, "dirty" any changes
+// to their working trees, and "all" submodules altogether
+func ignoreSubmodulesValue(value string) bool {
+	switch value {
+	case "none", "untracked", "dirty", "all":
+		return true
+	}
+	return false
+}
+
+// submoduleGitDir finds the git directory of the submodule checked out at dir, or returns ""
+// when it isn't checked out
+func submoduleGitDir(dir string) (string, error) {
+	dotGit := filepath.Join(dir, ".git")
+	fi, err := os.Stat(dotGit)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if fi.IsDir() {
+		return dotGit, nil
+	}
+	contents, err := os.ReadFile(dotGit)
+	if err != nil {
+		return "", err
+	}
+	gitDir, ok := strings.CutPrefix(strings.TrimSpace(string(contents)), "gitdir: ")
+	if !ok {
+		return "", fmt.Errorf("invalid gitfile format: %s", dotGit)
+	}
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(dir, gitDir)
+	}
+	return gitDir, nil
+}
+
+// submoduleHead returns the commit checked out in a submodule, following HEAD through a
+// loose or packed branch
+func submoduleHead(gitDir string) (string, error) {
+	contents, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return "", err
+	}
+	head := strings.TrimSpace(string(contents))
+	ref, symbolic := strings.CutPrefix(head, "ref: ")
+	if !symbolic {
+		return head, nil
+	}
+	if contents, err := os.ReadFile(filepath.Join(gitDir, ref)); err == nil {
+		return strings.TrimSpace(string(contents)), nil
+	}
+	packed, err := os.ReadFile(filepath.Join(gitDir, "packed-refs"))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	for _, line := range strings.Split(string(packed), "\n") {
+		if sha, name, ok := strings.Cut(line, " "); ok && name == ref {
+			return sha, nil
+		}
+	}
+	return "", nil //an unborn branch
+}
+
+// submoduleDirty reports whether the working tree of the submodule at dir has changes to its
+// tracked files or, when untracked is set, files it doesn't track that aren't ignored
+func submoduleDirty(dir string, gitDir string, untracked bool) (bool, error) {
+	entries, err := readIndexFile(filepath.Join(gitDir, "index"))
+	if os.IsNotExist(err) {
+		entries, err = nil, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	// the checks below work relative to the submodule's top, as they do to the superproject's
+	cwd, err := os.Getwd()
+	if err != nil {
+		return false, err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return false, err
+	}
+	defer os.Chdir(cwd)
+
+	tracked := map[string]bool{}
+	for _, entry := range entries {
+		tracked[entry.path] = true
+		if entry.mode == 0o160000 {
+			continue //a nested submodule's own changes don't count
+		}
+		if changed, err := worktreeChanged(entry); err != nil || changed {
+			return changed, err
+		}
+	}
+	if !untracked {
+		return false, nil
+	}
+
+	matcher := newIgnoreMatcher()
+	dirty := false
+	err = filepath.WalkDir(".", func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil || filePath == "." {
+			return err
+		}
+		filePath = filepath.ToSlash(filePath)
+		switch {
+		case d.Name() == ".git": //a directory, or a file pointing at one
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+		case tracked[filePath] && d.IsDir():
+			return filepath.SkipDir
+		case matcher.isIgnored(filePath, d.IsDir()):
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+		case !d.IsDir() && !tracked[filePath]:
+			dirty = true
+			return fs.SkipAll
+		}
+		return nil
+	})
+	return dirty, err
+}
+
+// submoduleSide describes the working tree side of a gitlink for diff: the commit checked out
+// in the submodule, marked "-dirty" when its working tree has changes that aren't ignored.
+// Like git, untracked files only count when ignore is "none" itself, not when it is unset. It
+// returns nil when the submodule isn't checked out or nothing about it is to be shown.
+func submoduleSide(entry indexEntry, ignore string) (*diffSide, error) {
+	if ignore == "all" {
+		return nil, nil
+	}
+	gitDir, err := submoduleGitDir(entry.path)
+	if err != nil || gitDir == "" {
+		return nil, err
+	}
+	head, err := submoduleHead(gitDir)
+	if err != nil || head == "" {
+		return nil, err
+	}
+	dirty := false
+	if ignore != "dirty" {
+		if dirty, err = submoduleDirty(entry.path, gitDir, ignore == "none"); err != nil {
+			return nil, err
+		}
+	}
+	if head == entry.sha && !dirty {
+		return nil, nil
+	}
+	side := &diffSide{mode: 0o160000, sha: head, contents: []byte("Subproject commit " + head)}
+	if dirty {
+		side.contents = append(side.contents, "-dirty"...)
+	}
+	side.contents = append(side.contents, '\n')
+	return side, nil
+}
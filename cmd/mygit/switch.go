@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+// Usage:
+//
+//	mygit switch [-f|--force] <branch>
+//	mygit switch [-f|--force] (-c|-C) <new-branch> [<start-point>]
+//	mygit switch [-f|--force] --detach [<commit>]
+//
+// -c creates <new-branch> at <start-point>, HEAD by default, and switches to it; -C does the
+// same when the branch already exists, resetting it. --detach points HEAD straight at the
+// commit, HEAD's by default, rather than at a branch.
+func cmdSwitch(args []string) {
+	usage := "usage: mygit switch [--force] <branch>\n" +
+		"   or: mygit switch [--force] (-c | -C) <new-branch> [<start-point>]\n" +
+		"   or: mygit switch [--force] --detach [<commit>]\n"
+	create, reset, detach, force := false, false, false, false
+	var names []string
+	for _, arg := range args {
+		switch arg {
+		case "-c", "--create":
+			create = true
+		case "-C", "--force-create":
+			create, reset = true, true
+		case "-d", "--detach":
+			detach = true
+		case "-f", "--force", "--discard-changes":
+			force = true
+		default:
+			if len(arg) > 0 && arg[0] == '-' {
+				fmt.Fprint(os.Stderr, usage)
+				os.Exit(1)
+			}
+			names = append(names, arg)
+		}
+	}
+	switch {
+	case create && detach,
+		create && (len(names) == 0 || len(names) > 2),
+		detach && len(names) > 1,
+		!create && !detach && len(names) != 1:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+
+	isBranch, current, err := ReadHEAD(".git")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading HEAD: %s\n", err)
+		os.Exit(1)
+	}
+	headSha, err := headCommit()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error resolving HEAD: %s\n", err)
+		os.Exit(1)
+	}
+
+	if create {
+		branch, start := names[0], "HEAD"
+		if len(names) == 2 {
+			start = names[1]
+		}
+		existed, err := switchToNewBranch(branch, start, headSha, !isBranch, reset, force)
+		if err != nil {
+			switchFailed(err)
+		}
+		switch {
+		case !existed:
+			fmt.Fprintf(os.Stderr, "Switched to a new branch '%s'\n", branch)
+		case isBranch && current == path.Join("refs", "heads", branch):
+			fmt.Fprintf(os.Stderr, "Reset branch '%s'\n", branch)
+		default:
+			fmt.Fprintf(os.Stderr, "Switched to and reset branch '%s'\n", branch)
+		}
+		return
+	}
+	if detach {
+		rev := "HEAD"
+		if len(names) == 1 {
+			rev = names[0]
+		}
+		if err := switchDetached(rev, headSha, !isBranch, force); err != nil {
+			switchFailed(err)
+		}
+		return
+	}
+
+	branch := names[0]
+	targetSha, err := readRef(path.Join("refs", "heads", branch))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: invalid reference: %s\n", branch)
+		os.Exit(128)
+	}
+	// with --force, the current branch is still checked out again, discarding local changes
+	already := isBranch && current == path.Join("refs", "heads", branch)
+	if already && !force {
+		fmt.Fprintf(os.Stderr, "Already on '%s'\n", branch)
+		return
+	}
+	if err := switchWorktree(headSha, targetSha, force); err != nil {
+		switchFailed(err)
+	}
+	if !isBranch {
+		if err := leaveDetachedHead(headSha, targetSha); err != nil {
+			switchFailed(err)
+		}
+	}
+	if err := setHead(branch, "checkout: moving from "+headDescription()+" to "+branch); err != nil {
+		fmt.Fprintf(os.Stderr, "Error updating HEAD: %s\n", err)
+		os.Exit(1)
+	}
+	if already {
+		fmt.Fprintf(os.Stderr, "Already on '%s'\n", branch)
+	} else {
+		fmt.Fprintf(os.Stderr, "Switched to branch '%s'\n", branch)
+	}
+}
+
+// switchFailed reports why a switch stopped and exits: local changes that would be lost, or
+// anything else as a fatal error
+func switchFailed(err error) {
+	if _, ok := err.(*checkoutConflictError); ok {
+		fmt.Fprintf(os.Stderr, "error: %s\nAborting\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+	os.Exit(128)
+}
+
+// switchWorktree is checkoutCommit for switch, which can force local changes to be discarded
+func switchWorktree(fromSha string, toSha string, force bool) error {
+	fromTree, err := commitTree(fromSha)
+	if err != nil {
+		return err
+	}
+	toTree, err := commitTree(toSha)
+	if err != nil {
+		return err
+	}
+	return checkoutTree(fromTree, toTree, force)
+}
+
+// resolveStartPoint resolves the commit a new branch or detached HEAD is to start at
+func resolveStartPoint(rev string) (string, error) {
+	sha, err := resolveObjectArg(rev)
+	if err == nil {
+		sha, err = peelToCommit(sha)
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid reference: %s", rev)
+	}
+	return sha, nil
+}
+
+// switchToNewBranch creates branch at start and points HEAD at it, first moving the working
+// tree and index there from headSha unless start is HEAD; on an unborn HEAD only HEAD moves.
+// With reset, a branch that exists already is moved to start instead; existed reports
+// whether it did. detached says HEAD isn't on a branch now, so leaving it is reported.
+func switchToNewBranch(branch string, start string, headSha string, detached bool, reset bool, force bool) (existed bool, err error) {
+	if !validBranchName(branch) {
+		return false, fmt.Errorf("'%s' is not a valid branch name", branch)
+	}
+	existed = branchExists(branch)
+	if existed && !reset {
+		return false, fmt.Errorf("a branch named '%s' already exists", branch)
+	}
+	startSha := headSha
+	if start != "HEAD" || headSha != "" {
+		if startSha, err = resolveStartPoint(start); err != nil {
+			return false, err
+		}
+	}
+	if startSha != headSha {
+		if err := switchWorktree(headSha, startSha, force); err != nil {
+			return false, err
+		}
+	}
+	if detached {
+		if err := leaveDetachedHead(headSha, startSha); err != nil {
+			return false, err
+		}
+	}
+	if startSha != "" {
+		message := "branch: Created from " + start
+		if existed {
+			message = "branch: Reset to " + start
+		}
+		if err := updateRef(path.Join("refs", "heads", branch), startSha, message); err != nil {
+			return false, err
+		}
+		// resetting the current branch moves HEAD too, and git logs it there as well
+		if current, _ := currentBranch(); existed && current == branch {
+			if err := logRefUpdate("HEAD", headSha, startSha, message); err != nil {
+				return false, err
+			}
+		}
+	}
+	return existed, setHead(branch, "checkout: moving from "+headDescription()+" to "+branch)
+}
+
+// switchDetached points HEAD straight at the commit rev names, moving the working tree and
+// index there from headSha, and says where HEAD is now. detached says HEAD isn't on a branch
+// now, so leaving it is reported.
+func switchDetached(rev string, headSha string, detached bool, force bool) error {
+	sha, err := resolveStartPoint(rev)
+	if err != nil {
+		return err
+	}
+	if err := switchWorktree(headSha, sha, force); err != nil {
+		return err
+	}
+	if detached {
+		if err := leaveDetachedHead(headSha, sha); err != nil {
+			return err
+		}
+	}
+	// HEAD already detached at the commit is left as it is, with nothing to log
+	if !detached || sha != headSha {
+		if err := detachHead(sha, "checkout: moving from "+headDescription()+" to "+rev); err != nil {
+			return err
+		}
+	}
+	return describeDetachedHead("HEAD is now at", sha)
+}
+
+// describeDetachedHead prints "<prefix> <short-sha> <subject>", as git does for the commit a
+// detached HEAD is at
+func describeDetachedHead(prefix string, sha string) error {
+	commit, err := readCommit(sha)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s %s %s\n", prefix, sha[:7], commit.Subject())
+	return nil
+}
+
+// leaveDetachedHead says what is left behind when HEAD moves from the detached commit old to
+// new: where HEAD was, or, like git, a warning listing the commits no branch or other ref can
+// reach any more
+func leaveDetachedHead(old string, new string) error {
+	if old == "" || old == new {
+		return nil
+	}
+	refs, err := listRefs("refs")
+	if err != nil {
+		return err
+	}
+	kept := []string{}
+	if new != "" {
+		kept = append(kept, new)
+	}
+	for _, sha := range refs {
+		if commit, err := peelToCommit(sha); err == nil {
+			kept = append(kept, commit)
+		}
+	}
+	reachable, err := ancestors(kept)
+	if err != nil {
+		return err
+	}
+	order, commits, err := topoOrder([]string{old})
+	if err != nil {
+		return err
+	}
+	var lost []string
+	for _, sha := range order {
+		if reachable[sha] == nil {
+			lost = append(lost, sha)
+		}
+	}
+	if len(lost) == 0 {
+		return describeDetachedHead("Previous HEAD position was", old)
+	}
+
+	// as in git, only the first four are listed, unless there are just five
+	shown := lost
+	if len(lost) > 5 {
+		shown = lost[:4]
+	}
+	it, commitNoun := "it", "commit"
+	if len(lost) > 1 {
+		it, commitNoun = "them", "commits"
+	}
+	fmt.Fprintf(os.Stderr, "Warning: you are leaving %d %s behind, not connected to\nany of your branches:\n\n", len(lost), commitNoun)
+	for _, sha := range shown {
+		fmt.Fprintf(os.Stderr, "  %s %s\n", sha[:7], commits[sha].Subject())
+	}
+	if len(shown) < len(lost) {
+		fmt.Fprintf(os.Stderr, " ... and %d more.\n", len(lost)-len(shown))
+	}
+	fmt.Fprintln(os.Stderr)
+	if configBool("advice.detachedHead", true) {
+		fmt.Fprintf(os.Stderr, "If you want to keep %s by creating a new branch, this may be a good time\nto do so with:\n\n mygit branch <new-branch-name> %s\n\n", it, old[:7])
+	}
+	return nil
+}
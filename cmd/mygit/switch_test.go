@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSwitchCreateRejectsInvalidNames(t *testing.T) {
+	initTestRepo(t)
+	head := testCommit(t, 0, "initial", map[string]string{"a": "a\n"})
+	if err := updateRef("refs/heads/master", head, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"../../evil", "../x", "a..b", "a b", "x.lock", ".hidden", "HEAD"} {
+		t.Run(name, func(t *testing.T) {
+			_, stderr, code := runMygit(t, "switch", "-c", name)
+			if code != 128 || !strings.Contains(stderr, "is not a valid branch name") {
+				t.Errorf("switch -c %s: exit %d, %q; want 128 and an invalid name error", name, code, stderr)
+			}
+			if branch, _ := currentBranch(); branch != "master" {
+				t.Errorf("HEAD moved to %q", branch)
+			}
+		})
+	}
+	for _, stray := range []string{".git/evil", "evil", ".git/refs/x"} {
+		if _, err := os.Stat(stray); err == nil {
+			t.Errorf("%s was written", stray)
+		}
+	}
+
+	runTestCommand(t, "switch", "-c", "topic/new")
+	if branch, _ := currentBranch(); branch != "topic/new" {
+		t.Errorf("HEAD is on %q after switch -c topic/new", branch)
+	}
+	if sha, err := readRef("refs/heads/topic/new"); err != nil || sha != head {
+		t.Errorf("topic/new is at %q (%v), want %s", sha, err, head)
+	}
+}
+
+func TestSwitchStartPointAndDetach(t *testing.T) {
+	initTestRepo(t)
+	first := testCommit(t, 0, "first", map[string]string{"a": "1\n"})
+	second := testCommit(t, 100, "second", map[string]string{"a": "2\n"}, first)
+	if err := updateRef("refs/heads/master", second, ""); err != nil {
+		t.Fatal(err)
+	}
+	runTestCommand(t, "checkout", "-f", "master")
+
+	// -c branches from the start point and moves the working tree there
+	runTestCommand(t, "switch", "-c", "old", "HEAD~1")
+	if sha, _ := readRef("refs/heads/old"); sha != first {
+		t.Errorf("old is at %s, want %s", sha, first)
+	}
+	if contents, _ := os.ReadFile("a"); string(contents) != "1\n" {
+		t.Errorf("a is %q after switch -c old HEAD~1", contents)
+	}
+	if _, stderr, code := runMygit(t, "switch", "-c", "old", "master"); code != 128 || !strings.Contains(stderr, "already exists") {
+		t.Errorf("switch -c of an existing branch: exit %d, %q", code, stderr)
+	}
+
+	// -C resets it instead
+	_, stderr, _ := runMygit(t, "switch", "-C", "old", "master")
+	if sha, _ := readRef("refs/heads/old"); sha != second || stderr != "Reset branch 'old'\n" {
+		t.Errorf("switch -C old master: old at %s, %q", sha, stderr)
+	}
+
+	// --detach points HEAD at the commit itself
+	_, stderr, _ = runMygit(t, "switch", "--detach", "HEAD~1")
+	if want := fmt.Sprintf("HEAD is now at %.7s first\n", first); stderr != want {
+		t.Errorf("switch --detach HEAD~1 said %q, want %q", stderr, want)
+	}
+	if isBranch, target, _ := ReadHEAD(".git"); isBranch || target != first {
+		t.Errorf("HEAD is %v %s after switch --detach, want %s", isBranch, target, first)
+	}
+	if contents, _ := os.ReadFile("a"); string(contents) != "1\n" {
+		t.Errorf("a is %q after switch --detach HEAD~1", contents)
+	}
+	_, stderr, _ = runMygit(t, "switch", "master")
+	if want := fmt.Sprintf("Previous HEAD position was %.7s first\nSwitched to branch 'master'\n", first); stderr != want {
+		t.Errorf("switching back said %q, want %q", stderr, want)
+	}
+	if _, stderr, code := runMygit(t, "switch", "--detach", "nowhere"); code != 128 || stderr != "fatal: invalid reference: nowhere\n" {
+		t.Errorf("switch --detach nowhere: exit %d, %q", code, stderr)
+	}
+}
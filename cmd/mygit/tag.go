@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Usage:
+//
+//	mygit tag [-l]
+//	mygit tag [-f] [-a] [-m <msg> | -F <file>] <tagname> [<commit>]
+//	mygit tag -d <tagname>...
+//
+// Lists the tags, or deletes them, whether they are loose files under refs/tags or entries in
+// packed-refs. Given a name, creates a tag on <commit>, HEAD by default: a lightweight one,
+// just the ref, or with -a, -m or -F an annotated one, a tag object carrying the tagger and a
+// message, opening the editor for it when none was given. -f replaces a tag that exists.
+func cmdTag(args []string) {
+	usage := "usage: mygit tag [-l]\n" +
+		"   or: mygit tag [-f] [-a] [-m <msg> | -F <file>] <tagname> [<commit>]\n" +
+		"   or: mygit tag -d <tagname>...\n"
+	if len(args) > 0 && (args[0] == "-d" || args[0] == "--delete") {
+		if len(args) == 1 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		}
+		if !deleteTags(args[1:]) {
+			os.Exit(1)
+		}
+		return
+	}
+	fatal := func(err error) {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(128)
+	}
+	list, annotate, force, messageGiven := false, false, false, false
+	var messages, names []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-l" || arg == "--list":
+			list = true
+		case arg == "-a" || arg == "--annotate":
+			annotate = true
+		case arg == "-f" || arg == "--force":
+			force = true
+		case (arg == "-m" || arg == "--message") && i+1 < len(args):
+			i++
+			messages, messageGiven = append(messages, args[i]), true
+		case (arg == "-F" || arg == "--file") && i+1 < len(args):
+			i++
+			contents, err := os.ReadFile(args[i])
+			if err != nil {
+				fatal(fmt.Errorf("could not open or read '%s': %s", args[i], err))
+			}
+			messages, messageGiven = append(messages, string(contents)), true
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		default:
+			names = append(names, arg)
+		}
+	}
+
+	if len(names) > 0 && !list {
+		if len(names) > 2 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(1)
+		}
+		target := "HEAD"
+		if len(names) == 2 {
+			target = names[1]
+		}
+		message := stripMessageSpace(strings.Join(messages, "\n\n"))
+		if annotate && !messageGiven {
+			var err error
+			if message, err = editTagMessage(names[0]); err != nil {
+				fatal(err)
+			}
+		}
+		if err := createTag(names[0], target, message, annotate || messageGiven, force); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if len(names) > 0 || annotate || force || messageGiven {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+
+	tags, err := listRefs("refs/tags")
+	if err != nil {
+		fatal(err)
+	}
+	names = make([]string, 0, len(tags))
+	for ref := range tags {
+		names = append(names, strings.TrimPrefix(ref, "refs/tags/"))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Println(name)
+	}
+}
+
+// createTag points refs/tags/<name> at target or, when annotated, at a new tag object for it
+// holding message. Without force, the tag may not exist yet.
+func createTag(name string, target string, message string, annotated bool, force bool) error {
+	ref := path.Join("refs", "tags", name)
+	if !validBranchName(name) {
+		return fmt.Errorf("'%s' is not a valid tag name.", name)
+	}
+	previous, err := readRef(ref)
+	exists := err == nil
+	if exists && !force {
+		return fmt.Errorf("tag '%s' already exists", name)
+	}
+	sha, err := resolveObjectArg(target)
+	if err != nil {
+		return fmt.Errorf("Failed to resolve '%s' as a valid ref.", target)
+	}
+
+	if annotated {
+		objType, _, err := readObjectHeader(sha)
+		if err != nil {
+			return err
+		}
+		tagger, err := signature("committer")
+		if err != nil {
+			return err
+		}
+		tag := fmt.Sprintf("object %s\ntype %s\ntag %s\ntagger %s\n", sha, objType, name, tagger)
+		if message != "" {
+			tag += "\n" + message
+		}
+		raw, err := writeObject("tag", []byte(tag))
+		if err != nil {
+			return err
+		}
+		sha = fmt.Sprintf("%x", raw)
+	}
+	if err := updateRef(ref, sha, ""); err != nil {
+		return err
+	}
+	if exists && previous != sha {
+		fmt.Printf("Updated tag '%s' (was %s)\n", name, previous[:7])
+	}
+	return nil
+}
+
+// editTagMessage has the user write the message of a new tag in the editor
+func editTagMessage(name string) (string, error) {
+	editPath := path.Join(".git", "TAG_EDITMSG")
+	template := fmt.Sprintf("\n#\n# Write a message for tag:\n#   %s\n# Lines starting with '#' will be ignored.\n", name)
+	if err := os.WriteFile(editPath, []byte(template), 0644); err != nil {
+		return "", err
+	}
+	if err := launchEditor(editPath); err != nil {
+		return "", err
+	}
+	edited, err := os.ReadFile(editPath)
+	if err != nil {
+		return "", err
+	}
+	message := stripMessageSpace(cleanupMessage(string(edited)))
+	if message == "" {
+		return "", fmt.Errorf("no tag message?")
+	}
+	return message, nil
+}
+
+// deleteTags deletes each named tag, carrying on past ones that don't exist, and reports
+// whether they were all deleted
+func deleteTags(names []string) bool {
+	ok := true
+	for _, name := range names {
+		ref := path.Join("refs", "tags", name)
+		sha, err := readRef(ref)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: tag '%s' not found.\n", name)
+			ok = false
+			continue
+		}
+		if err := deleteRef(ref); err != nil {
+			fmt.Fprintf(os.Stderr, "error: could not delete tag '%s': %s\n", name, err)
+			ok = false
+			continue
+		}
+		fmt.Printf("Deleted tag '%s' (was %s)\n", name, sha[:7])
+	}
+	return ok
+}
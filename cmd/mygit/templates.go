@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const systemTemplateDir = "/usr/share/git-core/templates"
+
+// templateDir picks the directory init copies into a new .git: --template, then
+// $GIT_TEMPLATE_DIR, init.templateDir, ~/.config/git/template and finally the system templates.
+// explicit says whether the user named it, so a missing one is worth a warning.
+func templateDir(flag string, flagGiven bool) (dir string, explicit bool) {
+	if flagGiven {
+		return flag, true
+	}
+	if dir, ok := os.LookupEnv("GIT_TEMPLATE_DIR"); ok {
+		return dir, true
+	}
+	if dir, ok := configGet("init.templateDir"); ok {
+		return expandHome(dir), true
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		if dir := filepath.Join(home, ".config", "git", "template"); fileExists(dir) {
+			return dir, false
+		}
+	}
+	return systemTemplateDir, false
+}
+
+// expandHome replaces a leading "~/" with the user's home directory
+func expandHome(filePath string) string {
+	if rest, ok := strings.CutPrefix(filePath, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return filePath
+}
+
+// copyTemplate copies everything under templateDir into gitDir, keeping file modes and
+// symlinks. Files that already exist are left alone, so re-running init never clobbers hooks
+// or config that have been changed since.
+func copyTemplate(templateDir string, gitDir string) error {
+	return filepath.WalkDir(templateDir, func(src string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(templateDir, src)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(gitDir, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(dst, info.Mode().Perm()|0700)
+		case fileExists(dst):
+			return nil
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(src)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, dst)
+		case info.Mode().IsRegular():
+			contents, err := os.ReadFile(src)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(dst, contents, info.Mode().Perm())
+		}
+		return nil //sockets and the like have no business in a repository
+	})
+}
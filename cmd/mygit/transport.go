@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+/*
+Besides smart HTTP, a remote can be reached the way git reaches ssh and local repositories: by
+running the service at the other end and talking to it over its stdin and stdout.
+"ssh://[user@]host[:port]/path" and "[user@]host:path" run it with ssh, and a plain path or a
+file:// URL runs it here. The service sends its ref advertisement first, without the
+"# service=" line HTTP adds, then reads one request and answers it, just as a POST would.
+
+The program run is git-upload-pack or git-receive-pack, unless --upload-pack or --receive-pack
+names another, such as a wrapper the server requires, or remote.<name>.uploadpack or
+remote.<name>.receivepack does for that remote.
+*/
+
+// servicePrograms are the programs to run for services, when not the service's own name
+var servicePrograms = map[string]string{}
+
+// remoteServicePrograms fills in the programs remote.<name>.uploadpack and receivepack name
+// for the services no option has picked a program for already
+func remoteServicePrograms(remote string) {
+	for service, key := range map[string]string{"git-upload-pack": "uploadpack", "git-receive-pack": "receivepack"} {
+		if program, ok := configGet("remote." + remote + "." + key); ok && servicePrograms[service] == "" {
+			servicePrograms[service] = program
+		}
+	}
+}
+
+// isHTTPURL reports whether a remote is reached over smart HTTP rather than by running the
+// service
+func isHTTPURL(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+// isLocalURL reports whether a remote is a repository on this machine
+func isLocalURL(url string) bool {
+	if isHTTPURL(url) || strings.HasPrefix(url, "ssh://") {
+		return false
+	}
+	colon := strings.IndexByte(url, ':')
+	return colon < 0 || strings.HasPrefix(url, "file://") || strings.ContainsRune(url[:colon], '/')
+}
+
+// serviceCommand is the command that runs service for a repository reached by ssh or locally
+func serviceCommand(url string, service string) *exec.Cmd {
+	program := servicePrograms[service]
+	if program == "" {
+		program = service
+	}
+	if isLocalURL(url) {
+		repo := strings.TrimPrefix(url, "file://")
+		return exec.Command("sh", "-c", program+" "+shellQuote(repo))
+	}
+	var host, port, repo string
+	if rest, ok := strings.CutPrefix(url, "ssh://"); ok {
+		host, repo, _ = strings.Cut(rest, "/")
+		repo = "/" + repo
+		if colon := strings.LastIndexByte(host, ':'); colon > strings.LastIndexByte(host, '@') {
+			host, port = host[:colon], host[colon+1:]
+		}
+	} else {
+		host, repo, _ = strings.Cut(url, ":")
+	}
+	args := []string{}
+	if port != "" {
+		args = append(args, "-p", port)
+	}
+	ssh := os.Getenv("GIT_SSH_COMMAND")
+	if ssh == "" {
+		ssh = "ssh"
+	}
+	args = append(args, host, program+" "+shellQuote(repo))
+	return exec.Command("sh", append([]string{"-c", ssh + ` "$@"`, ssh}, args...)...)
+}
+
+// serviceProcess is a running service: the advertisement, then the response to the request,
+// are read from r
+type serviceProcess struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	r     *bufio.Reader
+}
+
+// openServices are the services discoverRefs started, by URL and service, until a request
+// is sent to them
+var openServices = map[string]*serviceProcess{}
+
+func startService(url string, service string) (*serviceProcess, error) {
+	cmd := serviceCommand(url, service)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &serviceProcess{cmd: cmd, stdin: stdin, r: bufio.NewReader(stdout)}, nil
+}
+
+// Close ends the request, if it hasn't been, and waits for the service to exit
+func (p *serviceProcess) Close() error {
+	p.stdin.Close()
+	return p.cmd.Wait()
+}
+
+// hangUpService tells a service discoverRefs started that no request is coming, as git does
+// when there is nothing to fetch or push
+func hangUpService(url string, service string) {
+	if p := openServices[url+" "+service]; p != nil {
+		delete(openServices, url+" "+service)
+		writeFlush(p.stdin)
+		p.Close()
+	}
+}
+
+// errHungUp is what reading from a service that exited early means, usually that the
+// repository isn't there or can't be accessed
+var errHungUp = errors.New("Could not read from remote repository.\n\n" +
+	"Please make sure you have the correct access rights\nand the repository exists.")
+
+// postService sends a request to a service and returns its response: by POST for HTTP, and
+// otherwise to the service discoverRefs started, or to a new one once that has answered
+// something else
+func postService(url string, service string, request *bytes.Buffer) (io.ReadCloser, error) {
+	if isHTTPURL(url) {
+		resp, err := http.Post(url+"/"+service, "application/x-"+service+"-request", request)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s request to %s failed (HTTP %d)", strings.TrimPrefix(service, "git-"), url, resp.StatusCode)
+		}
+		return resp.Body, nil
+	}
+
+	p := openServices[url+" "+service]
+	delete(openServices, url+" "+service)
+	if p == nil {
+		var err error
+		if p, err = startService(url, service); err != nil {
+			return nil, err
+		}
+		if _, _, err := readAdvertisement(p.r); err != nil {
+			p.Close()
+			return nil, err
+		}
+	}
+	if _, err := p.stdin.Write(request.Bytes()); err != nil {
+		p.Close()
+		return nil, errHungUp
+	}
+	return readCloser{p.r, p}, nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type treeEntry struct {
+	mode uint32
+	name string
+	sha  string
+}
+
+func (e treeEntry) isTree() bool {
+	return e.mode == 0o040000
+}
+
+func parseTree(contents []byte) ([]treeEntry, error) {
+	var entries []treeEntry
+	for len(contents) > 0 {
+		space_index := bytes.IndexByte(contents, ' ')
+		if space_index < 0 {
+			return nil, fmt.Errorf("malformed tree entry")
+		}
+		mode, err := strconv.ParseUint(string(contents[:space_index]), 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("malformed tree entry mode: %w", err)
+		}
+		contents = contents[space_index+1:]
+
+		null_index := bytes.IndexByte(contents, 0)
+		if null_index < 0 || len(contents) < null_index+21 {
+			return nil, fmt.Errorf("malformed tree entry")
+		}
+		name := string(contents[:null_index])
+		contents = contents[null_index+1:]
+
+		entries = append(entries, treeEntry{
+			mode: uint32(mode),
+			name: name,
+			sha:  fmt.Sprintf("%x", contents[:20]),
+		})
+		contents = contents[20:]
+	}
+	return entries, nil
+}
+
+func readTree(sha string) ([]treeEntry, error) {
+	objType, contents, err := parseObject(sha)
+	if err != nil {
+		return nil, err
+	}
+	if objType != "tree" {
+		return nil, fmt.Errorf("object %s is a %s, not a tree", sha, objType)
+	}
+	return parseTree(contents)
+}
+
+// flattenTree walks a tree recursively and collects every non-tree entry keyed by its full path
+func flattenTree(sha string, prefix string, files map[string]treeEntry) error {
+	entries, err := readTree(sha)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		fullPath := path.Join(prefix, entry.name)
+		if entry.isTree() {
+			if err := flattenTree(entry.sha, fullPath, files); err != nil {
+				return err
+			}
+			continue
+		}
+		entry.name = fullPath
+		files[fullPath] = entry
+	}
+	return nil
+}
+
+// lookupTreePath finds the entry at a slash-separated path inside a tree
+func lookupTreePath(treeSha string, entryPath string) (treeEntry, error) {
+	entry := treeEntry{mode: 0o040000, sha: treeSha}
+	for _, name := range strings.Split(strings.Trim(entryPath, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		if !entry.isTree() {
+			return treeEntry{}, fmt.Errorf("path '%s' does not exist", entryPath)
+		}
+		entries, err := readTree(entry.sha)
+		if err != nil {
+			return treeEntry{}, err
+		}
+		found := false
+		for _, child := range entries {
+			if child.name == name {
+				entry, found = child, true
+				break
+			}
+		}
+		if !found {
+			return treeEntry{}, fmt.Errorf("path '%s' does not exist", entryPath)
+		}
+	}
+	return entry, nil
+}
+
+// Ways following symlinks through a tree can fail, as cat-file --follow-symlinks reports them
+var (
+	errSymlinkDangling = errors.New("dangling")
+	errSymlinkLoop     = errors.New("loop")
+	errNotDir          = errors.New("notdir")
+)
+
+// maxSymlinkFollows is how many links a lookup may follow before it is taken to be a loop
+const maxSymlinkFollows = 40
+
+// followTreePath is lookupTreePath, but symlinks along the path (and at the end of it) are
+// followed within the tree. A link that leads out of the tree stops the walk: outside is then
+// the link itself if it is absolute, or the rest of the path from where it climbs above the root.
+func followTreePath(treeSha string, entryPath string) (entry treeEntry, outside string, err error) {
+	current := treeSha
+	var parents []string //trees above current, innermost last
+	components := strings.Split(entryPath, "/")
+	follows := 0
+	for len(components) > 0 {
+		name := components[0]
+		components = components[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			if len(parents) == 0 {
+				return treeEntry{}, strings.Join(append([]string{".."}, components...), "/"), nil
+			}
+			current, parents = parents[len(parents)-1], parents[:len(parents)-1]
+			continue
+		}
+
+		entries, err := readTree(current)
+		if err != nil {
+			return treeEntry{}, "", err
+		}
+		found := false
+		for _, child := range entries {
+			if child.name == name {
+				entry, found = child, true
+				break
+			}
+		}
+		if !found {
+			if follows > 0 {
+				return treeEntry{}, "", errSymlinkDangling
+			}
+			return treeEntry{}, "", fmt.Errorf("path '%s' does not exist", entryPath)
+		}
+
+		switch {
+		case entry.isTree():
+			parents = append(parents, current)
+			current = entry.sha
+		case entry.mode == 0o120000:
+			if follows++; follows > maxSymlinkFollows {
+				return treeEntry{}, "", errSymlinkLoop
+			}
+			_, target, err := parseObject(entry.sha)
+			if err != nil {
+				return treeEntry{}, "", err
+			}
+			if len(target) > 0 && target[0] == '/' {
+				return treeEntry{}, string(target), nil
+			}
+			// the link is relative to the directory it is in, which is current
+			components = append(strings.Split(string(target), "/"), components...)
+		case len(components) > 0:
+			return treeEntry{}, "", errNotDir
+		case entry.mode == 0o160000 && follows > 0:
+			return treeEntry{}, "", errSymlinkDangling //a link to a submodule has no object here
+		default:
+			return entry, "", nil
+		}
+	}
+	return treeEntry{mode: 0o040000, sha: current}, "", nil
+}
+
+// peelToTree returns the tree for a tree or commit SHA
+func peelToTree(sha string) (string, error) {
+	objType, contents, err := parseObject(sha)
+	if err != nil {
+		return "", err
+	}
+	switch objType {
+	case "tree":
+		return sha, nil
+	case "commit":
+		commit, err := parseCommit(contents)
+		if err != nil {
+			return "", err
+		}
+		return commit.Tree, nil
+	case "tag":
+		return peelToTree(tagTarget(contents))
+	}
+	return "", fmt.Errorf("object %s is a %s, not a tree-ish", sha, objType)
+}
+
+// writeTreeFiles writes the trees for a flat path -> entry map (as built by flattenTree) and
+// returns the root tree's SHA
+func writeTreeFiles(files map[string]treeEntry) (string, error) {
+	children := map[string]map[string]treeEntry{} //subdirectory -> its files, relative to it
+	var entries []treeEntry
+	for filePath, file := range files {
+		dir, rest, nested := strings.Cut(filePath, "/")
+		if !nested {
+			entries = append(entries, treeEntry{mode: file.mode, name: filePath, sha: file.sha})
+			continue
+		}
+		if children[dir] == nil {
+			children[dir] = map[string]treeEntry{}
+		}
+		children[dir][rest] = file
+	}
+	for dir, subFiles := range children {
+		sha, err := writeTreeFiles(subFiles)
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, treeEntry{mode: 0o040000, name: dir, sha: sha})
+	}
+
+	// git sorts directories as if their names ended in "/"
+	sortKey := func(e treeEntry) string {
+		if e.isTree() {
+			return e.name + "/"
+		}
+		return e.name
+	}
+	sort.Slice(entries, func(i, j int) bool { return sortKey(entries[i]) < sortKey(entries[j]) })
+
+	var contents bytes.Buffer
+	for _, entry := range entries {
+		rawSha, err := hex.DecodeString(entry.sha)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&contents, "%o %s\x00", entry.mode, entry.name)
+		contents.Write(rawSha)
+	}
+	sha, err := writeObject("tree", contents.Bytes())
+	return fmt.Sprintf("%x", sha), err
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+/*
+A word diff shows each hunk as the new text with the words that changed marked in it, the old
+words in the old color followed by the new ones in the new color, instead of as whole removed
+and added lines. Lines kept as they were are shown as they are, and each run of changed lines
+between them is diffed word by word. What counts as a word is set by a regex; anything between
+two words, such as the whitespace, is taken from the new text and never counts as a change.
+*/
+
+// defaultWordRegex makes every run of non-whitespace a word
+const defaultWordRegex = `[^[:space:]]+`
+
+// diffWord is one word of a text, and where it is in it
+type diffWord struct {
+	text       string
+	begin, end int
+}
+
+// splitWords finds the words of text. Like git, a word never runs past the end of a line.
+func splitWords(text string, re *regexp.Regexp) []diffWord {
+	var words []diffWord
+	for i := 0; i < len(text); {
+		match := re.FindStringIndex(text[i:])
+		if match == nil || match[0] == match[1] {
+			break
+		}
+		begin, end := i+match[0], i+match[1]
+		if newline := strings.IndexByte(text[begin:end], '\n'); newline >= 0 {
+			end = begin + newline
+		}
+		if begin == end {
+			i = end + 1
+			continue
+		}
+		words = append(words, diffWord{text[begin:end], begin, end})
+		i = end
+	}
+	return words
+}
+
+// writeWordHunk writes a hunk as a word diff
+func writeWordHunk(w io.Writer, a []string, hunk []diffLine, re *regexp.Regexp, c diffColors) {
+	writeHunkHeader(w, a, hunk, c)
+	var minus, plus strings.Builder
+	for _, line := range hunk {
+		switch line.kind {
+		case '-':
+			minus.WriteString(line.text)
+		case '+':
+			plus.WriteString(line.text)
+		default:
+			writeWordDiff(w, minus.String(), plus.String(), re, c)
+			minus.Reset()
+			plus.Reset()
+			text, newline := strings.CutSuffix(line.text, "\n")
+			fmt.Fprint(w, c.paint(c.context, text))
+			if newline {
+				fmt.Fprintln(w)
+			}
+		}
+	}
+	writeWordDiff(w, minus.String(), plus.String(), re, c)
+	if !strings.HasSuffix(hunk[len(hunk)-1].text, "\n") {
+		fmt.Fprintln(w) //the last line still ends the output, without a "No newline" note
+	}
+}
+
+// writeWordDiff writes plus with the words that differ from minus marked
+func writeWordDiff(w io.Writer, minus, plus string, re *regexp.Regexp, c diffColors) {
+	if minus == "" && plus == "" {
+		return
+	}
+	if plus == "" {
+		writeWordText(w, minus, c.old, c)
+		return
+	}
+	minusWords, plusWords := splitWords(minus, re), splitWords(plus, re)
+	texts := func(words []diffWord) []string {
+		list := make([]string, len(words))
+		for i, word := range words {
+			list[i] = word.text
+		}
+		return list
+	}
+	script := editScript(texts(minusWords), texts(plusWords))
+
+	// the text a run of changed words spans on one side; a change that removes or adds no words
+	// on a side is placed right after the word before it there
+	span := func(words []diffWord, changed []int, next int) (int, int) {
+		if len(changed) > 0 {
+			return words[changed[0]].begin, words[changed[len(changed)-1]].end
+		}
+		if next == 0 {
+			return 0, 0
+		}
+		return words[next-1].end, words[next-1].end
+	}
+	shown := 0 //how much of plus has been written
+	for start := 0; start < len(script); {
+		if script[start].kind == ' ' {
+			start++
+			continue
+		}
+		end := start
+		for end < len(script) && script[end].kind != ' ' {
+			end++
+		}
+		var removed, added []int
+		for _, change := range script[start:end] {
+			if change.kind == '-' {
+				removed = append(removed, change.a)
+			} else {
+				added = append(added, change.b)
+			}
+		}
+		minusBegin, minusEnd := span(minusWords, removed, script[start].a)
+		plusBegin, plusEnd := span(plusWords, added, script[start].b)
+		writeWordText(w, plus[shown:plusBegin], c.context, c)
+		writeWordText(w, minus[minusBegin:minusEnd], c.old, c)
+		writeWordText(w, plus[plusBegin:plusEnd], c.new, c)
+		shown = plusEnd
+		start = end
+	}
+	writeWordText(w, plus[shown:], c.context, c)
+}
+
+// writeWordText writes part of a word diff in a color, coloring each line of it separately so
+// that the color never runs over a newline
+func writeWordText(w io.Writer, text string, color string, c diffColors) {
+	for text != "" {
+		line, rest, newline := strings.Cut(text, "\n")
+		if line != "" && color != "" {
+			fmt.Fprint(w, c.paint(color, line))
+		} else {
+			fmt.Fprint(w, line)
+		}
+		if newline {
+			fmt.Fprintln(w)
+		}
+		text = rest
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+func fileMode(fi os.FileInfo) uint32 {
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		return 0o120000
+	case fi.Mode()&0o111 != 0:
+		return 0o100755
+	default:
+		return 0o100644
+	}
+}
+
+// readWorktreeFile returns what would be stored as the blob for a path: file contents, or the target of a symlink
+func readWorktreeFile(filePath string) ([]byte, os.FileInfo, error) {
+	fi, err := os.Lstat(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(filePath)
+		return []byte(target), fi, err
+	}
+	contents, err := os.ReadFile(filePath)
+	return contents, fi, err
+}
+
+// worktreeChanged reports whether the working tree file differs from its index entry
+func worktreeChanged(entry indexEntry) (bool, error) {
+	fi, err := os.Lstat(entry.path)
+	if os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	if entry.statMatches(fi) {
+		return false, nil
+	}
+	if fi.IsDir() || fileMode(fi) != entry.mode {
+		return true, nil
+	}
+	contents, _, err := readWorktreeFile(entry.path)
+	if err != nil {
+		return false, err
+	}
+	return hashObject("blob", contents) != entry.sha, nil
+}
+
+func writeWorktreeFile(filePath string, entry treeEntry) error {
+	objType, contents, err := parseObject(entry.sha)
+	if err != nil {
+		return err
+	}
+	if objType != "blob" {
+		return fmt.Errorf("object %s for %s is a %s, not a blob", entry.sha, filePath, objType)
+	}
+	if err := os.MkdirAll(path.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filePath); err != nil {
+		return err
+	}
+	switch entry.mode {
+	case 0o120000:
+		return os.Symlink(string(contents), filePath)
+	case 0o100755:
+		return os.WriteFile(filePath, contents, 0755)
+	default:
+		return os.WriteFile(filePath, contents, 0644)
+	}
+}
+
+// removeWorktreeFile deletes a file and any parent directories left empty by it
+func removeWorktreeFile(filePath string) error {
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := path.Dir(filePath); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break //not empty
+		}
+	}
+	return nil
+}